@@ -0,0 +1,107 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+)
+
+// ABI describes the properties of a C application binary interface that
+// cannot be expressed by a MemoryModel alone. Targets of the same
+// architecture, like Linux and Windows on amd64, can differ in them.
+type ABI struct {
+	Name  string      // Like "sysv-amd64".
+	Model MemoryModel // Sizes and alignments of the IR types.
+
+	Long       TypeKind // The IR type of C long, Int32 or Int64.
+	LongDouble TypeKind // The IR type of C long double, Float64 or Float128.
+	WChar      TypeKind // The IR type of C wchar_t.
+
+	// MaxRegisterAggregate is the size of the largest struct or union
+	// passed by value in registers, larger aggregates are passed in
+	// memory. Zero if aggregates are always passed in memory.
+	MaxRegisterAggregate int64
+
+	// PowerOfTwoAggregates is set if only aggregates of size 1, 2, 4 or 8
+	// are passed in registers, like in the Microsoft x64 calling
+	// convention, which passes other aggregates by reference.
+	PowerOfTwoAggregates bool
+
+	SignedChar bool // Plain C char is signed.
+}
+
+// NewABI returns the ABI of name or an error, if any. Supported names are
+//
+//	"aarch64-aapcs"	Procedure Call Standard for the Arm 64-bit Architecture, for example linux/arm64.
+//	"ilp32"		System V i386, for example linux/386.
+//	"ms-x64"	Microsoft x64, for example windows/amd64.
+//	"sysv-amd64"	System V AMD64, for example linux/amd64.
+func NewABI(name string) (*ABI, error) {
+	var a *ABI
+	var t Target
+	switch name {
+	case "aarch64-aapcs":
+		t = Target{OS: "linux", Arch: "arm64"}
+		a = &ABI{Long: Int64, LongDouble: Float128, WChar: Uint32, MaxRegisterAggregate: 16}
+	case "ilp32":
+		t = Target{OS: "linux", Arch: "386"}
+		a = &ABI{Long: Int32, LongDouble: Float128, WChar: Int32, SignedChar: true}
+	case "ms-x64":
+		t = Target{OS: "windows", Arch: "amd64"}
+		a = &ABI{Long: Int32, LongDouble: Float64, WChar: Uint16, MaxRegisterAggregate: 8, PowerOfTwoAggregates: true, SignedChar: true}
+	case "sysv-amd64":
+		t = Target{OS: "linux", Arch: "amd64"}
+		a = &ABI{Long: Int64, LongDouble: Float128, WChar: Int32, MaxRegisterAggregate: 16, SignedChar: true}
+	default:
+		return nil, fmt.Errorf("unknown or unsupported ABI %q", name)
+	}
+
+	m, err := NewMemoryModelFor(t)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "aarch64-aapcs", "sysv-amd64":
+		// long double is 16 byte aligned.
+		for _, k := range []TypeKind{Float128, Complex256} {
+			v := m[k]
+			v.Align, v.StructAlign = 16, 16
+			m[k] = v
+		}
+	case "ilp32":
+		// long double occupies 12 bytes.
+		v := m[Float128]
+		v.Align, v.Size, v.StructAlign = 4, 12, 4
+		m[Float128] = v
+		v = m[Complex256]
+		v.Align, v.Size, v.StructAlign = 4, 24, 4
+		m[Complex256] = v
+	}
+	a.Name = name
+	a.Model = m
+	return a, nil
+}
+
+// NewMemoryModelFromABI returns the MemoryModel of the ABI name, see NewABI,
+// or an error, if any.
+func NewMemoryModelFromABI(name string) (MemoryModel, error) {
+	a, err := NewABI(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Model, nil
+}
+
+// PassInRegisters reports whether a struct or union of size bytes is passed
+// by value in registers.
+func (a *ABI) PassInRegisters(size int64) bool {
+	if size <= 0 || size > a.MaxRegisterAggregate {
+		return false
+	}
+
+	return !a.PowerOfTwoAggregates || size&(size-1) == 0
+}
@@ -6,17 +6,27 @@ package ir
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"go/token"
+	"io"
 	"io/ioutil"
 	"math"
+	"math/big"
 	"os"
 	"path"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func caller(s string, va ...interface{}) {
@@ -98,6 +108,7 @@ func TestLexer(t *testing.T) {
 		{"uint64", tokU64},
 		{"uint8", tokU8},
 		{"union", tokUnion},
+		{"void", tokVoid},
 		{"{", tok('{')},
 		{"}", tok('}')},
 		{fmt.Sprint(uint64(math.MaxInt64)), tokNumber},
@@ -264,6 +275,124 @@ func TestParser(t *testing.T) {
 	}
 }
 
+func TestSyncTypeCache(t *testing.T) {
+	var c SyncTypeCache
+	var wg sync.WaitGroup
+	ids := []TypeID{
+		TypeID(dict.SID("struct{a int8,b [3]*int16}")),
+		TypeID(dict.SID("func(int32,...)*struct{a int8,b [3]*int16}")),
+		TypeID(dict.SID("union{a int64,b [2]struct{a int8,b [3]*int16}}")),
+	}
+	types := make([][]Type, 8)
+	for i := range types {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for _, v := range ids {
+				types[i] = append(types[i], c.MustType(v))
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, v := range types {
+		for j, w := range v {
+			if w != types[0][j] {
+				t.Fatal(j, w)
+			}
+		}
+	}
+
+	if _, err := c.Type(TypeID(dict.SID("foo"))); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	// Comparing resolved types does not need the exclusive lock.
+	a := TypeID(dict.SID("*[?]int32"))
+	b := TypeID(dict.SID("*[10]int32"))
+	if _, err := c.Composite(a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.RLock()
+	done := make(chan TypeID)
+	go func() {
+		var r TypeID
+		if c.Compatible(a, b) {
+			r, _ = c.Composite(a, b)
+		}
+		done <- r
+	}()
+	select {
+	case g := <-done:
+		if e := b; g != e {
+			t.Fatal(g, e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("blocked")
+	}
+	c.mu.RUnlock()
+}
+
+func TestSyncTypeCacheShared(t *testing.T) {
+	var c SyncTypeCache
+	var wg sync.WaitGroup
+	st := TypeID(dict.SID("struct{a int8,b [3]*int16}"))
+	ta := TypeID(dict.SID("[5]int8"))
+	tb := TypeID(dict.SID("[7]int8"))
+	d := NameID(dict.SID("d"))
+	units := append(testUnits(2, 2),
+		[]Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: ta}}},
+		[]Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: tb}}},
+	)
+	errs := make(chan error, 32)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := VerifyValue(&CompositeValue{Values: []Value{&Int32Value{Value: 42}}}, st, &c); err != nil {
+				errs <- err
+				return
+			}
+
+			v := NewVerifier()
+			v.Types = &c
+			if err := v.Verify(&FunctionDefinition{ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType}, Body: testBody(2)}); err != nil {
+				errs <- err
+				return
+			}
+
+			if _, err := LinkLibWithOptions(&LinkLibOptions{Types: &c}, units...); err != nil {
+				errs <- err
+				return
+			}
+
+			l := Linker{Types: &c}
+			for _, v := range units {
+				if err := l.AddUnit(v); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if _, err := l.FinalizeLib(nil); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, v := range []TypeID{st, ta, tb} {
+		if c.c[v] == nil {
+			t.Fatalf("%v not in the shared cache", v)
+		}
+	}
+}
+
 func TestParser2(t *testing.T) {
 	types = TypeCache{}
 	if _, err := types.Type(TypeID(dict.SID("struct{a int8,b struct{c int16,d int32},e int64}"))); err != nil {
@@ -553,146 +682,7041 @@ func benchmarkTypeCache(b *testing.B) {
 	b.SetBytes(int64(n))
 }
 
+func benchmarkVerify(b *testing.B) {
+	body := testBody(1000)
+	f := &FunctionDefinition{ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Body = append(f.Body[:0], body...)
+		if err := f.Verify(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkReadFrom(b *testing.B) {
+	out := Objects{
+		[]Object{
+			&FunctionDefinition{
+				Body:       testBody(1000),
+				ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+			},
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := out.WriteTo(buf); err != nil {
+		b.Fatal(err)
+	}
+
+	src := buf.Bytes()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var in Objects
+		if _, err := in.ReadFrom(bytes.NewReader(src)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteToOptions(t *testing.T) {
+	objs := func(line int) Objects {
+		body := testBody(1)
+		for _, v := range body {
+			reflect.ValueOf(v).Elem().FieldByName("Position").Set(reflect.ValueOf(token.Position{Filename: "a.c", Line: line}))
+		}
+		return Objects{{
+			&FunctionDefinition{
+				Body:       body,
+				ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType, Position: token.Position{Filename: "a.c", Line: line}},
+			},
+		}}
+	}
+	o := objs(1)
+	var a, b bytes.Buffer
+	if _, err := o.WriteToOptions(&a, &WriteOptions{StripPositions: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := objs(2).WriteToOptions(&b, &WriteOptions{StripPositions: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("output differs")
+	}
+
+	if g, e := o[0][0].(*FunctionDefinition).Body[0].Pos().Line, 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	var in Objects
+	if _, err := in.ReadFrom(&a); err != nil {
+		t.Fatal(err)
+	}
+
+	f := in[0][0].(*FunctionDefinition)
+	if p := f.Body[0].Pos(); f.Position.IsValid() || p.IsValid() {
+		t.Fatal(f.Position, p)
+	}
+
+	a.Reset()
+	if _, err := o.WriteToOptions(&a, &WriteOptions{Target: Target{OS: "foo"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := in.ReadFrom(&a); err == nil || !strings.Contains(err.Error(), "foo") {
+		t.Fatal(err)
+	}
+}
+
+// testUnits returns n translation units, each defining m external and m
+// internal data objects referring to each other.
+func testUnits(n, m int) [][]Object {
+	r := make([][]Object, n)
+	for i := range r {
+		for j := 0; j < m; j++ {
+			ext := NameID(dict.SID(fmt.Sprintf("e%d.%d", i, j)))
+			in := NameID(dict.SID(fmt.Sprintf("i%d", j)))
+			next := NameID(dict.SID(fmt.Sprintf("e%d.%d", (i+1)%n, j)))
+			r[i] = append(r[i],
+				&DataDefinition{
+					ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: ext, TypeID: idPint32},
+					Value:      &AddressValue{Linkage: InternalLinkage, NameID: in},
+				},
+				&DataDefinition{
+					ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: in, TypeID: idPint32},
+					Value:      &AddressValue{Linkage: ExternalLinkage, NameID: next},
+				},
+			)
+		}
+	}
+	return r
+}
+
+func benchmarkLink(b *testing.B) {
+	units := testUnits(100, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LinkLib(units...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func Benchmark(b *testing.B) {
 	b.Run("Lexer", benchmarkLexer)
+	b.Run("Link", benchmarkLink)
 	b.Run("Parser", benchmarkParser)
+	b.Run("ReadFrom", benchmarkReadFrom)
 	b.Run("TypeCache", benchmarkTypeCache)
+	b.Run("Verify", benchmarkVerify)
 }
 
-func TestGobTypeID(t *testing.T) {
-	const c = "The quick brown fox type"
-	buf := bytes.NewBuffer(nil)
-	enc := gob.NewEncoder(buf)
-	in := TypeID(dict.SID(c))
-	if err := enc.Encode(in); err != nil {
-		t.Fatal(err)
+// testBody returns the body of a func()int32 with n conditionally executed
+// stores to a local variable.
+func testBody(n int) []Operation {
+	r := []Operation{
+		&BeginScope{},
+		&VariableDeclaration{TypeID: idInt32},
 	}
-
-	if !bytes.Contains(buf.Bytes(), []byte(c)) {
-		t.Fatal("TypeID gob encoding fail")
+	for i := 0; i < n; i++ {
+		r = append(r,
+			&Variable{TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: int32(i)},
+			&Lt{TypeID: idInt32},
+			&Jz{Number: i},
+			&Variable{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: int32(i)},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Label{Number: i},
+		)
 	}
+	return append(r,
+		&Result{Address: true, TypeID: idPint32},
+		&Variable{TypeID: idInt32},
+		&Store{TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+		&Return{},
+		&EndScope{},
+	)
+}
 
-	out := TypeID(-1)
-	dec := gob.NewDecoder(buf)
-	if err := dec.Decode(&out); err != nil {
+func TestVerify(t *testing.T) {
+	body := testBody(10)
+	f := &FunctionDefinition{
+		Body:       append([]Operation(nil), body...),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Verify(); err != nil {
 		t.Fatal(err)
 	}
 
-	if g, e := in, out; g != e {
+	if g, e := len(f.Body), len(body); g != e {
 		t.Fatal(g, e)
 	}
+
+	f.Body = append([]Operation(nil), body...)
+	f.Body[len(f.Body)-4] = &Drop{TypeID: idInt64}
+	if err := f.Verify(); err == nil {
+		t.Fatal("unexpected success")
+	}
 }
 
-func TestGobNameID(t *testing.T) {
-	const c = "The quick brown fox name"
-	buf := bytes.NewBuffer(nil)
-	enc := gob.NewEncoder(buf)
-	in := NameID(dict.SID(c))
-	if err := enc.Encode(in); err != nil {
-		t.Fatal(err)
+// testVerifyOps verifies a function body consisting of testBody(0) with ops
+// inserted after the variable declaration.
+func testVerifyOps(ops ...Operation) error {
+	body := testBody(0)
+	f := &FunctionDefinition{
+		Body:       append(append(append([]Operation(nil), body[:2]...), ops...), body[2:]...),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
 	}
+	return f.Verify()
+}
 
-	if !bytes.Contains(buf.Bytes(), []byte(c)) {
-		t.Fatal("NameID gob encoding fail")
+func TestVerifyError(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	err := testVerifyOps(c, &Drop{TypeID: idInt64})
+	e, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("%T %v", err, err)
 	}
 
-	out := NameID(-1)
-	dec := gob.NewDecoder(buf)
-	if err := dec.Decode(&out); err != nil {
-		t.Fatal(err)
+	if g, e := e.IP, 3; g != e {
+		t.Fatal(g, e)
 	}
 
-	if g, e := in, out; g != e {
+	if g, e := fmt.Sprint(e.Stack), "[int32]"; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(e.Window), 7; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := e.WindowIP, 0; g != e {
 		t.Fatal(g, e)
 	}
+
+	if s := e.Context(); !strings.Contains(s, "=> 0x00003\tdrop") || !strings.Contains(s, "stack: [int32]") {
+		t.Fatal(s)
+	}
 }
 
-func TestGobStringID(t *testing.T) {
-	const c = "The quick brown fox string"
-	buf := bytes.NewBuffer(nil)
-	enc := gob.NewEncoder(buf)
-	in := StringID(dict.SID(c))
-	if err := enc.Encode(in); err != nil {
+func TestDOT(t *testing.T) {
+	body := testBody(0)
+	f := &FunctionDefinition{
+		Body: append(append(append([]Operation(nil), body[:2]...),
+			&Argument{TypeID: idInt32},
+			&Jz{Number: 0},
+			&StringConst{TypeID: idPint32, Value: StringID(dict.SID(`a"b`)), Position: token.Position{Filename: "a.c", Line: 3, Column: 1}},
+			&Drop{TypeID: idPint32},
+			&Label{Number: 0},
+		), body[2:]...),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	var buf bytes.Buffer
+	if err := f.DOT(&buf); err != nil {
 		t.Fatal(err)
 	}
 
-	if !bytes.Contains(buf.Bytes(), []byte(c)) {
-		t.Fatal("NameID gob encoding fail")
+	s := buf.String()
+	for _, v := range []string{
+		"digraph \"main\" {",
+		"b0 -> b2;",
+		"b0 -> b1 [style=dashed];",
+		"b1 -> b2 [style=dashed];",
+		`\"a\\\"b\"`,
+		"a.c:3:1",
+	} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("%q\n%s", v, s)
+		}
+	}
+	if strings.Contains(s, "b2 ->") {
+		t.Fatal(s)
 	}
 
-	out := StringID(-1)
-	dec := gob.NewDecoder(buf)
-	if err := dec.Decode(&out); err != nil {
+	// DOT shows compressed positions but leaves them compressed.
+	f.CompressPositions()
+	buf.Reset()
+	if err := f.DOT(&buf); err != nil {
 		t.Fatal(err)
 	}
 
-	if g, e := in, out; g != e {
-		t.Fatal(g, e)
+	if g := buf.String(); g != s {
+		t.Fatalf("\ngot\n%s\nexp\n%s", g, s)
+	}
+
+	if f.Positions == nil || f.Body[4].Pos().Filename != "" {
+		t.Fatal(f.Positions, f.Body[4])
 	}
 }
 
-func TestObjectsGob(t *testing.T) {
-	out := Objects{
-		[]Object{
-			&FunctionDefinition{
-				Body: []Operation{
-					&Result{
-						Address: true,
-					},
-				},
-			},
-		},
+func TestRewriter(t *testing.T) {
+	body := testBody(2)
+	n := len(body)
+	r := NewRewriter(body)
+	r.InsertBefore(n-6, // Result
+		&Const32{TypeID: idInt32, Value: 1},
+		&Jz{Number: 0},
+		&Label{Number: 0},
+	)
+	r.Delete(n - 3) // Drop
+	r.Replace(n-4, &Store{TypeID: idInt32}, &Drop{TypeID: idInt32})
+	body = r.Body()
+	if g, e := len(body), n+3; g != e {
+		t.Fatal(g, e)
 	}
 
-	f := bytes.NewBuffer(nil)
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(out); err != nil {
-		t.Fatal(err)
+	if g, e := body[n-5].(*Jz).Number, 2; g != e {
+		t.Fatal(g, e)
 	}
 
-	var in Objects
-	dec := gob.NewDecoder(f)
-	if err := dec.Decode(&in); err != nil {
+	var consts int
+	body = Walk(body, func(ip int, op Operation) (Operation, bool) {
+		if _, ok := op.(*Const32); ok {
+			consts++
+			return &Const32{TypeID: idInt32, Value: 42}, true
+		}
+
+		return op, true
+	})
+	if g, e := consts, 5; g != e {
+		t.Fatal(g, e)
+	}
+
+	f := &FunctionDefinition{
+		Body:       body,
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Verify(); err != nil {
 		t.Fatal(err)
 	}
 
-	if g, e := PrettyString(in), PrettyString(out); g != e {
-		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	body = Walk(testBody(0), func(ip int, op Operation) (Operation, bool) { return nil, ip == 0 })
+	if g, e := len(body), len(testBody(0))-2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if _, ok := body[0].(*Result); !ok {
+		t.Fatalf("%T", body[0])
 	}
 }
 
-func TestObjects(t *testing.T) {
-	out := Objects{
-		[]Object{
+func TestPassManager(t *testing.T) {
+	fn := func() []Object {
+		body := testBody(0)
+		return []Object{
+			&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: idInt32}},
 			&FunctionDefinition{
-				Body: []Operation{
-					&Result{
-						Address: true,
-					},
-				},
+				Body: append(append(append([]Operation(nil), body[:2]...),
+					&Const32{TypeID: idInt32, Value: 6}, &Convert{TypeID: idInt32, Result: idInt32},
+					&Const32{TypeID: idInt32, Value: 7}, &Mul{TypeID: idInt32},
+					&Drop{TypeID: idInt32},
+				), body[2:]...),
+				ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
 			},
-		},
+		}
+	}
+	if _, err := NewPassManagerO(3); err == nil {
+		t.Fatal("unexpected success")
 	}
 
-	f, err := ioutil.TempFile("", "test-ir-")
+	for level, e := range []int{len(testBody(0)) + 4, len(testBody(0)) + 2, len(testBody(0)) + 2} {
+		m, err := NewPassManagerO(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := strings.Join(m.Passes(), " "), []string{
+			"verify",
+			"verify fold",
+			"verify fold loads stores",
+		}[level]; g != e {
+			t.Fatalf("%v: %q %q", level, g, e)
+		}
+
+		objs, err := m.Run(fn())
+		if err != nil {
+			t.Fatal(level, err)
+		}
+
+		if g := len(objs[1].(*FunctionDefinition).Body); g != e {
+			t.Fatal(level, g, e)
+		}
+
+		if g, e := len(m.Timings()), len(m.Passes()); g != e {
+			t.Fatal(level, g, e)
+		}
+	}
+
+	m := NewPassManager()
+	m.Add(&Pass{Name: "data", Module: func(objs []Object) ([]Object, error) { return objs[:1], nil }})
+	objs, err := m.Run(fn())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if _, err := Objects(out).WriteTo(f); err != nil {
+	if g, e := len(objs), 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	m = NewPassManager(&Pass{Name: "fail", Function: func(*FunctionDefinition) error { return fmt.Errorf("fail") }})
+	if _, err := m.Run(fn()); err == nil || !strings.HasPrefix(err.Error(), "fail: main:") {
 		t.Fatal(err)
 	}
+}
 
-	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+func TestFoldConstants(t *testing.T) {
+	c := func(v int32) Operation { return &Const32{TypeID: idInt32, Value: v} }
+	body := testBody(0)
+	f := &FunctionDefinition{
+		Body: append(append(append([]Operation(nil), body[:2]...),
+			&Variable{Address: true, TypeID: idPint32},
+			c(6), c(7), &Mul{TypeID: idInt32},
+			c(2), c(1), &Sub{TypeID: idInt32}, &Neg{TypeID: idInt32}, &Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			c(2), c(3), &Lt{TypeID: idInt32},
+			&Jz{Number: 0},
+			&Variable{Address: true, TypeID: idPint32},
+			c(math.MaxInt32), c(1), &Add{Overflow: OverflowTrap, TypeID: idInt32},
+			c(1), c(0), &Div{TypeID: idInt32}, &Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Label{Number: 0},
+		), body[2:]...),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	if err := FoldConstants(f); err != nil {
 		t.Fatal(err)
 	}
 
-	var in Objects
-	if _, err := in.ReadFrom(f); err != nil {
+	if g, e := f.Body[3].(*Const32).Value, int32(41); g != e {
+		t.Fatal(g, e)
+	}
+
+	var div, trap int
+	for _, v := range f.Body {
+		switch x := v.(type) {
+		case *Mul, *Sub, *Neg, *Lt, *Jz:
+			t.Fatalf("%v", v)
+		case *Add:
+			if x.Overflow == OverflowTrap {
+				trap++
+			}
+		case *Div:
+			div++
+		}
+	}
+	if div != 1 || trap != 1 {
+		t.Fatal(div, trap)
+	}
+}
+
+func TestSwitchCases(t *testing.T) {
+	tp := TypeID(dict.SID("*int8"))
+	sw := func(t TypeID, v Value) []Operation {
+		return []Operation{
+			&Switch{Default: Label{Number: 10}, Labels: []Label{{Number: 11}}, TypeID: t, Values: []Value{v}},
+			&Label{Number: 11},
+			&Label{Number: 10},
+		}
+	}
+	s := &StringConst{TypeID: tp, Value: StringID(dict.SID("foo"))}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{append([]Operation{&Const32{TypeID: idInt8, Value: 1}}, sw(idInt8, &Int32Value{Value: 1})...), true},
+		{append([]Operation{&Const32{TypeID: TypeID(dict.SID("uint16")), Value: 1}}, sw(TypeID(dict.SID("uint16")), &Int32Value{Value: 1})...), true},
+		{append([]Operation{&Const32{TypeID: idInt8, Value: 1}}, sw(idInt8, &Int64Value{Value: 1})...), false},
+		{append([]Operation{s}, sw(tp, &StringValue{StringID: StringID(dict.SID("foo"))})...), true},
+		{append([]Operation{s}, sw(tp, &AddressValue{Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("x"))})...), true},
+		{append([]Operation{s}, sw(tp, &Int32Value{})...), false},
+		{append([]Operation{&Const32{TypeID: idInt32, Value: 1}}, sw(idInt32, &StringValue{})...), false},
+		{append([]Operation{&Const32{TypeID: idUint32, Value: 1}}, sw(idUint32, &Uint32Value{Value: 1})...), true},
+		{append([]Operation{&Const64{TypeID: idUint64, Value: 1}}, sw(idUint64, &Uint64Value{Value: 1})...), true},
+		{append([]Operation{&Const32{TypeID: idUint32, Value: 1}}, sw(idUint32, &Uint64Value{Value: 1})...), false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	x := NameID(dict.SID("x"))
+	a := &AddressValue{Index: -1, Linkage: InternalLinkage, NameID: x}
+	if _, err := LinkLib([]Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: x, TypeID: idInt8}},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("f")), TypeID: TypeID(dict.SID("func()"))},
+			Body:       append(sw(tp, a), &Return{}),
+		},
+	}); err != nil {
 		t.Fatal(err)
 	}
 
-	if g, e := PrettyString(in), PrettyString(out); g != e {
-		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	if a.Index < 0 {
+		t.Fatal(a.Index)
+	}
+}
+
+func TestUnsignedValues(t *testing.T) {
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("u")), TypeID: idUint64},
+			Value:      &Uint64Value{Value: math.MaxUint64},
+		},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("v")), TypeID: TypeID(dict.SID("[2]uint32"))},
+			Value:      &CompositeValue{Values: []Value{&Uint32Value{Value: math.MaxUint32}, &Uint32Value{Value: 1}}},
+		},
+	}
+	for _, v := range objs {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, objs); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := Parse("u.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(in), PrettyString(objs); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if _, err := LinkLib(objs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFree(t *testing.T) {
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{&New{TypeID: idPint32}, &Free{TypeID: idPint32}}, true},
+		{[]Operation{&Const32{TypeID: idInt32, Value: 42}, &New{Size: idInt32, TypeID: idPint32}, &Free{TypeID: idPint32}}, true},
+		{[]Operation{&New{Size: idInt32, TypeID: idPint32}, &Free{TypeID: idPint32}}, false},
+		{[]Operation{&Const32{TypeID: idInt32, Value: 42}, &New{Size: idInt64, TypeID: idPint32}, &Free{TypeID: idPint32}}, false},
+		{[]Operation{&New{TypeID: idInt32}, &Free{TypeID: idInt32}}, false},
+		{[]Operation{&New{TypeID: idPint32}, &Free{TypeID: idInt32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestZero(t *testing.T) {
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{&New{TypeID: idPint32}, &Zero{TypeID: idInt32}, &Free{TypeID: idPint32}}, true},
+		{[]Operation{&New{TypeID: idPint32}, &Zero{TypeID: idInt64}, &Free{TypeID: idPint32}}, false},
+		{[]Operation{&Zero{TypeID: idInt32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestQualifiers(t *testing.T) {
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&Load{Volatile: true, TypeID: idPint32},
+			&Store{Atomic: true, Restrict: true, TypeID: idInt32},
+			&Copy{Restrict: true, TypeID: idInt32},
+			&PostIncrement{Delta: 1, TypeID: idInt32, Volatile: true},
+			&PreIncrement{Atomic: true, Delta: -1, TypeID: idInt32, Volatile: true},
+			&Load{TypeID: idPint32},
+			&Return{},
+		},
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := buf.String(); !strings.Contains(s, "load(volatile)") || !strings.Contains(s, "store(atomic,restrict)") || !strings.Contains(s, "-1 atomic volatile") {
+		t.Fatal(s)
+	}
+
+	objs, err := Parse("q.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(objs[0].(*FunctionDefinition).Body), PrettyString(f.Body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	p, err := Pack(f.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := p.Unpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(body), PrettyString(f.Body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if err := testVerifyOps(&New{TypeID: idPint32}, &Const32{TypeID: idInt32}, &Store{Atomic: true, Bits: 3, TypeID: idInt32}, &Drop{TypeID: idInt32}); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	d := &Const64{TypeID: idInt64, Value: 1}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{d, d, c, &Select{TypeID: idInt64}, &Drop{TypeID: idInt64}}, true},
+		{[]Operation{c, c, c, &Select{TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{d, c, c, &Select{TypeID: idInt64}, &Drop{TypeID: idInt64}}, false},
+		{[]Operation{d, d, d, &Select{TypeID: idInt64}, &Drop{TypeID: idInt64}}, false},
+		{[]Operation{d, c, &Select{TypeID: idInt64}, &Drop{TypeID: idInt64}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestVector(t *testing.T) {
+	vt, err := types.Type(TypeID(dict.SID("<4xint32>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := vt.(*VectorType).Items, int64(4); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := testModel.Sizeof(vt), int64(16); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := testModel.Alignof(vt), 16; g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, v := range []string{"<0xint32>", "<4x*int32>", "<4xint32"} {
+		if _, err := types.Type(TypeID(dict.SID(v))); err == nil {
+			t.Fatal(i, v)
+		}
+	}
+
+	load := func(s string) []Operation {
+		p := TypeID(dict.SID("*" + s))
+		return []Operation{&New{TypeID: p}, &Load{TypeID: p}}
+	}
+	vi := TypeID(dict.SID("<4xint32>"))
+	vf := TypeID(dict.SID("<4xfloat32>"))
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{append(append(load("<4xint32>"), load("<4xint32>")...), &Add{TypeID: vi}, &Drop{TypeID: vi}), true},
+		{append(append(load("<4xint32>"), load("<4xint32>")...), &Mul{Overflow: OverflowTrap, TypeID: vi}, &Drop{TypeID: vi}), true},
+		{append(append(load("<4xint32>"), load("<4xint32>")...), &Xor{TypeID: vi}, &Drop{TypeID: vi}), true},
+		{append(append(load("<4xfloat32>"), load("<4xfloat32>")...), &Sub{TypeID: vf}, &Drop{TypeID: vf}), true},
+		{append(append(load("<4xfloat32>"), load("<4xfloat32>")...), &And{TypeID: vf}, &Drop{TypeID: vf}), false},
+		{append(append(load("<4xint32>"), load("<4xfloat32>")...), &Add{TypeID: vi}, &Drop{TypeID: vi}), false},
+		{append(append(load("<4xint32>"), load("<4xint32>")...), &Div{TypeID: vi}, &Drop{TypeID: vi}), false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	u := &Const32{TypeID: idUint32, Value: 1}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{c, c, &Add{Overflow: OverflowWrap, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, c, &Sub{Overflow: OverflowTrap, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, c, &Mul{Overflow: Overflow(42), TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{c, &Neg{Overflow: OverflowTrap, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{u, &Neg{TypeID: idUint32}, &Drop{TypeID: idUint32}}, true},
+		{[]Operation{u, &Neg{Overflow: OverflowWrap, TypeID: idUint32}, &Drop{TypeID: idUint32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+	if g, e := (&Add{Overflow: OverflowWrap, TypeID: idInt32}).String(), "add(wrap)"; !strings.Contains(g, e) {
+		t.Fatalf("%q %q", g, e)
+	}
+}
+
+func TestDivMode(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{c, c, &Div{Mode: DivZero, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, c, &Rem{Mode: DivTrap, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, c, &Rem{Mode: DivMode(42), TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	div := &Div{TypeID: idInt32}
+	rem := &Rem{Mode: DivPanic, TypeID: idInt32}
+	SetDivMode([]Object{&DataDefinition{}, &FunctionDefinition{Body: []Operation{div, rem}}}, DivZero)
+	if g, e := div.Mode, DivZero; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := rem.Mode, DivPanic; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestNested(t *testing.T) {
+	n := NameID(dict.SID("nested"))
+	tn := TypeID(dict.SID("func()"))
+	tpn := TypeID(dict.SID("*func()"))
+	nested := &FunctionDefinition{
+		ObjectBase:  ObjectBase{Linkage: InternalLinkage, NameID: n, TypeID: tn},
+		Body:        []Operation{&BeginScope{}, &Chain{TypeID: idPint32}, &Drop{TypeID: idPint32}, &Return{}, &EndScope{}},
+		StaticChain: idPint32,
+	}
+	if err := nested.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	closure := &Closure{Chain: idPint32, Index: -1, NameID: n, TypeID: tpn}
+	ops := []Operation{&Variable{Address: true, TypeID: idPint32}, closure, &Drop{TypeID: tpn}}
+	if err := testVerifyOps(ops...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testVerifyOps(&Chain{TypeID: idPint32}, &Drop{TypeID: idPint32}); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	body := testBody(0)
+	f := &FunctionDefinition{
+		Body:       append(append(append([]Operation(nil), body[:2]...), ops...), body[2:]...),
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}
+	out, err := LinkLib([]Object{nested, f})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if closure.Index < 0 || out[closure.Index] != nested {
+		t.Fatal(closure.Index)
+	}
+}
+
+func TestVerifyData(t *testing.T) {
+	i32 := &Int32Value{Value: 42}
+	f := &Float64Value{Value: 42}
+	for i, v := range []struct {
+		t  string
+		v  Value
+		ok bool
+	}{
+		{"*int8", &StringValue{StringID: StringID(dict.SID("abc"))}, true},
+		{"[0]int8", nil, true},
+		{"[1]int32", &CompositeValue{Values: []Value{&DesignatedValue{Index: 1, Value: i32}}}, false},
+		{"[2]int32", &CompositeValue{Values: []Value{&DesignatedValue{Index: 1, Value: i32}}}, true},
+		{"[2]int32", &CompositeValue{Values: []Value{i32, i32, i32}}, false},
+		{"[2]uint16", &WideStringValue{Value: []rune("abc")}, false},
+		{"[3]int8", &StringValue{StringID: StringID(dict.SID("abc"))}, true},
+		{"[3]int8", &StringValue{StringID: StringID(dict.SID("abcd"))}, false},
+		{"[3]int8", &StringValue{StringID: StringID(dict.SID("abcd")), Offset: 1}, true},
+		{"[3]uint16", &WideStringValue{Value: []rune("abc")}, true},
+		{"float64", i32, false},
+		{"int32", &CompositeValue{Values: []Value{i32}}, true},
+		{"int32", &DesignatedValue{Value: i32}, false},
+		{"int32", f, false},
+		{"int32", i32, true},
+		{"struct{a int32,b float64}", &CompositeValue{Values: []Value{&DesignatedValue{Index: 1, Value: f}}}, true},
+		{"struct{a int32,b float64}", &CompositeValue{Values: []Value{i32, f}}, true},
+		{"struct{a int32,b float64}", &CompositeValue{Values: []Value{i32, i32}}, false},
+		{"union{a int32,b float64}", &CompositeValue{Values: []Value{&DesignatedValue{Index: 1, Value: f}}}, true},
+		{"union{a int32,b float64}", &CompositeValue{Values: []Value{i32, f}}, false},
+	} {
+		d := &DataDefinition{ObjectBase: ObjectBase{TypeID: TypeID(dict.SID(v.t))}, Value: v.v}
+		if err := d.Verify(); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestOffsetof(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := types.MustType(TypeID(dict.SID("struct{a int8,b [3]struct{c int8,d int32},e union{f int8,g [2]int16}}")))
+	for i, v := range []struct {
+		path []int
+		off  int64
+		ok   bool
+	}{
+		{nil, 0, true},
+		{[]int{0}, 0, true},
+		{[]int{1}, 4, true},
+		{[]int{1, 2}, 20, true},
+		{[]int{1, 2, 1}, 24, true},
+		{[]int{2}, 28, true},
+		{[]int{2, 1, 1}, 30, true},
+		{[]int{3}, 0, false},
+		{[]int{1, 3}, 0, false},
+		{[]int{0, 0}, 0, false},
+	} {
+		off, err := m.Offsetof(s, v.path...)
+		if (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+
+		if g, e := off, v.off; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestFrameInfo(t *testing.T) {
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt8},
+			&VariableDeclaration{Index: 1, TypeID: idInt64},
+			&VariableDeclaration{Index: 2, TypeID: TypeID(dict.SID("[3]int16"))},
+			&Result{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 1},
+			&Const64{TypeID: idInt64, Value: 2},
+			&Drop{TypeID: idInt64},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	m := MemoryModel{Pointer: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8}}
+	for k, v := range testModel {
+		m[k] = v
+	}
+	fl, err := FrameInfo(f, m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprintf("%+v", fl), "{Align:8 MaxStack:3 MaxStackSize:20 Size:24 Variables:[0 8 16]}"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+}
+
+func TestBitFieldLayout(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := types.MustType(TypeID(dict.SID("struct{a int32:3,b int32:30,c int8,d uint8:4,e uint8:6}"))).(*StructOrUnionType)
+	if g, e := fmt.Sprint(s.Bits), "[3 30 0 4 6]"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	if g, e := fmt.Sprint(m.Layout(s)), "[{0 4 0 0 3} {4 4 0 0 30} {8 1 0 0 0} {9 1 0 0 4} {10 1 1 0 6}]"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	if g, e := m.Sizeof(s), int64(12); g != e {
+		t.Fatal(g, e)
+	}
+
+	u := types.MustType(TypeID(dict.SID("union{a int32:3,b int8}"))).(*StructOrUnionType)
+	if g, e := m.Sizeof(u), int64(4); g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, v := range []string{
+		"struct{a float32:3}",
+		"struct{a int32:0}",
+		"struct{a int32:65}",
+	} {
+		if _, err := types.Type(TypeID(dict.SID(v))); err == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestMemoryModelFunction(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := types.MustType(TypeID(dict.SID("func()")))
+	m[Pointer] = MemoryModelItem{Align: 2, Size: 2, StructAlign: 2}
+	delete(m, Function)
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := m.Sizeof(fn), int64(2); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m.Alignof(fn), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	m[Function] = MemoryModelItem{Align: 4, Size: 4, StructAlign: 4}
+	if g, e := m.Sizeof(fn), int64(4); g != e {
+		t.Fatal(g, e)
+	}
+
+	m[Function] = MemoryModelItem{Align: 3, Size: 4, StructAlign: 4}
+	if err := m.Validate(); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	delete(m, Int8)
+	if err := m.Validate(); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	pos := token.Position{Filename: "a.c", Line: 1, Column: 2}
+	in := NameID(dict.SID("in"))
+	objs := Objects{
+		{
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: in, TypeID: TypeID(dict.SID("struct{a complex128,b [2]int32}")), Position: pos},
+				Value: &CompositeValue{Values: []Value{
+					&Complex128Value{Value: complex(1, math.Inf(-1))},
+					&DesignatedValue{Index: 1, Value: &CompositeValue{Values: []Value{&Int32Value{Value: 1}}}},
+				}},
+			},
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: WeakLinkage, NameID: NameID(dict.SID("p")), TypeID: idPint32},
+				Value:      &AddressValue{Index: -1, Linkage: InternalLinkage, NameID: in, Offset: 4},
+			},
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("w")), TypeID: TypeID(dict.SID("[3]int32"))},
+				Value:      &WideStringValue{Value: []rune("ab")},
+			},
+		},
+		{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: pos},
+				Body: append(testBody(1),
+					&Add{Overflow: OverflowTrap, TypeID: idInt32},
+					&Div{Mode: DivZero, TypeID: idInt32},
+					&Switch{
+						Default:  Label{Number: 1},
+						Labels:   []Label{{NameID: NameID(dict.SID("L"))}},
+						TypeID:   idInt32,
+						Values:   []Value{&Int32Value{Value: 42}},
+						Position: pos,
+					},
+				),
+			},
+		},
+	}
+	b, err := json.Marshal(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o Objects
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(o, objs) {
+		t.Fatalf("%s", b)
+	}
+
+	for _, v := range []string{`"Kind":"Add"`, `"TypeID":"*int32"`, `"Linkage":"WeakLinkage"`, `"Overflow":"OverflowTrap"`} {
+		if !bytes.Contains(b, []byte(v)) {
+			t.Fatalf("%s\n%s", v, b)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	pos := token.Position{Filename: "a.c", Line: 1, Column: 2}
+	g := NameID(dict.SID("g"))
+	in := NameID(dict.SID("in"))
+	ts := TypeID(dict.SID("struct{a int32,b [2]float64}"))
+	tps := TypeID(dict.SID("*struct{a int32,b [2]float64}"))
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: in, TypeID: ts, TypeName: NameID(dict.SID("S")), Position: pos},
+			Value: &CompositeValue{Values: []Value{
+				&Int32Value{Value: -1},
+				&DesignatedValue{Index: 1, Value: &CompositeValue{Values: []Value{&Float64Value{Value: 1.5}}}},
+			}},
+		},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: g, TypeID: idPint32},
+			Value:      &AddressValue{Index: -1, Linkage: InternalLinkage, NameID: in, Offset: 4},
+		},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: TypeID(dict.SID("[4]int8"))},
+			Value:      &StringValue{StringID: StringID(dict.SID("a\tb"))},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: pos},
+			Body: append(testBody(2)[:2],
+				&BeginScope{Value: true, Position: pos},
+				&Global{Address: true, Index: -1, Linkage: InternalLinkage, NameID: in, TypeID: tps, Position: pos},
+				&Field{Address: true, Index: 1, TypeID: tps},
+				&Const32{LOp: true, TypeID: idInt32, Value: 1},
+				&Element{Address: true, IndexType: idInt32, Neg: true, TypeID: TypeID(dict.SID("*[2]float64"))},
+				&Drop{Comma: true, TypeID: TypeID(dict.SID("*float64"))},
+				&Const{TypeID: TypeID(dict.SID("float64")), Value: &Float64Value{Value: 2.5}},
+				&Const64{TypeID: idInt64, Value: 1 << 40},
+				&Convert{TypeID: idInt64, Result: TypeID(dict.SID("float64"))},
+				&Sub{Overflow: OverflowWrap, TypeID: TypeID(dict.SID("float64"))},
+				&Drop{TypeID: TypeID(dict.SID("float64"))},
+				&StringConst{TypeID: TypeID(dict.SID("*int8")), Value: StringID(dict.SID("x\"y"))},
+				&Drop{TypeID: TypeID(dict.SID("*int8"))},
+				&Variable{TypeID: idInt32},
+				&Switch{
+					Default:  Label{Number: 1},
+					Labels:   []Label{{NameID: NameID(dict.SID("L"))}},
+					TypeID:   idInt32,
+					Values:   []Value{&Int32Value{Value: 42}},
+					Position: pos,
+				},
+				&Label{NameID: NameID(dict.SID("L"))},
+				&Variable{Address: true, TypeID: idPint32},
+				&PreIncrement{Delta: 1, TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Label{Number: 1, LAnd: true},
+				&Jmp{Number: 2},
+				&Label{Number: 2},
+				&EndScope{Value: true},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: 42},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			),
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, objs); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := buf.String()
+	out, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, exp)
+	}
+
+	buf.Reset()
+	if err := WriteAssembly(&buf, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), exp; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if g, e := PrettyString(out), PrettyString(objs); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if _, err := Parse("test", []byte("func\tExternalLinkage, f, func(), (), ()\n\tfoo\tint32\t; -\n")); err == nil || !strings.Contains(err.Error(), "test:2:") {
+		t.Fatal(err)
+	}
+}
+
+func TestGobTypeID(t *testing.T) {
+	const c = "The quick brown fox type"
+	buf := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(buf)
+	in := TypeID(dict.SID(c))
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(c)) {
+		t.Fatal("TypeID gob encoding fail")
+	}
+
+	out := TypeID(-1)
+	dec := gob.NewDecoder(buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := in, out; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestGobNameID(t *testing.T) {
+	const c = "The quick brown fox name"
+	buf := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(buf)
+	in := NameID(dict.SID(c))
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(c)) {
+		t.Fatal("NameID gob encoding fail")
+	}
+
+	out := NameID(-1)
+	dec := gob.NewDecoder(buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := in, out; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestGobStringID(t *testing.T) {
+	const c = "The quick brown fox string"
+	buf := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(buf)
+	in := StringID(dict.SID(c))
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(c)) {
+		t.Fatal("NameID gob encoding fail")
+	}
+
+	out := StringID(-1)
+	dec := gob.NewDecoder(buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := in, out; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestObjectsGob(t *testing.T) {
+	out := Objects{
+		[]Object{
+			&FunctionDefinition{
+				Body: []Operation{
+					&Result{
+						Address: true,
+					},
+				},
+			},
+		},
+	}
+
+	f := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(out); err != nil {
+		t.Fatal(err)
+	}
+
+	var in Objects
+	dec := gob.NewDecoder(f)
+	if err := dec.Decode(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(in), PrettyString(out); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	out := Objects{
+		[]Object{
+			&FunctionDefinition{
+				Body: []Operation{
+					&Result{
+						Address: true,
+					},
+				},
+			},
+		},
+	}
+
+	f, err := ioutil.TempFile("", "test-ir-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Objects(out).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+
+	var in Objects
+	if _, err := in.ReadFrom(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(in), PrettyString(out); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	p := NewProgram()
+	const nm = "The quick brown fox program"
+	out := Objects{
+		[]Object{
+			&DataDefinition{
+				ObjectBase: ObjectBase{
+					Linkage: ExternalLinkage,
+					NameID:  NameID(p.Dict.SID(nm)),
+					TypeID:  TypeID(p.Dict.SID("int32")),
+				},
+			},
+		},
+	}
+	p.Objects = out
+	buf := bytes.NewBuffer(nil)
+	if _, err := p.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := append([]byte(nil), buf.Bytes()...)
+	var in Objects
+	if _, err := in.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := in[0][0].(*DataDefinition)
+	if g, e := d.NameID.String(), nm; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	if g, e := d.TypeID, idInt32; g != e {
+		t.Fatal(g, e)
+	}
+
+	q := NewProgram()
+	if _, err := q.ReadFrom(bytes.NewBuffer(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	d = q.Objects[0][0].(*DataDefinition)
+	if g, e := string(q.Dict.S(int(d.NameID))), nm; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	// Objects written using the global dictionary.
+	buf.Reset()
+	if _, err := in.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	q = NewProgram()
+	if _, err := q.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d = q.Objects[0][0].(*DataDefinition)
+	if g, e := string(q.Dict.S(int(d.NameID))), nm; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	if g, e := string(q.Dict.S(int(d.TypeID))), "int32"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+}
+
+// countingDict counts the strings registered in it.
+type countingDict struct {
+	*Dict
+	n int
+}
+
+func (d *countingDict) ID(b []byte) int {
+	d.n++
+	return d.Dict.ID(b)
+}
+
+func (d *countingDict) SID(s string) int { return d.ID([]byte(s)) }
+
+func TestProgramIntern(t *testing.T) {
+	objs := func() Objects {
+		return Objects{[]Object{&FunctionDefinition{Body: testBody(100), ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType}}}}
+	}
+	var global bytes.Buffer
+	if _, err := objs().WriteTo(&global); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same objects having the identifiers registered in a private Dict.
+	p := NewProgram()
+	p.Objects = objs()
+	distinct := map[int]bool{}
+	remapIDs(reflect.ValueOf(p.Objects), map[clonePtr]bool{}, func(id int) int {
+		if id == 0 {
+			return 0
+		}
+
+		distinct[id] = true
+		return p.Dict.ID(dict.S(id))
+	})
+	var private bytes.Buffer
+	if _, err := p.WriteTo(&private); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range []*bytes.Buffer{&global, &private} {
+		d := &countingDict{Dict: NewDict()}
+		var in Objects
+		if _, err := in.readFrom(v, d, HostTarget()); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := d.n, len(distinct); g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	body := testBody(10)
+	v := NewVerifier()
+	for i := 0; i < 3; i++ {
+		f := &FunctionDefinition{
+			Body:       append([]Operation(nil), body...),
+			ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+		}
+		if i == 1 {
+			f.Body[len(f.Body)-4] = &Drop{TypeID: idInt64}
+		}
+		switch err := v.Verify(f); {
+		case i == 1 && err == nil:
+			t.Fatal("unexpected success")
+		case i != 1 && err != nil:
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestLinkLib(t *testing.T) {
+	units := testUnits(3, 5)
+	g := NameID(dict.SID("g"))
+	tg := TypeID(dict.SID("func()"))
+	tpg := TypeID(dict.SID("*func()"))
+	units[0] = append(units[0],
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: g, TypeID: tg},
+			Body:       []Operation{&Return{}},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: g, TypeID: tpg},
+				&Arguments{},
+				&CallFP{TypeID: tpg},
+				&Convert{TypeID: idInt32, Result: idInt32},
+				&Return{},
+			},
+		},
+	)
+	out, err := LinkLib(units...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(out), 3*5*2+2; g != e {
+		t.Fatal(g, e)
+	}
+
+	var f *FunctionDefinition
+	for _, v := range out {
+		if x, ok := v.(*FunctionDefinition); ok && x.NameID == idMain {
+			f = x
+		}
+	}
+	if g, e := len(f.Body), 3; g != e {
+		t.Fatal(g, e)
+	}
+
+	call, ok := f.Body[1].(*Call)
+	if !ok {
+		t.Fatalf("%T", f.Body[1])
+	}
+
+	if g, e := out[call.Index].Base().NameID, g; g != e {
+		t.Fatal(g, e)
+	}
+
+	if call.TypeID != tg || f.Body[0].(*Arguments).FunctionPointer {
+		t.Fatal(call.TypeID, f.Body[0])
+	}
+
+	for _, v := range out {
+		if x, ok := v.(*DataDefinition); ok {
+			if g, e := out[x.Value.(*AddressValue).Index].Base().Linkage, ExternalLinkage+InternalLinkage-x.Linkage; g != e {
+				t.Fatal(x.NameID, g, e)
+			}
+		}
+	}
+}
+
+func TestLinkOnce(t *testing.T) {
+	g := NameID(dict.SID("g"))
+	d := NameID(dict.SID("d"))
+	tg := TypeID(dict.SID("func()"))
+	unit := func(l Linkage, v int32) []Object {
+		return []Object{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: l, NameID: g, TypeID: tg},
+				Body:       []Operation{&Return{}},
+			},
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: l, NameID: d, TypeID: idInt32},
+				Value:      &Int32Value{Value: v},
+			},
+		}
+	}
+	for _, test := range []struct {
+		l   []Linkage
+		exp int32
+	}{
+		{[]Linkage{OnceLinkage, OnceLinkage, OnceLinkage}, 0},
+		{[]Linkage{OnceLinkage, ExternalLinkage, OnceLinkage}, 1},
+		{[]Linkage{ExternalLinkage, OnceLinkage}, 0},
+		{[]Linkage{WeakLinkage, WeakLinkage}, 0},
+		{[]Linkage{WeakLinkage, ExternalLinkage, WeakLinkage}, 1},
+		{[]Linkage{ExternalLinkage, WeakLinkage}, 0},
+	} {
+		var units [][]Object
+		for i, l := range test.l {
+			units = append(units, unit(l, int32(i)))
+		}
+		out, err := LinkLib(units...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := len(out), 3; g != e { // g, d, main
+			t.Fatal(test.l, g, e)
+		}
+
+		for _, v := range out {
+			if x, ok := v.(*DataDefinition); ok {
+				if g, e := x.Value.(*Int32Value).Value, test.exp; g != e {
+					t.Fatal(test.l, g, e)
+				}
+			}
+		}
+	}
+}
+
+func TestLinkWeakExternal(t *testing.T) {
+	w := NameID(dict.SID("w"))
+	p := &AddressValue{Index: -1, Linkage: WeakExternalLinkage, NameID: w}
+	unit := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("p")), TypeID: idPint32},
+			Value:      p,
+		},
+	}
+	if _, err := LinkLib(unit); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := p.Index, -1; g != e {
+		t.Fatal(g, e)
+	}
+
+	p.Index = -1
+	def := []Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: w, TypeID: idInt32}},
+	}
+	if _, err := LinkLib(unit, def); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Index < 0 {
+		t.Fatal(p.Index)
+	}
+}
+
+func TestLinkCommon(t *testing.T) {
+	d := NameID(dict.SID("d"))
+	ta := TypeID(dict.SID("[4]int32"))
+	type def struct {
+		l Linkage
+		t TypeID
+		v Value
+	}
+	for i, test := range []struct {
+		defs []def
+		exp  TypeID
+		val  bool
+		err  bool
+	}{
+		{[]def{{CommonLinkage, idInt32, nil}, {CommonLinkage, idInt32, nil}}, idInt32, false, false},
+		{[]def{{CommonLinkage, idInt32, nil}, {CommonLinkage, ta, nil}}, ta, false, false},
+		{[]def{{CommonLinkage, ta, nil}, {CommonLinkage, idInt32, nil}}, ta, false, false},
+		{[]def{{CommonLinkage, ta, nil}, {ExternalLinkage, idInt32, nil}}, idInt32, false, false},
+		{[]def{{CommonLinkage, ta, nil}, {CommonLinkage, idInt32, &Int32Value{Value: 42}}}, idInt32, true, false},
+		{[]def{{ExternalLinkage, idInt32, &Int32Value{Value: 42}}, {CommonLinkage, ta, nil}}, idInt32, true, false},
+		{[]def{{WeakLinkage, idInt32, &Int32Value{Value: 42}}, {CommonLinkage, ta, nil}}, ta, false, false},
+		{[]def{{CommonLinkage, idInt32, &Int32Value{Value: 42}}, {CommonLinkage, idInt32, &Int32Value{Value: 24}}}, 0, false, true},
+		{[]def{{ExternalLinkage, idInt32, &Int32Value{Value: 42}}, {CommonLinkage, idInt32, &Int32Value{Value: 24}}}, 0, false, true},
+	} {
+		var units [][]Object
+		for _, v := range test.defs {
+			units = append(units, []Object{
+				&DataDefinition{ObjectBase: ObjectBase{Linkage: v.l, NameID: d, TypeID: v.t}, Value: v.v},
+			})
+		}
+		out, err := LinkLib(units...)
+		if test.err {
+			if err == nil {
+				t.Fatal(i, "unexpected success")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		var x *DataDefinition
+		for _, v := range out {
+			if v, ok := v.(*DataDefinition); ok && v.NameID == d {
+				x = v
+			}
+		}
+		if x == nil {
+			t.Fatal(i, "missing definition")
+		}
+
+		if g, e := x.TypeID, test.exp; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g, e := x.Value != nil, test.val; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	out, err := Objects{
+		{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: idInt32}}},
+	}.Merge(Objects{
+		{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: ta}}},
+	}, ConflictError, Target{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(out), 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := out[0].Base().TypeID, ta; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestLinkCommonTarget(t *testing.T) {
+	d := NameID(dict.SID("d"))
+	tp := TypeID(dict.SID("*int8"))
+	ta := TypeID(dict.SID("[6]int8"))
+	units := [][]Object{
+		{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: tp}}},
+		{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: d, TypeID: ta}}},
+	}
+	for i, test := range []struct {
+		target Target
+		exp    TypeID
+	}{
+		{Target{OS: "linux", Arch: "386"}, ta},
+		{Target{OS: "linux", Arch: "amd64"}, tp},
+		{Target{OS: "foo", Arch: "bar"}, 0},
+	} {
+		out, err := LinkLibWithOptions(&LinkLibOptions{Target: test.target}, units...)
+		if test.exp == 0 {
+			if err == nil {
+				t.Fatal(i, "unexpected success")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		var x *DataDefinition
+		for _, v := range out {
+			if v, ok := v.(*DataDefinition); ok && v.NameID == d {
+				x = v
+			}
+		}
+		if x == nil {
+			t.Fatal(i, "missing definition")
+		}
+
+		if g, e := x.TypeID, test.exp; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		merged, err := Objects{units[0]}.Merge(Objects{units[1]}, ConflictError, test.target)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := merged[0].Base().TypeID, test.exp; len(merged) != 1 || g != e {
+			t.Fatal(i, len(merged), g, e)
+		}
+	}
+
+	var l Linker
+	for _, v := range units {
+		if err := l.AddUnit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, test := range []struct {
+		target Target
+		exp    TypeID
+	}{
+		{Target{OS: "linux", Arch: "386"}, ta},
+		{Target{OS: "linux", Arch: "amd64"}, tp},
+	} {
+		out, err := l.FinalizeLib(&LinkLibOptions{Target: test.target})
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		for _, v := range out {
+			if v, ok := v.(*DataDefinition); ok && v.NameID == d {
+				if g, e := v.TypeID, test.exp; g != e {
+					t.Fatal(i, g, e)
+				}
+			}
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	arr := NameID(dict.SID("arr"))
+	a := NameID(dict.SID("a"))
+	b := NameID(dict.SID("b"))
+	g := &Global{Address: true, Index: -1, Linkage: ExternalLinkage, NameID: b, TypeID: idPint32}
+	p := &AddressValue{Index: -1, Linkage: ExternalLinkage, NameID: b, Offset: 4}
+	unit := []Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: arr, TypeID: TypeID(dict.SID("[4]int32"))}},
+		NewAliasDefinition(token.Position{}, a, 0, idInt32, ExternalLinkage, arr, 4),
+		NewAliasDefinition(token.Position{}, b, 0, idInt32, ExternalLinkage, a, 4),
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("p")), TypeID: idPint32},
+			Value:      p,
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("f")), TypeID: TypeID(dict.SID("func()"))},
+			Body:       []Operation{g, &Drop{TypeID: idPint32}, &Return{}},
+		},
+	}
+	for _, v := range unit {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := LinkLib(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Index < 0 || out[g.Index].Base().NameID != arr {
+		t.Fatal(g.Index)
+	}
+
+	if g, e := g.Offset, uintptr(8); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := p.Index, g.Index; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := p.Offset, uintptr(12); g != e {
+		t.Fatal(g, e)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, unit[1:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := Parse("a.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x := objs[0].(*AliasDefinition); x.Target != arr || x.Offset != 4 {
+		t.Fatal(buf.String())
+	}
+
+	_, err = LinkLib([]Object{
+		NewAliasDefinition(token.Position{}, a, 0, idInt32, ExternalLinkage, b, 0),
+		NewAliasDefinition(token.Position{}, b, 0, idInt32, ExternalLinkage, a, 0),
+		NewAliasDefinition(token.Position{}, arr, 0, idInt32, ExternalLinkage, NameID(dict.SID("u")), 0),
+	})
+	x, ok := err.(LinkError)
+	if !ok || len(x) != 2 {
+		t.Fatalf("%T %v", err, err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	data := func(nm string, v Value) []Object {
+		return []Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID(nm)), TypeID: idPint32}, Value: v}}
+	}
+	a := NewArchive()
+	for _, v := range []struct {
+		nm   string
+		objs []Object
+	}{
+		{"d.o", data("d", &AddressValue{Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("e"))})},
+		{"e.o", data("e", nil)},
+		{"f.o", data("f", nil)},
+		{"d2.o", data("d", nil)},
+	} {
+		if err := a.Add(v.nm, v.objs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := a.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewArchive()
+	if _, err := b.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := b.Len(), 4; g != e {
+		t.Fatal(g, e)
+	}
+
+	if i, ok := b.Lookup(NameID(dict.SID("d"))); !ok || i != 0 {
+		t.Fatal(i, ok)
+	}
+
+	start := []Object{
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(idStart), TypeID: TypeID(dict.SID("func()"))},
+			Body: []Operation{
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: idPint32},
+				&Drop{TypeID: idPint32},
+				&Return{},
+			},
+		},
+	}
+	out, err := b.LinkMain(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, v := range out {
+		names = append(names, v.Base().NameID.String())
+	}
+	if g, e := strings.Join(names, " "), "_start d e"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+}
+
+func TestLinkError(t *testing.T) {
+	f := NameID(dict.SID("f"))
+	tf := TypeID(dict.SID("func()"))
+	pos := token.Position{Filename: "a.c", Line: 1}
+	data := func(nm string) Object {
+		return &DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID(nm)), TypeID: idInt32, Position: pos},
+			Value:      &Int32Value{},
+		}
+	}
+	fn := func(ops ...Operation) Object {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: f, TypeID: tf, Position: pos},
+			Body:       append(ops, &Return{}),
+		}
+	}
+	_, err := LinkLib(
+		[]Object{
+			data("d"),
+			fn(&Global{Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("u")), TypeID: idInt32, Position: pos}),
+		},
+		[]Object{
+			data("d"),
+			fn(&Global{Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("v")), TypeID: idInt32, Position: pos}),
+		},
+	)
+	x, ok := err.(LinkError)
+	if !ok {
+		t.Fatalf("%T %v", err, err)
+	}
+
+	if g, e := len(x), 3; g != e { // d, f, u
+		t.Fatal(g, e, err)
+	}
+
+	for _, v := range x {
+		if !v.Position.IsValid() {
+			t.Fatal(v)
+		}
+	}
+	t.Log(err)
+}
+
+func TestMerge(t *testing.T) {
+	g := NameID(dict.SID("g"))
+	in := NameID(dict.SID("in"))
+	tg := TypeID(dict.SID("func()"))
+	unit := func(v int32) []Object {
+		return []Object{
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: in, TypeID: idInt32},
+				Value:      &Int32Value{Value: v},
+			},
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: g, TypeID: tg},
+				Body: []Operation{
+					&Global{Linkage: InternalLinkage, NameID: in, TypeID: idInt32},
+					&Drop{TypeID: idInt32},
+					&Return{},
+				},
+			},
+		}
+	}
+	if _, err := (Objects{unit(0)}).Merge(Objects{unit(1)}, ConflictError, Target{}); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	for _, v := range []struct {
+		policy ConflictPolicy
+		n      int
+	}{
+		{ConflictKeepFirst, 3},
+		{ConflictRename, 4},
+	} {
+		u0, u1 := unit(0), unit(1)
+		out, err := (Objects{u0}).Merge(Objects{u1}, v.policy, Target{})
+		if err != nil {
+			t.Fatal(v.policy, err)
+		}
+
+		if g, e := len(out), v.n; g != e {
+			t.Fatal(v.policy, g, e)
+		}
+
+		names := map[NameID]bool{}
+		for _, v := range out {
+			names[v.Base().NameID] = true
+		}
+		if g, e := len(names), len(out); g != e {
+			t.Fatal(v.policy, g, e)
+		}
+
+		nm := u1[0].Base().NameID
+		if nm == in {
+			t.Fatal(v.policy, nm)
+		}
+
+		if g, e := u1[1].(*FunctionDefinition).Body[0].(*Global).NameID, nm; g != e {
+			t.Fatal(v.policy, g, e)
+		}
+
+		if g, e := u1[1].Base().NameID != g, v.policy == ConflictRename; g != e {
+			t.Fatal(v.policy, g, e)
+		}
+	}
+}
+
+func TestSeedTypes(t *testing.T) {
+	c := TypeCache{}
+	if g, e := c.MustType(idInt32), (TypeCache{}).MustType(idInt32); g != e {
+		t.Fatal(g, e)
+	}
+
+	const s = "struct{a int8,b *struct{}}"
+	if err := SeedTypes(s); err != nil {
+		t.Fatal(err)
+	}
+
+	id := TypeID(dict.SID(s))
+	if g, e := c.MustType(id), (TypeCache{}).MustType(id); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(c), 0; g != e {
+		t.Fatal(g, e)
+	}
+
+	if err := SeedTypes("struct{"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestPack(t *testing.T) {
+	pos := token.Position{Filename: "a.c", Line: 42, Column: 1}
+	body := append(testBody(3),
+		&Const{TypeID: idInt32, Value: &Int32Value{Value: 42}, Position: pos},
+		&ConstC128{TypeID: idInt32, Value: 1 + 2i, Position: pos},
+		&Switch{
+			Default:  Label{Number: 2},
+			Labels:   []Label{{Number: 1}},
+			TypeID:   idInt32,
+			Values:   []Value{&Int32Value{Value: 1}},
+			Position: pos,
+		},
+		&Global{Address: true, Index: -1, Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	)
+	p, err := Pack(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := p.Len(), len(body); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(p.PositionTable), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	u, err := p.Unpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(u), fmt.Sprint(body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestOpcodes(t *testing.T) {
+	// The values of the opcodes are part of the meaning of a PackedBody.
+	for _, v := range []struct {
+		op   Operation
+		code Opcode
+	}{
+		{&Add{}, 1},
+		{&Xor{}, 57},
+		{&New{}, 58},
+		{&Select{}, 63},
+		{&Fence{}, 75},
+		{&Annotate{}, 76},
+	} {
+		if g, e := OpcodeOf(v.op), v.code; g != e {
+			t.Fatalf("%T: %v %v", v.op, g, e)
+		}
+	}
+
+	for i, v := range opcodes {
+		if i == 0 {
+			continue
+		}
+
+		if v == nil {
+			t.Fatal(i)
+		}
+
+		if g, e := OpcodeOf(v), Opcode(i); g != e {
+			t.Fatal(g, e)
+		}
+
+		if g, e := Opcode(i).String(), reflect.TypeOf(v).Elem().Name(); g != e {
+			t.Fatal(g, e)
+		}
+	}
+}
+
+func TestCompressPositions(t *testing.T) {
+	pos := token.Position{Filename: "a.c", Offset: 10, Line: 2, Column: 3}
+	pos2 := token.Position{Filename: "b.h", Offset: 20, Line: 4, Column: 5}
+	f := &FunctionDefinition{
+		Body:       testBody(1),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	f.Body = append(f.Body, &Drop{TypeID: idInt32, Position: pos}, &Drop{TypeID: idInt32, Position: pos2}, &Drop{TypeID: idInt32, Position: pos})
+	exp := fmt.Sprint(f.Body)
+	f.CompressPositions()
+	if g, e := len(f.Files), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	n := len(f.Body)
+	for i, e := range []token.Position{pos, pos2, pos} {
+		if g := f.BodyPosition(n - 3 + i); g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g := f.Body[n-3+i].Pos(); g.IsValid() {
+			t.Fatal(i, g)
+		}
+	}
+
+	f.ExpandPositions()
+	if g, e := fmt.Sprint(f.Body), exp; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if f.Files != nil || f.Positions != nil {
+		t.Fatal(f.Files, f.Positions)
+	}
+}
+
+func TestVerifyCompressedPositions(t *testing.T) {
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&BeginScope{},
+			&Result{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 42},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Jmp{Number: 0},
+			&Const32{TypeID: idInt32, Value: 24}, // Unreachable.
+			&Drop{TypeID: idInt32},               // Unreachable.
+			&Label{Number: 0},
+			&Return{},
+			&EndScope{},
+		},
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	lines := map[Operation]int{}
+	for i, op := range f.Body {
+		setPosition(op, token.Position{Filename: "a.c", Line: i + 1})
+		lines[op] = i + 1
+	}
+	f.CompressPositions()
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(f.Body), 9; g != e {
+		t.Fatal(g, e)
+	}
+
+	if f.Positions != nil {
+		t.Fatal(len(f.Positions), len(f.Body))
+	}
+
+	for ip, op := range f.Body {
+		if g, e := f.BodyPosition(ip).Line, lines[op]; g != e {
+			t.Fatal(ip, g, e)
+		}
+	}
+
+	f.CompressPositions()
+	f.Body = append(f.Body, &Return{})
+	if p := f.BodyPosition(len(f.Body) - 1); p.IsValid() {
+		t.Fatal(p)
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	var objs []Object
+	for i := 0; i < 100; i++ {
+		f := &FunctionDefinition{
+			Body:       testBody(10),
+			ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+		}
+		if i%10 == 3 {
+			f.Body[len(f.Body)-4] = &Drop{TypeID: idInt64}
+		}
+		objs = append(objs, f, &DataDefinition{})
+	}
+	if g, e := VerifyAll(objs[:6], 0), []error(nil); g != nil {
+		t.Fatal(g, e)
+	}
+
+	errs := VerifyAll(objs, 4)
+	if g, e := len(errs), len(objs); g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, err := range errs {
+		if g, e := err != nil, i%20 == 6; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	fact := NameID(dict.SID("fact"))
+	puts := NameID(dict.SID("puts"))
+	counter := NameID(dict.SID("counter"))
+	tf := TypeID(dict.SID("func(int32)int32"))
+	tpf := TypeID(dict.SID("*func(int32)int32"))
+	tputs := TypeID(dict.SID("func(*int8)int32"))
+	tpputs := TypeID(dict.SID("*func(*int8)int32"))
+	ts := TypeID(dict.SID("struct{a int32:3,b int32:5,c int64}"))
+	tps := TypeID(dict.SID("*struct{a int32:3,b int32:5,c int64}"))
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: counter, TypeID: idInt32},
+			Value:      &Int32Value{Value: 40},
+		},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: ts},
+			Value:      &CompositeValue{Values: []Value{&Int32Value{Value: -2}, &Int32Value{Value: 7}, &Int64Value{Value: 1 << 40}}},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: puts, TypeID: tputs},
+			Body:       []Operation{&Panic{}},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: fact, TypeID: tf},
+			Body: []Operation{
+				&BeginScope{},
+				&Argument{TypeID: idInt32},
+				&Const32{TypeID: idInt32, Value: 2},
+				&Lt{TypeID: idInt32},
+				&Jz{Number: 0},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: 1},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&Label{Number: 0},
+				&Result{Address: true, TypeID: idPint32},
+				&Argument{TypeID: idInt32},
+				&AllocResult{TypeID: idInt32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: fact, TypeID: tpf},
+				&Arguments{},
+				&Argument{TypeID: idInt32},
+				&Const32{TypeID: idInt32, Value: 1},
+				&Sub{TypeID: idInt32},
+				&CallFP{Arguments: 1, TypeID: tpf},
+				&Mul{TypeID: idInt32},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&AllocResult{TypeID: idInt32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: puts, TypeID: tpputs},
+				&Arguments{},
+				&StringConst{TypeID: TypeID(dict.SID("*int8")), Value: StringID(dict.SID("hello"))},
+				&CallFP{Arguments: 1, TypeID: tpputs},
+				&Drop{TypeID: idInt32},
+				&Global{Address: true, Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: tps},
+				&Field{Address: true, Index: 1, TypeID: tps},
+				&PostIncrement{BitFieldType: idInt32, BitOffset: 3, Bits: 5, Delta: 1, TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Result{Address: true, TypeID: idPint32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: counter, TypeID: idInt32},
+				&Global{Address: true, Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: tps},
+				&Field{Index: 0, TypeID: tps},
+				&Sub{TypeID: idInt32},
+				&Global{Address: true, Index: -1, Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: tps},
+				&Field{Index: 1, TypeID: tps},
+				&Add{TypeID: idInt32},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		},
+	}
+	for _, v := range objs {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := LinkLib(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	in, err := NewInterpreter(out, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	in.Externals = map[NameID]ExternalFunction{
+		puts: func(in *Interpreter, args []interface{}) ([]interface{}, error) {
+			var err error
+			s, err = in.CString(args[0].(uint64))
+			return []interface{}{uint64(len(s))}, err
+		},
+	}
+	r, err := in.Call(fact, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := int32(r[0].(uint64)), int32(3628800); g != e {
+		t.Fatal(g, e)
+	}
+
+	r, err = in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := s, "hello"; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := int32(r[0].(uint64)), int32(40-(-2)+8); g != e {
+		t.Fatal(g, e)
+	}
+
+	in.Limit = 100
+	if _, err := in.Call(fact, 20); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatal(err)
+	}
+
+	in.Limit = 0
+	if _, err := in.Call(fact); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestFunctionBuilder(t *testing.T) {
+	fact := NameID(dict.SID("fact"))
+	tf := TypeID(dict.SID("func(int32)int32"))
+	b := NewFunctionBuilder(&FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: fact, TypeID: tf}})
+	i := b.Declare(idInt32, 0, &Int32Value{Value: 1})
+	loop := b.NewLabel()
+	done := b.NewLabel()
+	b.Label(loop)
+	b.Argument(0, false)
+	b.Const32(idInt32, 2)
+	b.Lt(idInt32)
+	b.JnzTo(done)
+	b.Variable(i, true)
+	b.Variable(i, false)
+	b.Argument(0, false)
+	b.Mul(idInt32)
+	b.Store(idInt32)
+	b.Drop(idInt32)
+	b.Argument(0, true)
+	b.Argument(0, false)
+	b.Const32(idInt32, 1)
+	b.Sub(idInt32)
+	b.Store(idInt32)
+	b.Drop(idInt32)
+	b.JmpTo(loop)
+	b.Label(done)
+	b.Result(0, true)
+	b.Variable(i, false)
+	b.Store(idInt32)
+	b.Drop(idInt32)
+	f, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(f.Body), 27; g != e {
+		t.Fatal(g, e)
+	}
+
+	b = NewFunctionBuilder(&FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType}})
+	b.Result(0, true)
+	b.BeginCall(fact, ExternalLinkage, tf)
+	b.Const32(idInt32, 5)
+	b.Call(1)
+	b.Store(idInt32)
+	b.Drop(idInt32)
+	main, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := LinkLib([]Object{f, main})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	in, err := NewInterpreter(out, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := int32(r[0].(uint64)), int32(120); g != e {
+		t.Fatal(g, e)
+	}
+
+	b = NewFunctionBuilder(&FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: fact, TypeID: tf}})
+	b.Argument(1, false)
+	if _, err := b.Finish(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	b = NewFunctionBuilder(&FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: fact, TypeID: tf}})
+	b.BeginScope()
+	if _, err := b.Finish(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestFloat128Value(t *testing.T) {
+	v := new(big.Float).SetPrec(Float128Prec).SetInt64(1)
+	v.Add(v, new(big.Float).SetMantExp(big.NewFloat(1), -100))
+	objs := Objects{{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("ld")), TypeID: TypeID(dict.SID("float128"))},
+			Value:      &Float128Value{Value: v},
+		},
+	}}
+	if err := objs[0][0].Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&DataDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("ld")), TypeID: TypeID(dict.SID("float128"))},
+		Value:      &Float128Value{},
+	}).Verify(); err == nil {
+		t.Fatal("expected error")
+	}
+
+	check := func(o Objects) {
+		if g := o[0][0].(*DataDefinition).Value.(*Float128Value).Value; g.Cmp(v) != 0 {
+			t.Fatal(g, v)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(objs); err != nil {
+		t.Fatal(err)
+	}
+
+	var o Objects
+	if err := gob.NewDecoder(&buf).Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+
+	check(o)
+	b, err := json.Marshal(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o = nil
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	check(o)
+	buf.Reset()
+	if err := WriteAssembly(&buf, objs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	check(Objects{p})
+}
+
+func TestTLS(t *testing.T) {
+	tls := NameID(dict.SID("tls"))
+	unit := func(g bool, v Value) []Object {
+		return []Object{
+			&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: tls, TLS: true, TypeID: idInt32}},
+			&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("p")), TypeID: idPint32}, Value: v},
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+				Body: []Operation{
+					&BeginScope{},
+					&Result{Address: true, TypeID: idPint32},
+					&Global{Index: -1, Linkage: ExternalLinkage, NameID: tls, TLS: g, TypeID: idInt32},
+					&Store{TypeID: idInt32},
+					&Drop{TypeID: idInt32},
+					&Return{},
+					&EndScope{},
+				},
+			},
+		}
+	}
+
+	u := unit(true, nil)
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, u); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"data(tls)\t", "global(tls) "} {
+		if !strings.Contains(buf.String(), v) {
+			t.Fatalf("%s\n%s", v, buf.Bytes())
+		}
+	}
+
+	out, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(out), PrettyString(u); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if _, err := LinkLib(u); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LinkLib(unit(false, nil)); err == nil || !strings.Contains(err.Error(), "thread local storage mismatch") {
+		t.Fatal(err)
+	}
+
+	if _, err := LinkLib(unit(true, &AddressValue{Index: -1, Linkage: ExternalLinkage, NameID: tls})); err == nil || !strings.Contains(err.Error(), "not a constant") {
+		t.Fatal(err)
+	}
+
+	if _, err := LinkLib(unit(true, nil), []Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: tls, TypeID: idInt32}}}); err == nil || !strings.Contains(err.Error(), "thread local storage mismatch") {
+		t.Fatal(err)
+	}
+
+	f := unit(true, nil)[2].(*FunctionDefinition)
+	f.TLS = true
+	if err := f.Verify(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestOverflowOps(t *testing.T) {
+	f := func(op Operation, a, b int32) *FunctionDefinition {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: a},
+				&Const32{TypeID: idInt32, Value: b},
+				op,
+				&Const32{TypeID: idInt32, Value: 10},
+				&Mul{TypeID: idInt32},
+				&Add{TypeID: idInt32},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		op   Operation
+		a, b int32
+		e    int32
+	}{
+		{&AddOv{TypeID: idInt32}, 1, 2, 3},
+		{&AddOv{TypeID: idInt32}, math.MaxInt32, 1, math.MinInt32 + 10},
+		{&SubOv{TypeID: idInt32}, math.MinInt32, 1, math.MinInt32 + 9},
+		{&MulOv{TypeID: idInt32}, 1 << 16, 1 << 16, 10},
+		{&MulOv{TypeID: idInt32}, -3, 4, -12},
+	} {
+		fd := f(v.op, v.a, v.b)
+		if err := fd.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{fd}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if _, err := Parse("test", buf.Bytes()); err != nil {
+			t.Fatal(i, err)
+		}
+
+		in, err := NewInterpreter([]Object{fd}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := int32(r[0].(uint64)), v.e; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	if err := f(&AddOv{TypeID: TypeID(dict.SID("float64"))}, 1, 2).Verify(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEndianness(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	b := make([]byte, 4)
+	m.ByteOrder().PutUint32(b, 0x01020304)
+	if g, e := m.DecodeInt(b, Int32), uint64(0x01020304); g != e {
+		t.Fatalf("%#x %#x", g, e)
+	}
+
+	be := MemoryModel{}
+	for k, v := range m {
+		v.Endianness = BigEndian
+		be[k] = v
+	}
+	if err := be.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	be.EncodeInt(b, Int32, 0x01020304)
+	if g, e := b, []byte{1, 2, 3, 4}; !bytes.Equal(g, e) {
+		t.Fatal(g, e)
+	}
+
+	if g, e := be.ByteOrder().Uint32(b), uint32(0x01020304); g != e {
+		t.Fatal(g, e)
+	}
+
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("x")), TypeID: idInt32},
+			Value:      &Int32Value{Value: 0x01020304},
+		},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("f")), TypeID: TypeID(dict.SID("float64"))},
+			Value:      &Float64Value{Value: 1.5},
+		},
+	}
+	in, err := NewInterpreter(objs, be)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := in.Address(NameID(dict.SID("x")))
+	if b, err = in.Read(a, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := b, []byte{1, 2, 3, 4}; !bytes.Equal(g, e) {
+		t.Fatal(g, e)
+	}
+
+	a, _ = in.Address(NameID(dict.SID("f")))
+	if b, err = in.Read(a, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := b[0], byte(0x3f); g != e {
+		t.Fatal(g, e)
+	}
+
+	be[Int8] = MemoryModelItem{Align: 1, Size: 1, StructAlign: 1, Endianness: 2}
+	if err := be.Validate(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	m, err := NewMemoryModelFor(Target{OS: "linux", Arch: "386"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := m[Pointer].Size, uint(4); g != e {
+		t.Fatal(g, e)
+	}
+
+	if m, err = NewMemoryModelFor(Target{OS: "linux", Arch: "sparc64"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := m[Pointer].Size, uint(8); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m.ByteOrder(), binary.ByteOrder(binary.BigEndian); g != e {
+		t.Fatal(g, e)
+	}
+
+	if _, err := NewMemoryModelFor(Target{OS: "linux", Arch: "foo"}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	target := Target{OS: "plan9", Arch: "mips"}
+	if target == HostTarget() {
+		t.Skip(target)
+	}
+
+	unit := []Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("x")), TypeID: idInt32}}}
+	var buf bytes.Buffer
+	if _, err := (Objects{unit}).WriteToOptions(&buf, &WriteOptions{Target: target}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	var o Objects
+	if _, err := o.ReadFrom(bytes.NewReader(b)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := o.ReadFromTarget(bytes.NewReader(b), target); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewArchive()
+	a.Target = target
+	if err := a.Add("x", unit); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if _, err := a.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	a = &Archive{Target: target}
+	if _, err := a.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := a.Member(0); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Target = Target{}
+	if _, _, err := a.Member(0); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	x := NameID(dict.SID("x"))
+	f := NameID(dict.SID("f"))
+	ext := NameID(dict.SID("ext"))
+	weak := NameID(dict.SID("weak"))
+	tf := TypeID(dict.SID("func()"))
+	tpf := TypeID(dict.SID("*func()"))
+	u0 := []Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: x, TypeID: idInt32}},
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: NameID(dict.SID("in")), TypeID: idInt32}},
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("p")), TypeID: idPint32},
+			Value:      &AddressValue{Index: -1, Linkage: WeakExternalLinkage, NameID: weak},
+		},
+	}
+	u1 := []Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: x, TypeID: TypeID(dict.SID("[4]int32"))}, Value: &Int32Value{Value: 1}},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: f, TypeID: tf},
+			Body: []Operation{
+				&BeginScope{},
+				&AllocResult{TypeID: idInt32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: ext, TypeID: tpf},
+				&Arguments{},
+				&CallFP{TypeID: tpf},
+				&Return{},
+				&EndScope{},
+			},
+		},
+	}
+	defined, undefined, err := Symbols(u0, u1)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if g, e := len(defined), 3; g != e {
+		t.Fatal(g, e)
+	}
+
+	if s := defined[x]; s.Linkage != ExternalLinkage || s.Unit != 1 || s.Size != 16 || s.Object != u1[0] {
+		t.Fatalf("%+v", s)
+	}
+
+	if s := defined[f]; s.Size != 0 || s.TypeID != tf {
+		t.Fatalf("%+v", s)
+	}
+
+	if g, e := len(undefined), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if s := undefined[ext]; s.Linkage != ExternalLinkage || s.TypeID != tpf || s.Unit != 1 || s.Object != nil {
+		t.Fatalf("%+v", s)
+	}
+
+	if s := undefined[weak]; s.Linkage != WeakExternalLinkage || s.Unit != 0 {
+		t.Fatalf("%+v", s)
+	}
+
+	if _, _, err := Symbols(u1, u1); err == nil || !strings.Contains(err.Error(), "multiple definitions of x") {
+		t.Fatal(err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("s")), TypeID: TypeID(dict.SID("*int8"))},
+			Value:      &StringValue{StringID: StringID(dict.SID("foo"))},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: 40},
+				&Const32{TypeID: idInt32, Value: 2},
+				&Add{TypeID: idInt32},
+				&Const32{TypeID: idInt32, Value: 2},
+				&Add{TypeID: idInt32},
+				&StringConst{TypeID: TypeID(dict.SID("*int8")), Value: StringID(dict.SID("foo"))},
+				&Drop{TypeID: TypeID(dict.SID("*int8"))},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		},
+	}
+	s := Stats(objs)
+	if g, e := s.Data, 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Operations, 13; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Counts["Add"], 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Functions[0].Counts["Const32"], 3; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Constants, 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := s.Strings, 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if s.Types < 4 {
+		t.Fatal(s.Types)
+	}
+
+	for _, v := range []string{"operations 13\n", "main     13\n", "Const32     3\n"} {
+		if !strings.Contains(s.String(), v) {
+			t.Fatalf("%q\n%s", v, s)
+		}
+	}
+}
+
+func TestVarArgTypes(t *testing.T) {
+	printf := NameID(dict.SID("printf"))
+	tf := TypeID(dict.SID("func(*int8,...)int32"))
+	tpf := TypeID(dict.SID("*func(*int8,...)int32"))
+	tp := TypeID(dict.SID("*int8"))
+	tf64 := TypeID(dict.SID("float64"))
+	f := func(va []TypeID) *FunctionDefinition {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&AllocResult{TypeID: idInt32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: printf, TypeID: tpf},
+				&Arguments{},
+				&StringConst{TypeID: tp, Value: StringID(dict.SID("%d %g\n"))},
+				&Const32{TypeID: idInt32, Value: 42},
+				&Const64{TypeID: tf64, Value: int64(math.Float64bits(1.5))},
+				&CallFP{Arguments: 3, TypeID: tpf, VarArgTypes: va},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		}
+	}
+
+	fd := f([]TypeID{idInt32, tf64})
+	if err := fd.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{fd}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if g, e := PrettyString(out), PrettyString([]Object{fd}); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	stub := &FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: printf, TypeID: tf}, Body: []Operation{&Panic{}}}
+	linked, err := LinkLib([]Object{fd, stub})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range linked {
+		if g, ok := v.(*FunctionDefinition); ok && g.NameID == idMain {
+			c := g.Body[6].(*Call)
+			if g, e := len(c.VarArgTypes), 2; g != e {
+				t.Fatal(g, e)
+			}
+		}
+	}
+
+	for _, v := range [][]TypeID{
+		{idInt32},
+		{tf64, idInt32},
+		{TypeID(dict.SID("int16")), tf64},
+	} {
+		if err := f(v).Verify(); err == nil {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestIncompleteTypes(t *testing.T) {
+	c := TypeCache{}
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		s        string
+		complete bool
+	}{
+		{"*[?]int8", true},
+		{"*void", true},
+		{"[2][?]int8", false},
+		{"[?]int32", false},
+		{"[?][2]int8", false},
+		{"func(*void)*void", true},
+		{"struct{a int32,b [?]int8}", false},
+		{"void", false},
+	} {
+		typ, err := c.Type(TypeID(dict.SID(v.s)))
+		if err != nil {
+			t.Fatalf("%q: %v", v.s, err)
+		}
+
+		if g, e := typ.ID().String(), v.s; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+
+		if g, e := IsComplete(typ), v.complete; g != e {
+			t.Fatalf("%q: got %v, expected %v", v.s, g, e)
+		}
+
+		func() {
+			defer func() {
+				if g, e := recover() != nil, !v.complete; g != e {
+					t.Fatalf("%q: panic %v, expected %v", v.s, g, e)
+				}
+			}()
+
+			m.Sizeof(typ)
+		}()
+	}
+
+	if g, e := c.MustType(TypeID(dict.SID("[?]int32"))).(*ArrayType).Items, int64(-1); g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, v := range []string{"[?", "[?]", "vo", "voidx"} {
+		if _, err := c.Type(TypeID(dict.SID(v))); err == nil {
+			t.Fatalf("%q: unexpected success", v)
+		}
+	}
+
+	a := TypeID(dict.SID("[?]int32"))
+	if err := (&DataDefinition{ObjectBase: ObjectBase{NameID: NameID(dict.SID("a")), TypeID: a}, Value: &CompositeValue{}}).Verify(); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if err := (&DataDefinition{ObjectBase: ObjectBase{NameID: NameID(dict.SID("a")), TypeID: a}}).Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	pv := TypeID(dict.SID("*void"))
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: TypeID(dict.SID("func(*void)"))},
+		Arguments:  []NameID{NameID(dict.SID("p"))},
+		Body: []Operation{
+			&BeginScope{},
+			&Argument{TypeID: pv},
+			&Load{TypeID: pv},
+			&Drop{TypeID: TypeID(dict.SID("void"))},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Fatal(err)
+	}
+}
+
+func TestNamedTypes(t *testing.T) {
+	c := TypeCache{}
+	tm := NameID(dict.SID("tm"))
+	u := TypeID(dict.SID("struct{tm_sec int32,tm_min int32}"))
+	nt, err := c.Define(tm, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, ok := nt.(*StructOrUnionType)
+	if !ok || st.Name != tm || st.Kind() != Struct || len(st.Fields) != 2 {
+		t.Fatalf("%T %+v", nt, nt)
+	}
+
+	if g, e := nt.ID().String(), "tm=struct{tm_sec int32,tm_min int32}"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	ut := c.MustType(u)
+	if nt.Equal(ut) || ut.(*StructOrUnionType).Name != 0 {
+		t.Fatal("named type identical to its underlying type")
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := m.Sizeof(nt), m.Sizeof(ut); g != e {
+		t.Fatal(g, e)
+	}
+
+	p := c.MustType(TypeID(dict.SID("*" + nt.ID().String())))
+	if g, e := p.(*PointerType).Element, nt; g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, v := range []struct{ s, abbrev string }{
+		{"*" + nt.ID().String(), "*tm"},
+		{"[2]t2=[3]int8", "[2]t2"},
+		{"func(*a=int32,b=float64)(c=*void,int32)", "func(*a,b)(c,int32)"},
+		{"int32", "int32"},
+		{"struct{a x=int32,b *y=struct{c int8}}", "struct{a x,b *y}"},
+		{"x1=<4xv=int32>", "x1"},
+	} {
+		id := TypeID(dict.SID(v.s))
+		if _, err := c.Type(id); err != nil {
+			t.Fatalf("%q: %v", v.s, err)
+		}
+
+		if g, e := id.Abbrev(), v.abbrev; g != e {
+			t.Fatalf("%q: got %q, expected %q", v.s, g, e)
+		}
+	}
+
+	if g, e := c.MustType(TypeID(dict.SID("[2]t2=[3]int8"))).(*ArrayType).Item.(*ArrayType).Name, NameID(dict.SID("t2")); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(c.Named(tm)), 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, v := range []string{"=int32", "1a=int32", "a=", "a=b"} {
+		if _, err := c.Type(TypeID(dict.SID(v))); err == nil {
+			t.Fatalf("%q: unexpected success", v)
+		}
+	}
+
+	d := &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("t")), TypeID: nt.ID()}, Value: &CompositeValue{Values: []Value{&Int32Value{Value: 42}}}}
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{d}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if g, e := PrettyString(out), PrettyString([]Object{d}); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestBitOps(t *testing.T) {
+	f := func(op Operation, a int32) *FunctionDefinition {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: a},
+				op,
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		op Operation
+		a  int32
+		e  int32
+	}{
+		{&Bswap{TypeID: idInt32}, 0x01020304, 0x04030201},
+		{&Bswap{TypeID: idInt32}, 0x000000ff, -0x01000000},
+		{&Clz{TypeID: idInt32}, 0, 32},
+		{&Clz{TypeID: idInt32}, 1, 31},
+		{&Clz{TypeID: idInt32}, -1, 0},
+		{&Ctz{TypeID: idInt32}, 0, 32},
+		{&Ctz{TypeID: idInt32}, 8, 3},
+		{&Ctz{TypeID: idInt32}, math.MinInt32, 31},
+		{&Popcnt{TypeID: idInt32}, 0, 0},
+		{&Popcnt{TypeID: idInt32}, 0x70f, 7},
+		{&Popcnt{TypeID: idInt32}, -1, 32},
+	} {
+		fd := f(v.op, v.a)
+		if err := fd.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{fd}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		out, err := Parse("test", buf.Bytes())
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := PrettyString(out), PrettyString([]Object{fd}); g != e {
+			t.Fatalf("%v\ngot\n%s\nexp\n%s", i, g, e)
+		}
+
+		in, err := NewInterpreter([]Object{fd}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := int32(r[0].(uint64)), v.e; g != e {
+			t.Fatalf("%v: got %#x, expected %#x", i, g, e)
+		}
+	}
+
+	for _, op := range []Operation{
+		&Popcnt{TypeID: TypeID(dict.SID("float64"))},
+		&Bswap{TypeID: TypeID(dict.SID("uint8"))},
+	} {
+		if err := f(op, 1).Verify(); err == nil {
+			t.Fatalf("%v: expected error", op)
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	f := func(a Attributes, ops ...Operation) *FunctionDefinition {
+		return &FunctionDefinition{
+			Attributes: a,
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("f")), TypeID: TypeID(dict.SID("func()"))},
+			Body:       append(append([]Operation{&BeginScope{}}, ops...), &EndScope{}),
+		}
+	}
+
+	if g, e := (AttrNoreturn | AttrInline).String(), "inline,noreturn"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	for i, v := range []struct {
+		f  *FunctionDefinition
+		ok bool
+	}{
+		{f(0, &Return{}), true},
+		{f(0, &Panic{}), false},
+		{f(AttrConst|AttrPure|AttrInline, &Return{}), true},
+		{f(AttrNoreturn, &Panic{}), true},
+		{f(AttrNoreturn, &Return{}), false},
+		{f(AttrNoreturn, &Const32{TypeID: idInt32}, &Jz{Number: 1}, &Return{}, &Label{Number: 1}, &Panic{}), false},
+		{f(AttrNoreturn|AttrPure, &Panic{}), false},
+		{f(AttrNaked|AttrAlwaysInline, &Return{}), true},
+		{f(AttrNaked, &VariableDeclaration{TypeID: idInt32}, &Return{}), false},
+		{f(1<<10, &Return{}), false},
+	} {
+		err := v.f.Verify()
+		if g, e := err == nil, v.ok; g != e {
+			t.Fatal(i, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{v.f}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		out, err := Parse("test", buf.Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", i, err, buf.Bytes())
+		}
+
+		if g, e := out[0].(*FunctionDefinition).Attributes, v.f.Attributes; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	fd := f(AttrNoreturn)
+	fd.Body = nil
+	if _, err := NewFunctionBuilder(fd).Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	linked, err := LinkLib([]Object{fd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range linked {
+		if g, ok := v.(*FunctionDefinition); ok && g.NameID == fd.NameID {
+			if g, e := g.Attributes, AttrNoreturn; g != e {
+				t.Fatal(g, e)
+			}
+
+			return
+		}
+	}
+	t.Fatal("missing function")
+}
+
+func TestLinkLibGC(t *testing.T) {
+	ft := TypeID(dict.SID("func()"))
+	pft := TypeID(dict.SID("*func()"))
+	a, b, c, d := NameID(dict.SID("a")), NameID(dict.SID("b")), NameID(dict.SID("c")), NameID(dict.SID("d"))
+	f := func(nm NameID, callee NameID) *FunctionDefinition {
+		body := []Operation{&BeginScope{}}
+		if callee != 0 {
+			body = append(body,
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: callee, TypeID: pft},
+				&Arguments{FunctionPointer: true},
+				&CallFP{TypeID: pft},
+			)
+		}
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: nm, TypeID: ft},
+			Body:       append(body, &Return{}, &EndScope{}),
+		}
+	}
+	data := &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: d, TypeID: idInt32}}
+	objs := func() []Object { return []Object{f(a, b), f(b, 0), f(c, b), data} }
+	for _, v := range objs() {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(objs []Object) (r []string) {
+		for _, v := range objs {
+			if nm := v.Base().NameID; nm != idMain {
+				r = append(r, nm.String())
+			}
+		}
+		sort.Strings(r)
+		return r
+	}
+
+	all, err := LinkLib(objs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(names(all)), "[a b c d]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+
+	gc, err := LinkLibWithOptions(&LinkLibOptions{Roots: []NameID{a}, GC: true}, objs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(names(gc)), "[a b]"; g != e {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+
+	if _, err := LinkLibWithOptions(&LinkLibOptions{Roots: []NameID{NameID(dict.SID("e"))}, GC: true}, objs()); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestFprint(t *testing.T) {
+	nt := TypeID(dict.SID("*tm=struct{tm_sec int32}"))
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: token.Position{Filename: "a.c", Line: 1}},
+		Body: []Operation{
+			&BeginScope{Position: token.Position{Filename: "a.c", Line: 2}},
+			&Nil{TypeID: nt},
+			&Drop{TypeID: nt},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	objs := []Object{f}
+
+	var buf bytes.Buffer
+	if err := Fprint(&buf, objs, PrintOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{"a.c:2", nt.String(), "EndScope"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q in\n%s", v, s)
+		}
+	}
+
+	buf.Reset()
+	if err := Fprint(&buf, objs, PrintOptions{Indent: "\t", MaxBody: 2, NoPositions: true, ShortTypes: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	s = buf.String()
+	for _, v := range []string{"a.c", nt.String(), "EndScope", "· "} {
+		if strings.Contains(s, v) {
+			t.Fatalf("unexpected %q in\n%s", v, s)
+		}
+	}
+
+	for _, v := range []string{"*tm", "... 3 more operations"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q in\n%s", v, s)
+		}
+	}
+
+	if f.Body[4] == nil || len(f.Body) != 5 {
+		t.Fatal("Fprint mutated its argument")
+	}
+
+	if err := Fprint(errorWriter{}, objs, PrintOptions{}); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) { return 0, fmt.Errorf("write error") }
+
+func TestDivFlags(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	u := TypeID(dict.SID("uint32"))
+	cu := &Const32{TypeID: u, Value: 1}
+	f64 := TypeID(dict.SID("float64"))
+	cf := &Const64{TypeID: f64, Value: int64(math.Float64bits(1))}
+	c128 := &Const{TypeID: idInt128, Value: &Int128Value{Value: big.NewInt(1)}}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{c, c, &Div{Exact: true, NoOverflow: true, NonZero: true, Mode: DivTrap, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c128, c128, &Div{NoOverflow: true, NonZero: true, TypeID: idInt128}, &Drop{TypeID: idInt128}}, true},
+		{[]Operation{c, c, &Rem{NoOverflow: true, NonZero: true, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{cu, cu, &Div{NonZero: true, TypeID: u}, &Drop{TypeID: u}}, true},
+		{[]Operation{cu, cu, &Div{NoOverflow: true, TypeID: u}, &Drop{TypeID: u}}, false},
+		{[]Operation{cf, cf, &Div{NonZero: true, TypeID: f64}, &Drop{TypeID: f64}}, false},
+		{[]Operation{cf, cf, &Div{Exact: true, TypeID: f64}, &Drop{TypeID: f64}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		op   Operation
+		a, b int32
+		e    int32
+		ok   bool
+	}{
+		{&Div{Exact: true, TypeID: idInt32}, 12, 4, 3, true},
+		{&Div{Exact: true, TypeID: idInt32}, 13, 4, 0, false},
+		{&Div{Mode: DivZero, TypeID: idInt32}, 13, 0, 0, true},
+		{&Div{Mode: DivZero, NonZero: true, TypeID: idInt32}, 13, 0, 0, false},
+		{&Rem{Mode: DivZero, TypeID: idInt32}, math.MinInt32, -1, 0, true},
+		{&Rem{Mode: DivZero, NoOverflow: true, NonZero: true, TypeID: idInt32}, math.MinInt32, -1, 0, false},
+	} {
+		fd := &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&Result{Address: true, TypeID: idPint32},
+				&Const32{TypeID: idInt32, Value: v.a},
+				&Const32{TypeID: idInt32, Value: v.b},
+				v.op,
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+				&EndScope{},
+			},
+		}
+		if err := fd.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{fd}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		out, err := Parse("test", buf.Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", i, err, buf.Bytes())
+		}
+
+		if g, e := PrettyString(out), PrettyString([]Object{fd}); g != e {
+			t.Fatalf("%v\ngot\n%s\nexp\n%s", i, g, e)
+		}
+
+		in, err := NewInterpreter([]Object{fd}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if g, e := err == nil, v.ok; g != e {
+			t.Fatal(i, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if g, e := int32(r[0].(uint64)), v.e; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestModule(t *testing.T) {
+	m := &Module{Objects: []Object{
+		&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("x")), TypeID: idInt32, Position: token.Position{Filename: "a.c", Line: 1}}, Value: &Int32Value{Value: 42}},
+	}}
+	m.SetMeta("producer", &StringValue{StringID: StringID(dict.SID("cc 1.0"))})
+	m.SetMeta("optimize", &Int32Value{Value: 2})
+	m.SetMeta("missing", nil)
+
+	var buf bytes.Buffer
+	if _, err := m.WriteToOptions(&buf, &WriteOptions{StripPositions: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := m.Objects[0].Base().Position.Line, 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	var m2 Module
+	if _, err := m2.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(m2.Metadata), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m2.Meta("producer").(*StringValue).StringID, StringID(dict.SID("cc 1.0")); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m2.Meta("optimize").(*Int32Value).Value, int32(2); g != e {
+		t.Fatal(g, e)
+	}
+
+	if m2.Meta("missing") != nil {
+		t.Fatal("unexpected metadata")
+	}
+
+	d := m2.Objects[0].(*DataDefinition)
+	if g, e := d.NameID, NameID(dict.SID("x")); g != e || d.Position.IsValid() {
+		t.Fatal(g, e, d.Position)
+	}
+
+	var o Objects
+	if _, err := o.ReadFrom(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f := func(ops ...Operation) *FunctionDefinition {
+		return &FunctionDefinition{
+			Body:       append(append([]Operation{&BeginScope{}}, ops...), &Return{}, &EndScope{}),
+			ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+		}
+	}
+	fd := f(
+		&VariableDeclaration{TypeID: idInt32},
+		&Const32{TypeID: idInt32, Value: 1},
+		&Convert{TypeID: idInt32, Result: idInt32},
+		&Jnz{Number: 0},
+		&Jmp{Number: 0},
+		&Label{Number: 1},
+		&Label{Number: 0},
+	)
+	body := append([]Operation(nil), fd.Body...)
+	if err := fd.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(fd.Body), len(body); g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, v := range body {
+		if fd.Body[i] != v {
+			t.Fatal(i, fd.Body[i], v)
+		}
+	}
+
+	for i, v := range []struct {
+		opts *VerifyOptions
+		ip   int
+	}{
+		{&VerifyOptions{}, -1},
+		{&VerifyOptions{UnreachableLabels: true}, 6},
+		{&VerifyOptions{UnusedVariables: true}, 1},
+	} {
+		switch err := fd.Validate(v.opts); {
+		case v.ip < 0:
+			if err != nil {
+				t.Fatal(i, err)
+			}
+		default:
+			e, ok := err.(*VerifyError)
+			if !ok {
+				t.Fatalf("%v: %T %v", i, err, err)
+			}
+
+			if g, e := e.IP, v.ip; g != e {
+				t.Fatal(i, g, e)
+			}
+		}
+	}
+
+	used := f(
+		&VariableDeclaration{TypeID: idInt32},
+		&Variable{Index: 0, TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+	)
+	if err := used.Validate(&VerifyOptions{UnreachableLabels: true, UnusedVariables: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fd.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(fd.Body), len(body); g >= e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	p := func(line, col int) token.Position { return token.Position{Filename: "a.c", Line: line, Column: col} }
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&BeginScope{},
+			&Const32{TypeID: idInt32, Value: 1, Position: p(2, 1)},
+			&Drop{TypeID: idInt32, Position: p(2, 1)},
+			&Const32{TypeID: idInt32, Value: 2},
+			&Drop{TypeID: idInt32, Position: p(3, 1)},
+			&Return{Position: p(2, 5)},
+			&EndScope{Position: p(2, 5)},
+		},
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	lt := LineTable(f)
+	if g, e := fmt.Sprint(lt), "[{1 a.c:2:1} {4 a.c:3:1} {5 a.c:2:5}]"; g != e {
+		t.Fatalf("\ngot %s\nexp %s", g, e)
+	}
+
+	f.CompressPositions()
+	if g, e := fmt.Sprint(LineTable(f)), fmt.Sprint(lt); g != e {
+		t.Fatalf("\ngot %s\nexp %s", g, e)
+	}
+
+	for ip, e := range []string{"-", "a.c:2:1", "a.c:2:1", "a.c:2:1", "a.c:3:1", "a.c:2:5", "a.c:2:5", "a.c:2:5"} {
+		if g := LinePosition(lt, ip).String(); g != e {
+			t.Fatal(ip, g, e)
+		}
+	}
+
+	if g, e := fmt.Sprint(LineIPs(lt, "a.c", 2)), "[1 5]"; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g := LineIPs(lt, "b.c", 2); g != nil {
+		t.Fatal(g)
+	}
+}
+
+func TestTypeCacheWriteTo(t *testing.T) {
+	specs := []string{
+		"[?]int32",
+		"[4]*int8",
+		"<4xfloat32>",
+		"func(int32,...)(int8,uint64)",
+		"func(*struct{a int32:3,b int32:5,c [2]uint8})",
+		"union{a float64,b void}",
+		"*tm=struct{tm_sec int32,tm_min int32}",
+		"**uint16",
+	}
+	c := TypeCache{}
+	for _, v := range specs {
+		if _, err := c.Type(TypeID(dict.SID(v))); err != nil {
+			t.Fatal(v, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := TypeCache{}
+	if _, err := d.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range specs {
+		if d[TypeID(dict.SID(v))] == nil {
+			t.Fatal(v)
+		}
+	}
+
+	for k, v := range c {
+		if g, e := d.MustType(k), v; !reflect.DeepEqual(g, e) {
+			t.Fatalf("%v\ngot %s\nexp %s", k, PrettyString(g), PrettyString(e))
+		}
+	}
+
+	if _, err := (TypeCache{}).ReadFrom(bytes.NewReader([]byte("foo"))); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestEliminateLoads(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	f := func(addr Operation, ld *Load, ops ...Operation) *FunctionDefinition {
+		body := []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+			&VariableDeclaration{Index: 1, TypeID: idInt32},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+		}
+		body = append(body, ops...)
+		body = append(body,
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			addr,
+			ld,
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		)
+		return &FunctionDefinition{
+			Body:       body,
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		}
+	}
+	c := &Const32{TypeID: idInt32, Value: 42}
+	v0 := &Variable{Address: true, Index: 0, TypeID: idPint32}
+	v1 := &Variable{Address: true, Index: 1, TypeID: idPint32}
+	ld := &Load{TypeID: idPint32}
+	for i, v := range []struct {
+		f       *FunctionDefinition
+		removed int
+	}{
+		{f(v0, ld, c), 3},
+		{f(v0, ld, c, c, &Add{TypeID: idInt32}), 3},
+		{f(v1, ld, c), 0},
+		{f(v0, &Load{TypeID: idPint32, Volatile: true}, c), 0},
+		{f(v0, ld, c, &Const32{TypeID: idInt32}, &Jz{Number: 0}, &Label{Number: 0}), 0},
+	} {
+		n := len(v.f.Body)
+		if err := EliminateLoads(v.f); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := n-len(v.f.Body), v.removed; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if err := v.f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if v.removed == 0 {
+			continue
+		}
+
+		in, err := NewInterpreter([]Object{v.f}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := int32(r[0].(uint64)), int32(42*(i+1)); g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestLinkMainWithMap(t *testing.T) {
+	d := NameID(dict.SID("d"))
+	units := [][]Object{
+		{
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: NameID(dict.SID("unused")), TypeID: idInt32},
+				Value:      &Int32Value{Value: 1},
+			},
+			&DataDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: d, TypeID: idInt32},
+				Value:      &Int32Value{Value: 42},
+			},
+		},
+		{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(idStart), TypeID: TypeID(dict.SID("func()"))},
+				Body: []Operation{
+					&Global{Index: -1, Linkage: ExternalLinkage, NameID: d, TypeID: idPint32},
+					&Drop{TypeID: idPint32},
+					&Return{},
+				},
+			},
+		},
+	}
+	out, m, err := LinkMainWithMap(units...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(m), len(out); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m.String(), `index unit kind     linkage         size type   name
+0     1    function ExternalLinkage 3    func() _start
+1     0    data     ExternalLinkage 4    int32  d
+`; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if g, e := m[1].UnitIndex, 1; g != e {
+		t.Fatal(g, e)
+	}
+
+	if _, _, err := LinkMainWithMap(units[0]); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestSaveRestoreContext(t *testing.T) {
+	jb := TypeID(dict.SID("[8]uint64"))
+	pjb := TypeID(dict.SID("*[8]uint64"))
+	ft := TypeID(dict.SID("func(*[8]uint64)"))
+	pft := TypeID(dict.SID("*func(*[8]uint64)"))
+	c := &Const32{TypeID: idInt32}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{&Variable{Address: true, TypeID: idPint32}, &SaveContext{TypeID: idPint32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{&Variable{Address: true, TypeID: idPint32}, &SaveContext{TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{c, &Variable{Address: true, TypeID: idPint32}, &SaveContext{TypeID: idPint32}, &Drop{TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{&Variable{Address: true, TypeID: idPint32}, c, &RestoreContext{TypeID: idPint32}}, true},
+		{[]Operation{&Variable{Address: true, TypeID: idPint32}, &RestoreContext{TypeID: idPint32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	longjmp := NameID(dict.SID("longjmp"))
+	for i, v := range []struct {
+		value, expect int32
+	}{
+		{7, 7},
+		{0, 1},
+	} {
+		objs := []Object{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: longjmp, TypeID: ft},
+				Arguments:  []NameID{0},
+				Body: []Operation{
+					&BeginScope{},
+					&Argument{TypeID: pjb},
+					&Const32{TypeID: idInt32, Value: v.value},
+					&RestoreContext{TypeID: pjb},
+					&Return{},
+					&EndScope{},
+				},
+			},
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+				Body: []Operation{
+					&BeginScope{},
+					&VariableDeclaration{TypeID: jb},
+					&Variable{Address: true, TypeID: pjb},
+					&SaveContext{TypeID: pjb},
+					&Const32{TypeID: idInt32, Value: v.expect},
+					&Eq{TypeID: idInt32},
+					&Jnz{Number: 0},
+					&Global{Index: -1, Linkage: ExternalLinkage, NameID: longjmp, TypeID: pft},
+					&Arguments{},
+					&Variable{Address: true, TypeID: pjb},
+					&CallFP{Arguments: 1, TypeID: pft},
+					&Return{},
+					&Label{Number: 0},
+					&Result{Address: true, TypeID: idPint32},
+					&Const32{TypeID: idInt32, Value: 42},
+					&Store{TypeID: idInt32},
+					&Drop{TypeID: idInt32},
+					&Return{},
+					&EndScope{},
+				},
+			},
+		}
+		for _, v := range objs {
+			if err := v.Verify(); err != nil {
+				t.Fatal(i, err)
+			}
+		}
+
+		out, err := LinkLib(objs)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		in, err := NewInterpreter(out, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := int32(r[0].(uint64)), int32(42); g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g := len(in.contexts); g != 0 {
+			t.Fatal(i, g)
+		}
+	}
+}
+
+func TestPositionsGob(t *testing.T) {
+	pos := func(line, col int) token.Position {
+		return token.Position{Filename: "/home/user/src/project/very/long/path/to/file.c", Line: line, Column: col, Offset: 10*line + col}
+	}
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: pos(1, 1)},
+		Body:       []Operation{&BeginScope{Position: pos(1, 12)}},
+	}
+	for i := 0; i < 500; i++ {
+		f.Body = append(f.Body,
+			&Const32{TypeID: idInt32, Value: int32(i), Position: pos(i+2, 5)},
+			&Drop{TypeID: idInt32, Position: pos(i+2, 5)},
+		)
+	}
+	f.Body = append(f.Body, &Return{}, &EndScope{Position: pos(1000, 1)})
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := (Objects{{f}}).WriteToOptions(&buf, &WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Body[1].Pos() != pos(2, 5) {
+		t.Fatal("WriteToOptions mutated its argument")
+	}
+
+	var o Objects
+	if _, err := o.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	g := o[0][0].(*FunctionDefinition)
+	if !reflect.DeepEqual(g, f) {
+		t.Fatalf("\ngot %s\nexp %s", PrettyString(g), PrettyString(f))
+	}
+
+	var m Module
+	m.Objects = []Object{f}
+	var buf2 bytes.Buffer
+	if _, err := m.WriteToOptions(&buf2, &WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m2 Module
+	if _, err := m2.ReadFrom(&buf2); err != nil {
+		t.Fatal(err)
+	}
+
+	if g := m2.Objects[0].(*FunctionDefinition); !reflect.DeepEqual(g, f) {
+		t.Fatalf("\ngot %s\nexp %s", PrettyString(g), PrettyString(f))
+	}
+
+	var strip bytes.Buffer
+	if _, err := (Objects{{f}}).WriteToOptions(&strip, &WriteOptions{StripPositions: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.Len(), 2*strip.Len(); g > e {
+		t.Fatalf("positions too expensive: %v bytes, %v bytes without positions", g, strip.Len())
+	}
+}
+
+func TestSignedRelops(t *testing.T) {
+	u := TypeID(dict.SID("uint32"))
+	c := &Const32{TypeID: idInt32, Value: 1}
+	cu := &Const32{TypeID: u, Value: 1}
+	p := &Variable{Address: true, TypeID: idPint32}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{c, c, &Lt{Signed: true, TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, c, &Lt{TypeID: idInt32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{cu, cu, &Geq{TypeID: u}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{cu, cu, &Geq{Signed: true, TypeID: u}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{cu, cu, &Gt{Signed: true, TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{p, p, &Leq{TypeID: idPint32}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{p, p, &Leq{Signed: true, TypeID: idPint32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{cu, cu, &Eq{TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	for _, v := range []struct {
+		id     TypeID
+		signed bool
+	}{
+		{idInt32, true},
+		{idInt64, true},
+		{idInt128, true},
+		{u, false},
+		{idPint32, false},
+		{TypeID(dict.SID("uint128")), false},
+	} {
+		if g, e := v.id.Signed(), v.signed; g != e {
+			t.Fatal(v.id, g, e)
+		}
+	}
+
+	// The signedness of a comparison is derived from its type by Verify,
+	// but not by Validate.
+	lt := &Lt{TypeID: idInt32}
+	body := testBody(0)
+	f := &FunctionDefinition{
+		Body:       append(append(append([]Operation(nil), body[:2]...), c, c, lt, &Drop{TypeID: idInt32}), body[2:]...),
+		ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Validate(nil); err != nil || lt.Signed {
+		t.Fatal(err, lt.Signed)
+	}
+
+	if err := f.Verify(); err != nil || !lt.Signed {
+		t.Fatal(err, lt.Signed)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{&FunctionDefinition{
+		Body:       testBody(1),
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := Parse("a.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f = objs[0].(*FunctionDefinition)
+	if x, ok := f.Body[4].(*Lt); !ok || !x.Signed {
+		t.Fatalf("%T %v", f.Body[4], f.Body[4])
+	}
+
+	b := NewFunctionBuilder(&FunctionDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType}})
+	b.Const32(u, 1)
+	b.Const32(u, 2)
+	b.Gt(u)
+	b.Drop(idInt32)
+	if f, err = b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlloca(t *testing.T) {
+	u64 := TypeID(dict.SID("uint64"))
+	ppint32 := TypeID(dict.SID("**int32"))
+	size := &Const64{TypeID: u64, Value: 16}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{size, &Alloca{Size: u64, TypeID: idPint32}, &Drop{TypeID: idPint32}}, true},
+		{[]Operation{size, &Alloca{Size: idInt32, TypeID: idPint32}, &Drop{TypeID: idPint32}}, false},
+		{[]Operation{size, &Alloca{Size: u64, TypeID: idInt32}, &Drop{TypeID: idInt32}}, false},
+		{[]Operation{&Alloca{Size: u64, TypeID: idPint32}, &Drop{TypeID: idPint32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	for i, v := range []*FunctionDefinition{
+		{
+			ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+			Body:       []Operation{size, &Alloca{Size: u64, TypeID: idPint32}, &Drop{TypeID: idPint32}, &Return{}},
+		},
+		{
+			ObjectBase: ObjectBase{NameID: idMain, TypeID: idMainType},
+			Attributes: AttrNaked,
+			Body:       []Operation{&BeginScope{}, size, &Alloca{Size: u64, TypeID: idPint32}, &Drop{TypeID: idPint32}, &Return{}, &EndScope{}},
+		},
+	} {
+		if err := v.Verify(); err == nil {
+			t.Fatal(i, "unexpected success")
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{TypeID: idPint32},
+			&BeginScope{},
+			&Variable{Address: true, TypeID: ppint32},
+			size,
+			&Alloca{Size: u64, TypeID: idPint32},
+			&Store{TypeID: idPint32},
+			&Drop{TypeID: idPint32},
+			&Variable{TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 42},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{TypeID: idPint32},
+			&Load{TypeID: idPint32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&EndScope{},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := NewInterpreter([]Object{f}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := len(in.blocks)
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := int32(r[0].(uint64)), int32(42); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(in.blocks), n; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	pos := func(line int) token.Position { return token.Position{Filename: "a.c", Line: line, Column: 1} }
+	f := func(pos func(int) token.Position, ops ...Operation) *FunctionDefinition {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       append(append([]Operation{&BeginScope{Position: pos(1)}}, ops...), &Return{Position: pos(9)}, &EndScope{Position: pos(9)}),
+		}
+	}
+	data := func(nm string, v int32) *DataDefinition {
+		return &DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID(nm)), TypeID: idInt32},
+			Value:      &Int32Value{Value: v},
+		}
+	}
+	c := func(v int32, line int) Operation { return &Const32{TypeID: idInt32, Value: v, Position: pos(line)} }
+	d := func(line int) Operation { return &Drop{TypeID: idInt32, Position: pos(line)} }
+	other := func(line int) token.Position { return token.Position{Filename: "b.c", Line: line + 10} }
+	a := []Object{f(pos, c(1, 2), d(2), c(2, 3), d(3)), data("x", 1), data("y", 1)}
+	b := []Object{f(other, &Const32{TypeID: idInt32, Value: 1}, d(2), c(3, 3), d(3)), data("x", 2), data("z", 1)}
+	if g := Diff(a, a); len(g) != 0 {
+		t.Fatal(g)
+	}
+
+	var s []string
+	for _, v := range Diff(a, b) {
+		s = append(s, v.String())
+	}
+	if g, e := strings.Join(s, "\n"), fmt.Sprintf(`main: 0x00003: -%v
+main: 0x00003: +%v
+x: value differs
+y: removed
+z: added`, c(2, 3), c(3, 3)); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+type testBackend struct {
+	aliases bool
+	buf     bytes.Buffer
+}
+
+func (b *testBackend) BeginFunction(index int, f *FunctionDefinition) error {
+	fmt.Fprintf(&b.buf, "func %v %s\n", index, f.NameID)
+	return nil
+}
+
+func (b *testBackend) DefineData(index int, d *DataDefinition) error {
+	fmt.Fprintf(&b.buf, "data %v %s\n", index, d.NameID)
+	return nil
+}
+
+func (b *testBackend) EmitTable() EmitTable {
+	emit := func(ip int, op Operation) error {
+		fmt.Fprintf(&b.buf, "\t%v %v\n", ip, OpcodeOf(op))
+		return nil
+	}
+	return EmitTable{
+		OpBeginScope: emit,
+		OpEndScope:   emit,
+		OpReturn: func(ip int, op Operation) error {
+			if _, ok := op.(*Return); !ok {
+				return fmt.Errorf("unexpected %T", op)
+			}
+
+			return emit(ip, op)
+		},
+	}
+}
+
+func (b *testBackend) EndFunction(f *FunctionDefinition) error {
+	fmt.Fprintf(&b.buf, "end %s\n", f.NameID)
+	return nil
+}
+
+type testAliasBackend struct{ testBackend }
+
+func (b *testAliasBackend) DefineAlias(index int, a *AliasDefinition) error {
+	fmt.Fprintf(&b.buf, "alias %v %s\n", index, a.NameID)
+	return nil
+}
+
+func TestLower(t *testing.T) {
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body:       []Operation{&BeginScope{}, &Return{}, &EndScope{}},
+	}
+	d := &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: idInt32}}
+	a := &AliasDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("a")), TypeID: idInt32}, Target: d.NameID}
+	var b testBackend
+	if err := Lower([]Object{d, f}, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := b.buf.String(), "data 0 d\nfunc 1 main\n\t0 BeginScope\n\t1 Return\n\t2 EndScope\nend main\n"; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if err := Lower([]Object{a}, &b); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	var ab testAliasBackend
+	if err := Lower([]Object{a}, &ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := ab.buf.String(), "alias 0 a\n"; g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	f.Body = []Operation{&BeginScope{}, &Const32{TypeID: idInt32}, &Drop{TypeID: idInt32}, &Return{}, &EndScope{}}
+	if err := Lower([]Object{f}, &b); err == nil || !strings.Contains(err.Error(), "back end does not support Const32") {
+		t.Fatal(err)
+	}
+
+	if g := OpcodeOf(nil); g != 0 {
+		t.Fatal(g)
+	}
+}
+
+func TestConvertFlags(t *testing.T) {
+	c := &Const32{TypeID: idInt32, Value: 1}
+	f64 := TypeID(dict.SID("float64"))
+	cf := &Const64{TypeID: f64, Value: int64(math.Float64bits(1))}
+	for i, v := range []struct {
+		ops []Operation
+		ok  bool
+	}{
+		{[]Operation{cf, &Convert{OutOfRange: OutOfRangeSaturate, Result: idInt32, Rounding: RoundNearestEven, TypeID: f64}, &Drop{TypeID: idInt32}}, true},
+		{[]Operation{c, &Convert{Result: f64, Rounding: RoundUp, TypeID: idInt32}, &Drop{TypeID: f64}}, true},
+		{[]Operation{c, &Convert{OutOfRange: OutOfRangeTrap, Result: f64, TypeID: idInt32}, &Drop{TypeID: f64}}, false},
+		{[]Operation{c, &Convert{Result: idInt8, Rounding: RoundDown, TypeID: idInt32}, &Drop{TypeID: idInt8}}, false},
+		{[]Operation{cf, &Convert{Result: idInt32, Rounding: Rounding(42), TypeID: f64}, &Drop{TypeID: idInt32}}, false},
+	} {
+		if err := testVerifyOps(v.ops...); (err == nil) != v.ok {
+			t.Fatal(i, err)
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		r  Rounding
+		o  OutOfRange
+		to TypeID
+		a  float64
+		e  int32
+		ok bool
+	}{
+		{RoundDefault, OutOfRangeUndefined, idInt32, -2.5, -2, true},
+		{RoundNearestEven, OutOfRangeUndefined, idInt32, -2.5, -2, true},
+		{RoundNearestEven, OutOfRangeUndefined, idInt32, 3.5, 4, true},
+		{RoundUp, OutOfRangeUndefined, idInt32, 2.25, 3, true},
+		{RoundDown, OutOfRangeUndefined, idInt32, -2.25, -3, true},
+		{RoundTowardZero, OutOfRangeSaturate, idInt8, 1e9, math.MaxInt8, true},
+		{RoundDefault, OutOfRangeSaturate, idInt8, -1e9, math.MinInt8, true},
+		{RoundDefault, OutOfRangeSaturate, idInt8, math.NaN(), 0, true},
+		{RoundDefault, OutOfRangeTrap, idInt8, 127.75, 127, true},
+		{RoundUp, OutOfRangeTrap, idInt8, 127.25, 0, false},
+		{RoundDefault, OutOfRangeTrap, idInt8, math.Inf(-1), 0, false},
+	} {
+		ops := []Operation{
+			&BeginScope{},
+			&Result{Address: true, TypeID: idPint32},
+			&Const64{TypeID: f64, Value: int64(math.Float64bits(v.a))},
+			&Convert{OutOfRange: v.o, Result: v.to, Rounding: v.r, TypeID: f64},
+		}
+		if v.to != idInt32 {
+			ops = append(ops, &Convert{Result: idInt32, TypeID: v.to})
+		}
+		fd := &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       append(ops, &Store{TypeID: idInt32}, &Drop{TypeID: idInt32}, &Return{}, &EndScope{}),
+		}
+		if err := fd.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{fd}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		out, err := Parse("test", buf.Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", i, err, buf.Bytes())
+		}
+
+		if g, e := PrettyString(out), PrettyString([]Object{fd}); g != e {
+			t.Fatalf("%v\ngot\n%s\nexp\n%s", i, g, e)
+		}
+
+		in, err := NewInterpreter([]Object{fd}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if g, e := err == nil, v.ok; g != e {
+			t.Fatal(i, err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if g, e := int32(r[0].(uint64)), v.e; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	for i, v := range []struct {
+		r Rounding
+		e float64
+	}{
+		{RoundNearestEven, 1 << 53},
+		{RoundTowardZero, 1 << 53},
+		{RoundDown, 1 << 53},
+		{RoundUp, 1<<53 + 2},
+	} {
+		g, err := interpConvertFlags(uint64(1<<53+1), Int64, Float64, v.r, OutOfRangeUndefined)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g.(float64) != v.e {
+			t.Fatal(i, g, v.e)
+		}
+	}
+}
+
+func TestEmitData(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tc := TypeCache{}
+	ts := TypeID(dict.SID("struct{a int8,b int32:3,c int32:5,d *int8,e [4]int8,f *int32}"))
+	x := &AddressValue{Index: 0, Linkage: ExternalLinkage, NameID: NameID(dict.SID("x")), Offset: 4}
+	abc := &StringValue{Offset: 1, StringID: StringID(dict.SID("abc"))}
+	d := &DataDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("y")), TypeID: ts},
+		Value: &CompositeValue{Values: []Value{
+			&Int32Value{Value: -1},
+			&Int32Value{Value: 3},
+			&Int32Value{Value: 5},
+			abc,
+			&StringValue{StringID: StringID(dict.SID("xy"))},
+			x,
+		}},
+	}
+	b, r, err := EmitData(d, m, tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := tc.MustType(ts).(*StructOrUnionType)
+	if g, e := int64(len(b)), m.Sizeof(s); g != e {
+		t.Fatal(g, e)
+	}
+
+	l := m.Layout(s)
+	if g, e := b[l[0].Offset], byte(0xff); g != e {
+		t.Fatal(g, e)
+	}
+
+	n := m.DecodeInt(b[l[1].Offset:l[1].Offset+l[1].Size], Int32)
+	if g, e := n>>uint(l[1].BitOffset)&7, uint64(3); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := n>>uint(l[2].BitOffset)&31, uint64(5); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := string(b[l[4].Offset:l[4].Offset+l[4].Size]), "xy\x00\x00"; g != e {
+		t.Fatalf("%q %q", g, e)
+	}
+
+	if g, e := len(r), 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, v := range []Relocation{
+		{Addend: 1, Offset: l[3].Offset, TypeID: TypeID(dict.SID("*int8")), Value: abc},
+		{Addend: 4, Offset: l[5].Offset, TypeID: idPint32, Value: x},
+	} {
+		if g, e := r[i], v; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		for _, c := range b[v.Offset : v.Offset+m.Sizeof(tc.MustType(v.TypeID))] {
+			if c != 0 {
+				t.Fatal(i, b)
+			}
+		}
+	}
+
+	d.Value = &CompositeValue{Values: []Value{&DesignatedValue{Index: 2, Value: x}}}
+	if _, _, err := EmitData(d, m, tc); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestDifferenceValue(t *testing.T) {
+	base := NameID(dict.SID("base"))
+	l1 := NameID(dict.SID("l1"))
+	l2 := NameID(dict.SID("l2"))
+	ta := TypeID(dict.SID("[2]int32"))
+	label := func(nm NameID, off uintptr) *AddressValue {
+		return &AddressValue{Index: -1, Label: nm, Linkage: ExternalLinkage, NameID: idMain, Offset: off}
+	}
+	d1 := &DifferenceValue{A: label(l1, 0), B: label(base, 0)}
+	d2 := &DifferenceValue{A: label(l2, 2), B: label(base, 0)}
+	body := testBody(0)
+	objs := []Object{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("table")), TypeID: ta},
+			Value:      &CompositeValue{Values: []Value{d1, d2}},
+		},
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       append(append(body[:2:2], &Label{NameID: base}, &Label{NameID: l1}, &Label{NameID: l2}), body[2:]...),
+		},
+	}
+	for _, v := range objs {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, objs); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if g, e := PrettyString(out), PrettyString(objs); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	b, err := json.Marshal(Objects{objs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o Objects
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(o, Objects{objs}) {
+		t.Fatalf("%s", b)
+	}
+
+	if err := (&DataDefinition{ObjectBase: ObjectBase{TypeID: idPint32}, Value: d1}).Verify(); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	linked, err := LinkLib(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d *DataDefinition
+	main := -1
+	for i, v := range linked {
+		switch x := v.(type) {
+		case *DataDefinition:
+			d = x
+		case *FunctionDefinition:
+			if x.NameID == idMain {
+				main = i
+			}
+		}
+	}
+	for _, v := range []*DifferenceValue{d1, d2} {
+		if v.A.Index != main || v.B.Index != main {
+			t.Fatal(v, main)
+		}
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	data, r, err := EmitData(d, m, TypeCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(data), 8; g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, v := range []Relocation{
+		{Offset: 0, TypeID: idInt32, Value: d1},
+		{Addend: 2, Offset: 4, TypeID: idInt32, Value: d2},
+	} {
+		if g, e := r[i], v; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestOrderBlocks(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cold := &Jz{Number: 2}
+	fd := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+			&VariableDeclaration{Index: 1, TypeID: idInt32},
+			&Label{Number: 0},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 10},
+			&Lt{Signed: true, TypeID: idInt32},
+			&Jz{Number: 1},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 5},
+			&Eq{TypeID: idInt32},
+			cold,
+			&Variable{Address: true, Index: 1, TypeID: idPint32},
+			&Variable{Index: 1, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 100},
+			&Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Label{Number: 2},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 1},
+			&Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Jmp{Number: 0},
+			&Label{Number: 1},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Index: 1, TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	if err := fd.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() {
+		in, err := NewInterpreter([]Object{fd}, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		in.Profile = true
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := int32(r[0].(uint64)), int32(100); g != e {
+			t.Fatal(g, e)
+		}
+
+		in.AttachProfile()
+	}
+	run()
+	if g, e := fmt.Sprint(cold.Taken, cold.NotTaken), "9 1"; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := fd.Body[18].(*Label).Count, int64(10); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := fd.Body[3].(*Label).Count, int64(11); g != e {
+		t.Fatal(g, e)
+	}
+
+	n := len(fd.Body)
+	if err := OrderBlocks(fd); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(fd.Body), n+2; g != e {
+		t.Fatal(g, e)
+	}
+
+	inv, ok := fd.Body[11].(*Jnz)
+	if !ok {
+		t.Fatalf("%T", fd.Body[11])
+	}
+
+	if _, ok := fd.Body[12].(*Label); !ok {
+		t.Fatalf("%T", fd.Body[12])
+	}
+
+	if x, ok := fd.Body[19].(*Jmp); !ok || x.Number != 0 {
+		t.Fatalf("%v", fd.Body[19])
+	}
+
+	if x, ok := fd.Body[20].(*Label); !ok || x.Number != inv.Number || x.Count != 1 {
+		t.Fatalf("%v", fd.Body[20])
+	}
+
+	if x, ok := fd.Body[27].(*Jmp); !ok || x.Number != 2 {
+		t.Fatalf("%v", fd.Body[27])
+	}
+
+	if err := fd.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	run()
+	if g, e := fmt.Sprint(inv.Taken, inv.NotTaken), "1 9"; g != e {
+		t.Fatal(g, e)
+	}
+
+	n = len(fd.Body)
+	if err := OrderBlocks(fd); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(fd.Body), n; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestTypeCacheTrailing(t *testing.T) {
+	c := TypeCache{}
+	id := TypeID(dict.SID("int32(1)"))
+	for i := 0; i < 2; i++ {
+		if _, err := c.Type(id); err == nil {
+			t.Fatal(i)
+		}
+	}
+}
+
+func TestObjectReader(t *testing.T) {
+	foo := NameID(dict.SID("foo"))
+	out := Objects{
+		{
+			&DataDefinition{ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: foo, TypeID: idInt32}},
+			&FunctionDefinition{
+				Body:       testBody(10),
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: token.Position{Filename: "a.c", Line: 1}},
+			},
+		},
+		nil,
+		{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: foo, TypeID: idInt32}}},
+		nil,
+	}
+	var buf bytes.Buffer
+	if _, err := out.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	var in Objects
+	if _, err := in.ReadFrom(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(in), PrettyString(out); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	r, err := NewObjectReader(bytes.NewReader(b), HostTarget())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var units []int
+	r.Filter = func(unit int, b *ObjectBase) bool {
+		units = append(units, unit)
+		return b.Linkage == ExternalLinkage && b.NameID == foo
+	}
+	unit, o, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := unit, 2; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := PrettyString(o), PrettyString(out[2][0]); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if _, _, err := r.Next(); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(units), "[0 0 2]"; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestLabelAddr(t *testing.T) {
+	pv := TypeID(dict.SID("*struct{}"))
+	body := func(addr *LabelAddr) []Operation {
+		return []Operation{
+			&BeginScope{},
+			addr,
+			&JmpP{},
+			&Label{Number: 1},
+			&Result{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		}
+	}
+	f := &FunctionDefinition{
+		Body:       body(&LabelAddr{Number: 1, TypeID: pv}),
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(f.Body), 10; g != e {
+		t.Fatal(g, e)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := Parse("q.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(objs[0].(*FunctionDefinition).Body), PrettyString(f.Body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	f.Body = body(&LabelAddr{Number: 2, TypeID: pv})
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "undefined label") {
+		t.Fatal(err)
+	}
+
+	f.Body = body(&LabelAddr{Number: 1, TypeID: idPint32})
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "expected *struct{}") {
+		t.Fatal(err)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	c := TypeCache{}
+	for i, v := range []struct {
+		a, b, composite string
+	}{
+		{"int32", "int32", "int32"},
+		{"int32", "int64", ""},
+		{"int32", "t=int32", ""},
+		{"*[?]int32", "*[10]int32", "*[10]int32"},
+		{"[3]int32", "[?]int32", "[3]int32"},
+		{"[3]int32", "[4]int32", ""},
+		{"<4xint32>", "<4xint32>", "<4xint32>"},
+		{"<4xint32>", "<2xint32>", ""},
+		{"func()int32", "func(int8,...)int32", "func(int8,...)int32"},
+		{"func(*int8,...)", "func()", "func(*int8,...)"},
+		{"func(*[?]int8)int32", "func(*[2]int8)int32", "func(*[2]int8)int32"},
+		{"func(int8)int32", "func(int8,...)int32", ""},
+		{"func(int8)int32", "func(int16)int32", ""},
+		{"func()int32", "func()int64", ""},
+		{"func()([?]int8,int32)", "func(int8)([2]int8,int32)", "func(int8)([2]int8,int32)"},
+		{"struct{a int32}", "struct{b int32}", ""},
+	} {
+		a, b := TypeID(dict.SID(v.a)), TypeID(dict.SID(v.b))
+		if g, e := c.Compatible(a, b), v.composite != ""; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g, e := c.Compatible(b, a), v.composite != ""; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		id, err := c.Composite(a, b)
+		if v.composite == "" {
+			if err == nil {
+				t.Fatal(i, id)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := id.String(), v.composite; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	a := NameID(dict.SID("a"))
+	objs, err := LinkLib(
+		[]Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: a, TypeID: TypeID(dict.SID("[?]int32"))}}},
+		[]Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: a, TypeID: TypeID(dict.SID("[3]int32"))}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range objs {
+		if v.Base().NameID == a {
+			if g, e := v.Base().TypeID.String(), "[3]int32"; g != e {
+				t.Fatal(g, e)
+			}
+
+			return
+		}
+	}
+	t.Fatal("missing definition")
+}
+
+func TestFence(t *testing.T) {
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&BeginScope{},
+			&Fence{},
+			&Result{Address: true, TypeID: idPint32},
+			&Fence{Scope: FenceCompiler},
+			&Const32{TypeID: idInt32, Value: 42},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := buf.String(); !strings.Contains(s, "fence(compiler)") {
+		t.Fatal(s)
+	}
+
+	objs, err := Parse("q.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(objs[0].(*FunctionDefinition).Body), PrettyString(f.Body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := NewInterpreter([]Object{f}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := r[0].(uint64), uint64(42); g != e {
+		t.Fatal(g, e)
+	}
+
+	f.Body[1] = &Fence{Scope: -1}
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "invalid fence scope") {
+		t.Fatal(err)
+	}
+}
+
+func TestRenameInternal(t *testing.T) {
+	x := NameID(dict.SID("x"))
+	tf := TypeID(dict.SID("func()"))
+	data := func(file string, v int32) Object {
+		return &DataDefinition{
+			ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: x, TypeID: idInt32, Position: token.Position{Filename: file}},
+			Value:      &Int32Value{Value: v},
+		}
+	}
+	fn := func(file, nm string) Object {
+		return &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID(nm)), TypeID: tf, Position: token.Position{Filename: file}},
+			Body: []Operation{
+				&Global{Index: -1, Linkage: InternalLinkage, NameID: x, TypeID: idPint32},
+				&Drop{TypeID: idPint32},
+				&Return{},
+			},
+		}
+	}
+	// b.c refers to x before defining it, c.c does not define x.
+	out, err := LinkLib([]Object{
+		data("a.c", 1),
+		fn("a.c", "fa"),
+		fn("b.c", "fb"),
+		data("b.c", 2),
+		fn("c.c", "fc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	for _, v := range out {
+		if f, ok := v.(*FunctionDefinition); ok && f.NameID != idMain {
+			g := f.Body[0].(*Global)
+			a = append(a, fmt.Sprintf("%v:%v=%v", f.NameID, g.NameID, out[g.Index].(*DataDefinition).Value))
+		}
+	}
+	if g, e := strings.Join(a, " "), "fa:x=1 fb:x.0=2 fc:x.0=2"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	pragma := NameID(dict.SID("pragma"))
+	f := &FunctionDefinition{
+		Body: []Operation{
+			&BeginScope{},
+			&Annotate{Annotations: []Annotation{{Key: pragma, Value: `omp "parallel", for`}, {Key: NameID(dict.SID("todo")), Value: "\tx"}}},
+			&Result{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 42},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+		ObjectBase: ObjectBase{Annotations: []Annotation{{Key: pragma, Value: "once"}}, Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := PrettyString(f); !strings.Contains(s, "once") || !strings.Contains(s, "parallel") {
+		t.Fatal(s)
+	}
+
+	var buf bytes.Buffer
+	if _, err := (Objects{{f}}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var in Objects
+	if _, err := in.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(in[0][0]), PrettyString(f); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	b, err := json.Marshal(Objects{{f}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o Objects
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(o[0][0].Base().Annotations, f.Annotations) || !reflect.DeepEqual(o[0][0].(*FunctionDefinition).Body[1], f.Body[1]) {
+		t.Fatalf("%s", b)
+	}
+
+	buf.Reset()
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := Parse("q.s", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := PrettyString(objs[0].(*FunctionDefinition).Body), PrettyString(f.Body); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestABI(t *testing.T) {
+	st := TypeCache{}.MustType(TypeID(dict.SID("struct{a int8,b float128}")))
+	for _, v := range []struct {
+		abi        string
+		long       TypeKind
+		longDouble TypeKind
+		size       int64
+		regs       string
+	}{
+		{"aarch64-aapcs", Int64, Float128, 32, "true true true false"},
+		{"ilp32", Int32, Float128, 16, "false false false false"},
+		{"ms-x64", Int32, Float64, 24, "false true false false"},
+		{"sysv-amd64", Int64, Float128, 32, "true true true false"},
+	} {
+		a, err := NewABI(v.abi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.Model.Validate(); err != nil {
+			t.Fatal(v.abi, err)
+		}
+
+		if g, e := a.Long, v.long; g != e {
+			t.Fatal(v.abi, g, e)
+		}
+
+		if g, e := a.LongDouble, v.longDouble; g != e {
+			t.Fatal(v.abi, g, e)
+		}
+
+		if g, e := a.Model.Sizeof(st), v.size; g != e {
+			t.Fatal(v.abi, g, e)
+		}
+
+		if g, e := fmt.Sprint(a.PassInRegisters(3), a.PassInRegisters(8), a.PassInRegisters(16), a.PassInRegisters(24)), v.regs; g != e {
+			t.Fatal(v.abi, g, e)
+		}
+
+		m, err := NewMemoryModelFromABI(v.abi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := m.Sizeof(st), v.size; g != e {
+			t.Fatal(v.abi, g, e)
+		}
+	}
+
+	if _, err := NewMemoryModelFromABI("foo"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestCost(t *testing.T) {
+	m32, err := NewMemoryModelFor(Target{OS: "linux", Arch: "386"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m64, err := NewMemoryModelFor(Target{OS: "linux", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pst := TypeID(dict.SID("*struct{a int64,b int64}"))
+	for i, v := range []struct {
+		op       Operation
+		c32, c64 int
+	}{
+		{&Add{TypeID: idInt32}, 1, 1},
+		{&Add{TypeID: TypeID(dict.SID("int64"))}, 2, 1},
+		{&Div{TypeID: TypeID(dict.SID("int64"))}, 16, 8},
+		{&Label{}, 0, 0},
+		{&Fence{Scope: FenceCompiler}, 0, 0},
+		{&Load{TypeID: pst}, 4, 2},
+		{&Call{TypeID: idMainType}, 5, 5},
+	} {
+		if g, e := Cost(v.op, m32), v.c32; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g, e := Cost(v.op, m64), v.c64; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	f := &FunctionDefinition{Body: testBody(1)}
+	if g, e := FunctionCost(f, m64), 13; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := (CostTable{"Jz": 10}).FunctionCost(f, m64), 22; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestSSA(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// for i := 0; i < 10; i++ { s += i&1 != 0 ? i : 2*i }
+	loop := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+			&VariableDeclaration{Index: 1, TypeID: idInt32, Value: &Int32Value{Value: 0}},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+			&Const32{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Label{Number: 0},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 10},
+			&Lt{Signed: true, TypeID: idInt32},
+			&Jz{Number: 1},
+			&Variable{Address: true, Index: 1, TypeID: idPint32},
+			&Variable{Index: 1, TypeID: idInt32},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 1},
+			&And{TypeID: idInt32},
+			&Jz{Number: 2},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Jmp{Cond: true, Number: 3},
+			&Label{Cond: true, Number: 2},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Dup{TypeID: idInt32},
+			&Add{TypeID: idInt32},
+			&Label{Cond: true, Number: 3},
+			&Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Const32{TypeID: idInt32, Value: 1},
+			&Add{TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Jmp{Number: 0},
+			&Label{Number: 1},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Index: 1, TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	for i, f := range []*FunctionDefinition{
+		loop,
+		{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       testBody(3),
+		},
+	} {
+		if err := f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		run := func(f *FunctionDefinition) uint64 {
+			in, err := NewInterpreter([]Object{f}, m)
+			if err != nil {
+				t.Fatal(i, err)
+			}
+
+			r, err := in.Call(idMain)
+			if err != nil {
+				t.Fatal(i, err)
+			}
+
+			return r[0].(uint64)
+		}
+
+		s, err := ToSSA(f)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		phis := 0
+		for _, b := range s.Blocks {
+			phis += len(b.Phis)
+			for _, v := range b.Values {
+				switch v.Op.(type) {
+				case *Drop, *Dup, *Variable:
+					t.Fatalf("%v: unexpected %s\n%s", i, v, s)
+				}
+			}
+		}
+		if phis == 0 {
+			t.Fatalf("%v: missing phis\n%s", i, s)
+		}
+
+		g, err := s.Lower()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if err := g.Verify(); err != nil {
+			t.Fatalf("%v: %v\n%s", i, err, s)
+		}
+
+		e := run(f)
+		if i == 0 && e != 65 {
+			t.Fatal(i, e)
+		}
+
+		if g := run(g); g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	idPPint32 := TypeID(dict.SID("**int32"))
+	idPArray := TypeID(dict.SID("*[3]int32"))
+	// int32 *p, a[3]; if (assign) p = &a[index]; *p = 42; return *p;
+	body := func(assign bool, index int32) []Operation {
+		r := []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idPint32},
+			&VariableDeclaration{Index: 1, TypeID: TypeID(dict.SID("[3]int32"))},
+		}
+		if assign {
+			r = append(r,
+				&Variable{Address: true, Index: 0, TypeID: idPPint32},
+				&Variable{Address: true, Index: 1, TypeID: idPArray},
+				&Convert{TypeID: idPArray, Result: idPint32},
+				&Const32{TypeID: idInt32, Value: index},
+				&Element{Address: true, IndexType: idInt32, TypeID: idPint32},
+				&Store{TypeID: idPint32},
+				&Drop{TypeID: idPint32},
+			)
+		}
+		return append(r,
+			&Variable{Index: 0, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 42},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Index: 0, TypeID: idPint32},
+			&Load{TypeID: idPint32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		)
+	}
+	for i, v := range []struct {
+		assign bool
+		index  int32
+		panics int
+		fail   bool
+	}{
+		{true, 1, 2, false},
+		{true, 2, 2, false},
+		{false, 0, 2, true},
+		{true, 4, 3, true},
+		{true, -1, 3, true},
+	} {
+		f := &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       body(v.assign, v.index),
+		}
+		if err := Sanitize(f, SanitizePolicy{Bounds: true, Nil: true}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		n := 0
+		for _, op := range f.Body {
+			if _, ok := op.(*Panic); ok {
+				n++
+			}
+		}
+		if g, e := n, v.panics; g != e {
+			t.Fatalf("%v: got %v Panics, expected %v", i, g, e)
+		}
+
+		if err := f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		in, err := NewInterpreter([]Object{f}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if v.fail {
+			if err == nil || !strings.Contains(err.Error(), "panic") {
+				t.Fatal(i, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := r[0].(uint64), uint64(42); g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body:       testBody(3),
+	}
+	n := len(f.Body)
+	if err := Sanitize(f, SanitizePolicy{Bounds: true, Nil: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g := len(f.Body); g != n {
+		t.Fatal(g, n)
+	}
+}
+
+func TestCallResults(t *testing.T) {
+	ts := TypeID(dict.SID("struct{a int32,b int32}"))
+	tps := TypeID(dict.SID("*struct{a int32,b int32}"))
+	tf := TypeID(dict.SID("func()(int32,struct{a int32,b int32},struct{a int32,b int32})"))
+	tpf := TypeID(dict.SID("*func()(int32,struct{a int32,b int32},struct{a int32,b int32})"))
+	pair := NameID(dict.SID("pair"))
+	field := func(p Operation, index int, value int32) []Operation {
+		return []Operation{
+			p,
+			&Field{Address: true, Index: index, TypeID: tps},
+			&Const32{TypeID: idInt32, Value: value},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+		}
+	}
+	// return 3, {10, 20}, {100, 200}
+	callee := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: pair, TypeID: tf},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{TypeID: ts},
+			&Result{Address: true, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 3},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+		},
+	}
+	callee.Body = append(callee.Body, field(&Result{Address: true, Index: 1, TypeID: tps}, 0, 10)...)
+	callee.Body = append(callee.Body, field(&Result{Address: true, Index: 1, TypeID: tps}, 1, 20)...)
+	callee.Body = append(callee.Body, field(&Variable{Address: true, TypeID: tps}, 0, 100)...)
+	callee.Body = append(callee.Body, field(&Variable{Address: true, TypeID: tps}, 1, 200)...)
+	callee.Body = append(callee.Body,
+		&Result{Address: true, Index: 2, TypeID: tps},
+		&Variable{Address: true, TypeID: tps},
+		&Copy{TypeID: ts},
+		&Drop{TypeID: tps},
+		&Return{},
+		&EndScope{},
+	)
+	load := func(index, field int) []Operation {
+		return []Operation{
+			&Variable{Address: true, Index: index, TypeID: tps},
+			&Field{Index: field, TypeID: tps},
+			&Add{TypeID: idInt32},
+		}
+	}
+	// struct{a, b int32} x, y; int32 n; n, x, y = pair(); return n+x.a+x.b+y.a+y.b
+	main := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: ts},
+			&VariableDeclaration{Index: 1, TypeID: ts},
+			&Result{Address: true, TypeID: idPint32},
+			&AllocResult{TypeID: idInt32},
+			&Variable{Address: true, Index: 0, TypeID: tps},
+			&Variable{Address: true, Index: 1, TypeID: tps},
+			&Global{Index: -1, Linkage: ExternalLinkage, NameID: pair, TypeID: tpf},
+			&Arguments{},
+			&CallFP{TypeID: tpf},
+			&Drop{TypeID: tps},
+			&Drop{TypeID: tps},
+		},
+	}
+	main.Body = append(main.Body, load(0, 0)...)
+	main.Body = append(main.Body, load(0, 1)...)
+	main.Body = append(main.Body, load(1, 0)...)
+	main.Body = append(main.Body, load(1, 1)...)
+	main.Body = append(main.Body,
+		&Store{TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+		&Return{},
+		&EndScope{},
+	)
+	// Results reserved by value.
+	byValue := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: ts},
+			&Result{Address: true, TypeID: idPint32},
+			&AllocResult{TypeID: idInt32},
+			&Variable{Address: true, Index: 0, TypeID: tps},
+			&AllocResult{TypeID: ts},
+			&Global{Index: -1, Linkage: ExternalLinkage, NameID: pair, TypeID: tpf},
+			&Arguments{},
+			&CallFP{TypeID: tpf},
+			&Store{TypeID: ts},
+			&Drop{TypeID: ts},
+		},
+	}
+	byValue.Body = append(byValue.Body, load(0, 0)...)
+	byValue.Body = append(byValue.Body, load(0, 1)...)
+	byValue.Body = append(byValue.Body,
+		&Store{TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+		&Return{},
+		&EndScope{},
+	)
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		f *FunctionDefinition
+		e uint64
+	}{
+		{main, 333},
+		{byValue, 303},
+	} {
+		if err := callee.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if err := v.f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		s, err := ToSSA(v.f)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		g, err := s.Lower()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		for j, f := range []*FunctionDefinition{v.f, g} {
+			out, err := LinkLib([]Object{callee, f})
+			if err != nil {
+				t.Fatal(i, j, err)
+			}
+
+			in, err := NewInterpreter(out, m)
+			if err != nil {
+				t.Fatal(i, j, err)
+			}
+
+			r, err := in.Call(idMain)
+			if err != nil {
+				t.Fatal(i, j, err)
+			}
+
+			if g, e := r[0].(uint64), v.e; g != e {
+				t.Fatal(i, j, g, e)
+			}
+		}
+	}
+
+	bad := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+			&AllocResult{TypeID: ts},
+			&AllocResult{TypeID: ts},
+			&Global{Index: -1, Linkage: ExternalLinkage, NameID: pair, TypeID: tpf},
+			&Arguments{},
+			&CallFP{TypeID: tpf},
+			&Drop{TypeID: ts},
+			&Drop{TypeID: ts},
+			&Drop{TypeID: idPint32},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	if err := bad.Verify(); err == nil || !strings.Contains(err.Error(), "mismatched result #0") {
+		t.Fatal(err)
+	}
+}
+
+func TestCloneObject(t *testing.T) {
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{
+			Annotations: []Annotation{{Key: NameID(dict.SID("pragma")), Value: "once"}},
+			Linkage:     ExternalLinkage,
+			NameID:      idMain,
+			TypeID:      idMainType,
+		},
+		Body: testBody(3),
+	}
+	f.Body[1].(*VariableDeclaration).Value = &Int32Value{Value: 42}
+	d := &DataDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: TypeID(dict.SID("struct{a int32,b float128}"))},
+		Value: &CompositeValue{Values: []Value{
+			&DesignatedValue{Index: 0, Value: &Int32Value{Value: -1}},
+			&DesignatedValue{Index: 1, Value: &Float128Value{Value: big.NewFloat(1.5).SetPrec(Float128Prec)}},
+		}},
+	}
+	for i, o := range []Object{f, d} {
+		c := CloneObject(o)
+		if c == o || !EqualObjects(o, c) {
+			t.Fatal(i)
+		}
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(Objects{{o}}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		var g Objects
+		if err := gob.NewDecoder(&buf).Decode(&g); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if !EqualObjects(o, g[0][0]) {
+			t.Fatal(i)
+		}
+	}
+
+	c := CloneObject(f).(*FunctionDefinition)
+	if &c.Body[0] == &f.Body[0] || c.Body[1] == f.Body[1] || &c.Annotations[0] == &f.Annotations[0] {
+		t.Fatal("shared memory")
+	}
+
+	c.Body[1].(*VariableDeclaration).Value.(*Int32Value).Value = 24
+	if EqualObjects(f, c) || f.Body[1].(*VariableDeclaration).Value.(*Int32Value).Value != 42 {
+		t.Fatal("clone not independent")
+	}
+
+	e := CloneObject(d).(*DataDefinition)
+	e.Value.(*CompositeValue).Values[1].(*DesignatedValue).Value.(*Float128Value).Value.SetInt64(2)
+	if EqualObjects(d, e) {
+		t.Fatal("clone not independent")
+	}
+
+	op := &Drop{TypeID: idInt32}
+	f.Body = append(f.Body, op, op)
+	c = CloneObject(f).(*FunctionDefinition)
+	n := len(c.Body)
+	if c.Body[n-1] != c.Body[n-2] || c.Body[n-1] == Operation(op) {
+		t.Fatal("aliasing not preserved")
+	}
+
+	if EqualObjects(f, d) || EqualObjects(f, nil) || !EqualObjects(nil, nil) || CloneObject(nil) != nil {
+		t.Fatal("nil or kind mismatch")
+	}
+}
+
+func TestShiftCountType(t *testing.T) {
+	idUint8 := TypeID(dict.SID("uint8"))
+	body := func(count TypeID) []Operation {
+		return []Operation{
+			&BeginScope{},
+			&Result{Address: true, TypeID: idPint32},
+			&Const64{TypeID: idUint64, Value: 1},
+			&Const64{TypeID: idUint64, Value: 40},
+			&Lsh{CountType: idUint64, TypeID: idUint64},
+			&Const32{TypeID: count, Value: 38},
+			&Rsh{CountType: count, TypeID: idUint64},
+			&Convert{TypeID: idUint64, Result: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		}
+	}
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body:       body(idUint8),
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	in, err := NewInterpreter([]Object{f}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := r[0].(uint64), uint64(4); g != e {
+		t.Fatal(g, e)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if d := Diff([]Object{f}, p); len(d) != 0 {
+		t.Fatalf("%v\n%s", d, buf.Bytes())
+	}
+
+	f.Body = body(TypeID(dict.SID("float32")))
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "shift count must be an integral type") {
+		t.Fatal(err)
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	idFloat16 := TypeID(dict.SID("float16"))
+	idFloat32 := TypeID(dict.SID("float32"))
+	typ, err := TypeCache{}.Type(idFloat16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := typ.Kind(), Float16; g != e {
+		t.Fatal(g, e)
+	}
+
+	for _, v := range []struct {
+		f float64
+		h uint16
+	}{
+		{0, 0},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.1, 0x2e66},
+		{1. / 3, 0x3555},
+		{65504, 0x7bff},
+		{65519, 0x7bff},
+		{65520, 0x7c00},
+		{0x1p-14, 0x0400},
+		{0x1p-24, 0x0001},
+		{0x1p-26, 0},
+		{math.Inf(-1), 0xfc00},
+	} {
+		if g, e := float16Bits(v.f), v.h; g != e {
+			t.Errorf("%v: %#04x %#04x", v.f, g, e)
+		}
+		if g, e := float16Bits(float16FromBits(v.h)), v.h; g != e {
+			t.Errorf("%#04x: %#04x %#04x", v.h, g, e)
+		}
+	}
+	if g := float16FromBits(float16Bits(math.NaN())); !math.IsNaN(g) {
+		t.Error(g)
+	}
+
+	for _, arch := range []string{"386", "amd64p32", "amd64"} {
+		m, err := NewMemoryModelFor(Target{OS: "linux", Arch: arch})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := m.Sizeof(typ), int64(2); g != e {
+			t.Fatal(arch, g, e)
+		}
+	}
+
+	// float16 h = 0.1; r = int32(float32(h)*10000); h = float16(3.14159); return r+int32(float32(h)*1000)
+	scaled := func(n float32) []Operation {
+		return []Operation{
+			&Variable{TypeID: idFloat16},
+			&Convert{TypeID: idFloat16, Result: idFloat32},
+			&Const32{TypeID: idFloat32, Value: int32(math.Float32bits(n))},
+			&Mul{TypeID: idFloat32},
+			&Convert{TypeID: idFloat32, Result: idInt32},
+		}
+	}
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{TypeID: idFloat16, Value: &Float32Value{Value: 0.1}},
+			&Result{Address: true, TypeID: idPint32},
+		},
+	}
+	f.Body = append(f.Body, scaled(10000)...)
+	f.Body = append(f.Body,
+		&Variable{Address: true, TypeID: TypeID(dict.SID("*float16"))},
+		&Const32{TypeID: idFloat32, Value: int32(math.Float32bits(3.14159))},
+		&Convert{TypeID: idFloat32, Result: idFloat16},
+		&Store{TypeID: idFloat16},
+		&Drop{TypeID: idFloat16},
+	)
+	f.Body = append(f.Body, scaled(1000)...)
+	f.Body = append(f.Body,
+		&Add{TypeID: idInt32},
+		&Store{TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+		&Return{},
+		&EndScope{},
+	)
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	in, err := NewInterpreter([]Object{f}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := r[0].(uint64), uint64(999+3140); g != e {
+		t.Fatal(g, e)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if d := Diff([]Object{f}, p); len(d) != 0 {
+		t.Fatalf("%v\n%s", d, buf.Bytes())
+	}
+
+	f.Body[4].(*Convert).Result = TypeID(dict.SID("float64"))
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "float16 converts only to and from float32") {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyValue(t *testing.T) {
+	ta := TypeID(dict.SID("[3]int32"))
+	ts := TypeID(dict.SID("struct{a int32,b [3]int32}"))
+	designated := func(index int, v Value) Value { return &DesignatedValue{Index: index, Value: v} }
+	for i, v := range []struct {
+		v   Value
+		t   TypeID
+		err string
+	}{
+		{&CompositeValue{Values: []Value{designated(2, &Int32Value{Value: 1})}}, ta, ""},
+		{&CompositeValue{Values: []Value{designated(3, &Int32Value{Value: 1})}}, ta, "index 3 out of bounds"},
+		{&CompositeValue{Values: []Value{designated(1, &Int32Value{Value: 1}), &Int32Value{Value: 2}, &Int32Value{Value: 3}}}, ta, "index 3 out of bounds"},
+		{&CompositeValue{Values: []Value{designated(-1, &Int32Value{Value: 1})}}, ta, "index -1 out of bounds"},
+		{&CompositeValue{Values: []Value{designated(1, &CompositeValue{Values: []Value{designated(2, &Int32Value{Value: 1})}})}}, ts, ""},
+		{&CompositeValue{Values: []Value{designated(2, &Int32Value{Value: 1})}}, ts, "field index 2 out of range"},
+		{&CompositeValue{Values: []Value{designated(1, &CompositeValue{Values: []Value{designated(4, &Int32Value{Value: 1})}})}}, ts, "index 4 out of bounds"},
+	} {
+		err := VerifyValue(v.v, v.t, nil)
+		if v.err == "" {
+			if err != nil {
+				t.Fatal(i, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Fatal(i, err)
+		}
+
+		d := &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: v.t}, Value: v.v}
+		if g, e := d.Verify() == nil, v.err == ""; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		_, err = LinkLib([]Object{d})
+		if g, e := err == nil, v.err == ""; g != e {
+			t.Fatal(i, g, e, err)
+		}
+
+		f := &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       testBody(1),
+		}
+		f.Body = append(f.Body[:2], append([]Operation{&VariableDeclaration{Index: 1, TypeID: v.t, Value: v.v}}, f.Body[2:]...)...)
+		if err := f.Verify(); (err == nil) != (v.err == "") {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	var a bytes.Buffer
+	opts := &WriteOptions{Features: []string{"b", "a"}, Producer: "cc", ProducerVersion: "1.2"}
+	if _, err := (Objects{main}).WriteToOptions(&a, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(bytes.NewReader(a.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Checksums != nil {
+		t.Fatal(m.Checksums)
+	}
+
+	a.Reset()
+	opts.Checksum = true
+	if _, err := (Objects{main}).WriteToOptions(&a, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = ReadManifest(bytes.NewReader(a.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != manifestVersion || m.BinaryVersion != binaryVersion || m.Target != HostTarget() || m.Producer != "cc" || m.ProducerVersion != "1.2" {
+		t.Fatalf("%+v", m)
+	}
+
+	if g, e := fmt.Sprint(m.Features), "[a b]"; g != e || !m.HasFeature("b") || m.HasFeature("c") {
+		t.Fatalf("got %s, expected %s", g, e)
+	}
+
+	if len(m.Checksums["sha256"]) != 64 {
+		t.Fatal(m.Checksums)
+	}
+
+	// rewrite returns the data in a with the gzip extra field set to extra.
+	rewrite := func(extra []byte) []byte {
+		gr, err := gzip.NewReader(bytes.NewReader(a.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(gr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		gw := gzip.NewWriter(&b)
+		gw.Header.Extra = extra
+		if _, err := gw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		return b.Bytes()
+	}
+
+	host := HostTarget()
+	for i, v := range []struct {
+		extra []byte
+		err   string
+	}{
+		{a.Bytes(), ""},
+		{append(append([]byte(nil), magic...), fmt.Sprintf("%s|%s|%v", host.OS, host.Arch, binaryVersion)...), ""},
+		{append(append([]byte(nil), magic...), fmt.Sprintf("%s|%s|%v", host.OS, host.Arch, binaryVersion-1)...), "version"},
+		{append(append([]byte(nil), magic...), `{"Version":1,"BinaryVersion":4,"Target":{"OS":"foo"}}`...), "foo"},
+		{append(append([]byte(nil), magic...), `{"Version":99}`...), "manifest version"},
+		{append(append([]byte(nil), magic...), fmt.Sprintf(`{"Version":1,"BinaryVersion":%v,"Target":{"OS":%q,"Arch":%q},"Checksums":{"sha256":"00"},"Future":true}`, binaryVersion, host.OS, host.Arch)...), "checksum"},
+		{[]byte("foo"), "unrecognized"},
+	} {
+		b := v.extra
+		if i != 0 {
+			b = rewrite(v.extra)
+		}
+		var o Objects
+		_, err := o.ReadFrom(bytes.NewReader(b))
+		if v.err == "" {
+			if err != nil {
+				t.Fatal(i, err)
+			}
+
+			if !EqualObjects(o[0][0], main[0]) {
+				t.Fatal(i)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Fatal(i, err)
+		}
+	}
+
+	r, err := NewObjectReader(bytes.NewReader(rewrite(append(append([]byte(nil), magic...), fmt.Sprintf("%s|%s|%v", host.OS, host.Arch, binaryVersion)...))), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m := r.Manifest(); m.Version != 0 || m.Target != host || m.Checksums != nil {
+		t.Fatalf("%+v", m)
+	}
+}
+
+func TestEvalConst(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	i32 := func(n int32) Value { return &Int32Value{Value: n} }
+	designated := func(index int, v Value) Value { return &DesignatedValue{Index: index, Value: v} }
+	composite := func(v ...Value) Value { return &CompositeValue{Values: v} }
+	for i, v := range []struct {
+		v   Value
+		t   string
+		e   string
+		err string
+	}{
+		{i32(-1), "uint8", "255u", ""},
+		{i32(255), "int8", "-1", ""},
+		{i32(256), "uint8", "", "overflows"},
+		{i32(-129), "int8", "", "overflows"},
+		{&Uint64Value{Value: math.MaxUint64}, "int64", "-1", ""},
+		{i32(3), "float32", "3", ""},
+		{i32(1 << 24), "float16", "", "overflows"},
+		{&Float64Value{Value: 1e300}, "float32", "", "overflows"},
+		{&Float64Value{Value: -2.9}, "int32", "-2", ""},
+		{&Float64Value{Value: 300}, "uint8", "", "overflows"},
+		{&Float64Value{Value: math.NaN()}, "int32", "", "overflows"},
+		{i32(2), "float128", "2", ""},
+		{i32(2), "complex64", "(2+0i)", ""},
+		{&Complex128Value{Value: 2 + 3i}, "float64", "2", ""},
+		{composite(i32(7)), "int64", "7", ""},
+		{composite(), "float64", "0", ""},
+		{composite(i32(1), i32(2)), "int32", "", "too many values"},
+		{composite(designated(2, i32(3)), designated(0, i32(1)), i32(2)), "[3]float64", "{1, 2, 3}", ""},
+		{composite(designated(2, i32(3)), designated(2, i32(4))), "[4]int8", "{2: 4}", ""},
+		{composite(designated(3, i32(3))), "[3]int8", "", "index 3 out of bounds"},
+		{composite(composite(i32(1), i32(2)), designated(2, composite(i32(3)))), "[3]struct{a int32,b float64}", "{{1, 2}, 2: {3}}", ""},
+		{composite(i32(8), i32(-1)), "struct{a int32:3,b uint8:2}", "", "overflows bit field of 3 bits"},
+		{composite(i32(-1), i32(-1)), "struct{a int32:3,b uint8:2}", "{-1, 3u}", ""},
+		{composite(i32(1), designated(0, &Float64Value{Value: 2.5})), "union{a int32,b float64}", "{2}", ""},
+		{composite(&AddressValue{NameID: idMain}), "struct{a *int8:1}", "", "bit field"},
+		{&StringValue{StringID: StringID(dict.SID("abc"))}, "[2]int8", "", "does not fit"},
+		{&StringValue{StringID: StringID(dict.SID("abc"))}, "*int8", `"abc"+0`, ""},
+	} {
+		r, err := EvalConst(v.v, TypeID(dict.SID(v.t)), nil, m)
+		if v.err != "" {
+			if err == nil || !strings.Contains(err.Error(), v.err) {
+				t.Fatal(i, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := fmt.Sprint(r), v.e; g != e {
+			t.Fatalf("%v: got %s, expected %s", i, g, e)
+		}
+
+		if err := VerifyValue(r, TypeID(dict.SID(v.t)), nil); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestEliminateDeadStores(t *testing.T) {
+	c := func(n int32) Operation { return &Const32{TypeID: idInt32, Value: n} }
+	v0 := func() Operation { return &Variable{Address: true, Index: 0, TypeID: idPint32} }
+	store := func(ops ...Operation) []Operation {
+		return append(append(append([]Operation{v0()}, ops...), &Store{TypeID: idInt32}), &Drop{TypeID: idInt32})
+	}
+	ret := []Operation{
+		&Result{Address: true, TypeID: idPint32},
+		&Variable{Index: 0, TypeID: idInt32},
+		&Store{TypeID: idInt32},
+		&Drop{TypeID: idInt32},
+	}
+	f := func(ops ...[]Operation) *FunctionDefinition {
+		body := []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+		}
+		for _, v := range ops {
+			body = append(body, v...)
+		}
+		body = append(body, &Return{}, &EndScope{})
+		return &FunctionDefinition{
+			Body:       body,
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		}
+	}
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for i, v := range []struct {
+		f       *FunctionDefinition
+		removed int
+		result  int32
+	}{
+		{f(store(c(1)), store(c(2)), ret), 2, 2},
+		{f(store(c(1)), store(c(2))), 4, 0},
+		{f(store(c(1)), []Operation{c(0), &Jz{Number: 0}}, store(c(2)), []Operation{&Label{Number: 0}}, ret), 0, 1},
+		{f(store(c(1)), []Operation{c(1), &Jz{Number: 0}}, store(c(2)), []Operation{&Label{Number: 0}}, ret), 0, 2},
+		{f(store(c(1)), []Operation{c(1), &Jz{Number: 0}, &Label{Number: 1}}, store(c(2)), []Operation{&Jmp{Number: 2}, &Label{Number: 0}}, store(c(3)), []Operation{&Label{Number: 2}}, ret), 2, 2},
+		{f(store(c(1)), []Operation{&Fence{}}, store(c(2)), ret), 0, 2},
+		{f([]Operation{v0(), c(1), &Store{TypeID: idInt32, Volatile: true}, &Drop{TypeID: idInt32}}, store(c(2)), ret), 0, 2},
+		{f([]Operation{v0(), &Dup{TypeID: idPint32}, c(1), &Store{TypeID: idInt32}, &Drop{TypeID: idInt32}, c(2), &Store{TypeID: idInt32}, &Drop{TypeID: idInt32}}, ret), 0, 2},
+		{f(store(c(1)), []Operation{&Variable{Address: true, Index: 0, TypeID: idPint32}, &Load{TypeID: idPint32}, &Drop{TypeID: idInt32}}, store(c(2)), ret), 0, 2},
+		{f(store(c(1)), []Operation{&Variable{Address: true, Index: 0, TypeID: idPint32}, &Convert{TypeID: idPint32, Result: idPint32}, &Drop{TypeID: idPint32}}, store(c(2)), ret), 0, 2},
+	} {
+		n := len(v.f.Body)
+		if err := EliminateDeadStores(v.f); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := n-len(v.f.Body), v.removed; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if err := v.f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if v.result == 0 {
+			continue
+		}
+
+		out, err := LinkLib([]Object{v.f})
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		in, err := NewInterpreter(out, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if g, e := int32(r[0].(uint64)), v.result; g != e {
+			t.Fatal(i, g, e)
+		}
+	}
+}
+
+func TestNestedLayout(t *testing.T) {
+	m, err := NewMemoryModelFor(Target{OS: "linux", Arch: "386"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typ := types.MustType(TypeID(dict.SID("struct{a int8,_ union{d float64,b [9]int8},c int32}"))).(*StructOrUnionType)
+	if g, e := m.Alignof(typ.Fields[1]), 4; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m.Sizeof(typ.Fields[1]), int64(12); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := m.Sizeof(typ), int64(20); g != e {
+		t.Fatal(g, e)
+	}
+
+	if m, err = NewMemoryModelFor(Target{OS: "linux", Arch: "amd64"}); err != nil {
+		t.Fatal(err)
+	}
+
+	nm := func(s string) NameID { return NameID(dict.SID(s)) }
+	typ = types.MustType(TypeID(dict.SID("struct{a int8,_ struct{x int32,_ union{y int16,z float64}},s struct{q int32},w int32:3}"))).(*StructOrUnionType)
+	root := m.NestedLayout(typ)
+	if g, e := root.Size, m.Sizeof(typ); g != e {
+		t.Fatal(g, e)
+	}
+
+	for i, v := range []struct {
+		nm   string
+		off  int64
+		path string
+	}{
+		{"a", 0, "[0]"},
+		{"x", 8, "[1 0]"},
+		{"y", 16, "[1 1 0]"},
+		{"z", 16, "[1 1 1]"},
+		{"s", 24, "[2]"},
+		{"w", 28, "[3]"},
+		{"q", -1, ""},
+		{"_", -1, ""},
+	} {
+		f := root.Field(nm(v.nm))
+		if v.off < 0 {
+			if f != nil {
+				t.Fatal(i, f.Path)
+			}
+
+			continue
+		}
+
+		if f == nil {
+			t.Fatal(i)
+		}
+
+		if g, e := f.Offset, v.off; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if g, e := fmt.Sprint(f.Path), v.path; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if off, err := m.Offsetof(typ, f.Path...); err != nil || off != f.Offset {
+			t.Fatal(i, off, err)
+		}
+	}
+
+	if f := root.Field(nm("w")); f.Bits != 3 || f.Anonymous || f.Fields != nil {
+		t.Fatalf("%+v", f)
+	}
+
+	if !root.Fields[1].Anonymous || root.Fields[2].Anonymous || root.Fields[2].Field(nm("q")).Offset != 24 {
+		t.Fatal("anonymous")
+	}
+}
+
+func TestMergeConstants(t *testing.T) {
+	nm := func(s string) NameID { return NameID(dict.SID(s)) }
+	idPpint32 := TypeID(dict.SID("**int32"))
+	idPint8 := TypeID(dict.SID("*int8"))
+	data := func(name string, ro bool, typ TypeID, v Value) *DataDefinition {
+		return &DataDefinition{ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: nm(name), TypeID: typ}, ReadOnly: ro, Value: v}
+	}
+	global := func(name string, typ TypeID) Operation {
+		return &Global{Address: true, Index: -1, Linkage: InternalLinkage, NameID: nm(name), TypeID: typ}
+	}
+	str := func(s string) *StringValue { return &StringValue{StringID: StringID(dict.SID(s))} }
+	sv := str("bc")
+	unit := []Object{
+		data("d1", true, idInt32, &Int32Value{Value: 42}),
+		data("d2", true, idInt32, &Int32Value{Value: 42}),
+		data("d3", false, idInt32, &Int32Value{Value: 42}),
+		data("p1", true, idPint32, &AddressValue{Linkage: InternalLinkage, NameID: nm("d1")}),
+		data("p2", true, idPint32, &AddressValue{Linkage: InternalLinkage, NameID: nm("d2")}),
+		data("s", true, idPint8, sv),
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&BeginScope{},
+				&Result{Address: true, TypeID: idPint32},
+				global("d2", idPint32),
+				&Load{TypeID: idPint32},
+				global("p2", idPpint32),
+				&Load{TypeID: idPpint32},
+				&Load{TypeID: idPint32},
+				&Add{TypeID: idInt32},
+				global("d3", idPint32),
+				&Load{TypeID: idPint32},
+				&Add{TypeID: idInt32},
+				global("p1", idPpint32),
+				&Load{TypeID: idPpint32},
+				&Load{TypeID: idPint32},
+				&Add{TypeID: idInt32},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				global("s", TypeID(dict.SID("**int8"))),
+				&Load{TypeID: TypeID(dict.SID("**int8"))},
+				&Drop{TypeID: idPint8},
+				&StringConst{Value: StringID(dict.SID("abc")), TypeID: idPint8},
+				&Drop{TypeID: idPint8},
+				&Return{},
+				&EndScope{},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, unit[:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := buf.String(); strings.Count(s, "data(ro)\t") != 2 || strings.Count(s, "data\t") != 1 {
+		t.Fatal(s)
+	}
+
+	objs, err := Parse("", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !objs[0].(*DataDefinition).ReadOnly || objs[2].(*DataDefinition).ReadOnly {
+		t.Fatal(objs)
+	}
+
+	out, err := LinkLib(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := len(out)
+	if out, err = NewPassManager(PassMergeConstants).Run(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(out), n-2; g != e {
+		t.Fatal(g, e)
+	}
+
+	names := map[NameID]bool{}
+	for _, v := range out {
+		names[v.Base().NameID] = true
+	}
+	if names[nm("d1")] == names[nm("d2")] || names[nm("p1")] == names[nm("p2")] || !names[nm("d3")] {
+		t.Fatal(names)
+	}
+
+	if g, e := sv.String(), `"abc"+1`; g != e {
+		t.Fatal(g, e)
+	}
+
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	in, err := NewInterpreter(out, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := in.Call(idMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := int32(r[0].(uint64)), int32(168); g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestInt128(t *testing.T) {
+	idUint128 := TypeID(dict.SID("uint128"))
+	for _, v := range []struct {
+		s string
+		k TypeKind
+	}{
+		{"int128", Int128},
+		{"uint128", Uint128},
+		{"int16", Int16},
+		{"uint16", Uint16},
+	} {
+		typ, err := TypeCache{}.Type(TypeID(dict.SID(v.s)))
+		if err != nil {
+			t.Fatal(v.s, err)
+		}
+
+		if g, e := typ.Kind(), v.k; g != e {
+			t.Fatal(v.s, g, e)
+		}
+	}
+	if !idInt128.Signed() || idUint128.Signed() {
+		t.Fatal("signedness")
+	}
+
+	if _, err := (TypeCache{}).Type(TypeID(dict.SID("struct{a int128:3}"))); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	for _, v := range []struct {
+		arch  string
+		size  int64
+		align int
+	}{
+		{"386", 0, 0},
+		{"amd64p32", 16, 16},
+		{"amd64", 16, 16},
+	} {
+		m, err := NewMemoryModelFor(Target{OS: "linux", Arch: v.arch})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Validate(); err != nil {
+			t.Fatal(v.arch, err)
+		}
+
+		if _, ok := m[Int128]; !ok {
+			if v.size != 0 {
+				t.Fatal(v.arch)
+			}
+			continue
+		}
+
+		if g, e := m.Sizeof(TypeCache{}.MustType(idInt128)), v.size; g != e {
+			t.Fatal(v.arch, g, e)
+		}
+
+		if g, e := m.Alignof(TypeCache{}.MustType(idUint128)), v.align; g != e {
+			t.Fatal(v.arch, g, e)
+		}
+	}
+
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	if err := VerifyValue(&Int128Value{Value: max}, idUint128, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyValue(&Int128Value{Value: new(big.Int).Add(max, big.NewInt(1))}, idInt128, nil); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatal(err)
+	}
+
+	if err := VerifyValue(&Int128Value{Value: big.NewInt(1)}, idInt64, nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	for _, v := range []struct {
+		v Value
+		t TypeID
+		e string
+	}{
+		{&Int32Value{Value: -1}, idUint128, max.String()},
+		{&Int32Value{Value: -1}, idInt128, max.String()},
+		{&Uint64Value{Value: math.MaxUint64}, idInt128, "18446744073709551615"},
+		{&Float64Value{Value: 1e30}, idInt128, "1000000000000000019884624838656"},
+	} {
+		r, err := EvalConst(v.v, v.t, nil, MemoryModel{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := fmt.Sprint(r), v.e; g != e {
+			t.Fatalf("got %s, expected %s", g, e)
+		}
+	}
+	if _, err := EvalConst(&Float64Value{Value: 1e40}, idInt128, nil, MemoryModel{}); err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Fatal(err)
+	}
+
+	d := &DataDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("x")), TypeID: TypeID(dict.SID("[2]int128"))},
+		Value:      &CompositeValue{Values: []Value{&Int128Value{Value: big.NewInt(-2)}, &Int128Value{Value: new(big.Int).Lsh(big.NewInt(1), 64)}}},
+	}
+	for _, v := range []struct {
+		arch string
+		e    string
+	}{
+		{"amd64", "feffffffffffffffffffffffffffffff0000000000000000" + "0100000000000000"},
+		{"ppc64", "fffffffffffffffffffffffffffffffe0000000000000001" + "0000000000000000"},
+	} {
+		m, err := NewMemoryModelFor(Target{OS: "linux", Arch: v.arch})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _, err := EmitData(d, m, TypeCache{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := hex.EncodeToString(b), v.e; g != e {
+			t.Fatalf("%s:\ngot %s\nexp %s", v.arch, g, e)
+		}
+	}
+
+	// int128 x = 1<<100; return int32((x<<1)+x >> 100)
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{TypeID: idInt128, Value: &Int128Value{Value: new(big.Int).Lsh(big.NewInt(1), 100)}},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{TypeID: idInt128},
+			&Const32{TypeID: idInt32, Value: 1},
+			&Lsh{TypeID: idInt128},
+			&Variable{TypeID: idInt128},
+			&Add{TypeID: idInt128},
+			&Const{TypeID: idInt128, Value: &Int128Value{Value: big.NewInt(100)}},
+			&Convert{TypeID: idInt128, Result: idInt32},
+			&Rsh{TypeID: idInt128},
+			&Neg{TypeID: idInt128},
+			&Const{TypeID: idInt128, Value: &Int128Value{Value: max}},
+			&Lt{Signed: true, TypeID: idInt128},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAssembly(&buf, []Object{f, d}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Parse("test", buf.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if d := Diff([]Object{f, d}, p); len(d) != 0 {
+		t.Fatalf("%v\n%s", d, buf.Bytes())
+	}
+
+	b, err := json.Marshal(Objects{{f, d}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o Objects
+	if err := json.Unmarshal(b, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if !EqualObjects(d, o[0][1]) {
+		t.Fatalf("%s", b)
+	}
+}
+
+func TestLinkCallFPType(t *testing.T) {
+	g := NameID(dict.SID("g"))
+	pos := token.Position{Filename: "a.c", Line: 2}
+	for i, v := range []struct {
+		ptr string
+		ok  bool
+	}{
+		{"*func(int64)", false},
+		{"*func()", true}, // Calling a function declared without a prototype.
+		{"*func(int32)", true},
+	} {
+		tp := TypeID(dict.SID(v.ptr))
+		_, err := LinkLib([]Object{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: g, TypeID: TypeID(dict.SID("func(int32)"))},
+				Body:       []Operation{&Return{}},
+			},
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+				Body: []Operation{
+					&Global{Index: -1, Linkage: ExternalLinkage, NameID: g, TypeID: tp, Position: pos},
+					&Arguments{},
+					&CallFP{TypeID: tp, Position: pos},
+					&Const32{TypeID: idInt32},
+					&Return{},
+				},
+			},
+		})
+		if v.ok {
+			if err != nil {
+				t.Fatal(i, err)
+			}
+
+			continue
+		}
+
+		x, ok := err.(LinkError)
+		if !ok {
+			t.Fatalf("%v: %T %v", i, err, err)
+		}
+
+		if g, e := len(x), 1; g != e {
+			t.Fatal(i, g, e, err)
+		}
+
+		if x[0].Position != pos || !strings.Contains(x[0].Msg, "incompatible function pointer type "+v.ptr) || !strings.Contains(x[0].Msg, "func(int32)") {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestSmallTypeIDs(t *testing.T) {
+	base := baseTypes.Load().(TypeCache)
+	for _, v := range standardTypes {
+		id := TypeID(dict.SID(v))
+		if g, e := id.String(), v; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+
+		c := TypeCache{}
+		if g, e := c.MustType(id), base[id]; g != e {
+			t.Fatal(v, g, e)
+		}
+
+		if g, e := len(c), 0; g != e {
+			t.Fatal(v, g, e)
+		}
+	}
+	if len(smallTypes) == 0 {
+		t.Fatal("no small TypeIDs")
+	}
+
+	const s = "struct{a int8,b [2]uint128}"
+	id := TypeID(dict.SID(s))
+	for i := 0; i < 2; i++ {
+		if g, e := id.String(), s; g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+	}
+	if g, e := TypeID(0).String(), ""; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestLinker(t *testing.T) {
+	f := NameID(dict.SID("f"))
+	tf := TypeID(dict.SID("func()int32"))
+	tpf := TypeID(dict.SID("*func()int32"))
+	unit := func(n int32) []Object {
+		return []Object{
+			&FunctionDefinition{
+				ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: f, TypeID: tf},
+				Body: []Operation{
+					&Result{Address: true, TypeID: idPint32},
+					&Const32{TypeID: idInt32, Value: n},
+					&Store{TypeID: idInt32},
+					&Drop{TypeID: idInt32},
+					&Return{},
+				},
+			},
+		}
+	}
+	mainUnit := []Object{
+		&FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body: []Operation{
+				&Result{Address: true, TypeID: idPint32},
+				&AllocResult{TypeID: idInt32},
+				&Global{Index: -1, Linkage: ExternalLinkage, NameID: f, TypeID: tpf},
+				&Arguments{},
+				&CallFP{TypeID: tpf},
+				&Store{TypeID: idInt32},
+				&Drop{TypeID: idInt32},
+				&Return{},
+			},
+		},
+	}
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	run := func(l *Linker) int32 {
+		out, err := l.FinalizeLib(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		in, err := NewInterpreter(out, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := in.Call(idMain)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return int32(r[0].(uint64))
+	}
+
+	clone := CloneObject(mainUnit[0])
+	var l Linker
+	if err := l.AddUnit(mainUnit); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.AddUnit(unit(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := run(&l), int32(42); g != e {
+		t.Fatal(g, e)
+	}
+
+	if !EqualObjects(mainUnit[0], clone) {
+		t.Fatal("linker mutated its input")
+	}
+
+	if err := l.ReplaceUnit(1, unit(314)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := run(&l), int32(314); g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := run(&l), int32(314); g != e {
+		t.Fatal(g, e)
+	}
+
+	if err := l.ReplaceUnit(1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.FinalizeLib(nil); err == nil || !strings.Contains(err.Error(), "undefined reference to f") {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Finalize(); err == nil || !strings.Contains(err.Error(), "_start undefined") {
+		t.Fatal(err)
+	}
+
+	if err := l.ReplaceUnit(2, nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if g, e := l.Units(), 2; g != e {
+		t.Fatal(g, e)
+	}
+}
+
+func TestRemapPositions(t *testing.T) {
+	pos := func(fn string, line int) token.Position { return token.Position{Filename: fn, Line: line} }
+	sw := &Switch{
+		TypeID:  idInt32,
+		Default: Label{Number: 0, Position: pos("/sandbox/src/a.c", 3)},
+		Labels:  []Label{{Number: 1, Position: pos("/sandbox/srcx/a.c", 4)}},
+		Values:  []Value{&Int32Value{Value: 1}},
+	}
+	ret := &Return{Position: pos("/sandbox/src/a.c", 9)}
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType, Position: pos("/sandbox/src/a.c", 1)},
+		Body: []Operation{
+			&Const32{TypeID: idInt32, Position: pos("/sandbox/inc/a.h", 2)},
+			sw,
+			ret,
+			ret,
+		},
+	}
+	g := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("g")), TypeID: TypeID(dict.SID("func()")), Position: pos("/sandbox/src/b.c", 1)},
+		Body:       []Operation{&Return{Position: pos("/sandbox/src/b.c", 2)}},
+	}
+	g.CompressPositions()
+	d := &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("d")), TypeID: idInt32, Position: pos("/usr/include/c.h", 5)}}
+	RemapPositions([]Object{f, g, d}, []PathRule{
+		{From: "/sandbox/inc/", To: "/include/"},
+		{From: "/sandbox/src", To: "/home/src"},
+		{From: "/sandbox", To: "/other"},
+	})
+	for i, v := range []struct {
+		g token.Position
+		e string
+	}{
+		{f.Position, "/home/src/a.c"},
+		{f.Body[0].Pos(), "/include/a.h"},
+		{sw.Default.Position, "/home/src/a.c"},
+		{sw.Labels[0].Position, "/other/srcx/a.c"},
+		{ret.Position, "/home/src/a.c"},
+		{g.Position, "/home/src/b.c"},
+		{g.BodyPosition(0), "/home/src/b.c"},
+		{d.Position, "/usr/include/c.h"},
+	} {
+		if g, e := v.g.Filename, v.e; g != e {
+			t.Errorf("%v: got %q, expected %q", i, g, e)
+		}
+	}
+}
+
+func TestElementBoundsChecked(t *testing.T) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	idPArray := TypeID(dict.SID("*[3]int32"))
+	// int32 a[3] = {...}; return a[index];
+	body := func(index int32, address bool, items int64, unknown bool) []Operation {
+		r := []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: TypeID(dict.SID("[3]int32"))},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Address: true, Index: 0, TypeID: idPArray},
+			&Convert{TypeID: idPArray, Result: idPint32},
+			&Const32{TypeID: idInt32, Value: index},
+			&Element{Address: address, BoundsChecked: true, IndexType: idInt32, Items: items, ItemsUnknown: unknown, TypeID: idPint32},
+		}
+		if address {
+			r = append(r, &Drop{TypeID: idPint32}, &Const32{TypeID: idInt32, Value: 42})
+		}
+		return append(r,
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		)
+	}
+	for i, v := range []struct {
+		index   int32
+		address bool
+		items   int64
+		unknown bool
+		fail    bool
+	}{
+		{0, false, 3, false, false},
+		{2, false, 3, false, false},
+		{3, false, 3, false, true},
+		{3, true, 3, false, false},
+		{4, true, 3, false, true},
+		{-1, false, 3, false, true},
+		{7, false, 0, true, false},
+		{0, true, 0, false, false}, // Address of a zero-length array.
+		{0, false, 0, false, true},
+	} {
+		f := &FunctionDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+			Body:       body(v.index, v.address, v.items, v.unknown),
+		}
+		if err := f.Verify(); err != nil {
+			t.Fatal(i, err)
+		}
+
+		if err := f.Validate(&VerifyOptions{OutOfBounds: true}); (err != nil) != v.fail {
+			t.Fatal(i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAssembly(&buf, []Object{f}); err != nil {
+			t.Fatal(i, err)
+		}
+
+		p, err := Parse("test", buf.Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", i, err, buf.Bytes())
+		}
+
+		if d := Diff([]Object{f}, p); len(d) != 0 {
+			t.Fatalf("%v: %v\n%s", i, d, buf.Bytes())
+		}
+
+		if v.unknown {
+			continue // Reads past a.
+		}
+
+		in, err := NewInterpreter([]Object{f}, m)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+
+		if _, err := in.Call(idMain); (err != nil) != v.fail || err != nil && !strings.Contains(err.Error(), "out of bounds") {
+			t.Fatal(i, err)
+		}
+	}
+
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body:       body(0, false, -1, false),
+	}
+	if err := f.Verify(); err == nil || !strings.Contains(err.Error(), "invalid number of items") {
+		t.Fatal(err)
+	}
+}
+
+// jumpTableTest returns a function jumping through a pointer to a label which
+// address is taken only by an AddressValue of a jump table, and the table.
+//
+//	int32 x = 7; goto *table[0]; L: return x;
+func jumpTableTest() (*FunctionDefinition, *DataDefinition) {
+	l := NameID(dict.SID("L"))
+	f := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: idMain, TypeID: idMainType},
+		Body: []Operation{
+			&BeginScope{},
+			&VariableDeclaration{Index: 0, TypeID: idInt32},
+			&Variable{Address: true, Index: 0, TypeID: idPint32},
+			&Const32{TypeID: idInt32, Value: 7},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Nil{TypeID: TypeID(dict.SID("*struct{}"))},
+			&JmpP{},
+			&Label{NameID: l},
+			&Result{Address: true, TypeID: idPint32},
+			&Variable{Index: 0, TypeID: idInt32},
+			&Store{TypeID: idInt32},
+			&Drop{TypeID: idInt32},
+			&Return{},
+			&EndScope{},
+		},
+	}
+	d := &DataDefinition{
+		ObjectBase: ObjectBase{Linkage: InternalLinkage, NameID: NameID(dict.SID("table")), TypeID: TypeID(dict.SID("[1]*struct{}"))},
+		Value:      &CompositeValue{Values: []Value{&AddressValue{Index: -1, Label: l, Linkage: ExternalLinkage, NameID: idMain}}},
+	}
+	return f, d
+}
+
+func TestSSAJumpTable(t *testing.T) {
+	f, d := jumpTableTest()
+	for _, v := range []Object{f, d} {
+		if err := v.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g, e := len(f.Body), 15; g != e {
+		t.Fatalf("got %v operations, expected %v", g, e)
+	}
+
+	s, err := ToSSA(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range s.Blocks {
+		if len(b.Values) == 0 {
+			continue
+		}
+
+		if _, ok := b.Values[0].Op.(*Label); !ok {
+			continue
+		}
+
+		if b.Unreachable || len(b.Preds) != 1 || len(b.Values) != 4 {
+			t.Fatalf("%s", s)
+		}
+
+		return
+	}
+	t.Fatalf("missing label\n%s", s)
+}
+
+func TestEliminateDeadStoresJumpTable(t *testing.T) {
+	f, _ := jumpTableTest()
+	n := len(f.Body)
+	if err := EliminateDeadStores(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(f.Body), n; g != e {
+		t.Fatalf("got %v operations, expected %v\n%s", g, e, PrettyString(f))
+	}
+
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramConcurrent(t *testing.T) {
+	const n = 8
+	p := NewProgram()
+	// Make the IDs of p.Dict differ from the IDs of the same strings in
+	// the global dictionary.
+	for i := 0; i < 10; i++ {
+		p.Dict.SID(fmt.Sprintf("The quick brown fox concurrent %v", i))
+	}
+	nm := "The quick brown fox private"
+	p.Objects = Objects{{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(p.Dict.SID(nm)), TypeID: TypeID(p.Dict.SID("int32"))},
+			Value:      &StringValue{StringID: StringID(p.Dict.SID("\xff\x00 escaped"))},
+		},
+	}}
+	global := Objects{{
+		&DataDefinition{
+			ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: NameID(dict.SID("The quick brown fox global")), TypeID: idInt32},
+			Value:      &StringValue{StringID: StringID(dict.SID("\xff\xff escaped"))},
+		},
+	}}
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*n)
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+
+			var buf bytes.Buffer
+			if _, err := p.WriteTo(&buf); err != nil {
+				errs <- err
+				return
+			}
+
+			q := NewProgram()
+			if _, err := q.ReadFrom(&buf); err != nil {
+				errs <- err
+				return
+			}
+
+			d := q.Objects[0][0].(*DataDefinition)
+			if g, e := string(q.Dict.S(int(d.NameID))), nm; g != e {
+				errs <- fmt.Errorf("got %q, expected %q", g, e)
+			}
+			if g, e := string(q.Dict.S(int(d.Value.(*StringValue).StringID))), "\xff\x00 escaped"; g != e {
+				errs <- fmt.Errorf("got %q, expected %q", g, e)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			var buf bytes.Buffer
+			if _, err := global.WriteTo(&buf); err != nil {
+				errs <- err
+				return
+			}
+
+			var o Objects
+			if _, err := o.ReadFrom(&buf); err != nil {
+				errs <- err
+				return
+			}
+
+			if !EqualObjects(o[0][0], global[0][0]) {
+				errs <- fmt.Errorf("got %v, expected %v", o[0][0], global[0][0])
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			in := []StringID{StringID(dict.SID("The quick brown fox gob")), StringID(dict.SID("\xff\x00 escaped"))}
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+				errs <- err
+				return
+			}
+
+			var out []StringID
+			if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+				errs <- err
+				return
+			}
+
+			if !reflect.DeepEqual(out, in) {
+				errs <- fmt.Errorf("got %v, expected %v", out, in)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLinkerIncremental(t *testing.T) {
+	c := NameID(dict.SID("c"))
+	f := NameID(dict.SID("f"))
+	ta := TypeID(dict.SID("[4]int32"))
+	common := func(t TypeID) *DataDefinition {
+		return &DataDefinition{ObjectBase: ObjectBase{Linkage: CommonLinkage, NameID: c, TypeID: t}}
+	}
+	fn := &FunctionDefinition{
+		ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: f, TypeID: TypeID(dict.SID("func()"))},
+		Body:       []Operation{&Return{}},
+	}
+	var l Linker
+	for _, v := range [][]Object{{fn}, {common(idInt32)}, {common(ta)}} {
+		if err := l.AddUnit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(e TypeID) {
+		out, err := l.FinalizeLib(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range out {
+			if v.Base().NameID == c {
+				if g := v.Base().TypeID; g != e {
+					t.Fatalf("got %v, expected %v", g, e)
+				}
+				return
+			}
+		}
+		t.Fatal("missing c")
+	}
+	link(ta)
+	if g, e := len(l.dirty), 0; g != e {
+		t.Fatal(g, e)
+	}
+
+	if err := l.ReplaceUnit(2, []Object{common(idInt32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(l.dirty), 1; g != e || !l.dirty[c] {
+		t.Fatal(g, e, l.dirty)
+	}
+
+	link(idInt32)
+	if err := l.ReplaceUnit(0, []Object{fn, &DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: c, TypeID: idInt32}, Value: &Int32Value{Value: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	link(idInt32)
+	if err := l.ReplaceUnit(1, []Object{&DataDefinition{ObjectBase: ObjectBase{Linkage: ExternalLinkage, NameID: c, TypeID: idInt32}, Value: &Int32Value{Value: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.FinalizeLib(nil); err == nil || !strings.Contains(err.Error(), "multiple definitions of c") {
+		t.Fatal(err)
+	}
+
+	if err := l.ReplaceUnit(1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	link(idInt32)
+}
+
+func TestTypeCacheSmall(t *testing.T) {
+	c := TypeCache{}
+	if g, e := c.MustType(idInt32), smallTypes[idInt32]; g != e {
+		t.Fatal(g, e)
+	}
+
+	if g, e := len(c), 0; g != e {
+		t.Fatal(g, e)
+	}
+
+	id := TypeID(dict.SID("struct{a int8,b *struct{c uint16}}"))
+	for i := 0; i < 2; i++ {
+		if g, e := c.MustType(id).Kind(), Struct; g != e {
+			t.Fatal(i, g, e)
+		}
+
+		if c[id] == nil {
+			t.Fatal(i, "type not memoized")
+		}
 	}
 }
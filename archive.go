@@ -0,0 +1,287 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+
+	"github.com/cznic/internal/buffer"
+)
+
+const (
+	archiveVersion = 1 // Compatibility version of Archive.
+)
+
+var (
+	_ io.ReaderFrom = (*Archive)(nil)
+	_ io.WriterTo   = (*Archive)(nil)
+
+	archiveMagic = []byte("!<irarch>\n")
+)
+
+// Archive is a collection of named translation units together with an index
+// of the external names they define, like a static library. Members are kept
+// in their serialized form and decoded only when needed.
+type Archive struct {
+	Target  Target         // Target of the members. The host target is used if zero.
+	index   map[NameID]int // External name: member.
+	members []archiveMember
+}
+
+type archiveMember struct {
+	Data []byte // Serialized Objects.
+	Name string
+}
+
+// archiveFile is the serialized form of an Archive.
+type archiveFile struct {
+	Members []archiveMember
+	Symbols []string // Sorted.
+	Targets []int    // Member defining Symbols[i].
+	Version int
+}
+
+// NewArchive returns a newly created, empty Archive.
+func NewArchive() *Archive { return &Archive{index: map[NameID]int{}} }
+
+// Add adds the translation unit objs as a member named name. External
+// names already defined by a previously added member are not indexed again,
+// the first member defining a name wins.
+func (a *Archive) Add(name string, objs []Object) error {
+	var buf bytes.Buffer
+	if _, err := (Objects{objs}).WriteToOptions(&buf, &WriteOptions{Target: a.target()}); err != nil {
+		return err
+	}
+
+	a.add(name, buf.Bytes(), objs)
+	return nil
+}
+
+func (a *Archive) add(name string, data []byte, objs []Object) {
+	n := len(a.members)
+	a.members = append(a.members, archiveMember{Data: data, Name: name})
+	for _, v := range objs {
+		b := v.Base()
+		switch b.Linkage {
+		case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+			if _, ok := a.index[b.NameID]; !ok {
+				a.index[b.NameID] = n
+			}
+		}
+	}
+}
+
+func (a *Archive) target() Target {
+	if a.Target == (Target{}) {
+		return HostTarget()
+	}
+
+	return a.Target
+}
+
+// Len returns the number of members of a.
+func (a *Archive) Len() int { return len(a.members) }
+
+// Lookup returns the index of the member defining the external name nm.
+func (a *Archive) Lookup(nm NameID) (int, bool) {
+	i, ok := a.index[nm]
+	return i, ok
+}
+
+// Member returns the name and the objects of the member i. Every call decodes
+// the member anew, so the objects can be linked without affecting a.
+func (a *Archive) Member(i int) (string, []Object, error) {
+	m := a.members[i]
+	var o Objects
+	if _, err := o.ReadFromTarget(bytes.NewReader(m.Data), a.target()); err != nil {
+		return "", nil, fmt.Errorf("archive member %s: %v", m.Name, err)
+	}
+
+	if len(o) != 1 {
+		return "", nil, fmt.Errorf("archive member %s: corrupted", m.Name)
+	}
+
+	return m.Name, o[0], nil
+}
+
+// ReadFrom reads a from r. The Target of a is kept.
+func (a *Archive) ReadFrom(r io.Reader) (n int64, err error) {
+	var c counter
+	r = io.TeeReader(r, &c)
+	b := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return int64(c), err
+	}
+
+	if !bytes.Equal(b, archiveMagic) {
+		return int64(c), fmt.Errorf("unrecognized file format")
+	}
+
+	var f archiveFile
+	if err := gob.NewDecoder(r).Decode(&f); err != nil {
+		return int64(c), err
+	}
+
+	if f.Version != archiveVersion {
+		return int64(c), fmt.Errorf("invalid version number %v", f.Version)
+	}
+
+	if len(f.Symbols) != len(f.Targets) {
+		return int64(c), fmt.Errorf("corrupted file")
+	}
+
+	t := a.Target
+	*a = *NewArchive()
+	a.Target = t
+	a.members = f.Members
+	for i, v := range f.Symbols {
+		if t := f.Targets[i]; t < 0 || t >= len(a.members) {
+			return int64(c), fmt.Errorf("corrupted file")
+		}
+
+		a.index[NameID(dict.SID(v))] = f.Targets[i]
+	}
+	return int64(c), nil
+}
+
+// WriteTo writes a to w. The output depends only on the contents of a.
+func (a *Archive) WriteTo(w io.Writer) (n int64, err error) {
+	f := archiveFile{Members: a.members, Version: archiveVersion}
+	for k := range a.index {
+		f.Symbols = append(f.Symbols, k.String())
+	}
+	sort.Strings(f.Symbols)
+	for _, v := range f.Symbols {
+		f.Targets = append(f.Targets, a.index[NameID(dict.SID(v))])
+	}
+	var c counter
+	w = io.MultiWriter(w, &c)
+	if _, err := w.Write(archiveMagic); err != nil {
+		return int64(c), err
+	}
+
+	err = gob.NewEncoder(w).Encode(&f)
+	return int64(c), err
+}
+
+// LinkMain is like the LinkMain function, but it additionally links the
+// members of a defining names referenced, directly or indirectly, from
+// translationUnits and not defined by them. Other members are not decoded.
+func (a *Archive) LinkMain(translationUnits ...[]Object) ([]Object, error) {
+	defined := map[NameID]bool{}
+	var undefined []NameID
+	loaded := map[int]bool{}
+	scan := func(objs []Object) {
+		intern := map[NameID]bool{}
+		for _, v := range objs {
+			switch b := v.Base(); b.Linkage {
+			case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+				defined[b.NameID] = true
+			case InternalLinkage:
+				intern[b.NameID] = true
+			}
+		}
+		for _, v := range objs {
+			if x, ok := v.(*AliasDefinition); ok {
+				if !intern[x.Target] {
+					undefined = append(undefined, x.Target)
+				}
+				continue
+			}
+
+			externalReferences(v, func(nm NameID) { undefined = append(undefined, nm) })
+		}
+	}
+	units := append([][]Object(nil), translationUnits...)
+	for _, v := range units {
+		scan(v)
+	}
+	undefined = append(undefined, NameID(idStart))
+	for len(undefined) != 0 {
+		nm := undefined[len(undefined)-1]
+		undefined = undefined[:len(undefined)-1]
+		if defined[nm] {
+			continue
+		}
+
+		i, ok := a.index[nm]
+		if !ok {
+			var buf buffer.Bytes
+			buf.Write(dict.S(idBuiltinPrefix))
+			buf.Write(dict.S(int(nm)))
+			i, ok = a.index[NameID(dict.ID(buf.Bytes()))]
+			buf.Close()
+		}
+		if !ok || loaded[i] {
+			continue
+		}
+
+		loaded[i] = true
+		_, objs, err := a.Member(i)
+		if err != nil {
+			return nil, err
+		}
+
+		units = append(units, objs)
+		scan(objs)
+	}
+	return LinkMain(units...)
+}
+
+// externalReferences calls fn for every external name, except weak external
+// references, referred to by o.
+func externalReferences(o Object, fn func(NameID)) {
+	references(o, func(l Linkage, nm NameID, _ TypeID, _ token.Position) {
+		switch l {
+		case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+			fn(nm)
+		}
+	})
+}
+
+// references calls fn for every global name referred to by o. The type is
+// zero for references by an AddressValue.
+func references(o Object, fn func(l Linkage, nm NameID, t TypeID, pos token.Position)) {
+	var value func(Value, token.Position)
+	value = func(v Value, pos token.Position) {
+		switch x := v.(type) {
+		case *AddressValue:
+			fn(x.Linkage, x.NameID, 0, pos)
+		case *CompositeValue:
+			for _, v := range x.Values {
+				value(v, pos)
+			}
+		case *DesignatedValue:
+			value(x.Value, pos)
+		case *DifferenceValue:
+			value(x.A, pos)
+			value(x.B, pos)
+		}
+	}
+	switch x := o.(type) {
+	case *DataDefinition:
+		value(x.Value, x.Position)
+	case *FunctionDefinition:
+		for _, op := range x.Body {
+			switch y := op.(type) {
+			case *Const:
+				value(y.Value, y.Position)
+			case *Global:
+				fn(y.Linkage, y.NameID, y.TypeID, y.Position)
+			case *Switch:
+				for _, v := range y.Values {
+					value(v, y.Position)
+				}
+			case *VariableDeclaration:
+				value(y.Value, y.Position)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// EmitFunc emits op, which is f.Body[ip] of the function passed to the last
+// Backend.BeginFunction. The dynamic type of op is the operation of the
+// Opcode the EmitFunc is registered for, for example *Add for OpAdd.
+type EmitFunc func(ip int, op Operation) error
+
+// EmitTable is the dispatch table of a Backend indexed by Opcode.
+type EmitTable map[Opcode]EmitFunc
+
+// Backend is implemented by code generators driven by Lower.
+//
+// Lower dispatches the operations using the EmitTable of the back end, so a
+// back end needs no type switch over the operations. Operations added to this
+// package after the back end was written are reported as unsupported instead
+// of being silently miscompiled.
+type Backend interface {
+	// BeginFunction is called before the operations of function
+	// definition f, which is the object at index.
+	BeginFunction(index int, f *FunctionDefinition) error
+
+	// DefineData is called for data definition d, which is the object at
+	// index.
+	DefineData(index int, d *DataDefinition) error
+
+	// EmitTable returns the functions emitting the operations supported
+	// by the back end. Lower calls it once.
+	EmitTable() EmitTable
+
+	// EndFunction is called after all operations of f were emitted.
+	EndFunction(f *FunctionDefinition) error
+}
+
+// AliasBackend is a Backend supporting alias definitions.
+type AliasBackend interface {
+	Backend
+
+	// DefineAlias is called for alias definition a, which is the object
+	// at index.
+	DefineAlias(index int, a *AliasDefinition) error
+}
+
+// OpcodeOf returns the Opcode of op or zero if op is not an operation defined
+// by this package.
+func OpcodeOf(op Operation) Opcode {
+	v := reflect.ValueOf(op)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return 0
+	}
+
+	return typeOpcodes[v.Type().Elem()]
+}
+
+// Lower walks objects, which should be the result of LinkMain or LinkLib, in
+// order and calls the respective methods of b. Alias definitions are reported
+// as an error unless b is an AliasBackend. Lower stops on the first error,
+// which it returns annotated with the object name and position.
+func Lower(objects []Object, b Backend) error {
+	t := b.EmitTable()
+	for i, v := range objects {
+		switch x := v.(type) {
+		case *AliasDefinition:
+			ab, ok := b.(AliasBackend)
+			if !ok {
+				return fmt.Errorf("%s: %s: back end does not support aliases", x.Position, x.NameID)
+			}
+
+			if err := ab.DefineAlias(i, x); err != nil {
+				return fmt.Errorf("%s: %s: %v", x.Position, x.NameID, err)
+			}
+		case *DataDefinition:
+			if err := b.DefineData(i, x); err != nil {
+				return fmt.Errorf("%s: %s: %v", x.Position, x.NameID, err)
+			}
+		case *FunctionDefinition:
+			if err := b.BeginFunction(i, x); err != nil {
+				return fmt.Errorf("%s: %s: %v", x.Position, x.NameID, err)
+			}
+
+			for ip, op := range x.Body {
+				code := OpcodeOf(op)
+				f := t[code]
+				if f == nil {
+					return fmt.Errorf("%s: %s: %#05x: back end does not support %v", x.BodyPosition(ip), x.NameID, ip, code)
+				}
+
+				if err := f(ip, op); err != nil {
+					return fmt.Errorf("%s: %s: %#05x: %v", x.BodyPosition(ip), x.NameID, ip, err)
+				}
+			}
+			if err := b.EndFunction(x); err != nil {
+				return fmt.Errorf("%s: %s: %v", x.Position, x.NameID, err)
+			}
+		default:
+			return fmt.Errorf("unexpected object %T", x)
+		}
+	}
+	return nil
+}
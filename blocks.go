@@ -0,0 +1,180 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+// OrderBlocks reorders the basic blocks of f using the execution counts of a
+// profile attached to its Labels and conditional branches, see
+// Interpreter.AttachProfile, so that hot paths fall through.
+//
+// The code between a Jnz or Jz and its target label, which the branch skips
+// more often than it enters, is moved after the last Jmp, Panic or Return of
+// the enclosing scope following the target label, if there is one, the
+// branch is inverted to jump to the moved code and a Jmp back to the target
+// label is appended to the moved code unless it ends by a Jmp, Panic or
+// Return. Only code entered by falling through the branch with an empty
+// evaluation stack, not containing labels, scopes or variable declarations,
+// is moved. The Return ending the function stays last.
+func OrderBlocks(f *FunctionDefinition) error {
+	f.ExpandPositions()
+	depth := make([]int, len(f.Body)) // Evaluation stack depth after ip.
+	for i := range depth {
+		depth[i] = -1
+	}
+	v := NewVerifier()
+	v.stackHook = func(s []TypeID) { depth[v.ip] = len(s) }
+	if err := v.Validate(f, nil); err != nil {
+		return err
+	}
+
+	body := f.Body
+	labels := map[int]int{}       // Label key: ip.
+	end := make([]int, len(body)) // BeginScope ip: ip of the matching EndScope, zero if none.
+	var scopes []int
+	number := 0
+	for ip, op := range body {
+		switch x := op.(type) {
+		case *BeginScope:
+			scopes = append(scopes, ip)
+		case *EndScope:
+			if n := len(scopes); n != 0 {
+				end[scopes[n-1]] = ip
+				scopes = scopes[:n-1]
+			}
+		case *Label:
+			labels[labelKey(x.NameID, x.Number)] = ip
+			if x.NameID == 0 && x.Number >= number {
+				number = x.Number + 1
+			}
+		}
+	}
+	scope := make([]int, len(body)) // ip: BeginScope of the innermost scope, -1 if none.
+	scopes = scopes[:0]
+	for ip, op := range body {
+		scope[ip] = -1
+		if n := len(scopes); n != 0 {
+			scope[ip] = scopes[n-1]
+		}
+		switch op.(type) {
+		case *BeginScope:
+			scopes = append(scopes, ip)
+		case *EndScope:
+			if n := len(scopes); n != 0 {
+				scopes = scopes[:n-1]
+			}
+		}
+	}
+
+	type region struct{ branch, target int }
+	var regions []region
+	remove := make([]bool, len(body))
+	for ip := 0; ip < len(body); ip++ {
+		var lop bool
+		var nm NameID
+		var num int
+		var taken, notTaken int64
+		switch x := body[ip].(type) {
+		case *Jnz:
+			lop, nm, num, taken, notTaken = x.LOp, x.NameID, x.Number, x.Taken, x.NotTaken
+		case *Jz:
+			lop, nm, num, taken, notTaken = x.LOp, x.NameID, x.Number, x.Taken, x.NotTaken
+		default:
+			continue
+		}
+		if lop || taken <= notTaken || depth[ip] != 0 || scope[ip] < 0 {
+			continue
+		}
+
+		target, ok := labels[labelKey(nm, num)]
+		if !ok || target <= ip+1 || !orderMovable(body[ip+1:target]) {
+			continue
+		}
+
+		if l := body[target].(*Label); l.Cond || l.LAnd || l.LOr || l.Nop {
+			continue
+		}
+
+		regions = append(regions, region{ip, target})
+		for i := ip + 1; i < target; i++ {
+			remove[i] = true
+		}
+		ip = target
+	}
+
+	move := map[int][]Operation{} // ip: moved code following it.
+	replace := map[int]Operation{}
+	for _, r := range regions {
+		sc := scope[r.branch]
+		q := -1
+		for ip := end[sc] - 1; q < 0 && ip > r.target; ip-- {
+			// The Return ending the function must stay last.
+			if scope[ip] != sc || remove[ip] || depth[ip] < 0 || scope[sc] < 0 && ip+1 == end[sc] {
+				continue
+			}
+
+			switch body[ip].(type) {
+			case *Jmp, *Panic, *Return:
+				q = ip
+			}
+		}
+		if q < 0 {
+			for i := r.branch + 1; i < r.target; i++ {
+				remove[i] = false
+			}
+			continue
+		}
+
+		var nm NameID
+		var num int
+		var count int64
+		pos := body[r.branch].Pos()
+		switch x := body[r.branch].(type) {
+		case *Jnz:
+			nm, num, count = x.NameID, x.Number, x.NotTaken
+			replace[r.branch] = &Jz{Number: number, NotTaken: x.Taken, Taken: x.NotTaken, Position: pos}
+		case *Jz:
+			nm, num, count = x.NameID, x.Number, x.NotTaken
+			replace[r.branch] = &Jnz{Number: number, NotTaken: x.Taken, Taken: x.NotTaken, Position: pos}
+		}
+		m := append(move[q], &Label{Count: count, Number: number, Position: pos})
+		number++
+		m = append(m, body[r.branch+1:r.target]...)
+		switch body[r.target-1].(type) {
+		case *Jmp, *Panic, *Return:
+			// ok
+		default:
+			m = append(m, &Jmp{NameID: nm, Number: num, Position: body[r.target-1].Pos()})
+		}
+		move[q] = m
+	}
+	if len(replace) == 0 {
+		return nil
+	}
+
+	s := make([]Operation, 0, len(body)+2*len(replace))
+	for ip, op := range body {
+		if remove[ip] {
+			continue
+		}
+
+		if x, ok := replace[ip]; ok {
+			op = x
+		}
+		s = append(s, op)
+		s = append(s, move[ip]...)
+	}
+	f.Body = s
+	return nil
+}
+
+// orderMovable reports whether OrderBlocks can move ops.
+func orderMovable(ops []Operation) bool {
+	for _, op := range ops {
+		switch op.(type) {
+		case *BeginScope, *EndScope, *Label, *VariableDeclaration:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,407 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// FunctionBuilder constructs the body of a function definition. It allocates
+// variable indices and label numbers, derives the types of operations where
+// possible and verifies the result in Finish.
+//
+// Misuse, like an invalid argument index, is recorded and reported by Finish,
+// the methods have no error results.
+type FunctionBuilder struct {
+	// Position of the subsequently emitted operations.
+	Position token.Position
+
+	calls     []TypeID // Function pointer types of the pending calls.
+	err       error
+	f         *FunctionDefinition
+	label     int // Next free label number.
+	scopes    int
+	typ       *FunctionType
+	typeCache TypeCache
+	variables []TypeID
+}
+
+// NewFunctionBuilder returns a newly created FunctionBuilder of f, which
+// must have an empty body. The function scope is opened.
+func NewFunctionBuilder(f *FunctionDefinition) *FunctionBuilder {
+	b := &FunctionBuilder{f: f, typeCache: TypeCache{}}
+	t, err := b.typeCache.Type(f.TypeID)
+	switch {
+	case err != nil:
+		b.err = err
+	case t.Kind() != Function:
+		b.err = fmt.Errorf("expected function type, have %s", f.TypeID)
+	case len(f.Body) != 0:
+		b.err = fmt.Errorf("function body is not empty")
+	default:
+		b.typ = t.(*FunctionType)
+	}
+	b.BeginScope()
+	return b
+}
+
+func (b *FunctionBuilder) errorf(format string, arg ...interface{}) {
+	if b.err == nil {
+		b.err = fmt.Errorf("%s: %s", b.Position, fmt.Sprintf(format, arg...))
+	}
+}
+
+func (b *FunctionBuilder) pointer(t TypeID) TypeID {
+	u, err := b.typeCache.Type(t)
+	if err != nil {
+		b.errorf("%v", err)
+		return 0
+	}
+
+	return u.Pointer().ID()
+}
+
+// signed reports whether t is a signed integer type.
+func (b *FunctionBuilder) signed(t TypeID) bool {
+	u, err := b.typeCache.Type(t)
+	if err != nil {
+		b.errorf("%v", err)
+		return false
+	}
+
+	return isSigned(u.Kind())
+}
+
+func (b *FunctionBuilder) typeOf(t TypeID, address bool) TypeID {
+	if address {
+		return b.pointer(t)
+	}
+
+	return t
+}
+
+// Emit appends ops to the body as they are.
+func (b *FunctionBuilder) Emit(ops ...Operation) { b.f.Body = append(b.f.Body, ops...) }
+
+// Finish closes the function scope, adding a Return, or a Panic if the
+// function has the AttrNoreturn attribute, if the body does not end with one,
+// verifies the function and returns it.
+func (b *FunctionBuilder) Finish() (*FunctionDefinition, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if b.scopes != 1 {
+		return nil, fmt.Errorf("%s: %v unclosed scopes", b.Position, b.scopes-1)
+	}
+
+	if len(b.calls) != 0 {
+		return nil, fmt.Errorf("%s: %v unfinished calls", b.Position, len(b.calls))
+	}
+
+	switch last := b.f.Body[len(b.f.Body)-1]; {
+	case b.f.Attributes&AttrNoreturn != 0:
+		switch last.(type) {
+		case *Panic, *RestoreContext:
+			// ok
+		default:
+			b.Panic()
+		}
+	default:
+		if _, ok := last.(*Return); !ok {
+			b.Return()
+		}
+	}
+	b.EndScope()
+	if err := b.f.Verify(); err != nil {
+		return nil, err
+	}
+
+	return b.f, nil
+}
+
+// BeginScope opens a block scope.
+func (b *FunctionBuilder) BeginScope() {
+	b.scopes++
+	b.Emit(&BeginScope{Position: b.Position})
+}
+
+// EndScope closes the innermost block scope.
+func (b *FunctionBuilder) EndScope() {
+	if b.scopes == 0 {
+		b.errorf("unbalanced end scope")
+		return
+	}
+
+	b.scopes--
+	b.Emit(&EndScope{Position: b.Position})
+}
+
+// Declare declares a local variable of type t with an optional name and
+// initializer and returns its index.
+func (b *FunctionBuilder) Declare(t TypeID, name NameID, v Value) int {
+	n := len(b.variables)
+	b.variables = append(b.variables, t)
+	b.Emit(&VariableDeclaration{Index: n, NameID: name, TypeID: t, Value: v, Position: b.Position})
+	return n
+}
+
+// Variable pushes the local variable index, or its address.
+func (b *FunctionBuilder) Variable(index int, address bool) {
+	if index < 0 || index >= len(b.variables) {
+		b.errorf("invalid variable index %v", index)
+		return
+	}
+
+	b.Emit(&Variable{Address: address, Index: index, TypeID: b.typeOf(b.variables[index], address), Position: b.Position})
+}
+
+// Argument pushes the function argument index, or its address.
+func (b *FunctionBuilder) Argument(index int, address bool) {
+	if b.typ == nil || index < 0 || index >= len(b.typ.Arguments) {
+		b.errorf("invalid argument index %v", index)
+		return
+	}
+
+	b.Emit(&Argument{Address: address, Index: index, TypeID: b.typeOf(b.typ.Arguments[index].ID(), address), Position: b.Position})
+}
+
+// Result pushes the function result index, or its address.
+func (b *FunctionBuilder) Result(index int, address bool) {
+	if b.typ == nil || index < 0 || index >= len(b.typ.Results) {
+		b.errorf("invalid result index %v", index)
+		return
+	}
+
+	b.Emit(&Result{Address: address, Index: index, TypeID: b.typeOf(b.typ.Results[index].ID(), address), Position: b.Position})
+}
+
+// Global pushes the global object name of type t, or its address. The type
+// of a function is the pointer to the function type.
+func (b *FunctionBuilder) Global(name NameID, l Linkage, t TypeID, address bool) {
+	b.Emit(&Global{Address: address, Index: -1, Linkage: l, NameID: name, TypeID: b.typeOf(t, address), Position: b.Position})
+}
+
+// Const pushes the constant v of type t.
+func (b *FunctionBuilder) Const(t TypeID, v Value) {
+	b.Emit(&Const{TypeID: t, Value: v, Position: b.Position})
+}
+
+// Const32 pushes the 32 bit constant v of type t.
+func (b *FunctionBuilder) Const32(t TypeID, v int32) {
+	b.Emit(&Const32{TypeID: t, Value: v, Position: b.Position})
+}
+
+// Const64 pushes the 64 bit constant v of type t.
+func (b *FunctionBuilder) Const64(t TypeID, v int64) {
+	b.Emit(&Const64{TypeID: t, Value: v, Position: b.Position})
+}
+
+// StringConst pushes a pointer of type t to the string s.
+func (b *FunctionBuilder) StringConst(t TypeID, s string) {
+	b.Emit(&StringConst{TypeID: t, Value: StringID(dict.SID(s)), Position: b.Position})
+}
+
+// Nil pushes a nil pointer of type t.
+func (b *FunctionBuilder) Nil(t TypeID) { b.Emit(&Nil{TypeID: t, Position: b.Position}) }
+
+// Add emits an Add operation of operands of type t.
+func (b *FunctionBuilder) Add(t TypeID) { b.Emit(&Add{TypeID: t, Position: b.Position}) }
+
+// Alloca emits an Alloca operation producing a pointer of type t from a size
+// operand of type size.
+func (b *FunctionBuilder) Alloca(t, size TypeID) {
+	b.Emit(&Alloca{Size: size, TypeID: t, Position: b.Position})
+}
+
+// And emits an And operation of operands of type t.
+func (b *FunctionBuilder) And(t TypeID) { b.Emit(&And{TypeID: t, Position: b.Position}) }
+
+// Bool emits a Bool operation of an operand of type t.
+func (b *FunctionBuilder) Bool(t TypeID) { b.Emit(&Bool{TypeID: t, Position: b.Position}) }
+
+// Bswap emits a Bswap operation of an operand of type t.
+func (b *FunctionBuilder) Bswap(t TypeID) { b.Emit(&Bswap{TypeID: t, Position: b.Position}) }
+
+// Clz emits a Clz operation of an operand of type t.
+func (b *FunctionBuilder) Clz(t TypeID) { b.Emit(&Clz{TypeID: t, Position: b.Position}) }
+
+// Convert emits a Convert operation of an operand of type from to type to.
+func (b *FunctionBuilder) Convert(from, to TypeID) {
+	b.Emit(&Convert{Result: to, TypeID: from, Position: b.Position})
+}
+
+// Cpl emits a Cpl operation of an operand of type t.
+func (b *FunctionBuilder) Cpl(t TypeID) { b.Emit(&Cpl{TypeID: t, Position: b.Position}) }
+
+// Ctz emits a Ctz operation of an operand of type t.
+func (b *FunctionBuilder) Ctz(t TypeID) { b.Emit(&Ctz{TypeID: t, Position: b.Position}) }
+
+// Div emits a Div operation of operands of type t.
+func (b *FunctionBuilder) Div(t TypeID) { b.Emit(&Div{TypeID: t, Position: b.Position}) }
+
+// Drop emits a Drop operation of an operand of type t.
+func (b *FunctionBuilder) Drop(t TypeID) { b.Emit(&Drop{TypeID: t, Position: b.Position}) }
+
+// Dup emits a Dup operation of an operand of type t.
+func (b *FunctionBuilder) Dup(t TypeID) { b.Emit(&Dup{TypeID: t, Position: b.Position}) }
+
+// Eq emits an Eq operation of operands of type t.
+func (b *FunctionBuilder) Eq(t TypeID) { b.Emit(&Eq{TypeID: t, Position: b.Position}) }
+
+// Geq emits a Geq operation of operands of type t.
+func (b *FunctionBuilder) Geq(t TypeID) {
+	b.Emit(&Geq{Signed: b.signed(t), TypeID: t, Position: b.Position})
+}
+
+// Gt emits a Gt operation of operands of type t.
+func (b *FunctionBuilder) Gt(t TypeID) {
+	b.Emit(&Gt{Signed: b.signed(t), TypeID: t, Position: b.Position})
+}
+
+// Leq emits a Leq operation of operands of type t.
+func (b *FunctionBuilder) Leq(t TypeID) {
+	b.Emit(&Leq{Signed: b.signed(t), TypeID: t, Position: b.Position})
+}
+
+// Load emits a Load operation of a pointer of type t.
+func (b *FunctionBuilder) Load(t TypeID) { b.Emit(&Load{TypeID: t, Position: b.Position}) }
+
+// Lsh emits a Lsh operation of an operand of type t.
+func (b *FunctionBuilder) Lsh(t TypeID) { b.Emit(&Lsh{TypeID: t, Position: b.Position}) }
+
+// Lt emits a Lt operation of operands of type t.
+func (b *FunctionBuilder) Lt(t TypeID) {
+	b.Emit(&Lt{Signed: b.signed(t), TypeID: t, Position: b.Position})
+}
+
+// Mul emits a Mul operation of operands of type t.
+func (b *FunctionBuilder) Mul(t TypeID) { b.Emit(&Mul{TypeID: t, Position: b.Position}) }
+
+// Neg emits a Neg operation of an operand of type t.
+func (b *FunctionBuilder) Neg(t TypeID) { b.Emit(&Neg{TypeID: t, Position: b.Position}) }
+
+// Neq emits a Neq operation of operands of type t.
+func (b *FunctionBuilder) Neq(t TypeID) { b.Emit(&Neq{TypeID: t, Position: b.Position}) }
+
+// Not emits a Not operation.
+func (b *FunctionBuilder) Not() { b.Emit(&Not{Position: b.Position}) }
+
+// Or emits an Or operation of operands of type t.
+func (b *FunctionBuilder) Or(t TypeID) { b.Emit(&Or{TypeID: t, Position: b.Position}) }
+
+// Popcnt emits a Popcnt operation of an operand of type t.
+func (b *FunctionBuilder) Popcnt(t TypeID) { b.Emit(&Popcnt{TypeID: t, Position: b.Position}) }
+
+// Rem emits a Rem operation of operands of type t.
+func (b *FunctionBuilder) Rem(t TypeID) { b.Emit(&Rem{TypeID: t, Position: b.Position}) }
+
+// Rsh emits a Rsh operation of an operand of type t.
+func (b *FunctionBuilder) Rsh(t TypeID) { b.Emit(&Rsh{TypeID: t, Position: b.Position}) }
+
+// Store emits a Store operation of a value of type t.
+func (b *FunctionBuilder) Store(t TypeID) { b.Emit(&Store{TypeID: t, Position: b.Position}) }
+
+// Sub emits a Sub operation of operands of type t.
+func (b *FunctionBuilder) Sub(t TypeID) { b.Emit(&Sub{TypeID: t, Position: b.Position}) }
+
+// Xor emits a Xor operation of operands of type t.
+func (b *FunctionBuilder) Xor(t TypeID) { b.Emit(&Xor{TypeID: t, Position: b.Position}) }
+
+// Annotate emits an Annotate operation attaching a to the next emitted
+// operation.
+func (b *FunctionBuilder) Annotate(a ...Annotation) {
+	b.Emit(&Annotate{Annotations: a, Position: b.Position})
+}
+
+// Fence emits a Fence operation of scope s.
+func (b *FunctionBuilder) Fence(s FenceScope) { b.Emit(&Fence{Scope: s, Position: b.Position}) }
+
+// Panic emits a Panic operation.
+func (b *FunctionBuilder) Panic() { b.Emit(&Panic{Position: b.Position}) }
+
+// Return emits a Return operation.
+func (b *FunctionBuilder) Return() { b.Emit(&Return{Position: b.Position}) }
+
+// SaveContext emits a SaveContext operation of a context pointer of type t.
+func (b *FunctionBuilder) SaveContext(t TypeID) {
+	b.Emit(&SaveContext{TypeID: t, Position: b.Position})
+}
+
+// RestoreContext emits a RestoreContext operation of a context pointer of type t.
+func (b *FunctionBuilder) RestoreContext(t TypeID) {
+	b.Emit(&RestoreContext{TypeID: t, Position: b.Position})
+}
+
+// NewLabel returns a newly allocated label number. The label is placed using
+// Label.
+func (b *FunctionBuilder) NewLabel() int {
+	b.label++
+	return b.label - 1
+}
+
+// Label places the label n allocated by NewLabel.
+func (b *FunctionBuilder) Label(n int) { b.Emit(&Label{Number: n, Position: b.Position}) }
+
+// LabelAddr emits a LabelAddr operation pushing the address of label n as a
+// value of type t.
+func (b *FunctionBuilder) LabelAddr(n int, t TypeID) {
+	b.Emit(&LabelAddr{Number: n, TypeID: t, Position: b.Position})
+}
+
+// JmpTo emits a branch to label n.
+func (b *FunctionBuilder) JmpTo(n int) { b.Emit(&Jmp{Number: n, Position: b.Position}) }
+
+// JnzTo emits a branch to label n taken if TOS is non zero.
+func (b *FunctionBuilder) JnzTo(n int) { b.Emit(&Jnz{Number: n, Position: b.Position}) }
+
+// JzTo emits a branch to label n taken if TOS is zero.
+func (b *FunctionBuilder) JzTo(n int) { b.Emit(&Jz{Number: n, Position: b.Position}) }
+
+// BeginCall starts a static call of the function name of function type t. It
+// reserves the function results and pushes the function pointer. The function
+// arguments are pushed next and the call is completed using Call.
+func (b *FunctionBuilder) BeginCall(name NameID, l Linkage, t TypeID) {
+	u, err := b.typeCache.Type(t)
+	if err != nil {
+		b.errorf("%v", err)
+		return
+	}
+
+	ft, ok := u.(*FunctionType)
+	if !ok {
+		b.errorf("expected function type, have %s", t)
+		return
+	}
+
+	for _, v := range ft.Results {
+		b.Emit(&AllocResult{TypeID: v.ID(), Position: b.Position})
+	}
+	b.Global(name, l, b.pointer(t), false)
+	b.BeginCallFP(b.pointer(t))
+}
+
+// BeginCallFP starts a call using a function pointer of type t already
+// pushed after the reserved function results. The function arguments are
+// pushed next and the call is completed using Call.
+func (b *FunctionBuilder) BeginCallFP(t TypeID) {
+	b.calls = append(b.calls, t)
+	b.Emit(&Arguments{Position: b.Position})
+}
+
+// Call completes the innermost call started by BeginCall or BeginCallFP
+// passing args arguments.
+func (b *FunctionBuilder) Call(args int) {
+	n := len(b.calls)
+	if n == 0 {
+		b.errorf("call without BeginCall")
+		return
+	}
+
+	t := b.calls[n-1]
+	b.calls = b.calls[:n-1]
+	b.Emit(&CallFP{Arguments: args, TypeID: t, Position: b.Position})
+}
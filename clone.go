@@ -0,0 +1,204 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"math/big"
+	"reflect"
+)
+
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+type clonePtr struct {
+	p uintptr
+	t reflect.Type
+}
+
+// CloneObject returns a deep copy of o, including the body of a function and
+// the initializer of data, which shares no memory with o. Linking mutates the
+// linked objects, linking clones allows to link the same objects again.
+// Operations and values referenced more than once by o are referenced the
+// same way by the copy.
+func CloneObject(o Object) Object {
+	if o == nil {
+		return nil
+	}
+
+	return cloneValue(reflect.ValueOf(o), map[clonePtr]reflect.Value{}, nil).Interface().(Object)
+}
+
+// cloneValue returns a deep copy of v. If id is not nil, the NameIDs,
+// StringIDs and TypeIDs of the copy are replaced by the result of id.
+func cloneValue(v reflect.Value, m map[clonePtr]reflect.Value, id func(int) int) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+
+		k := clonePtr{v.Pointer(), v.Type()}
+		if r, ok := m[k]; ok {
+			return r
+		}
+
+		var r reflect.Value
+		switch v.Type() {
+		case bigFloatType:
+			r = reflect.ValueOf(new(big.Float).Copy(v.Interface().(*big.Float)))
+		case bigIntType:
+			r = reflect.ValueOf(new(big.Int).Set(v.Interface().(*big.Int)))
+		default:
+			r = reflect.New(v.Type().Elem())
+			m[k] = r
+			r.Elem().Set(cloneValue(v.Elem(), m, id))
+		}
+		m[k] = r
+		return r
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+
+		r := reflect.New(v.Type()).Elem()
+		r.Set(cloneValue(v.Elem(), m, id))
+		return r
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		r := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			r.Index(i).Set(cloneValue(v.Index(i), m, id))
+		}
+		return r
+	case reflect.Array:
+		r := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			r.Index(i).Set(cloneValue(v.Index(i), m, id))
+		}
+		return r
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+
+		r := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			r.SetMapIndex(cloneValue(k, m, id), cloneValue(v.MapIndex(k), m, id))
+		}
+		return r
+	case reflect.Struct:
+		r := reflect.New(v.Type()).Elem()
+		r.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := r.Field(i); f.CanSet() {
+				f.Set(cloneValue(v.Field(i), m, id))
+			}
+		}
+		return r
+	case reflect.Int:
+		if id != nil && isIDType(v.Type()) {
+			r := reflect.New(v.Type()).Elem()
+			r.SetInt(int64(id(int(v.Int()))))
+			return r
+		}
+	}
+
+	return v
+}
+
+// EqualObjects reports whether a and b are structurally equal, including the
+// bodies of functions and the initializers of data. Unlike in Diff, positions
+// are compared as well. Nil and empty slices are considered equal, so an
+// object equals its serialized and deserialized form.
+func EqualObjects(a, b Object) bool {
+	return equalValues(reflect.ValueOf(a), reflect.ValueOf(b), map[[2]uintptr]bool{})
+}
+
+func equalValues(a, b reflect.Value, m map[[2]uintptr]bool) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+
+	if !a.IsValid() {
+		return true
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+
+		k := [2]uintptr{a.Pointer(), b.Pointer()}
+		if k[0] == k[1] || m[k] {
+			return true
+		}
+
+		m[k] = true
+		switch a.Type() {
+		case bigFloatType:
+			return a.Interface().(*big.Float).Cmp(b.Interface().(*big.Float)) == 0
+		case bigIntType:
+			return a.Interface().(*big.Int).Cmp(b.Interface().(*big.Int)) == 0
+		}
+
+		return equalValues(a.Elem(), b.Elem(), m)
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+
+		return equalValues(a.Elem(), b.Elem(), m)
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i), m) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+
+		for _, k := range a.MapKeys() {
+			if !equalValues(a.MapIndex(k), b.MapIndex(k), m) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equalValues(a.Field(i), b.Field(i), m) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+
+	return false
+}
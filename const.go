@@ -0,0 +1,330 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// EvalConst returns the initializer v of an object of type t in canonical
+// form. Types are resolved using tc, which may be nil. The memory model m
+// determines the size of pointers.
+//
+// Nested composite values are evaluated recursively. Their items are ordered
+// by index, an item overriding a previous item of the same index replaces it
+// and a DesignatedValue is used only for an item not following its
+// predecessor. A composite value of a scalar type must have at most one item.
+//
+// Numeric values are converted to the type they initialize, the result is an
+// Int32Value, Int64Value, Uint32Value or Uint64Value for integer and pointer
+// types, an Int128Value, holding the unsigned representation, for int128 and
+// uint128, a Float32Value for float16 and float32, a Float64Value, a
+// Float128Value, a Complex64Value or a Complex128Value. An integer value must
+// fit the width of the initialized integer or bit field, either as a signed
+// or as an unsigned number, and it wraps around like in C, so -1 initializes
+// an unsigned type to its maximum. A floating point value initializing an
+// integer is truncated and it must be in the range of the type. A finite value
+// must not overflow a floating point type. Addresses and strings are checked
+// like in VerifyValue and returned unchanged.
+func EvalConst(v Value, t TypeID, tc Types, m MemoryModel) (Value, error) {
+	tc = newTypes(tc)
+	typ, err := tc.Type(t)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &constEvaluator{model: m, verifier: &verifier{typeCache: tc}}
+	return e.eval(typ, v, 0)
+}
+
+type constEvaluator struct {
+	model    MemoryModel
+	verifier *verifier
+}
+
+// eval returns the canonical form of v initializing t. Non zero bits is the
+// width of a bit field of type t.
+func (e *constEvaluator) eval(t Type, v Value, bits int) (Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case *CompositeValue:
+		switch t.(type) {
+		case *ArrayType, *StructOrUnionType:
+			return e.composite(t, x)
+		}
+
+		switch len(x.Values) {
+		case 0:
+			return e.eval(t, &Int32Value{}, bits)
+		case 1:
+			return e.eval(t, x.Values[0], bits)
+		}
+
+		return nil, fmt.Errorf("too many values for %s", t.ID())
+	case *AddressValue, *DifferenceValue, *StringValue, *WideStringValue:
+		if bits != 0 {
+			return nil, fmt.Errorf("cannot initialize a bit field of %s using an address", t.ID())
+		}
+
+		if err := e.verifier.verifyValue(t, v); err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	case *DesignatedValue:
+		return nil, fmt.Errorf("designated value outside of a composite value")
+	}
+
+	return e.number(t, v, bits)
+}
+
+func (e *constEvaluator) composite(t Type, v *CompositeValue) (Value, error) {
+	m := map[int]Value{}
+	var index int
+	for _, v := range v.Values {
+		if d, ok := v.(*DesignatedValue); ok {
+			index = d.Index
+			v = d.Value
+		}
+		var err error
+		switch x := t.(type) {
+		case *ArrayType:
+			if index < 0 || int64(index) >= x.Items {
+				return nil, fmt.Errorf("index %v out of bounds of %s", index, t.ID())
+			}
+
+			if v, err = e.eval(x.Item, v, 0); err != nil {
+				return nil, fmt.Errorf("[%v]: %v", index, err)
+			}
+		case *StructOrUnionType:
+			if index < 0 || index >= len(x.Fields) {
+				return nil, fmt.Errorf("field index %v out of range of %s", index, t.ID())
+			}
+
+			var bits int
+			if index < len(x.Bits) {
+				bits = x.Bits[index]
+			}
+			if v, err = e.eval(x.Fields[index], v, bits); err != nil {
+				return nil, fmt.Errorf("field #%v: %v", index, err)
+			}
+
+			if x.Kind() == Union {
+				for k := range m {
+					delete(m, k)
+				}
+			}
+		}
+		m[index] = v
+		index++
+	}
+
+	a := make([]int, 0, len(m))
+	for k, v := range m {
+		if v != nil {
+			a = append(a, k)
+		}
+	}
+	sort.Ints(a)
+	r := &CompositeValue{}
+	for i, k := range a {
+		v := m[k]
+		if i == 0 && k != 0 || i != 0 && k != a[i-1]+1 {
+			v = &DesignatedValue{Index: k, Value: v}
+		}
+		r.Values = append(r.Values, v)
+	}
+	return r, nil
+}
+
+// number returns the numeric value v converted to t.
+func (e *constEvaluator) number(t Type, v Value, bits int) (Value, error) {
+	var (
+		c complex128
+		f *big.Float // Finite floating point value.
+		g float64    // Floating point value, valid if f is nil.
+		n *big.Int   // Integer value.
+	)
+	switch x := v.(type) {
+	case *Complex64Value:
+		c = complex128(x.Value)
+	case *Complex128Value:
+		c = x.Value
+	case *Float128Value:
+		if x.Value == nil {
+			return nil, fmt.Errorf("missing float128 value")
+		}
+
+		f = x.Value
+		if f.IsInf() {
+			f, g = nil, math.Inf(f.Sign())
+		}
+	case *Float32Value:
+		g = float64(x.Value)
+	case *Float64Value:
+		g = x.Value
+	case *Int128Value:
+		if x.Value == nil {
+			return nil, fmt.Errorf("missing int128 value")
+		}
+
+		n = new(big.Int).Set(x.Value)
+	case *Int32Value:
+		n = big.NewInt(int64(x.Value))
+	case *Int64Value:
+		n = big.NewInt(x.Value)
+	case *Uint32Value:
+		n = new(big.Int).SetUint64(uint64(x.Value))
+	case *Uint64Value:
+		n = new(big.Int).SetUint64(x.Value)
+	default:
+		return nil, fmt.Errorf("unexpected value %T", x)
+	}
+	k := t.Kind()
+	switch v.(type) {
+	case *Complex64Value, *Complex128Value:
+		switch k {
+		case Complex64, Complex128, Complex256:
+			// ok
+		default:
+			g = real(c) // The imaginary part is discarded like in C.
+		}
+	}
+	if n == nil && f == nil && !math.IsNaN(g) && !math.IsInf(g, 0) {
+		f = big.NewFloat(g)
+	}
+
+	switch {
+	case k == Pointer || isIntegral(k):
+		if n == nil {
+			if f == nil {
+				return nil, fmt.Errorf("constant %v overflows %s", g, t.ID())
+			}
+
+			n, _ = f.Int(nil)
+			if foldWrap(new(big.Int).Set(n), k).Cmp(n) != 0 || bits != 0 && constWrap(new(big.Int).Set(n), bits, isSigned(k)).Cmp(n) != 0 {
+				return nil, fmt.Errorf("constant %v overflows %s", f, t.ID())
+			}
+		}
+
+		w := bits
+		if w == 0 {
+			w = 8 * int(e.sizeof(t))
+		}
+		min := new(big.Int).Lsh(big.NewInt(-1), uint(w-1))
+		max := new(big.Int).Lsh(big.NewInt(1), uint(w))
+		if n.Cmp(min) < 0 || n.Cmp(max) >= 0 {
+			if bits != 0 {
+				return nil, fmt.Errorf("constant %v overflows bit field of %v bits", n, bits)
+			}
+
+			return nil, fmt.Errorf("constant %v overflows %s", n, t.ID())
+		}
+
+		n = constWrap(n, w, k != Pointer && isSigned(k))
+		switch {
+		case k == Int128, k == Uint128:
+			return &Int128Value{Value: constWrap(n, 128, false)}, nil
+		case k == Int64:
+			return &Int64Value{Value: n.Int64()}, nil
+		case isSigned(k):
+			return &Int32Value{Value: int32(n.Int64())}, nil
+		case w > 32:
+			return &Uint64Value{Value: n.Uint64()}, nil
+		default:
+			return &Uint32Value{Value: uint32(n.Uint64())}, nil
+		}
+	case k == Float128:
+		switch {
+		case n != nil:
+			f = new(big.Float).SetInt(n)
+		case f == nil && math.IsNaN(g):
+			return nil, fmt.Errorf("cannot represent NaN as %s", t.ID())
+		case f == nil:
+			f = new(big.Float).SetInf(g < 0)
+		}
+		return &Float128Value{Value: new(big.Float).SetPrec(Float128Prec).Set(f)}, nil
+	case isFloating(k):
+		if n != nil {
+			f = new(big.Float).SetInt(n)
+		}
+		if f != nil {
+			g, _ = f.Float64()
+		}
+		r := interpFloat(g, k)
+		if f != nil && math.IsInf(r, 0) {
+			return nil, fmt.Errorf("constant %v overflows %s", f, t.ID())
+		}
+
+		if k == Float64 {
+			return &Float64Value{Value: r}, nil
+		}
+
+		return &Float32Value{Value: float32(r)}, nil
+	}
+
+	switch k {
+	case Complex64, Complex128, Complex256:
+		switch v.(type) {
+		case *Complex64Value, *Complex128Value:
+			// ok
+		default:
+			if n != nil {
+				f = new(big.Float).SetInt(n)
+			}
+			if f != nil {
+				g, _ = f.Float64()
+			}
+			c = complex(g, 0)
+		}
+		r := interpComplex(c, k)
+		if !isInfComplex(c) && isInfComplex(r) {
+			return nil, fmt.Errorf("constant %v overflows %s", c, t.ID())
+		}
+
+		if k == Complex64 {
+			return &Complex64Value{Value: complex64(r)}, nil
+		}
+
+		return &Complex128Value{Value: r}, nil
+	}
+
+	return nil, fmt.Errorf("cannot initialize %s using %T", t.ID(), v)
+}
+
+// sizeof returns the size of the integer or pointer type t.
+func (e *constEvaluator) sizeof(t Type) int64 {
+	if t.Kind() == Pointer {
+		return e.model.Sizeof(t)
+	}
+
+	switch t.Kind() {
+	case Int8, Uint8:
+		return 1
+	case Int16, Uint16:
+		return 2
+	case Int32, Uint32:
+		return 4
+	case Int128, Uint128:
+		return 16
+	}
+	return 8
+}
+
+// constWrap returns n truncated to bits, sign extended if signed.
+func constWrap(n *big.Int, bits int, signed bool) *big.Int {
+	m := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	n.Mod(n, m)
+	if signed && n.Cmp(new(big.Int).Rsh(m, 1)) >= 0 {
+		n.Sub(n, m)
+	}
+	return n
+}
+
+func isInfComplex(c complex128) bool { return math.IsInf(real(c), 0) || math.IsInf(imag(c), 0) }
@@ -0,0 +1,110 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"reflect"
+)
+
+// CostTable maps operation names, like "Add", to the relative costs of the
+// operations on operands not wider than a pointer. Operations not in a table
+// cost 1.
+type CostTable map[string]int
+
+// DefaultCosts is the CostTable used by Cost. It should be treated as read
+// only, use a CostTable of your own to override its entries.
+var DefaultCosts = CostTable{
+	"Alloca":     4,
+	"Annotate":   0,
+	"BeginScope": 0,
+	"Call":       5,
+	"CallFP":     6,
+	"Div":        8,
+	"EndScope":   0,
+	"Fence":      4,
+	"Free":       20,
+	"Label":      0,
+	"Mul":        3,
+	"MulOv":      4,
+	"New":        20,
+	"Rem":        8,
+	"Switch":     3,
+
+	"VariableDeclaration": 0,
+}
+
+// Cost returns the relative cost of op on a target of memory model m, using
+// DefaultCosts, so that inliners and code generators can make consistent
+// size and speed decisions. Operations on values wider than a pointer of m,
+// like int64 on 32 bit targets or structs copied by Copy, Load and Store,
+// cost their table cost times the number of pointer sized words of the
+// value.
+func Cost(op Operation, m MemoryModel) int { return DefaultCosts.Cost(op, m) }
+
+// Cost is like the function Cost but it uses c. Operations not in c fall back
+// to DefaultCosts.
+func (c CostTable) Cost(op Operation, m MemoryModel) int { return c.cost(op, m, TypeCache{}) }
+
+func (c CostTable) cost(op Operation, m MemoryModel, tc TypeCache) int {
+	rv := reflect.ValueOf(op).Elem()
+	nm := rv.Type().Name()
+	n, ok := c[nm]
+	if !ok {
+		if n, ok = DefaultCosts[nm]; !ok {
+			n = 1
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+
+	if x, ok := op.(*Fence); ok && x.Scope == FenceCompiler {
+		return 0
+	}
+
+	f := rv.FieldByName("TypeID")
+	if !f.IsValid() || f.Type() != typeIDType || f.Int() == 0 {
+		return n
+	}
+
+	t, err := tc.Type(TypeID(f.Int()))
+	if err != nil {
+		return n
+	}
+
+	if _, ok := op.(*Load); ok {
+		if p, ok := t.(*PointerType); ok {
+			t = p.Element
+		}
+	}
+	if t.Kind() == Function || !IsComplete(t) {
+		return n
+	}
+
+	w := int64(m[Pointer].Size)
+	if w == 0 {
+		return n
+	}
+
+	if words := (m.Sizeof(t) + w - 1) / w; words > 1 {
+		return n * int(words)
+	}
+
+	return n
+}
+
+// FunctionCost returns the sum of the costs of the operations of f on a target
+// of memory model m, see Cost.
+func FunctionCost(f *FunctionDefinition, m MemoryModel) int { return DefaultCosts.FunctionCost(f, m) }
+
+// FunctionCost is like the function FunctionCost but it uses c.
+func (c CostTable) FunctionCost(f *FunctionDefinition, m MemoryModel) int {
+	tc := TypeCache{}
+	r := 0
+	for _, op := range f.Body {
+		r += c.cost(op, m, tc)
+	}
+	return r
+}
@@ -0,0 +1,373 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// Relocation describes an address, which value is not known before linking
+// or loading, stored in the bytes produced by EmitData. The bytes at Offset
+// are zero. The address of a DifferenceValue is the difference of the
+// addresses of its A and B names or labels.
+type Relocation struct {
+	Addend int64  // Added to the address of Value.
+	Offset int64  // Of the address in the data.
+	TypeID TypeID // Of the address, normally a pointer type.
+	Value  Value  // *AddressValue, *DifferenceValue, *StringValue or *WideStringValue.
+}
+
+// EmitData returns the initialized bytes of d laid out according to memory
+// model m, including the padding of structs, unions and arrays, the bit
+// fields and the zero bytes of the members without an initializer, and the
+// relocations of the addresses d contains. Types are resolved using tc.
+//
+// String literals initializing an array are stored in the returned bytes, a
+// string literal initializing a pointer is an address and it produces a
+// Relocation. Relocations are in the order of their Offset.
+func EmitData(d *DataDefinition, m MemoryModel, tc Types) ([]byte, []Relocation, error) {
+	t, err := tc.Type(d.TypeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", d.Position, err)
+	}
+
+	b := make([]byte, m.Sizeof(t))
+	var r []Relocation
+	e := &dataEncoder{
+		model: m,
+		pointer: func(t Type, p []byte, v Value) (uint64, error) {
+			// p is a subslice of b.
+			x := Relocation{Offset: int64(cap(b) - cap(p)), TypeID: t.ID(), Value: v}
+			switch y := v.(type) {
+			case *AddressValue:
+				x.Addend = int64(y.Offset)
+			case *DifferenceValue:
+				x.Addend = int64(y.A.Offset) - int64(y.B.Offset)
+			case *StringValue:
+				x.Addend = int64(y.Offset)
+			}
+			for i, v := range r {
+				if v.Offset == x.Offset { // Designated initializer override.
+					r[i] = x
+					return 0, nil
+				}
+			}
+
+			r = append(r, x)
+			return 0, nil
+		},
+	}
+	if err := e.init(t, b, d.Value); err != nil {
+		return nil, nil, fmt.Errorf("%s: invalid initializer of %s: %v", d.Position, d.NameID, err)
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].Offset < r[j].Offset })
+	return b, r, nil
+}
+
+// dataEncoder stores scalars and initializers in memory.
+type dataEncoder struct {
+	model MemoryModel
+
+	// pointer returns the value of the address v of type t, which is
+	// an *AddressValue, *DifferenceValue, *StringValue or *WideStringValue
+	// initializing b.
+	pointer func(t Type, b []byte, v Value) (uint64, error)
+}
+
+// load returns the value of type t stored in b.
+func (e *dataEncoder) load(t Type, b []byte) interface{} {
+	switch k := t.Kind(); k {
+	case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64, Pointer:
+		return interpInt(e.model.DecodeInt(b, k), k)
+	case Float16:
+		return float16FromBits(uint16(e.model.DecodeInt(b, k)))
+	case Float32:
+		return float64(math.Float32frombits(uint32(e.model.DecodeInt(b, k))))
+	case Float64, Float128:
+		return math.Float64frombits(e.model.DecodeInt(b[:8], k))
+	case Complex64:
+		return complex(float64(math.Float32frombits(uint32(e.model.DecodeInt(b[:4], k)))), float64(math.Float32frombits(uint32(e.model.DecodeInt(b[4:], k)))))
+	case Complex128, Complex256:
+		h := len(b) / 2
+		return complex(math.Float64frombits(e.model.DecodeInt(b[:8], k)), math.Float64frombits(e.model.DecodeInt(b[h:h+8], k)))
+	default:
+		return append([]byte(nil), b...)
+	}
+}
+
+// store stores v of type t in b.
+func (e *dataEncoder) store(t Type, b []byte, v interface{}) {
+	switch k := t.Kind(); k {
+	case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64, Pointer:
+		e.model.EncodeInt(b, k, v.(uint64))
+	case Float16:
+		e.model.EncodeInt(b, k, uint64(float16Bits(v.(float64))))
+	case Float32:
+		e.model.EncodeInt(b, k, uint64(math.Float32bits(float32(v.(float64)))))
+	case Float64, Float128:
+		e.model.EncodeInt(b[:8], k, math.Float64bits(v.(float64)))
+	case Complex64:
+		c := v.(complex128)
+		e.model.EncodeInt(b[:4], k, uint64(math.Float32bits(float32(real(c)))))
+		e.model.EncodeInt(b[4:], k, uint64(math.Float32bits(float32(imag(c)))))
+	case Complex128, Complex256:
+		c := v.(complex128)
+		h := len(b) / 2
+		e.model.EncodeInt(b[:8], k, math.Float64bits(real(c)))
+		e.model.EncodeInt(b[h:h+8], k, math.Float64bits(imag(c)))
+	default:
+		copy(b, v.([]byte))
+	}
+}
+
+// float16Bits returns the IEEE 754 half precision representation of f
+// rounded to nearest even.
+func float16Bits(f float64) uint16 {
+	sign := uint16(math.Float64bits(f)>>48) & 0x8000
+	a := math.Abs(f)
+	switch {
+	case math.IsNaN(f):
+		return sign | 0x7e00
+	case a >= 65520: // Rounds to infinity.
+		return sign | 0x7c00
+	case a < 0x1p-14: // Subnormal.
+		return sign | uint16(math.RoundToEven(a*0x1p24))
+	}
+
+	frac, exp := math.Frexp(a)
+	m := math.RoundToEven((2*frac - 1) * 1024)
+	if m == 1024 {
+		m = 0
+		exp++
+	}
+	return sign | uint16(exp+14)<<10 | uint16(m)
+}
+
+// float16FromBits returns the value of the IEEE 754 half precision
+// representation h.
+func float16FromBits(h uint16) float64 {
+	sign := 1.
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	m := float64(h & 0x3ff)
+	switch e := int(h>>10) & 0x1f; e {
+	case 0:
+		return sign * math.Ldexp(m, -24)
+	case 0x1f:
+		if m != 0 {
+			return math.NaN()
+		}
+
+		return math.Inf(int(sign))
+	default:
+		return sign * math.Ldexp(1024+m, e-25)
+	}
+}
+
+// init stores the initializer v of type t in b.
+func (e *dataEncoder) init(t Type, b []byte, v Value) error {
+	k := t.Kind()
+	switch k {
+	case Int128, Uint128:
+		return e.init128(t, b, v)
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case *AddressValue:
+		a, err := e.pointer(t, b, x)
+		if err != nil {
+			return err
+		}
+
+		return e.initNumber(t, b, a, Pointer)
+	case *Complex64Value:
+		return e.initNumber(t, b, complex128(x.Value), Complex64)
+	case *Complex128Value:
+		return e.initNumber(t, b, x.Value, Complex128)
+	case *CompositeValue:
+		return e.initComposite(t, b, x)
+	case *DifferenceValue:
+		a, err := e.pointer(t, b, x)
+		if err != nil {
+			return err
+		}
+
+		return e.initNumber(t, b, a, Int64)
+	case *Float128Value:
+		f, _ := x.Value.Float64()
+		return e.initNumber(t, b, f, Float64)
+	case *Float32Value:
+		return e.initNumber(t, b, float64(x.Value), Float32)
+	case *Float64Value:
+		return e.initNumber(t, b, x.Value, Float64)
+	case *Int32Value:
+		return e.initNumber(t, b, uint64(x.Value), Int32)
+	case *Int64Value:
+		return e.initNumber(t, b, uint64(x.Value), Int64)
+	case *StringValue:
+		s := dict.S(int(x.StringID))
+		switch k {
+		case Pointer:
+			a, err := e.pointer(t, b, x)
+			if err != nil {
+				return err
+			}
+
+			return e.initNumber(t, b, a, Pointer)
+		case Array:
+			if int(x.Offset) <= len(s) {
+				copy(b, s[x.Offset:])
+			}
+			return nil
+		}
+	case *Uint32Value:
+		return e.initNumber(t, b, uint64(x.Value), Uint32)
+	case *Uint64Value:
+		return e.initNumber(t, b, x.Value, Uint64)
+	case *WideStringValue:
+		switch k {
+		case Pointer:
+			a, err := e.pointer(t, b, x)
+			if err != nil {
+				return err
+			}
+
+			return e.initNumber(t, b, a, Pointer)
+		case Array:
+			a := t.(*ArrayType)
+			sz := e.model.Sizeof(a.Item)
+			for i, c := range x.Value {
+				if int64(i) < a.Items {
+					e.store(a.Item, b[int64(i)*sz:int64(i+1)*sz], interpInt(uint64(c), a.Item.Kind()))
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot initialize %s using %T", t.ID(), v)
+}
+
+// init128 stores the integer initializer v of the 128 bit integer type t in b.
+func (e *dataEncoder) init128(t Type, b []byte, v Value) error {
+	var n *big.Int
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case *CompositeValue:
+		return e.initComposite(t, b, x)
+	case *Int128Value:
+		n = new(big.Int).Set(x.Value)
+	case *Int32Value:
+		n = big.NewInt(int64(x.Value))
+	case *Int64Value:
+		n = big.NewInt(x.Value)
+	case *Uint32Value:
+		n = new(big.Int).SetUint64(uint64(x.Value))
+	case *Uint64Value:
+		n = new(big.Int).SetUint64(x.Value)
+	default:
+		return fmt.Errorf("cannot initialize %s using %T", t.ID(), v)
+	}
+
+	for i := range b {
+		b[i] = 0
+	}
+	s := constWrap(n, 128, false).Bytes() // Big endian.
+	copy(b[len(b)-len(s):], s)
+	if e.model.item(t.Kind()).Endianness != BigEndian {
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	return nil
+}
+
+func (e *dataEncoder) initNumber(t Type, b []byte, v interface{}, k TypeKind) error {
+	r, err := interpConvert(v, k, t.Kind())
+	if err != nil {
+		return err
+	}
+
+	e.store(t, b, r)
+	return nil
+}
+
+func (e *dataEncoder) initComposite(t Type, b []byte, v *CompositeValue) error {
+	switch x := t.(type) {
+	case *ArrayType:
+		sz := e.model.Sizeof(x.Item)
+		var index int64
+		for _, v := range v.Values {
+			if d, ok := v.(*DesignatedValue); ok {
+				index = int64(d.Index)
+				v = d.Value
+			}
+			if index < 0 || index >= x.Items {
+				return fmt.Errorf("index %v out of bounds of %s", index, t.ID())
+			}
+
+			if err := e.init(x.Item, b[index*sz:(index+1)*sz], v); err != nil {
+				return err
+			}
+
+			index++
+		}
+		return nil
+	case *StructOrUnionType:
+		layout := e.model.Layout(x)
+		var index int
+		for _, v := range v.Values {
+			if d, ok := v.(*DesignatedValue); ok {
+				index = d.Index
+				v = d.Value
+			}
+			if index < 0 || index >= len(x.Fields) {
+				return fmt.Errorf("field index %v out of range of %s", index, t.ID())
+			}
+
+			f := layout[index]
+			u := b[f.Offset : f.Offset+f.Size]
+			if f.Bits == 0 {
+				if err := e.init(x.Fields[index], u, v); err != nil {
+					return err
+				}
+
+				index++
+				continue
+			}
+
+			switch v.(type) {
+			case *AddressValue, *DifferenceValue, *StringValue, *WideStringValue:
+				return fmt.Errorf("cannot initialize bit field %v of %s using an address", index, t.ID())
+			}
+
+			ft := x.Fields[index]
+			w := make([]byte, len(u))
+			if err := e.init(ft, w, v); err != nil {
+				return err
+			}
+
+			e.store(ft, u, interpSetBits(e.load(ft, u).(uint64), e.load(ft, w).(uint64), f.BitOffset, f.Bits))
+			index++
+		}
+		return nil
+	}
+
+	switch len(v.Values) {
+	case 0:
+		return nil
+	case 1:
+		return e.init(t, b, v.Values[0])
+	}
+
+	return fmt.Errorf("too many values for %s", t.ID())
+}
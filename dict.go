@@ -0,0 +1,341 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	_ Dictionary    = (*Dict)(nil)
+	_ io.ReaderFrom = (*Program)(nil)
+	_ io.WriterTo   = (*Program)(nil)
+)
+
+// Dictionary maps byte strings to numeric identifiers and back. The zero ID
+// always maps to the empty string.
+type Dictionary interface {
+	ID([]byte) int
+	S(int) []byte
+	SID(string) int
+}
+
+// gobEscape starts the gob encoding of a placeholder and of a string starting
+// with gobEscape, see gobEncodeID.
+const gobEscape = 0xff
+
+// gobEncodeID returns the gob encoding of a NameID, StringID or TypeID. A non
+// negative id is encoded as its string in the global dictionary. The IDs
+// written by an encoder using a private Dictionary are replaced by negative
+// placeholders, which are encoded as gobEscape, zero and the varint index of
+// the placeholder into the string table of the stream.
+func gobEncodeID(id int) []byte {
+	if id < 0 {
+		b := make([]byte, 2+binary.MaxVarintLen64)
+		b[0] = gobEscape
+		return b[:2+binary.PutUvarint(b[2:], uint64(-id-1))]
+	}
+
+	s := dict.S(id)
+	if len(s) != 0 && s[0] == gobEscape {
+		return append([]byte{gobEscape}, s...)
+	}
+
+	return append([]byte(nil), s...)
+}
+
+// gobDecodeID reverts the effect of gobEncodeID.
+func gobDecodeID(b []byte) (int, error) {
+	if len(b) == 0 || b[0] != gobEscape {
+		return dict.ID(b), nil
+	}
+
+	if len(b) > 1 && b[1] == 0 {
+		n, k := binary.Uvarint(b[2:])
+		if k <= 0 || 2+k != len(b) || n >= math.MaxInt32 {
+			return 0, fmt.Errorf("corrupted identifier")
+		}
+
+		return -int(n) - 1, nil
+	}
+
+	return dict.ID(b[1:]), nil
+}
+
+func isIDType(t reflect.Type) bool { return t == nameIDType || t == stringIDType || t == typeIDType }
+
+// encoder is a gob encoder of a stream written by encode. Every value written
+// by encode is preceded by the strings of its NameIDs, StringIDs and TypeIDs,
+// as registered in dict, not written before, and its identifiers are replaced
+// by placeholders indexing the strings of the stream. The stream thus does not
+// depend on any dictionary and it can be read into any, see decoder.decode.
+//
+// Replacing the identifiers requires a copy of the value, so an encoder using
+// the global dictionary writes an empty string table and the identifiers as
+// their strings instead.
+type encoder struct {
+	*gob.Encoder
+	dict Dictionary
+	ids  map[int]int // ID: placeholder index.
+}
+
+func newEncoder(w io.Writer, d Dictionary) *encoder {
+	return &encoder{Encoder: gob.NewEncoder(w), dict: d, ids: map[int]int{}}
+}
+
+// encode writes v to the stream.
+func (e *encoder) encode(v interface{}) error {
+	if e.dict == dict {
+		if err := e.Encode([]string(nil)); err != nil {
+			return err
+		}
+
+		return e.Encode(v)
+	}
+
+	var a []string
+	c := cloneValue(reflect.ValueOf(v), map[clonePtr]reflect.Value{}, func(id int) int {
+		if id == 0 {
+			return 0
+		}
+
+		n, ok := e.ids[id]
+		if !ok {
+			n = len(e.ids)
+			e.ids[id] = n
+			a = append(a, string(e.dict.S(id)))
+		}
+		return -n - 1
+	})
+	if err := e.Encode(a); err != nil {
+		return err
+	}
+
+	return e.EncodeValue(c)
+}
+
+// decode reads from the stream a value written by encoder.encode, registering
+// its identifiers in d.dict. A nil v discards the value. The identifiers of a
+// stream written using the global dictionary are already registered in it, so
+// they are remapped only if d.dict is a private Dictionary.
+//
+// Every distinct string of the stream is registered in d.dict only once, the
+// strings of placeholders via d.ids and the global identifiers via d.global.
+// Reading a stream written using the global dictionary into the global
+// dictionary is the exception, gob gives gobDecodeID no per stream state to
+// remember the identifiers in, so it registers every decoded identifier.
+func (d *decoder) decode(v interface{}) error {
+	var a []string
+	if err := d.Decode(&a); err != nil {
+		return err
+	}
+
+	for _, s := range a {
+		d.ids = append(d.ids, d.dict.SID(s))
+	}
+	if err := d.Decode(v); err != nil || v == nil {
+		return err
+	}
+
+	if len(d.ids) == 0 && d.dict == dict {
+		return nil
+	}
+
+	var err error
+	remapIDs(reflect.ValueOf(v), map[clonePtr]bool{}, func(id int) int {
+		switch {
+		case id < 0:
+			if n := -id - 1; n < len(d.ids) {
+				return d.ids[n]
+			}
+
+			err = fmt.Errorf("corrupted file")
+			return 0
+		case id == 0 || d.dict == dict:
+			return id
+		}
+
+		n, ok := d.global[id]
+		if !ok {
+			if d.global == nil {
+				d.global = map[int]int{}
+			}
+			n = d.dict.ID(dict.S(id))
+			d.global[id] = n
+		}
+		return n
+	})
+	return err
+}
+
+// remapIDs replaces in place the NameIDs, StringIDs and TypeIDs reachable from
+// v by the result of id.
+func remapIDs(v reflect.Value, m map[clonePtr]bool, id func(int) int) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+
+		k := clonePtr{v.Pointer(), v.Type()}
+		if m[k] {
+			return
+		}
+
+		m[k] = true
+		remapIDs(v.Elem(), m, id)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr {
+			remapIDs(e, m, id)
+			return
+		}
+
+		if v.CanSet() {
+			c := reflect.New(e.Type()).Elem()
+			c.Set(e)
+			remapIDs(c, m, id)
+			v.Set(c)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			remapIDs(v.Index(i), m, id)
+		}
+	case reflect.Map:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+
+		r := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			ck := reflect.New(k.Type()).Elem()
+			ck.Set(k)
+			remapIDs(ck, m, id)
+			cv := reflect.New(v.Type().Elem()).Elem()
+			cv.Set(v.MapIndex(k))
+			remapIDs(cv, m, id)
+			r.SetMapIndex(ck, cv)
+		}
+		v.Set(r)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				remapIDs(f, m, id)
+			}
+		}
+	case reflect.Int:
+		if v.CanSet() && isIDType(v.Type()) {
+			v.SetInt(int64(id(int(v.Int()))))
+		}
+	}
+}
+
+// Dict is a Dictionary private to a particular program. Using a Dict instead
+// of the global dictionary avoids lock contention between concurrent front
+// ends and lets the memory be reclaimed once the program is no longer used.
+// Dict is safe for concurrent use by multiple goroutines.
+//
+// NameIDs, StringIDs and TypeIDs registered in a Dict are meaningful only
+// together with that Dict. Their String methods, TypeCache, the verifier and
+// the linker always use the global dictionary, so a Dict serves producing and
+// storing IR. The objects of a Program written by Program.WriteTo are read
+// into the global dictionary by Objects.ReadFrom before they are verified,
+// linked or printed.
+type Dict struct {
+	m  map[string]int
+	mu sync.RWMutex
+	s  [][]byte
+}
+
+// NewDict returns a newly created Dict.
+func NewDict() *Dict {
+	return &Dict{
+		m: map[string]int{"": 0},
+		s: [][]byte{nil},
+	}
+}
+
+// ID implements Dictionary.
+func (d *Dict) ID(b []byte) int {
+	d.mu.RLock()
+	id, ok := d.m[string(b)]
+	d.mu.RUnlock()
+	if ok {
+		return id
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, ok := d.m[string(b)]; ok {
+		return id
+	}
+
+	id = len(d.s)
+	s := string(b)
+	d.m[s] = id
+	d.s = append(d.s, []byte(s))
+	return id
+}
+
+// S implements Dictionary.
+func (d *Dict) S(id int) []byte {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if id < 0 || id >= len(d.s) {
+		return nil
+	}
+
+	return d.s[id]
+}
+
+// SID implements Dictionary.
+func (d *Dict) SID(s string) int { return d.ID([]byte(s)) }
+
+// Program collects translation units and the dictionary their NameIDs,
+// StringIDs and TypeIDs are registered in. A Program with a nil Dict uses the
+// global dictionary.
+//
+// The serialized form of a Program is the same as that of its Objects, ie.
+// files written by Program.WriteTo can be read by Objects.ReadFrom and vice
+// versa.
+type Program struct {
+	Dict    *Dict
+	Objects Objects
+}
+
+// NewProgram returns a newly created Program using a private Dict.
+func NewProgram() *Program { return &Program{Dict: NewDict()} }
+
+// ReadFrom reads p.Objects from r registering all identifiers in p.Dict.
+func (p *Program) ReadFrom(r io.Reader) (n int64, err error) {
+	return p.Objects.readFrom(r, p.dictionary(), HostTarget())
+}
+
+// WriteTo writes p.Objects to w resolving all identifiers using p.Dict.
+func (p *Program) WriteTo(w io.Writer) (n int64, err error) {
+	return p.Objects.writeTo(w, p.dictionary(), &WriteOptions{ModTime: time.Now()})
+}
+
+// dictionary returns the dictionary of p.
+func (p *Program) dictionary() Dictionary {
+	if p.Dict != nil {
+		return p.Dict
+	}
+
+	return dict
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Difference describes a difference of two object sets found by Diff.
+type Difference struct {
+	A   Object    // Object of the first set, nil if B was added.
+	B   Object    // Object of the second set, nil if A was removed.
+	IPA int       // Index of OpA in the body of A or -1.
+	IPB int       // Index of OpB in the body of B or -1.
+	Msg string    // Description of the difference.
+	OpA Operation // Operation of A missing in B or nil.
+	OpB Operation // Operation of B missing in A or nil.
+}
+
+// String implements fmt.Stringer.
+func (d *Difference) String() string {
+	var nm NameID
+	switch {
+	case d.A != nil:
+		nm = d.A.Base().NameID
+	case d.B != nil:
+		nm = d.B.Base().NameID
+	}
+	switch {
+	case d.OpA != nil:
+		return fmt.Sprintf("%s: %#05x: -%s", nm, d.IPA, d.OpA)
+	case d.OpB != nil:
+		return fmt.Sprintf("%s: %#05x: +%s", nm, d.IPB, d.OpB)
+	}
+
+	return fmt.Sprintf("%s: %s", nm, d.Msg)
+}
+
+// Diff returns the differences of the object sets a and b, for example two
+// versions of the output of a front end. Objects are matched by name, of
+// multiple objects of the same name the first ones are matched first.
+// Positions are ignored when comparing, but the differing operations are
+// reported with their positions, by their indices in both bodies. Differing
+// function bodies are compared using the longest common subsequence of their
+// operations. The result is sorted by name.
+func Diff(a, b []Object) []Difference {
+	names := map[NameID]struct{}{}
+	byName := func(objs []Object) map[NameID][]Object {
+		m := map[NameID][]Object{}
+		for _, v := range objs {
+			nm := v.Base().NameID
+			m[nm] = append(m[nm], v)
+			names[nm] = struct{}{}
+		}
+		return m
+	}
+	ma, mb := byName(a), byName(b)
+	var a2 []NameID
+	for k := range names {
+		a2 = append(a2, k)
+	}
+	sort.Slice(a2, func(i, j int) bool { return a2[i].String() < a2[j].String() })
+	var r []Difference
+	for _, nm := range a2 {
+		oa, ob := ma[nm], mb[nm]
+		for i := 0; i < len(oa) || i < len(ob); i++ {
+			switch {
+			case i >= len(ob):
+				r = append(r, Difference{A: oa[i], IPA: -1, IPB: -1, Msg: "removed"})
+			case i >= len(oa):
+				r = append(r, Difference{B: ob[i], IPA: -1, IPB: -1, Msg: "added"})
+			default:
+				r = diffObjects(r, oa[i], ob[i])
+			}
+		}
+	}
+	return r
+}
+
+func diffObjects(r []Difference, a, b Object) []Difference {
+	d := func(format string, arg ...interface{}) {
+		r = append(r, Difference{A: a, B: b, IPA: -1, IPB: -1, Msg: fmt.Sprintf(format, arg...)})
+	}
+	ba, bb := a.Base(), b.Base()
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		d("kind differs: %T, %T", a, b)
+		return r
+	}
+
+	if ba.TypeID != bb.TypeID {
+		d("type differs: %s, %s", ba.TypeID, bb.TypeID)
+	}
+	if ba.Linkage != bb.Linkage {
+		d("linkage differs: %v, %v", ba.Linkage, bb.Linkage)
+	}
+	switch x := a.(type) {
+	case *AliasDefinition:
+		y := b.(*AliasDefinition)
+		if x.Target != y.Target || x.Offset != y.Offset {
+			d("alias target differs: %s%+d, %s%+d", x.Target, x.Offset, y.Target, y.Offset)
+		}
+	case *DataDefinition:
+		y := b.(*DataDefinition)
+		if x.TLS != y.TLS || x.ReadOnly != y.ReadOnly || !reflect.DeepEqual(x.Value, y.Value) {
+			d("value differs")
+		}
+	case *FunctionDefinition:
+		y := b.(*FunctionDefinition)
+		if x.Attributes != y.Attributes {
+			d("attributes differ: %v, %v", x.Attributes, y.Attributes)
+		}
+		if x.StaticChain != y.StaticChain {
+			d("static chain differs: %s, %s", x.StaticChain, y.StaticChain)
+		}
+		r = diffBodies(r, x, y)
+	}
+	return r
+}
+
+// diffBodies appends to r the operations of a and b not in their longest
+// common subsequence.
+func diffBodies(r []Difference, a, b *FunctionDefinition) []Difference {
+	ka, kb := diffKeys(a), diffKeys(b)
+	lo := 0
+	for lo < len(ka) && lo < len(kb) && ka[lo] == kb[lo] {
+		lo++
+	}
+	ha, hb := len(ka), len(kb)
+	for ha > lo && hb > lo && ka[ha-1] == kb[hb-1] {
+		ha--
+		hb--
+	}
+	ka, kb = ka[lo:ha], kb[lo:hb]
+
+	// lcs[i][j] is the length of the LCS of ka[i:] and kb[j:].
+	lcs := make([][]int32, len(ka)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(kb)+1)
+	}
+	for i := len(ka) - 1; i >= 0; i-- {
+		for j := len(kb) - 1; j >= 0; j-- {
+			switch {
+			case ka[i] == kb[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	removed := func(i int) {
+		r = append(r, Difference{A: a, B: b, IPA: lo + i, IPB: -1, Msg: "removed operation", OpA: a.Body[lo+i]})
+	}
+	added := func(j int) {
+		r = append(r, Difference{A: a, B: b, IPA: -1, IPB: lo + j, Msg: "added operation", OpB: b.Body[lo+j]})
+	}
+	i, j := 0, 0
+	for i < len(ka) && j < len(kb) {
+		switch {
+		case ka[i] == kb[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed(i)
+			i++
+		default:
+			added(j)
+			j++
+		}
+	}
+	for ; i < len(ka); i++ {
+		removed(i)
+	}
+	for ; j < len(kb); j++ {
+		added(j)
+	}
+	return r
+}
+
+// diffKeys returns the string forms of the operations of f without positions.
+func diffKeys(f *FunctionDefinition) []string {
+	r := make([]string, len(f.Body))
+	for i, op := range f.Body {
+		if p := op.Pos(); f.Positions == nil && p.IsValid() {
+			p := reflect.New(reflect.TypeOf(op).Elem())
+			p.Elem().Set(reflect.ValueOf(op).Elem())
+			op = p.Interface().(Operation)
+			setPosition(op, token.Position{})
+		}
+		r[i] = strings.TrimSpace(fmt.Sprint(op))
+	}
+	return r
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cznic/internal/buffer"
+)
+
+// DOT writes the control flow graph of f to w in the Graphviz[0] DOT
+// language. Nodes are the basic blocks of f.Body labeled by their operations,
+// prefixed by their indices. Fall through edges are dashed.
+//
+//  [0]: http://www.graphviz.org/
+func (f *FunctionDefinition) DOT(w io.Writer) error {
+	var buf buffer.Bytes
+
+	defer buf.Close()
+
+	if f.Positions != nil {
+		// The labels show positions, expand them in a copy, f is left as is.
+		f = CloneObject(f).(*FunctionDefinition)
+		f.ExpandPositions()
+	}
+	body := f.Body
+	labels := map[int]int{}     // Label key: ip.
+	addressed := map[int]bool{} // Label key: address taken by LabelAddr.
+	leader := make([]bool, len(body)+1)
+	if len(body) != 0 {
+		leader[0] = true
+	}
+	for ip, op := range body {
+		switch x := op.(type) {
+		case *Label:
+			labels[labelKey(x.NameID, x.Number)] = ip
+			leader[ip] = true
+		case *Jmp, *JmpP, *Jnz, *Jz, *Panic, *RestoreContext, *Return, *Switch:
+			leader[ip+1] = true
+		case *LabelAddr:
+			addressed[labelKey(x.NameID, x.Number)] = true
+		}
+	}
+
+	fmt.Fprintf(&buf, "digraph %q {\n\tnode [shape=box, fontname=\"monospace\"];\n", f.NameID.String())
+	block := map[int]int{} // Leader ip: block number.
+	var starts []int
+	for ip := range body {
+		if leader[ip] {
+			block[ip] = len(starts)
+			starts = append(starts, ip)
+		}
+	}
+	for i, start := range starts {
+		end := len(body)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		fmt.Fprintf(&buf, "\tb%d [label=\"", i)
+		for ip := start; ip < end; ip++ {
+			s := strings.TrimSpace(strings.Replace(fmt.Sprint(body[ip]), "\t", " ", -1))
+			s = strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1)
+			fmt.Fprintf(&buf, "%#05x %s\\l", ip, strings.Replace(s, "\n", "\\l        ", -1))
+		}
+		buf.WriteString("\"];\n")
+	}
+	edge := func(from, to int, fall bool) {
+		if to >= len(body) {
+			return
+		}
+
+		fmt.Fprintf(&buf, "\tb%d -> b%d", block[from], block[to])
+		if fall {
+			buf.WriteString(" [style=dashed]")
+		}
+		buf.WriteString(";\n")
+	}
+	for i, start := range starts {
+		end := len(body)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		switch x := body[end-1].(type) {
+		case *Jmp:
+			edge(start, labels[labelKey(x.NameID, x.Number)], false)
+		case *JmpP:
+			for k, ip := range labels {
+				if k < 0 || addressed[k] {
+					edge(start, ip, false)
+				}
+			}
+		case *Jnz:
+			edge(start, labels[labelKey(x.NameID, x.Number)], false)
+			edge(start, end, true)
+		case *Jz:
+			edge(start, labels[labelKey(x.NameID, x.Number)], false)
+			edge(start, end, true)
+		case *Panic, *RestoreContext, *Return:
+			// nop
+		case *Switch:
+			for _, v := range x.Labels {
+				edge(start, labels[labelKey(v.NameID, v.Number)], false)
+			}
+			edge(start, labels[labelKey(x.Default.NameID, x.Default.Number)], false)
+		default:
+			edge(start, end, true)
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// labelKey returns the key identifying a label the same way the verifier
+// does: -NameID for named labels, Number otherwise.
+func labelKey(nm NameID, num int) int {
+	if nm != 0 {
+		return -int(nm)
+	}
+
+	return num
+}
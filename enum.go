@@ -4,6 +4,55 @@
 
 package ir
 
+// ConflictPolicy determines how Objects.Merge handles multiple definitions of
+// the same external name.
+type ConflictPolicy int
+
+// ConflictPolicy values.
+const (
+	ConflictError     ConflictPolicy = iota // Merge fails.
+	ConflictKeepFirst                       // The first definition is kept, the others are discarded.
+	ConflictRename                          // The later definitions and the references to them in their translation unit are renamed.
+)
+
+// DivMode represents the semantics of an integer division or remainder by
+// zero and of dividing the minimum value of a signed integer type by -1.
+type DivMode int
+
+// DivMode values.
+const (
+	DivDefault DivMode = iota // The back end default, normally DivPanic.
+	DivPanic                  // Run time panic.
+	DivTrap                   // Abort the program, for example by a hardware trap.
+	DivZero                   // The result is zero.
+)
+
+// Endianness represents the byte order of multi-byte values in memory.
+type Endianness int
+
+// Endianness values.
+const (
+	LittleEndian Endianness = iota // The least significant byte comes first.
+	BigEndian                      // The most significant byte comes first.
+)
+
+// FenceScope represents the extent of a Fence.
+type FenceScope int
+
+// FenceScope values.
+const (
+	FenceFull     FenceScope = iota // A memory barrier ordering all memory accesses, for example C11 atomic_thread_fence.
+	FenceCompiler                   // Memory accesses are not reordered by the compiler only, for example asm volatile("" ::: "memory") or atomic_signal_fence.
+)
+
+func (s FenceScope) suffix() string {
+	if s == FenceCompiler {
+		return "(compiler)"
+	}
+
+	return ""
+}
+
 // Linkage represents a linkage type.
 type Linkage int
 
@@ -13,8 +62,96 @@ const (
 
 	ExternalLinkage
 	InternalLinkage
+	OnceLinkage         // Like ExternalLinkage, but multiple definitions are permitted and the linker keeps one of them.
+	WeakLinkage         // Like ExternalLinkage, but a definition with ExternalLinkage overrides it.
+	WeakExternalLinkage // Reference to an external name that may remain undefined. The linker resolves such references to a null address.
+	CommonLinkage       // Tentative data definition. The linker keeps the largest one unless there is a definition with ExternalLinkage.
+)
+
+// weak reports whether a definition with linkage l yields to a definition
+// with ExternalLinkage.
+func (l Linkage) weak() bool { return l == OnceLinkage || l == WeakLinkage }
+
+func (m DivMode) suffix() string {
+	switch m {
+	case DivPanic:
+		return "(panic)"
+	case DivTrap:
+		return "(trap)"
+	case DivZero:
+		return "(zero)"
+	}
+	return ""
+}
+
+// OutOfRange represents the semantics of converting a floating point value
+// which, after rounding, is not representable in the integer result type.
+type OutOfRange int
+
+// OutOfRange values.
+const (
+	OutOfRangeUndefined OutOfRange = iota // The result is undefined, the C default.
+	OutOfRangeSaturate                    // The result is the nearest representable value, NaN converts to zero.
+	OutOfRangeTrap                        // The conversion aborts the program, for example -fsanitize=float-cast-overflow.
 )
 
+func (o OutOfRange) suffix() string {
+	switch o {
+	case OutOfRangeSaturate:
+		return "(sat)"
+	case OutOfRangeTrap:
+		return "(trap)"
+	}
+	return ""
+}
+
+// Overflow represents the semantics of a signed integer overflow.
+type Overflow int
+
+// Overflow values.
+const (
+	OverflowUndefined Overflow = iota // Overflow is undefined behavior, the C default.
+	OverflowWrap                      // The result wraps around, for example -fwrapv.
+	OverflowTrap                      // Overflow aborts the program, for example -ftrapv or a sanitizer.
+)
+
+func (o Overflow) suffix() string {
+	switch o {
+	case OverflowWrap:
+		return "(wrap)"
+	case OverflowTrap:
+		return "(trap)"
+	}
+	return ""
+}
+
+// Rounding represents the rounding mode of a conversion between a floating
+// point type and an integer type.
+type Rounding int
+
+// Rounding values.
+const (
+	RoundDefault     Rounding = iota // Toward zero for floating point to integer conversions, the current rounding mode otherwise, the C default.
+	RoundNearestEven                 // To the nearest value, ties to even.
+	RoundTowardZero                  // Toward zero.
+	RoundUp                          // Toward positive infinity.
+	RoundDown                        // Toward negative infinity.
+)
+
+func (r Rounding) suffix() string {
+	switch r {
+	case RoundNearestEven:
+		return "(rne)"
+	case RoundTowardZero:
+		return "(rtz)"
+	case RoundUp:
+		return "(rup)"
+	case RoundDown:
+		return "(rdn)"
+	}
+	return ""
+}
+
 // TypeKind represents a particular type kind.
 type TypeKind int
 
@@ -26,12 +163,15 @@ const (
 	Int16
 	Int32
 	Int64
+	Int128 // Two's complement 128 bit integer, like __int128 of GCC and Clang.
 
 	Uint8
 	Uint16
 	Uint32
 	Uint64
+	Uint128
 
+	Float16 // IEEE 754 half precision, a storage format converted to and from Float32.
 	Float32
 	Float64
 	Float128
@@ -45,6 +185,8 @@ const (
 	Struct
 	Pointer
 	Function
+	Vector
+	Void
 )
 
 // Kind implements Type.
@@ -59,12 +201,15 @@ const (
 	tokI16
 	tokI32
 	tokI64
+	tokI128
 
 	tokU8
 	tokU16
 	tokU32
 	tokU64
+	tokU128
 
+	tokF16
 	tokF32
 	tokF64
 	tokF128
@@ -78,6 +223,7 @@ const (
 	tokNumber
 	tokStruct
 	tokUnion
+	tokVoid
 
 	tokName
 
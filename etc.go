@@ -5,31 +5,45 @@
 package ir
 
 import (
+	"bytes"
 	"encoding/gob"
 	"fmt"
 	"go/token"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"reflect"
+	"strings"
 
 	"github.com/cznic/strutil"
 	"github.com/cznic/xc"
 )
 
 func init() {
+	gob.Register(&AliasDefinition{})
 	gob.Register(&DataDefinition{})
 	gob.Register(&FunctionDefinition{})
+	gob.Register(&functionGob{})
 	gob.Register(NameID(0))
 	gob.Register(StringID(0))
 	gob.Register(TypeID(0))
 
 	gob.Register(&Add{})
+	gob.Register(&AddOv{})
 	gob.Register(&AllocResult{})
+	gob.Register(&Alloca{})
 	gob.Register(&And{})
+	gob.Register(&Annotate{})
 	gob.Register(&Argument{})
 	gob.Register(&Arguments{})
 	gob.Register(&BeginScope{})
 	gob.Register(&Bool{})
+	gob.Register(&Bswap{})
 	gob.Register(&Call{})
 	gob.Register(&CallFP{})
+	gob.Register(&Chain{})
+	gob.Register(&Closure{})
+	gob.Register(&Clz{})
 	gob.Register(&Const{})
 	gob.Register(&Const32{})
 	gob.Register(&Const64{})
@@ -37,14 +51,17 @@ func init() {
 	gob.Register(&Convert{})
 	gob.Register(&Copy{})
 	gob.Register(&Cpl{})
+	gob.Register(&Ctz{})
 	gob.Register(&Div{})
 	gob.Register(&Drop{})
 	gob.Register(&Dup{})
 	gob.Register(&Element{})
 	gob.Register(&EndScope{})
 	gob.Register(&Eq{})
+	gob.Register(&Fence{})
 	gob.Register(&Field{})
 	gob.Register(&FieldValue{})
+	gob.Register(&Free{})
 	gob.Register(&Geq{})
 	gob.Register(&Global{})
 	gob.Register(&Gt{})
@@ -53,49 +70,97 @@ func init() {
 	gob.Register(&Jnz{})
 	gob.Register(&Jz{})
 	gob.Register(&Label{})
+	gob.Register(&LabelAddr{})
 	gob.Register(&Leq{})
 	gob.Register(&Load{})
 	gob.Register(&Lsh{})
 	gob.Register(&Lt{})
 	gob.Register(&Mul{})
+	gob.Register(&MulOv{})
 	gob.Register(&Neg{})
 	gob.Register(&Neq{})
+	gob.Register(&New{})
 	gob.Register(&Nil{})
 	gob.Register(&Not{})
 	gob.Register(&Or{})
 	gob.Register(&Panic{})
+	gob.Register(&Popcnt{})
 	gob.Register(&PostIncrement{})
 	gob.Register(&PreIncrement{})
 	gob.Register(&PtrDiff{})
 	gob.Register(&Rem{})
+	gob.Register(&RestoreContext{})
 	gob.Register(&Result{})
 	gob.Register(&Return{})
 	gob.Register(&Rsh{})
+	gob.Register(&SaveContext{})
+	gob.Register(&Select{})
 	gob.Register(&Store{})
 	gob.Register(&StringConst{})
 	gob.Register(&Sub{})
+	gob.Register(&SubOv{})
 	gob.Register(&Switch{})
 	gob.Register(&Variable{})
 	gob.Register(&VariableDeclaration{})
 	gob.Register(&Xor{})
+	gob.Register(&Zero{})
 
 	gob.Register(&AddressValue{})
 	gob.Register(&Complex128Value{})
 	gob.Register(&Complex64Value{})
 	gob.Register(&CompositeValue{})
 	gob.Register(&DesignatedValue{})
+	gob.Register(&DifferenceValue{})
+	gob.Register(&Float128Value{})
 	gob.Register(&Float32Value{})
 	gob.Register(&Float64Value{})
+	gob.Register(&Int128Value{})
 	gob.Register(&Int32Value{})
 	gob.Register(&Int64Value{})
 	gob.Register(&StringValue{})
+	gob.Register(&Uint32Value{})
+	gob.Register(&Uint64Value{})
 	gob.Register(&WideStringValue{})
+
+	// Assign the gob type IDs in a fixed order, so that the encoding of
+	// Objects does not depend on what was gob encoded before.
+	var ops []Operation
+	for _, v := range opcodes {
+		if v != nil {
+			ops = append(ops, v)
+		}
+	}
+	if err := gob.NewEncoder(ioutil.Discard).Encode(Objects{{
+		&AliasDefinition{},
+		&DataDefinition{Value: &CompositeValue{Values: []Value{
+			&AddressValue{},
+			&Complex128Value{},
+			&Complex64Value{},
+			&DesignatedValue{Value: &Int32Value{}},
+			&DifferenceValue{A: &AddressValue{}, B: &AddressValue{}},
+			&Float128Value{Value: new(big.Float)},
+			&Float32Value{},
+			&Float64Value{},
+			&Int128Value{Value: new(big.Int)},
+			&Int32Value{},
+			&Int64Value{},
+			&StringValue{},
+			&Uint32Value{},
+			&Uint64Value{},
+			&WideStringValue{},
+		}}},
+		&FunctionDefinition{Body: ops},
+	}}); err != nil {
+		panic(err)
+	}
 }
 
 var (
 	dict = xc.Dict
 
+	idBlank         = NameID(dict.SID("_"))
 	idBuiltinPrefix = dict.SID("__builtin_")
+	idInt128        = TypeID(dict.SID("int128"))
 	idInt16         = TypeID(dict.SID("int16"))
 	idInt32         = TypeID(dict.SID("int32"))
 	idInt64         = TypeID(dict.SID("int64"))
@@ -173,6 +238,263 @@ func PrettyString(v interface{}) string {
 	}
 }
 
+// PrintOptions amend Fprint.
+type PrintOptions struct {
+	Indent      string // Replaces the default indentation unit "· " if not empty.
+	MaxBody     int    // If positive, print at most MaxBody operations of a function body.
+	NoPositions bool   // Omit positions.
+	ShortTypes  bool   // Print types as TypeID.Abbrev does.
+}
+
+func (o *PrintOptions) hooks() strutil.PrettyPrintHooks {
+	if !o.NoPositions && !o.ShortTypes {
+		return printHooks
+	}
+
+	h := strutil.PrettyPrintHooks{}
+	for k, v := range printHooks {
+		h[k] = v
+	}
+	if o.NoPositions {
+		h[reflect.TypeOf(token.Position{})] = func(strutil.Formatter, interface{}, string, string) {}
+	}
+	if o.ShortTypes {
+		h[reflect.TypeOf(TypeID(0))] = func(f strutil.Formatter, v interface{}, prefix, suffix string) {
+			x := v.(TypeID)
+			if x == 0 {
+				return
+			}
+
+			f.Format(prefix)
+			f.Format("%s", x.Abbrev())
+			f.Format(suffix)
+		}
+	}
+	return h
+}
+
+// Fprint writes v to w in the format of PrettyString as amended by opts.
+// Objects and []Object are written one object at a time, each prefixed by its
+// index, so the whole text is never held in memory.
+func Fprint(w io.Writer, v interface{}, opts PrintOptions) error {
+	ew := &errWriter{w: w}
+	var out io.Writer = ew
+	if opts.Indent != "" {
+		out = &indenter{indent: []byte(opts.Indent), w: ew}
+	}
+	hooks := opts.hooks()
+	switch x := v.(type) {
+	case Objects:
+		for i, v := range x {
+			for j, v := range v {
+				opts.fprint(out, v, fmt.Sprintf("%v.%v: ", i, j), hooks)
+			}
+		}
+	case []Object:
+		for i, v := range x {
+			opts.fprint(out, v, fmt.Sprintf("%v: ", i), hooks)
+		}
+	default:
+		opts.fprint(out, v, "", hooks)
+	}
+	if x, ok := out.(*indenter); ok {
+		x.flush()
+	}
+	return ew.err
+}
+
+func (o *PrintOptions) fprint(w io.Writer, v interface{}, prefix string, hooks strutil.PrettyPrintHooks) {
+	more := 0
+	if f, ok := v.(*FunctionDefinition); ok && (o.NoPositions || o.MaxBody > 0 && len(f.Body) > o.MaxBody) {
+		g := *f
+		if o.NoPositions {
+			g.Files = nil
+			g.Positions = nil
+		}
+		if o.MaxBody > 0 && len(g.Body) > o.MaxBody {
+			more = len(g.Body) - o.MaxBody
+			g.Body = g.Body[:o.MaxBody]
+		}
+		v = &g
+	}
+	strutil.PrettyPrint(w, v, prefix, "\n", hooks)
+	if more != 0 {
+		fmt.Fprintf(w, "... %v more operations\n", more)
+	}
+}
+
+type errWriter struct {
+	err error
+	w   io.Writer
+}
+
+func (w *errWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	var n int
+	n, w.err = w.w.Write(b)
+	return n, w.err
+}
+
+var indentUnit = []byte("· ")
+
+// indenter replaces the indentation units at the start of lines written to w
+// by indent.
+type indenter struct {
+	indent []byte
+	mid    bool   // Not at the start of a line.
+	p      []byte // Pending prefix of indentUnit.
+	w      io.Writer
+}
+
+// Write implements io.Writer. Errors are recorded by the underlying
+// errWriter.
+func (w *indenter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) != 0 {
+		if w.mid {
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				w.w.Write(b)
+				break
+			}
+
+			w.w.Write(b[:i+1])
+			b = b[i+1:]
+			w.mid = false
+			continue
+		}
+
+		c := b[0]
+		b = b[1:]
+		w.p = append(w.p, c)
+		switch {
+		case bytes.Equal(w.p, indentUnit):
+			w.w.Write(w.indent)
+			w.p = w.p[:0]
+		case !bytes.HasPrefix(indentUnit, w.p):
+			w.w.Write(w.p)
+			w.mid = c != '\n'
+			w.p = w.p[:0]
+		}
+	}
+	return n, nil
+}
+
+func (w *indenter) flush() { w.w.Write(w.p) }
+
+// SetDivMode sets the Mode of all Div and Rem operations in the function
+// definitions of objs having the DivDefault mode to m. It's intended for
+// selecting the division semantics of a whole translation unit.
+func SetDivMode(objs []Object, m DivMode) {
+	for _, v := range objs {
+		f, ok := v.(*FunctionDefinition)
+		if !ok {
+			continue
+		}
+
+		for _, v := range f.Body {
+			switch x := v.(type) {
+			case *Div:
+				if x.Mode == DivDefault {
+					x.Mode = m
+				}
+			case *Rem:
+				if x.Mode == DivDefault {
+					x.Mode = m
+				}
+			}
+		}
+	}
+}
+
+// divModifiers returns the String form of the mode and flags of a Div or Rem
+// operation, for example "(trap,nonzero)", or "" if there are none.
+func convertModifiers(r Rounding, o OutOfRange) string {
+	var a []string
+	if s := r.suffix(); s != "" {
+		a = append(a, s[1:len(s)-1])
+	}
+	if s := o.suffix(); s != "" {
+		a = append(a, s[1:len(s)-1])
+	}
+	if len(a) == 0 {
+		return ""
+	}
+
+	return "(" + strings.Join(a, ",") + ")"
+}
+
+func divModifiers(m DivMode, exact, nonZero, noOverflow bool) string {
+	var a []string
+	if s := m.suffix(); s != "" {
+		a = append(a, s[1:len(s)-1])
+	}
+	if exact {
+		a = append(a, "exact")
+	}
+	if noOverflow {
+		a = append(a, "noov")
+	}
+	if nonZero {
+		a = append(a, "nonzero")
+	}
+	if len(a) == 0 {
+		return ""
+	}
+
+	return "(" + strings.Join(a, ",") + ")"
+}
+
+// qualifiers returns the String form of the access qualifier flags of an
+// operation, for example "(atomic,volatile)", or "" if no flag is set.
+func qualifiers(atomic, restrict, volatile bool) string {
+	if a := qualifierList(atomic, restrict, volatile); len(a) != 0 {
+		return "(" + strings.Join(a, ",") + ")"
+	}
+
+	return ""
+}
+
+// incQualifiers is like qualifiers but returns the form used after the Delta
+// of PostIncrement and PreIncrement, for example " atomic volatile".
+func incQualifiers(atomic, restrict, volatile bool) string {
+	if a := qualifierList(atomic, restrict, volatile); len(a) != 0 {
+		return " " + strings.Join(a, " ")
+	}
+
+	return ""
+}
+
+func qualifierList(atomic, restrict, volatile bool) (r []string) {
+	if atomic {
+		r = append(r, "atomic")
+	}
+	if restrict {
+		r = append(r, "restrict")
+	}
+	if volatile {
+		r = append(r, "volatile")
+	}
+	return r
+}
+
+// varArgs returns the form of the VarArgTypes of Call and CallFP used by
+// their String methods, for example ", (int32, float64)".
+func varArgs(a []TypeID) string {
+	if a == nil {
+		return ""
+	}
+
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = v.String()
+	}
+	return ", (" + strings.Join(s, ", ") + ")"
+}
+
 func addr(n bool) string {
 	if n {
 		return "&"
@@ -0,0 +1,557 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"go/token"
+	"math/big"
+)
+
+// FoldConstants replaces arithmetic, bitwise and relational operations of
+// integer type, other than int128 and uint128, which operands are Const32 or
+// Const64 operations by the constant result and then verifies f. Verification
+// turns branches on constant conditions into jumps or removes them and
+// removes the code rendered unreachable.
+//
+// Operations which result is not defined, like division by zero, or which
+// would trap, like a signed overflow of an operation with the OverflowTrap
+// flag, are left alone. If the positions of f are compressed, FoldConstants
+// expands them.
+func FoldConstants(f *FunctionDefinition) error {
+	f.ExpandPositions()
+	c := folder{TypeCache{}}
+	s := f.Body[:0]
+	for _, op := range f.Body {
+		s = append(s, op)
+		for {
+			n := len(s)
+			if n < 2 {
+				break
+			}
+
+			var r Operation
+			switch x := s[n-1].(type) {
+			case *Cpl, *Neg, *Not:
+				r = c.unop(x, s[n-2])
+				if r != nil {
+					s = s[:n-2]
+				}
+			default:
+				if n < 3 {
+					break
+				}
+
+				if r = c.binop(x, s[n-3], s[n-2]); r != nil {
+					s = s[:n-3]
+				}
+			}
+			if r == nil {
+				break
+			}
+
+			s = append(s, r)
+		}
+	}
+	f.Body = s
+	return f.Verify()
+}
+
+type folder struct {
+	typeCache TypeCache
+}
+
+// constant returns the value of a foldable constant operation and its type
+// kind.
+func (c folder) constant(op Operation) (*big.Int, TypeID, TypeKind, bool) {
+	var v int64
+	var t TypeID
+	switch x := op.(type) {
+	case *Const32:
+		if x.LOp {
+			return nil, 0, 0, false
+		}
+
+		v, t = int64(x.Value), x.TypeID
+	case *Const64:
+		v, t = x.Value, x.TypeID
+	default:
+		return nil, 0, 0, false
+	}
+
+	k := c.typeCache.MustType(t).Kind()
+	if !isIntegral(k) || k == Int128 || k == Uint128 {
+		return nil, 0, 0, false
+	}
+
+	return foldWrap(big.NewInt(v), k), t, k, true
+}
+
+func (c folder) unop(op, a Operation) Operation {
+	x, t, k, ok := c.constant(a)
+	if !ok {
+		return nil
+	}
+
+	r := new(big.Int)
+	switch y := op.(type) {
+	case *Cpl:
+		if y.TypeID != t {
+			return nil
+		}
+
+		r.Not(x)
+	case *Neg:
+		if y.TypeID != t {
+			return nil
+		}
+
+		r.Neg(x)
+		if y.Overflow == OverflowTrap && foldWrap(new(big.Int).Set(r), k).Cmp(r) != 0 {
+			return nil
+		}
+	case *Not:
+		if t != idInt32 {
+			return nil
+		}
+
+		if x.Sign() == 0 {
+			r.SetInt64(1)
+		}
+	}
+	return foldResult(r, t, k, op.Pos())
+}
+
+func (c folder) binop(op, a, b Operation) Operation {
+	var t TypeID
+	switch x := op.(type) {
+	case *Add:
+		t = x.TypeID
+	case *And:
+		t = x.TypeID
+	case *Div:
+		t = x.TypeID
+	case *Eq:
+		t = x.TypeID
+	case *Geq:
+		t = x.TypeID
+	case *Gt:
+		t = x.TypeID
+	case *Leq:
+		t = x.TypeID
+	case *Lt:
+		t = x.TypeID
+	case *Mul:
+		t = x.TypeID
+	case *Neq:
+		t = x.TypeID
+	case *Or:
+		t = x.TypeID
+	case *Rem:
+		t = x.TypeID
+	case *Sub:
+		t = x.TypeID
+	case *Xor:
+		t = x.TypeID
+	default:
+		return nil
+	}
+
+	x, tx, k, ok := c.constant(a)
+	if !ok || tx != t {
+		return nil
+	}
+
+	y, ty, _, ok := c.constant(b)
+	if !ok || ty != t {
+		return nil
+	}
+
+	r := new(big.Int)
+	var trap bool
+	switch o := op.(type) {
+	case *Add:
+		r.Add(x, y)
+		trap = o.Overflow == OverflowTrap
+	case *And:
+		r.And(x, y)
+	case *Div:
+		if y.Sign() == 0 {
+			return nil
+		}
+
+		r.Quo(x, y)
+		trap = true
+	case *Eq:
+		return foldBool(x.Cmp(y) == 0, op.Pos())
+	case *Geq:
+		return foldBool(x.Cmp(y) >= 0, op.Pos())
+	case *Gt:
+		return foldBool(x.Cmp(y) > 0, op.Pos())
+	case *Leq:
+		return foldBool(x.Cmp(y) <= 0, op.Pos())
+	case *Lt:
+		return foldBool(x.Cmp(y) < 0, op.Pos())
+	case *Mul:
+		r.Mul(x, y)
+		trap = o.Overflow == OverflowTrap
+	case *Neq:
+		return foldBool(x.Cmp(y) != 0, op.Pos())
+	case *Or:
+		r.Or(x, y)
+	case *Rem:
+		if y.Sign() == 0 {
+			return nil
+		}
+
+		if q := new(big.Int).Quo(x, y); foldWrap(new(big.Int).Set(q), k).Cmp(q) != 0 {
+			return nil
+		}
+
+		r.Rem(x, y)
+	case *Sub:
+		r.Sub(x, y)
+		trap = o.Overflow == OverflowTrap
+	case *Xor:
+		r.Xor(x, y)
+	}
+	if trap && foldWrap(new(big.Int).Set(r), k).Cmp(r) != 0 {
+		return nil
+	}
+
+	return foldResult(r, t, k, op.Pos())
+}
+
+func foldBool(b bool, pos token.Position) Operation {
+	r := &Const32{TypeID: idInt32, Position: pos}
+	if b {
+		r.Value = 1
+	}
+	return r
+}
+
+// foldResult returns a constant operation pushing v of type t.
+func foldResult(v *big.Int, t TypeID, k TypeKind, pos token.Position) Operation {
+	v = foldWrap(v, k)
+	switch k {
+	case Int64:
+		return &Const64{TypeID: t, Value: v.Int64(), Position: pos}
+	case Uint64:
+		return &Const64{TypeID: t, Value: int64(v.Uint64()), Position: pos}
+	case Uint32:
+		return &Const32{TypeID: t, Value: int32(uint32(v.Uint64())), Position: pos}
+	default:
+		return &Const32{TypeID: t, Value: int32(v.Int64()), Position: pos}
+	}
+}
+
+// foldWrap sets v to v modulo the range of the integer type kind k and returns
+// v.
+func foldWrap(v *big.Int, k TypeKind) *big.Int {
+	var bits uint
+	switch k {
+	case Int8, Uint8:
+		bits = 8
+	case Int16, Uint16:
+		bits = 16
+	case Int32, Uint32:
+		bits = 32
+	case Int128, Uint128:
+		bits = 128
+	default:
+		bits = 64
+	}
+	m := new(big.Int).Lsh(big.NewInt(1), bits)
+	v.Mod(v, m) // Euclidean, v is now in [0, m).
+	if isSigned(k) {
+		if v.Cmp(new(big.Int).Rsh(m, 1)) >= 0 {
+			v.Sub(v, m)
+		}
+	}
+	return v
+}
+
+// EliminateLoads removes the reloads of stored values. A non volatile Store
+// followed by a Drop of the stored value and a non volatile Load of the same
+// local variable, argument or global is replaced by the Store alone, which
+// leaves the stored value on the evaluation stack. C front ends produce this
+// pattern for every assignment whose value is used afterwards, like in
+// "a = b; f(a)".
+//
+// Atomic operations and stores to bit fields are left alone. If the positions
+// of f are compressed, EliminateLoads expands them.
+func EliminateLoads(f *FunctionDefinition) error {
+	f.ExpandPositions()
+	depth := make([]int, len(f.Body)) // Evaluation stack depth after ip.
+	for i := range depth {
+		depth[i] = -1
+	}
+	v := NewVerifier()
+	v.stackHook = func(s []TypeID) { depth[v.ip] = len(s) }
+	if err := v.Validate(f, nil); err != nil {
+		return err
+	}
+
+	tc := v.typeCache
+	body := f.Body
+	remove := make([]bool, len(body))
+	for ip := 1; ip+3 < len(body); ip++ {
+		st, ok := body[ip].(*Store)
+		if !ok || st.Atomic || st.Volatile || st.Bits != 0 || depth[ip-1] < 2 {
+			continue
+		}
+
+		drop, ok := body[ip+1].(*Drop)
+		if !ok || drop.TypeID != st.TypeID {
+			continue
+		}
+
+		ld, ok := body[ip+3].(*Load)
+		if !ok || ld.Atomic || ld.Volatile {
+			continue
+		}
+
+		if p, ok := tc.MustType(ld.TypeID).(*PointerType); !ok || p.Element.ID() != st.TypeID {
+			continue
+		}
+
+		// Find the operation which pushed the address consumed by the
+		// Store.
+		d := depth[ip-1] - 1
+		a := ip - 1
+		for ; a >= 0 && depth[a] > d; a-- {
+			if _, ok := body[a].(*Label); ok {
+				a = -1
+				break
+			}
+		}
+		if a < 0 || depth[a] != d || remove[a] || !sameAddress(body[a], body[ip+2]) {
+			continue
+		}
+
+		remove[ip+1] = true
+		remove[ip+2] = true
+		remove[ip+3] = true
+		ip += 3
+	}
+	w := 0
+	for ip, op := range body {
+		if !remove[ip] {
+			body[w] = op
+			w++
+		}
+	}
+	f.Body = body[:w]
+	return nil
+}
+
+// sameAddress reports whether a and b push the address of the same local
+// variable, argument or global.
+func sameAddress(a, b Operation) bool {
+	switch x := a.(type) {
+	case *Argument:
+		y, ok := b.(*Argument)
+		return ok && x.Address && y.Address && x.Index == y.Index && x.TypeID == y.TypeID
+	case *Global:
+		y, ok := b.(*Global)
+		return ok && x.Address && y.Address && x.NameID == y.NameID && x.Index == y.Index && x.Offset == y.Offset && x.TypeID == y.TypeID
+	case *Variable:
+		y, ok := b.(*Variable)
+		return ok && x.Address && y.Address && x.Index == y.Index && x.TypeID == y.TypeID
+	}
+	return false
+}
+
+// EliminateDeadStores removes the stores to local variables which are not
+// loaded afterwards on any path reachable from the store, like the stores of
+// a variable assigned a value which is overwritten before it is used or the
+// stores of a variable never read at all. The removed Store and the
+// operation pushing the address of the variable are dropped, the stored value
+// is computed as before and left on the evaluation stack.
+//
+// Only variables which address is used solely to load or store them are
+// considered. Volatile and atomic accesses keep the variable alone and a
+// Fence is considered to load all variables. Functions using SaveContext are
+// left alone. If the positions of f are compressed, EliminateDeadStores
+// expands them.
+func EliminateDeadStores(f *FunctionDefinition) error {
+	f.ExpandPositions()
+	for _, op := range f.Body {
+		if _, ok := op.(*SaveContext); ok {
+			return nil
+		}
+	}
+
+	c, err := newSSABuilder(f, false)
+	if err != nil {
+		return err
+	}
+
+	tc := c.v.typeCache
+	nvars := 0
+	escaped := map[int]bool{}
+	for _, b := range c.Blocks {
+		for _, v := range b.Values {
+			if x, ok := v.Op.(*Variable); ok && x.Index >= nvars {
+				nvars = x.Index + 1
+			}
+		}
+	}
+	uses := map[*SSAValue]int{}
+	addr := func(v *SSAValue) (int, bool) {
+		if x, ok := v.Op.(*Variable); ok && x.Address {
+			return x.Index, true
+		}
+
+		return 0, false
+	}
+	for _, b := range c.Blocks {
+		for _, v := range b.Phis {
+			for _, a := range v.Args {
+				if n, ok := addr(a); ok {
+					escaped[n] = true
+				}
+			}
+		}
+		for _, v := range b.Values {
+			for i, a := range v.Args {
+				n, ok := addr(a)
+				if !ok {
+					continue
+				}
+
+				uses[a]++
+				switch x := v.Op.(type) {
+				case *Load:
+					if i != 0 || x.Atomic || x.Volatile {
+						escaped[n] = true
+					}
+				case *Store:
+					if i != 0 || x.Atomic || x.Volatile {
+						escaped[n] = true
+					}
+				default:
+					escaped[n] = true
+				}
+			}
+		}
+	}
+	if len(escaped) == nvars {
+		return nil
+	}
+
+	// classify returns the kind of the access of v to a local variable
+	// and the variable index.
+	type access int
+	const (
+		accessNone access = iota
+		accessLoad
+		accessStore
+		accessStorePart
+		accessFence
+	)
+	classify := func(v *SSAValue) (access, int) {
+		switch x := v.Op.(type) {
+		case *Fence:
+			return accessFence, 0
+		case *Load:
+			if n, ok := addr(v.Args[0]); ok && !escaped[n] {
+				return accessLoad, n
+			}
+		case *Store:
+			if n, ok := addr(v.Args[0]); ok && !escaped[n] {
+				if x.Bits == 0 && tc.MustType(v.Args[0].TypeID).(*PointerType).Element.ID() == x.TypeID {
+					return accessStore, n
+				}
+
+				return accessStorePart, n
+			}
+		case *Variable:
+			if !x.Address && !escaped[x.Index] {
+				return accessLoad, x.Index
+			}
+		}
+		return accessNone, 0
+	}
+
+	// Compute the variables live at block entry.
+	liveIn := make([][]bool, len(c.Blocks))
+	for i := range liveIn {
+		liveIn[i] = make([]bool, nvars)
+	}
+	liveOut := func(b *SSABlock) []bool {
+		r := make([]bool, nvars)
+		for _, s := range b.Succs {
+			for i, v := range liveIn[s.ID] {
+				r[i] = r[i] || v
+			}
+		}
+		return r
+	}
+	// transfer updates live at the entry of v, which is a dead store if
+	// it stores a variable not live at its exit.
+	transfer := func(v *SSAValue, live []bool) (dead bool) {
+		switch a, n := classify(v); a {
+		case accessFence:
+			for i := range live {
+				live[i] = true
+			}
+		case accessLoad:
+			live[n] = true
+		case accessStore:
+			dead = !live[n]
+			live[n] = false
+		case accessStorePart:
+			dead = !live[n]
+		}
+		return dead
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(c.rpo) - 1; i >= 0; i-- {
+			b := c.rpo[i]
+			live := liveOut(b)
+			for j := len(b.Values) - 1; j >= 0; j-- {
+				transfer(b.Values[j], live)
+			}
+			for j, v := range live {
+				if v && !liveIn[b.ID][j] {
+					liveIn[b.ID][j] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	ips := map[Operation]int{}
+	for ip, op := range f.Body {
+		ips[op] = ip
+	}
+	remove := make([]bool, len(f.Body))
+	removed := false
+	for _, b := range c.rpo {
+		live := liveOut(b)
+		for j := len(b.Values) - 1; j >= 0; j-- {
+			v := b.Values[j]
+			if transfer(v, live) && uses[v.Args[0]] == 1 && c.dups[v.Args[0]] == 0 {
+				remove[ips[v.Op]] = true
+				remove[ips[v.Args[0].Op]] = true
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	w := 0
+	for ip, op := range f.Body {
+		if !remove[ip] {
+			f.Body[w] = op
+			w++
+		}
+	}
+	f.Body = f.Body[:w]
+	return nil
+}
@@ -0,0 +1,1643 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"sort"
+
+	"github.com/cznic/mathutil"
+)
+
+const (
+	interpBase     = 1 << 12 // Lowest valid address.
+	interpGuard    = 16      // Minimum gap between and alignment of memory blocks.
+	interpMaxDepth = 1 << 12 // Maximum call depth.
+)
+
+// ExternalFunction implements a function for an Interpreter, typically a
+// function the IR only declares, like a C library function. It is passed the
+// function arguments, including any variadic ones, and returns the function
+// results. See Interpreter for the representation of values.
+type ExternalFunction func(in *Interpreter, args []interface{}) ([]interface{}, error)
+
+// Interpreter executes linked objects, for example to test the code produced
+// by a front end or to evaluate static initializers at compile time.
+//
+// Objects are laid out according to a memory model in a private address
+// space. Scalars are stored in the byte order of the memory model, float128
+// and complex256 values are computed and stored with float64 precision.
+// Values of integer and pointer types are represented as uint64, sign
+// extended if the type is signed, values of floating point types as float64,
+// values of complex types as complex128 and all other values, like structs,
+// as []byte holding their memory representation. Int128 and uint128 values
+// are represented like structs, they can be loaded, stored and passed around
+// but not computed.
+//
+// Computed gotos are not supported.
+type Interpreter struct {
+	// Externals implement functions by name. An external function
+	// overrides the body of the function definition of the same name.
+	// Linking requires all called functions to be defined, a function
+	// implemented by Externals can be defined by a stub, for example one
+	// consisting of a single Panic operation.
+	Externals map[NameID]ExternalFunction
+
+	// Limit is the maximum number of operations executed by Call, zero
+	// means no limit.
+	Limit int64
+
+	// Profile enables counting how many times the control reaches Labels
+	// and how many times the Jnz and Jz branches are taken or not taken.
+	// See AttachProfile.
+	Profile bool
+
+	dataEncoder
+
+	addrs     []uint64                 // Object index: address.
+	blocks    []*interpBlock           // Sorted by address.
+	code      map[uint64]interpCode    // Function pointer: target.
+	contexts  map[uint64]interpContext // Saved by SaveContext, context address: context.
+	depth     int                      // Call depth.
+	funcs     map[*FunctionDefinition]*interpFunc
+	next      uint64 // Next free address.
+	objects   []Object
+	steps     int64
+	strings   map[interpString]uint64
+	typeCache TypeCache
+}
+
+type interpBlock struct {
+	addr uint64
+	b    []byte
+	heap bool // Allocated by New.
+}
+
+type interpCode struct {
+	chain uint64 // Static chain bound by Closure.
+	index int    // Function object index.
+}
+
+// interpContext is an execution context saved by SaveContext.
+type interpContext struct {
+	fr *interpFrame
+	ip int // Of the SaveContext operation.
+}
+
+// interpRestore is returned by exec of RestoreContext and propagates up to
+// the frame of the restored context.
+type interpRestore struct {
+	ctx   interpContext
+	value uint64
+}
+
+func (r *interpRestore) Error() string { return "invalid context" }
+
+type interpString struct {
+	s    string
+	size int64 // Item size.
+}
+
+// interpFunc is the frame layout of a function. The frame holds the
+// arguments, results and local variables.
+type interpFunc struct {
+	align     int
+	args      []int64 // Offsets.
+	argTypes  []Type
+	counts    [][2]int64  // Profile, ip: reached or taken, not taken.
+	labels    map[int]int // Label, see verifyFunction: ip.
+	results   []int64
+	size      int64
+	typ       *FunctionType
+	variables []int64
+	varTypes  []Type
+}
+
+type interpFrame struct {
+	addr    uint64
+	allocas []uint64 // Memory allocated by Alloca.
+	chain   uint64
+	f       *FunctionDefinition
+	p       *interpFunc
+	scopes  []int // Length of allocas at BeginScope.
+	stack   interpStack
+}
+
+// NewInterpreter returns a newly created Interpreter of objects, which must
+// be the result of LinkMain or LinkLib, using memory model m. The data
+// definitions are allocated and initialized.
+func NewInterpreter(objects []Object, m MemoryModel) (*Interpreter, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	in := &Interpreter{
+		addrs:     make([]uint64, len(objects)),
+		code:      map[uint64]interpCode{},
+		funcs:     map[*FunctionDefinition]*interpFunc{},
+		next:      interpBase,
+		objects:   objects,
+		strings:   map[interpString]uint64{},
+		typeCache: TypeCache{},
+	}
+	in.dataEncoder = dataEncoder{model: m, pointer: in.pointer}
+	for i, v := range objects {
+		switch x := v.(type) {
+		case *DataDefinition:
+			t, err := in.typeCache.Type(x.TypeID)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", x.Position, err)
+			}
+
+			in.addrs[i] = in.alloc(m.Sizeof(t), m.Alignof(t), false)
+		case *FunctionDefinition:
+			in.addrs[i] = in.alloc(1, 1, false)
+			in.code[in.addrs[i]] = interpCode{index: i}
+		default:
+			return nil, fmt.Errorf("%s: unexpected object %T", v.Base().Position, x)
+		}
+	}
+	for i, v := range objects {
+		if x, ok := v.(*DataDefinition); ok && x.Value != nil {
+			t := in.typeCache.MustType(x.TypeID)
+			b, err := in.mem(in.addrs[i], m.Sizeof(t))
+			if err != nil {
+				return nil, err
+			}
+
+			if err := in.init(t, b, x.Value); err != nil {
+				return nil, fmt.Errorf("%s: invalid initializer of %s: %v", x.Position, x.NameID, err)
+			}
+		}
+	}
+	return in, nil
+}
+
+// Address returns the address of the object name. Objects with external
+// linkage are preferred.
+func (in *Interpreter) Address(name NameID) (uint64, bool) {
+	if i := in.lookup(name); i >= 0 {
+		return in.addrs[i], true
+	}
+
+	return 0, false
+}
+
+// Read returns a copy of n bytes of memory at addr.
+func (in *Interpreter) Read(addr uint64, n int64) ([]byte, error) {
+	b, err := in.mem(addr, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), b...), nil
+}
+
+// Write copies b to memory at addr.
+func (in *Interpreter) Write(addr uint64, b []byte) error {
+	m, err := in.mem(addr, int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	copy(m, b)
+	return nil
+}
+
+// CString returns the zero terminated string at addr.
+func (in *Interpreter) CString(addr uint64) (string, error) {
+	var s []byte
+	for {
+		b, err := in.mem(addr, 1)
+		if err != nil {
+			return "", err
+		}
+
+		if b[0] == 0 {
+			return string(s), nil
+		}
+
+		s = append(s, b[0])
+		addr++
+	}
+}
+
+// Call executes function name and returns its results. Arguments of integer
+// types may be passed as any Go integer type, arguments of floating point
+// types as float32 or float64 and arguments of complex types as complex64 or
+// complex128. Variadic arguments are passed to the function as they are.
+func (in *Interpreter) Call(name NameID, args ...interface{}) (_ []interface{}, err error) {
+	if !Testing {
+		defer func() {
+			switch x := recover().(type) {
+			case nil:
+				// nop
+			case error:
+				if err == nil {
+					err = x
+				}
+			default:
+				err = fmt.Errorf("ir.Interpreter PANIC: %v", x)
+			}
+		}()
+	}
+	i := in.lookup(name)
+	f, ok := in.object(i).(*FunctionDefinition)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+
+	t := in.typeCache.MustType(f.TypeID).(*FunctionType)
+	if len(args) < len(t.Arguments) || len(args) > len(t.Arguments) && !t.Variadic {
+		return nil, fmt.Errorf("%s: wrong number of arguments, have %v, expected %v", name, len(args), len(t.Arguments))
+	}
+
+	a := append([]interface{}(nil), args...)
+	for i, v := range t.Arguments {
+		if a[i], err = in.arg(v, a[i]); err != nil {
+			return nil, fmt.Errorf("%s: argument #%v: %v", name, i, err)
+		}
+	}
+	in.steps = 0
+	return in.call(i, 0, a)
+}
+
+func (in *Interpreter) lookup(name NameID) int {
+	r := -1
+	for i, v := range in.objects {
+		if b := v.Base(); b.NameID == name {
+			if b.Linkage != InternalLinkage {
+				return i
+			}
+
+			if r < 0 {
+				r = i
+			}
+		}
+	}
+	return r
+}
+
+func (in *Interpreter) object(i int) Object {
+	if i < 0 || i >= len(in.objects) {
+		return nil
+	}
+
+	return in.objects[i]
+}
+
+// arg converts the Go value v to the representation of a value of type t.
+func (in *Interpreter) arg(t Type, v interface{}) (interface{}, error) {
+	k := t.Kind()
+	switch x := v.(type) {
+	case int:
+		v = uint64(x)
+	case int8:
+		v = uint64(x)
+	case int16:
+		v = uint64(x)
+	case int32:
+		v = uint64(x)
+	case int64:
+		v = uint64(x)
+	case uint:
+		v = uint64(x)
+	case uint8:
+		v = uint64(x)
+	case uint16:
+		v = uint64(x)
+	case uint32:
+		v = uint64(x)
+	case uintptr:
+		v = uint64(x)
+	case float32:
+		v = float64(x)
+	case complex64:
+		v = complex128(x)
+	}
+	switch x := v.(type) {
+	case uint64:
+		if isIntegral(k) && k != Int128 && k != Uint128 || k == Pointer {
+			return interpInt(x, k), nil
+		}
+	case float64:
+		switch k {
+		case Float16, Float32, Float64, Float128:
+			return interpFloat(x, k), nil
+		}
+	case complex128:
+		switch k {
+		case Complex64, Complex128, Complex256:
+			return interpComplex(x, k), nil
+		}
+	case []byte:
+		if int64(len(x)) == in.model.Sizeof(t) {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot use %T as %s", v, t.ID())
+}
+
+func (in *Interpreter) alloc(n int64, align int, heap bool) uint64 {
+	a := uint64(roundup(int64(in.next), int64(mathutil.Max(align, interpGuard))))
+	in.blocks = append(in.blocks, &interpBlock{addr: a, b: make([]byte, n), heap: heap})
+	in.next = a + uint64(n) + interpGuard
+	return a
+}
+
+// freeAllocas releases the memory allocated by Alloca operations of fr
+// except the first n and closes the innermost scope, if any.
+func (in *Interpreter) freeAllocas(fr *interpFrame, n int) error {
+	for _, v := range fr.allocas[n:] {
+		if err := in.free(v, false); err != nil {
+			return err
+		}
+	}
+	fr.allocas = fr.allocas[:n]
+	if k := len(fr.scopes); k != 0 {
+		fr.scopes = fr.scopes[:k-1]
+	}
+	return nil
+}
+
+func (in *Interpreter) block(addr uint64) int {
+	i := sort.Search(len(in.blocks), func(i int) bool { return in.blocks[i].addr > addr }) - 1
+	if i < 0 || in.blocks[i].addr != addr {
+		return -1
+	}
+
+	return i
+}
+
+func (in *Interpreter) free(addr uint64, heap bool) error {
+	i := in.block(addr)
+	if i < 0 || in.blocks[i].heap != heap {
+		return fmt.Errorf("invalid free of %#x", addr)
+	}
+
+	in.blocks = append(in.blocks[:i], in.blocks[i+1:]...)
+	return nil
+}
+
+// mem returns the n bytes of memory at addr.
+func (in *Interpreter) mem(addr uint64, n int64) ([]byte, error) {
+	i := sort.Search(len(in.blocks), func(i int) bool { return in.blocks[i].addr > addr }) - 1
+	if i >= 0 && n >= 0 {
+		b := in.blocks[i]
+		if off := addr - b.addr; off+uint64(n) <= uint64(len(b.b)) {
+			return b.b[off : off+uint64(n)], nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid memory access of %v bytes at %#x", n, addr)
+}
+
+func (in *Interpreter) sizeof(t Type) int64 {
+	if t.Kind() == Function {
+		return 0
+	}
+
+	return in.model.Sizeof(t)
+}
+
+// str returns the address of the zero terminated string s with items of size
+// bytes.
+func (in *Interpreter) str(s []rune, size int64) uint64 {
+	k := interpString{string(s), size}
+	if a, ok := in.strings[k]; ok {
+		return a
+	}
+
+	a := in.alloc(size*int64(len(s)+1), int(size), false)
+	b, _ := in.mem(a, size*int64(len(s)))
+	for i, c := range s {
+		n := uint64(c)
+		for j := int64(0); j < size; j++ {
+			b[int64(i)*size+j] = byte(n)
+			n >>= 8
+		}
+	}
+	in.strings[k] = a
+	return a
+}
+
+// pointer returns the address initializer v of type t.
+func (in *Interpreter) pointer(t Type, b []byte, v Value) (uint64, error) {
+	switch x := v.(type) {
+	case *AddressValue:
+		if x.Label != 0 {
+			return 0, fmt.Errorf("label addresses are not supported")
+		}
+
+		if x.Index < 0 {
+			return 0, nil
+		}
+
+		return in.addrs[x.Index] + uint64(x.Offset), nil
+	case *DifferenceValue:
+		a, err := in.pointer(t, b, x.A)
+		if err != nil {
+			return 0, err
+		}
+
+		c, err := in.pointer(t, b, x.B)
+		if err != nil {
+			return 0, err
+		}
+
+		return a - c, nil
+	case *StringValue:
+		return in.str([]rune(string(dict.S(int(x.StringID)))), 1) + uint64(x.Offset), nil
+	case *WideStringValue:
+		return in.str(x.Value, in.sizeof(t.(*PointerType).Element)), nil
+	}
+	return 0, fmt.Errorf("cannot initialize %s using %T", t.ID(), v)
+}
+
+// constant returns the value of the constant v of type t.
+func (in *Interpreter) constant(t Type, v Value) (interface{}, error) {
+	b := make([]byte, in.sizeof(t))
+	if err := in.init(t, b, v); err != nil {
+		return nil, err
+	}
+
+	return in.load(t, b), nil
+}
+
+func (in *Interpreter) function(f *FunctionDefinition) *interpFunc {
+	if p := in.funcs[f]; p != nil {
+		return p
+	}
+
+	t := in.typeCache.MustType(f.TypeID).(*FunctionType)
+	p := &interpFunc{align: 1, labels: map[int]int{}, typ: t}
+	place := func(t Type) int64 {
+		a := mathutil.Max(1, in.model.Alignof(t))
+		if a > p.align {
+			p.align = a
+		}
+		off := roundup(p.size, int64(a))
+		p.size = off + in.model.Sizeof(t)
+		return off
+	}
+	for _, v := range t.Arguments {
+		if v.Kind() == Array {
+			v = v.(*ArrayType).Item.Pointer()
+		}
+		p.argTypes = append(p.argTypes, v)
+		p.args = append(p.args, place(v))
+	}
+	for _, v := range t.Results {
+		p.results = append(p.results, place(v))
+	}
+	for ip, op := range f.Body {
+		switch x := op.(type) {
+		case *Label:
+			n := -int(x.NameID)
+			if n == 0 {
+				n = x.Number
+			}
+			p.labels[n] = ip
+		case *VariableDeclaration:
+			t := in.typeCache.MustType(x.TypeID)
+			p.varTypes = append(p.varTypes, t)
+			p.variables = append(p.variables, place(t))
+		}
+	}
+	in.funcs[f] = p
+	return p
+}
+
+func (in *Interpreter) call(index int, chain uint64, args []interface{}) ([]interface{}, error) {
+	f, ok := in.object(index).(*FunctionDefinition)
+	if !ok {
+		return nil, fmt.Errorf("invalid function object #%v", index)
+	}
+
+	t := in.typeCache.MustType(f.TypeID).(*FunctionType)
+	if fn := in.Externals[f.NameID]; fn != nil {
+		r, err := fn(in, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.NameID, err)
+		}
+
+		if g, e := len(r), len(t.Results); g != e {
+			return nil, fmt.Errorf("%s: external function returned %v results, expected %v", f.NameID, g, e)
+		}
+
+		return r, nil
+	}
+
+	if in.depth == interpMaxDepth {
+		return nil, fmt.Errorf("%s: call stack overflow", f.NameID)
+	}
+
+	in.depth++
+
+	defer func() { in.depth-- }()
+
+	p := in.function(f)
+	fr := &interpFrame{addr: in.alloc(p.size, p.align, false), chain: chain, f: f, p: p}
+
+	defer in.free(fr.addr, false)
+
+	defer in.freeAllocas(fr, 0)
+
+	defer func() {
+		for k, v := range in.contexts {
+			if v.fr == fr {
+				delete(in.contexts, k)
+			}
+		}
+	}()
+
+	for i, off := range p.args {
+		if i < len(args) {
+			at := p.argTypes[i]
+			b, _ := in.mem(fr.addr+uint64(off), in.sizeof(at))
+			in.store(at, b, args[i])
+		}
+	}
+	if err := in.run(fr); err != nil {
+		return nil, err
+	}
+
+	r := make([]interface{}, len(p.results))
+	for i, off := range p.results {
+		rt := t.Results[i]
+		b, _ := in.mem(fr.addr+uint64(off), in.sizeof(rt))
+		r[i] = in.load(rt, b)
+	}
+	return r, nil
+}
+
+func (in *Interpreter) run(fr *interpFrame) error {
+	body := fr.f.Body
+	for ip := 0; ip < len(body); ip++ {
+		if in.Limit != 0 {
+			if in.steps == in.Limit {
+				return fmt.Errorf("%s: %s: limit of %v executed operations reached", body[ip].Pos(), fr.f.NameID, in.Limit)
+			}
+
+			in.steps++
+		}
+		next, err := in.exec(fr, ip)
+		if in.Profile && err == nil {
+			in.count(fr.p, body, ip, next)
+		}
+		if err != nil {
+			if x, ok := err.(*interpRestore); ok {
+				if x.ctx.fr != fr {
+					return err
+				}
+
+				fr.stack = append(fr.stack[:0], x.value)
+				ip = x.ctx.ip
+				continue
+			}
+
+			return fmt.Errorf("%s: %s: %v", body[ip].Pos(), fr.f.NameID, err)
+		}
+
+		if next < 0 {
+			break
+		}
+
+		ip = next
+	}
+	return nil
+}
+
+// count updates the profile of p after executing the operation at ip of body
+// and continuing after next.
+func (in *Interpreter) count(p *interpFunc, body []Operation, ip, next int) {
+	if p.counts == nil {
+		p.counts = make([][2]int64, len(body))
+	}
+	switch body[ip].(type) {
+	case *Label:
+		p.counts[ip][0]++
+	case *Jnz, *Jz:
+		switch {
+		case next == ip:
+			p.counts[ip][1]++
+		default:
+			p.counts[ip][0]++
+		}
+	}
+	if next != ip && next >= 0 {
+		if _, ok := body[next].(*Label); ok {
+			p.counts[next][0]++
+		}
+	}
+}
+
+// AttachProfile sets the Count fields of the Labels and the Taken and NotTaken
+// fields of the Jnz and Jz operations of the functions executed since Profile
+// was set to the collected execution counts.
+func (in *Interpreter) AttachProfile() {
+	for f, p := range in.funcs {
+		if p.counts == nil {
+			continue
+		}
+
+		for ip, op := range f.Body {
+			switch x := op.(type) {
+			case *Jnz:
+				x.Taken, x.NotTaken = p.counts[ip][0], p.counts[ip][1]
+			case *Jz:
+				x.Taken, x.NotTaken = p.counts[ip][0], p.counts[ip][1]
+			case *Label:
+				x.Count = p.counts[ip][0]
+			}
+		}
+	}
+}
+
+// exec executes the operation at ip of fr and returns the index of the last
+// executed operation, or a negative value on return.
+func (in *Interpreter) exec(fr *interpFrame, ip int) (int, error) {
+	s := &fr.stack
+	switch x := fr.f.Body[ip].(type) {
+	case
+		*Annotate,
+		*Arguments,
+		*Fence,
+		*Label:
+		// nop
+	case *Add:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, x.Overflow, k, a, b)
+		})
+	case *AddOv:
+		in.overflowOp(s, x.TypeID, (*big.Int).Add)
+	case *AllocResult:
+		t := in.typeCache.MustType(x.TypeID)
+		s.push(in.load(t, make([]byte, in.sizeof(t))))
+	case *Alloca:
+		n := int64(s.pop().(uint64))
+		if isSigned(in.typeCache.MustType(x.Size).Kind()) && n < 0 {
+			return ip, fmt.Errorf("invalid size %v", n)
+		}
+
+		a := in.alloc(n, 1, false)
+		fr.allocas = append(fr.allocas, a)
+		s.push(a)
+	case *And:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, 0, k, a, b)
+		})
+	case *Argument:
+		return ip, in.local(s, fr.addr+uint64(fr.p.args[x.Index]), x.Address, fr.p.argTypes[x.Index])
+	case *BeginScope:
+		fr.scopes = append(fr.scopes, len(fr.allocas))
+	case *Bool:
+		s.push(interpBool(!interpIsZero(s.pop())))
+	case *Bswap:
+		in.bitop(s, x.TypeID, func(n uint64, w int) uint64 { return bits.ReverseBytes64(n) >> uint(64-w) })
+	case *Call:
+		return ip, in.callOp(s, x.Index, 0, x.Arguments)
+	case *CallFP:
+		args := s.popN(x.Arguments)
+		fp := s.pop().(uint64)
+		c, ok := in.code[fp]
+		if !ok {
+			return ip, fmt.Errorf("invalid function pointer %#x", fp)
+		}
+
+		s.push(args...)
+		return ip, in.callOp(s, c.index, c.chain, x.Arguments)
+	case *Chain:
+		s.push(fr.chain)
+	case *Clz:
+		in.bitop(s, x.TypeID, func(n uint64, w int) uint64 { return uint64(bits.LeadingZeros64(n) - (64 - w)) })
+	case *Closure:
+		a := in.alloc(1, 1, false)
+		in.code[a] = interpCode{chain: s.pop().(uint64), index: x.Index}
+		s.push(a)
+	case *Const:
+		v, err := in.constant(in.typeCache.MustType(x.TypeID), x.Value)
+		if err != nil {
+			return ip, err
+		}
+
+		s.push(v)
+	case *Const32:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		switch k {
+		case Float16:
+			s.push(float16FromBits(uint16(x.Value)))
+		case Float32:
+			s.push(float64(math.Float32frombits(uint32(x.Value))))
+		default:
+			s.push(interpInt(uint64(x.Value), k))
+		}
+	case *Const64:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		switch k {
+		case Float64, Float128:
+			s.push(math.Float64frombits(uint64(x.Value)))
+		default:
+			s.push(interpInt(uint64(x.Value), k))
+		}
+	case *ConstC128:
+		s.push(interpComplex(x.Value, in.typeCache.MustType(x.TypeID).Kind()))
+	case *Convert:
+		from, to := in.typeCache.MustType(x.TypeID), in.typeCache.MustType(x.Result)
+		var v interface{}
+		var err error
+		switch {
+		case x.Rounding != RoundDefault || x.OutOfRange != OutOfRangeUndefined:
+			v, err = interpConvertFlags(s.pop(), from.Kind(), to.Kind(), x.Rounding, x.OutOfRange)
+		default:
+			v, err = in.convert(s.pop(), from, to)
+		}
+		if err != nil {
+			return ip, err
+		}
+
+		s.push(v)
+	case *Copy:
+		n := in.sizeof(in.typeCache.MustType(x.TypeID))
+		src, err := in.mem(s.pop().(uint64), n)
+		if err != nil {
+			return ip, err
+		}
+
+		dst, err := in.mem(s.top().(uint64), n)
+		if err != nil {
+			return ip, err
+		}
+
+		copy(dst, src)
+	case *Cpl:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		s.push(interpInt(^s.pop().(uint64), k))
+	case *Ctz:
+		in.bitop(s, x.TypeID, func(n uint64, w int) uint64 {
+			if n == 0 {
+				return uint64(w)
+			}
+
+			return uint64(bits.TrailingZeros64(n))
+		})
+	case *Div:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpDiv(x, x.Mode, k, a, b)
+		})
+	case *Drop:
+		s.pop()
+	case *Dup:
+		s.push(s.top())
+	case *Element:
+		t := in.typeCache.MustType(x.TypeID).(*PointerType).Element
+		i := int64(s.pop().(uint64))
+		if x.Neg {
+			i = -i
+		}
+		if x.outOfBounds(i) {
+			return ip, fmt.Errorf("index %v out of bounds of %v items", i, x.Items)
+		}
+
+		a := s.pop().(uint64) + uint64(i*in.sizeof(t))
+		if x.Address {
+			s.push(a)
+			break
+		}
+
+		return ip, in.local(s, a, false, t)
+	case *EndScope:
+		if n := len(fr.scopes); n != 0 {
+			return ip, in.freeAllocas(fr, fr.scopes[n-1])
+		}
+	case
+		*Eq,
+		*Geq,
+		*Gt,
+		*Leq,
+		*Lt,
+		*Neq:
+
+		b, a := s.pop(), s.pop()
+		r, err := interpCompare(x, in.opType(x), a, b)
+		if err != nil {
+			return ip, err
+		}
+
+		s.push(interpBool(r))
+	case *Field:
+		st := in.typeCache.MustType(x.TypeID).(*PointerType).Element.(*StructOrUnionType)
+		f := in.model.Layout(st)[x.Index]
+		a := s.pop().(uint64) + uint64(f.Offset)
+		if x.Address {
+			s.push(a)
+			break
+		}
+
+		b, err := in.mem(a, f.Size)
+		if err != nil {
+			return ip, err
+		}
+
+		s.push(in.field(st.Fields[x.Index], f, b))
+	case *FieldValue:
+		st := in.typeCache.MustType(x.TypeID).(*StructOrUnionType)
+		f := in.model.Layout(st)[x.Index]
+		b := s.pop().([]byte)
+		s.push(in.field(st.Fields[x.Index], f, b[f.Offset:f.Offset+f.Size]))
+	case *Free:
+		if a := s.pop().(uint64); a != 0 {
+			return ip, in.free(a, true)
+		}
+	case *Global:
+		var a uint64
+		if x.Index >= 0 {
+			a = in.addrs[x.Index] + uint64(x.Offset)
+		}
+		if _, ok := in.object(x.Index).(*FunctionDefinition); ok {
+			s.push(a)
+			break
+		}
+
+		return ip, in.local(s, a, x.Address, in.typeCache.MustType(x.TypeID))
+	case *Jmp:
+		return in.jump(fr, x.NameID, x.Number)
+	case *JmpP:
+		return ip, fmt.Errorf("computed goto is not supported")
+	case *Jnz:
+		if s.pop().(uint64) != 0 {
+			return in.jump(fr, x.NameID, x.Number)
+		}
+	case *Jz:
+		if s.pop().(uint64) == 0 {
+			return in.jump(fr, x.NameID, x.Number)
+		}
+	case *LabelAddr:
+		return ip, fmt.Errorf("label addresses are not supported")
+	case *Load:
+		t := in.typeCache.MustType(x.TypeID).(*PointerType).Element
+		return ip, in.local(s, s.pop().(uint64), false, t)
+	case *Lsh:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		n := s.pop().(uint64)
+		s.push(interpInt(s.pop().(uint64)<<uint(n), k))
+	case *Mul:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, x.Overflow, k, a, b)
+		})
+	case *MulOv:
+		in.overflowOp(s, x.TypeID, (*big.Int).Mul)
+	case *Neg:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		r, err := interpArith(&Sub{}, x.Overflow, k, interpZero(s.top()), s.pop())
+		if err != nil {
+			return ip, err
+		}
+
+		s.push(r)
+	case *New:
+		n := in.sizeof(in.typeCache.MustType(x.TypeID).(*PointerType).Element)
+		if x.Size != 0 {
+			n = int64(s.pop().(uint64))
+			if n < 0 {
+				return ip, fmt.Errorf("invalid size %v", n)
+			}
+		}
+		s.push(in.alloc(n, 1, true))
+	case *Nil:
+		s.push(uint64(0))
+	case *Not:
+		s.push(interpBool(s.pop().(uint64) == 0))
+	case *Or:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, 0, k, a, b)
+		})
+	case *Panic:
+		return ip, fmt.Errorf("panic")
+	case *Popcnt:
+		in.bitop(s, x.TypeID, func(n uint64, w int) uint64 { return uint64(bits.OnesCount64(n)) })
+	case *PostIncrement:
+		return ip, in.increment(s, x.TypeID, x.BitFieldType, x.BitOffset, x.Bits, x.Delta, true)
+	case *PreIncrement:
+		return ip, in.increment(s, x.TypeID, x.BitFieldType, x.BitOffset, x.Bits, x.Delta, false)
+	case *PtrDiff:
+		sz := in.sizeof(in.typeCache.MustType(x.PtrType).(*PointerType).Element)
+		if sz == 0 {
+			sz = 1
+		}
+		b, a := s.pop().(uint64), s.pop().(uint64)
+		s.push(interpInt(uint64((int64(a)-int64(b))/sz), in.typeCache.MustType(x.TypeID).Kind()))
+	case *Rem:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpDiv(x, x.Mode, k, a, b)
+		})
+	case *RestoreContext:
+		v := s.pop().(uint64)
+		ctx, ok := in.contexts[s.pop().(uint64)]
+		if !ok {
+			return ip, fmt.Errorf("invalid context")
+		}
+
+		if v == 0 {
+			v = 1
+		}
+		return ip, &interpRestore{ctx, v}
+	case *Result:
+		return ip, in.local(s, fr.addr+uint64(fr.p.results[x.Index]), x.Address, fr.p.typ.Results[x.Index])
+	case *Return:
+		return -1, nil
+	case *Rsh:
+		k := in.typeCache.MustType(x.TypeID).Kind()
+		n := s.pop().(uint64)
+		a := s.pop().(uint64)
+		switch {
+		case isSigned(k):
+			s.push(interpInt(uint64(int64(a)>>uint(n)), k))
+		default:
+			s.push(interpInt(a>>uint(n), k))
+		}
+	case *SaveContext:
+		if in.contexts == nil {
+			in.contexts = map[uint64]interpContext{}
+		}
+		in.contexts[s.pop().(uint64)] = interpContext{fr, ip}
+		s.push(uint64(0))
+	case *Select:
+		c := s.pop().(uint64)
+		b, a := s.pop(), s.pop()
+		if c == 0 {
+			a = b
+		}
+		s.push(a)
+	case *Store:
+		t := in.typeCache.MustType(x.TypeID)
+		v := s.pop()
+		b, err := in.mem(s.pop().(uint64), in.sizeof(t))
+		if err != nil {
+			return ip, err
+		}
+
+		switch {
+		case x.Bits != 0:
+			in.store(t, b, interpSetBits(in.load(t, b).(uint64), v.(uint64), x.BitOffset, x.Bits))
+		default:
+			in.store(t, b, v)
+		}
+		s.push(v)
+	case *StringConst:
+		t := in.typeCache.MustType(x.TypeID).(*PointerType).Element
+		s.push(in.str([]rune(x.Value.String()), mathutil.MaxInt64(1, in.sizeof(t))))
+	case *Sub:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, x.Overflow, k, a, b)
+		})
+	case *SubOv:
+		in.overflowOp(s, x.TypeID, (*big.Int).Sub)
+	case *Switch:
+		t := in.typeCache.MustType(x.TypeID)
+		v := s.pop()
+		for i, c := range x.Values {
+			w, err := in.constant(t, c)
+			if err != nil {
+				return ip, err
+			}
+
+			if w == v {
+				return in.jump(fr, x.Labels[i].NameID, x.Labels[i].Number)
+			}
+		}
+		return in.jump(fr, x.Default.NameID, x.Default.Number)
+	case *Variable:
+		return ip, in.local(s, fr.addr+uint64(fr.p.variables[x.Index]), x.Address, fr.p.varTypes[x.Index])
+	case *VariableDeclaration:
+		t := fr.p.varTypes[x.Index]
+		b, _ := in.mem(fr.addr+uint64(fr.p.variables[x.Index]), in.sizeof(t))
+		if x.Value != nil {
+			for i := range b {
+				b[i] = 0
+			}
+			return ip, in.init(t, b, x.Value)
+		}
+	case *Xor:
+		return ip, in.binop(s, x.TypeID, func(k TypeKind, a, b interface{}) (interface{}, error) {
+			return interpArith(x, 0, k, a, b)
+		})
+	case *Zero:
+		b, err := in.mem(s.top().(uint64), in.sizeof(in.typeCache.MustType(x.TypeID)))
+		if err != nil {
+			return ip, err
+		}
+
+		for i := range b {
+			b[i] = 0
+		}
+	default:
+		return ip, fmt.Errorf("unsupported operation %T", x)
+	}
+	return ip, nil
+}
+
+func (in *Interpreter) opType(op Operation) TypeKind {
+	var t TypeID
+	switch x := op.(type) {
+	case *Eq:
+		t = x.TypeID
+	case *Geq:
+		t = x.TypeID
+	case *Gt:
+		t = x.TypeID
+	case *Leq:
+		t = x.TypeID
+	case *Lt:
+		t = x.TypeID
+	case *Neq:
+		t = x.TypeID
+	}
+	return in.typeCache.MustType(t).Kind()
+}
+
+func (in *Interpreter) jump(fr *interpFrame, nm NameID, number int) (int, error) {
+	n := -int(nm)
+	if n == 0 {
+		n = number
+	}
+	ip, ok := fr.p.labels[n]
+	if !ok {
+		return 0, fmt.Errorf("undefined label")
+	}
+
+	return ip, nil
+}
+
+// local pushes the object of type t at address a, or a itself.
+func (in *Interpreter) local(s *interpStack, a uint64, address bool, t Type) error {
+	if address {
+		s.push(a)
+		return nil
+	}
+
+	b, err := in.mem(a, in.sizeof(t))
+	if err != nil {
+		return err
+	}
+
+	s.push(in.load(t, b))
+	return nil
+}
+
+func (in *Interpreter) field(t Type, f FieldProperties, b []byte) interface{} {
+	v := in.load(t, b)
+	if f.Bits != 0 {
+		v = interpBits(v.(uint64), f.BitOffset, f.Bits, t.Kind())
+	}
+	return v
+}
+
+func (in *Interpreter) callOp(s *interpStack, index int, chain uint64, n int) error {
+	r, err := in.call(index, chain, s.popN(n))
+	if err != nil {
+		return err
+	}
+
+	items := (*s)[len(*s)-len(r):]
+	for i, v := range r {
+		// A pointer to the space for an aggregate result, see Call.
+		if a, ok := items[i].(uint64); ok {
+			if b, ok := v.([]byte); ok {
+				dst, err := in.mem(a, int64(len(b)))
+				if err != nil {
+					return err
+				}
+
+				copy(dst, b)
+				continue
+			}
+		}
+
+		items[i] = v
+	}
+	return nil
+}
+
+func (in *Interpreter) increment(s *interpStack, t, bt TypeID, off, bits, delta int, post bool) error {
+	tt := in.typeCache.MustType(t)
+	b, err := in.mem(s.pop().(uint64), in.sizeof(tt))
+	if err != nil {
+		return err
+	}
+
+	k := tt.Kind()
+	old := in.load(tt, b)
+	var v, r interface{}
+	switch x := old.(type) {
+	case uint64:
+		if bits != 0 {
+			bk := in.typeCache.MustType(bt).Kind()
+			o := interpBits(x, off, bits, bk)
+			n := interpBits(o+uint64(delta), 0, bits, bk)
+			in.store(tt, b, interpSetBits(x, n, off, bits))
+			switch {
+			case post:
+				s.push(o)
+			default:
+				s.push(n)
+			}
+			return nil
+		}
+
+		v = interpInt(x+uint64(delta), k)
+	case float64:
+		v = interpFloat(x+float64(delta), k)
+	case complex128:
+		v = interpComplex(x+complex(float64(delta), 0), k)
+	default:
+		return fmt.Errorf("invalid operand type %s", t)
+	}
+	in.store(tt, b, v)
+	r = v
+	if post {
+		r = old
+	}
+	s.push(r)
+	return nil
+}
+
+// binop replaces the top two stack items with the result of f, element-wise
+// for vectors.
+// overflowOp performs the operation e of AddOv, MulOv or SubOv.
+func (in *Interpreter) overflowOp(s *interpStack, t TypeID, e func(*big.Int, *big.Int, *big.Int) *big.Int) {
+	k := in.typeCache.MustType(t).Kind()
+	b, a := s.pop().(uint64), s.pop().(uint64)
+	x, y := new(big.Int).SetUint64(a), new(big.Int).SetUint64(b)
+	if isSigned(k) {
+		x.SetInt64(int64(a))
+		y.SetInt64(int64(b))
+	}
+	r := e(new(big.Int), x, y)
+	ov := interpTrap(r, k)
+	n := foldWrap(r, k).Uint64()
+	if isSigned(k) {
+		n = uint64(r.Int64())
+	}
+	s.push(interpInt(n, k), interpBool(ov))
+}
+
+// bitop replaces TOS of integer type t by f(n, w), where n is TOS truncated to
+// w, the bit width of t.
+func (in *Interpreter) bitop(s *interpStack, t TypeID, f func(n uint64, w int) uint64) {
+	typ := in.typeCache.MustType(t)
+	w := 8 * int(in.model.Sizeof(typ))
+	n := s.pop().(uint64)
+	if w < 64 {
+		n &= 1<<uint(w) - 1
+	}
+	s.push(interpInt(f(n, w), typ.Kind()))
+}
+
+func (in *Interpreter) binop(s *interpStack, t TypeID, f func(k TypeKind, a, b interface{}) (interface{}, error)) error {
+	b, a := s.pop(), s.pop()
+	v, ok := in.typeCache.MustType(t).(*VectorType)
+	if !ok {
+		r, err := f(in.typeCache.MustType(t).Kind(), a, b)
+		if err != nil {
+			return err
+		}
+
+		s.push(r)
+		return nil
+	}
+
+	sz := in.model.Sizeof(v.Item)
+	x, y := a.([]byte), b.([]byte)
+	r := make([]byte, len(x))
+	for i := int64(0); i < v.Items; i++ {
+		p, q := x[i*sz:(i+1)*sz], y[i*sz:(i+1)*sz]
+		c, err := f(v.Item.Kind(), in.load(v.Item, p), in.load(v.Item, q))
+		if err != nil {
+			return err
+		}
+
+		in.store(v.Item, r[i*sz:(i+1)*sz], c)
+	}
+	s.push(r)
+	return nil
+}
+
+func (in *Interpreter) convert(v interface{}, from, to Type) (interface{}, error) {
+	if x, ok := v.([]byte); ok {
+		if int64(len(x)) != in.sizeof(to) {
+			return nil, fmt.Errorf("cannot convert %s to %s", from.ID(), to.ID())
+		}
+
+		return x, nil
+	}
+
+	return interpConvert(v, from.Kind(), to.Kind())
+}
+
+type interpStack []interface{}
+
+func (s *interpStack) push(v ...interface{}) { *s = append(*s, v...) }
+
+func (s *interpStack) pop() interface{} {
+	n := len(*s) - 1
+	v := (*s)[n]
+	*s = (*s)[:n]
+	return v
+}
+
+func (s *interpStack) popN(n int) []interface{} {
+	m := len(*s) - n
+	v := append([]interface{}(nil), (*s)[m:]...)
+	*s = (*s)[:m]
+	return v
+}
+
+func (s interpStack) top() interface{} { return s[len(s)-1] }
+
+// interpInt returns n truncated to the integer type kind k, sign extended if
+// k is signed.
+func interpInt(n uint64, k TypeKind) uint64 {
+	switch k {
+	case Int8:
+		return uint64(int8(n))
+	case Int16:
+		return uint64(int16(n))
+	case Int32:
+		return uint64(int32(n))
+	case Uint8:
+		return uint64(uint8(n))
+	case Uint16:
+		return uint64(uint16(n))
+	case Uint32:
+		return uint64(uint32(n))
+	}
+	return n
+}
+
+// interpConvertFlags converts the scalar v of type kind from to type kind to
+// using rounding mode r and out of range semantics o.
+func interpConvertFlags(v interface{}, from, to TypeKind, r Rounding, o OutOfRange) (interface{}, error) {
+	switch x := v.(type) {
+	case uint64:
+		f := new(big.Float).SetPrec(53)
+		if to == Float32 {
+			f.SetPrec(24)
+		}
+		switch r {
+		case RoundNearestEven:
+			f.SetMode(big.ToNearestEven)
+		case RoundTowardZero:
+			f.SetMode(big.ToZero)
+		case RoundUp:
+			f.SetMode(big.ToPositiveInf)
+		case RoundDown:
+			f.SetMode(big.ToNegativeInf)
+		}
+		switch {
+		case isSigned(from):
+			f.SetInt64(int64(x))
+		default:
+			f.SetUint64(x)
+		}
+		g, _ := f.Float64()
+		return interpConvert(g, Float64, to)
+	case float64:
+		switch r {
+		case RoundNearestEven:
+			x = math.RoundToEven(x)
+		case RoundUp:
+			x = math.Ceil(x)
+		case RoundDown:
+			x = math.Floor(x)
+		default:
+			x = math.Trunc(x)
+		}
+		if o == OutOfRangeUndefined {
+			return interpConvert(x, Float64, to)
+		}
+
+		if !math.IsNaN(x) && !math.IsInf(x, 0) {
+			n, _ := big.NewFloat(x).Int(nil)
+			if foldWrap(new(big.Int).Set(n), to).Cmp(n) == 0 {
+				return interpConvert(x, Float64, to)
+			}
+		}
+
+		if o == OutOfRangeTrap {
+			return nil, fmt.Errorf("floating point value out of range")
+		}
+
+		min, max := interpLimits(to)
+		switch {
+		case math.IsNaN(x):
+			return uint64(0), nil
+		case x < 0:
+			return min, nil
+		default:
+			return max, nil
+		}
+	}
+	return interpConvert(v, from, to)
+}
+
+// interpLimits returns the minimum and maximum values of the integer type
+// kind k.
+func interpLimits(k TypeKind) (min, max uint64) {
+	bits := uint(64)
+	switch k {
+	case Int8, Uint8:
+		bits = 8
+	case Int16, Uint16:
+		bits = 16
+	case Int32, Uint32:
+		bits = 32
+	}
+	if isSigned(k) {
+		return interpInt(1<<(bits-1), k), 1<<(bits-1) - 1
+	}
+
+	return 0, math.MaxUint64 >> (64 - bits)
+}
+
+func interpFloat(f float64, k TypeKind) float64 {
+	switch k {
+	case Float16:
+		return float16FromBits(float16Bits(f))
+	case Float32:
+		return float64(float32(f))
+	}
+
+	return f
+}
+
+func interpComplex(c complex128, k TypeKind) complex128 {
+	if k == Complex64 {
+		return complex128(complex64(c))
+	}
+
+	return c
+}
+
+func interpBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func interpIsZero(v interface{}) bool {
+	switch x := v.(type) {
+	case uint64:
+		return x == 0
+	case float64:
+		return x == 0
+	case complex128:
+		return x == 0
+	}
+	panic(fmt.Errorf("invalid operand %T", v))
+}
+
+func interpZero(v interface{}) interface{} {
+	switch v.(type) {
+	case uint64:
+		return uint64(0)
+	case float64:
+		return float64(0)
+	case complex128:
+		return complex128(0)
+	}
+	panic(fmt.Errorf("invalid operand %T", v))
+}
+
+// interpBits returns the bit field of width bits at bit offset off of n, sign
+// extended if k is signed.
+func interpBits(n uint64, off, bits int, k TypeKind) uint64 {
+	n = n >> uint(off) & (uint64(1)<<uint(bits) - 1)
+	if isSigned(k) && n&(1<<uint(bits-1)) != 0 {
+		n |= ^uint64(0) << uint(bits)
+	}
+	return n
+}
+
+// interpSetBits returns n with the bit field of width bits at bit offset off
+// set to v.
+func interpSetBits(n, v uint64, off, bits int) uint64 {
+	m := (uint64(1)<<uint(bits) - 1) << uint(off)
+	return n&^m | v<<uint(off)&m
+}
+
+// interpTrap reports whether the exact result r of a signed integer
+// operation does not fit type kind k.
+func interpTrap(r *big.Int, k TypeKind) bool {
+	return foldWrap(new(big.Int).Set(r), k).Cmp(r) != 0
+}
+
+func interpArith(op Operation, o Overflow, k TypeKind, a, b interface{}) (interface{}, error) {
+	switch x := a.(type) {
+	case uint64:
+		y := b.(uint64)
+		var r uint64
+		var e func(*big.Int, *big.Int, *big.Int) *big.Int
+		switch op.(type) {
+		case *Add:
+			r, e = x+y, (*big.Int).Add
+		case *And:
+			r = x & y
+		case *Mul:
+			r, e = x*y, (*big.Int).Mul
+		case *Or:
+			r = x | y
+		case *Sub:
+			r, e = x-y, (*big.Int).Sub
+		case *Xor:
+			r = x ^ y
+		}
+		if o == OverflowTrap && isSigned(k) && e != nil && interpTrap(e(new(big.Int), big.NewInt(int64(x)), big.NewInt(int64(y))), k) {
+			return nil, fmt.Errorf("integer overflow")
+		}
+
+		return interpInt(r, k), nil
+	case float64:
+		y := b.(float64)
+		switch op.(type) {
+		case *Add:
+			return interpFloat(x+y, k), nil
+		case *Mul:
+			return interpFloat(x*y, k), nil
+		case *Sub:
+			return interpFloat(x-y, k), nil
+		}
+	case complex128:
+		y := b.(complex128)
+		switch op.(type) {
+		case *Add:
+			return interpComplex(x+y, k), nil
+		case *Mul:
+			return interpComplex(x*y, k), nil
+		case *Sub:
+			return interpComplex(x-y, k), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operands %T", a)
+}
+
+func interpDiv(op Operation, m DivMode, k TypeKind, a, b interface{}) (interface{}, error) {
+	var exact, nonZero, noOverflow bool
+	switch x := op.(type) {
+	case *Div:
+		exact, nonZero, noOverflow = x.Exact, x.NonZero, x.NoOverflow
+	case *Rem:
+		nonZero, noOverflow = x.NonZero, x.NoOverflow
+	}
+	_, rem := op.(*Rem)
+	switch x := a.(type) {
+	case uint64:
+		y := b.(uint64)
+		if y == 0 {
+			if nonZero {
+				return nil, fmt.Errorf("division by zero asserted non zero")
+			}
+
+			if m == DivZero {
+				return uint64(0), nil
+			}
+
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		if !isSigned(k) {
+			if exact && x%y != 0 {
+				return nil, fmt.Errorf("division asserted exact has a remainder")
+			}
+
+			if rem {
+				return x % y, nil
+			}
+
+			return x / y, nil
+		}
+
+		sx, sy := int64(x), int64(y)
+		if exact && sx%sy != 0 {
+			return nil, fmt.Errorf("division asserted exact has a remainder")
+		}
+
+		if interpTrap(new(big.Int).Quo(big.NewInt(sx), big.NewInt(sy)), k) {
+			if noOverflow {
+				return nil, fmt.Errorf("division overflow asserted not to overflow")
+			}
+
+			if m == DivZero {
+				return uint64(0), nil
+			}
+
+			return nil, fmt.Errorf("division overflow")
+		}
+
+		if rem {
+			return interpInt(uint64(sx%sy), k), nil
+		}
+
+		return interpInt(uint64(sx/sy), k), nil
+	case float64:
+		y := b.(float64)
+		if rem {
+			return interpFloat(math.Mod(x, y), k), nil
+		}
+
+		return interpFloat(x/y, k), nil
+	case complex128:
+		if !rem {
+			return interpComplex(x/b.(complex128), k), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operands %T", a)
+}
+
+func interpCompare(op Operation, k TypeKind, a, b interface{}) (bool, error) {
+	var eq, lt, unordered bool
+	switch x := a.(type) {
+	case uint64:
+		y := b.(uint64)
+		eq = x == y
+		switch {
+		case isSigned(k):
+			lt = int64(x) < int64(y)
+		default:
+			lt = x < y
+		}
+	case float64:
+		y := b.(float64)
+		eq, lt, unordered = x == y, x < y, math.IsNaN(x) || math.IsNaN(y)
+	case complex128:
+		eq = x == b.(complex128)
+		switch op.(type) {
+		case *Eq, *Neq:
+			// ok
+		default:
+			return false, fmt.Errorf("invalid operands %T", a)
+		}
+	default:
+		return false, fmt.Errorf("invalid operands %T", a)
+	}
+
+	switch op.(type) {
+	case *Eq:
+		return eq, nil
+	case *Geq:
+		return !lt && !unordered, nil
+	case *Gt:
+		return !lt && !eq && !unordered, nil
+	case *Leq:
+		return lt || eq, nil
+	case *Lt:
+		return lt, nil
+	default:
+		return !eq, nil
+	}
+}
+
+// interpConvert converts the scalar v of type kind from to type kind to.
+func interpConvert(v interface{}, from, to TypeKind) (interface{}, error) {
+	switch x := v.(type) {
+	case uint64:
+		switch to {
+		case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64, Pointer:
+			return interpInt(x, to), nil
+		}
+
+		f := float64(x)
+		if isSigned(from) {
+			f = float64(int64(x))
+		}
+		return interpConvert(f, Float64, to)
+	case float64:
+		switch to {
+		case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64, Pointer:
+			if isSigned(to) || x < 0 {
+				return interpInt(uint64(int64(x)), to), nil
+			}
+
+			return interpInt(uint64(x), to), nil
+		case Float16, Float32, Float64, Float128:
+			return interpFloat(x, to), nil
+		case Complex64, Complex128, Complex256:
+			return interpComplex(complex(x, 0), to), nil
+		}
+	case complex128:
+		switch to {
+		case Complex64, Complex128, Complex256:
+			return interpComplex(x, to), nil
+		}
+
+		return interpConvert(real(x), Float64, to)
+	}
+	return nil, fmt.Errorf("cannot convert %s to %s", from, to)
+}
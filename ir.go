@@ -9,9 +9,9 @@
 //
 // Concepts
 //
-// From the POV of this package, an IR is a slice of Objects. Object is either
-// a DataDefinition or a FunctionDefinition. All objects are defined by
-// Linkage, NameID and TypeID fields.
+// From the POV of this package, an IR is a slice of Objects. Object is a
+// DataDefinition, a FunctionDefinition or an AliasDefinition. All objects are
+// defined by Linkage, NameID and TypeID fields.
 //
 // DataDefintions reserve global, static data storage and have an optional
 // Value. If Value is nil the DataDefintion defines a zero value of its type.
@@ -36,21 +36,32 @@
 // probably just to verify a particular IR generator or to provide an
 // interpreter for scripts loaded/entered at run time. A "standard" back-end
 // should normally produce machine code,
+//
+// Interpreter executes linked IR directly, for example to test a front end or
+// to evaluate static initializers at compile time.
 package ir
 
 import (
 	"fmt"
 	"go/token"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cznic/internal/buffer"
+	"github.com/cznic/mathutil"
 )
 
 var (
+	_ Object = (*AliasDefinition)(nil)
 	_ Object = (*DataDefinition)(nil)
 	_ Object = (*FunctionDefinition)(nil)
 
 	// Testing amends things for tests.
 	Testing bool
+
+	verifiers = sync.Pool{New: func() interface{} { return NewVerifier() }}
 )
 
 // NameID is a numeric identifier of an identifier as registered in a global
@@ -64,14 +75,13 @@ func (t NameID) String() string { return string(dict.S(int(t))) }
 
 // GobDecode implements GobDecoder.
 func (t *NameID) GobDecode(b []byte) error {
-	*t = NameID(dict.ID(b))
-	return nil
+	id, err := gobDecodeID(b)
+	*t = NameID(id)
+	return err
 }
 
 // GobEncode implements GobEncoder.
-func (t NameID) GobEncode() ([]byte, error) {
-	return append([]byte(nil), dict.S(int(t))...), nil
-}
+func (t NameID) GobEncode() ([]byte, error) { return gobEncodeID(int(t)), nil }
 
 // StringID is a numeric identifier of a string literal as registered in a
 // global dictionary[0].
@@ -84,30 +94,43 @@ func (t StringID) String() string { return string(dict.S(int(t))) }
 
 // GobDecode implements GobDecoder.
 func (t *StringID) GobDecode(b []byte) error {
-	*t = StringID(dict.ID(b))
-	return nil
+	id, err := gobDecodeID(b)
+	*t = StringID(id)
+	return err
 }
 
 // GobEncode implements GobEncoder.
-func (t StringID) GobEncode() ([]byte, error) {
-	return append([]byte(nil), dict.S(int(t))...), nil
-}
+func (t StringID) GobEncode() ([]byte, error) { return gobEncodeID(int(t)), nil }
 
 // Object represents a declarations or definitions of static data and functions.
 type Object interface {
 	// Verify checks if the object is well-formed. Verify may mutate the
 	// object. For example, Verify may remove provably unreachable code of
-	// a FunctionDefinition.Body.
+	// a FunctionDefinition.Body. Use FunctionDefinition.Validate to check
+	// a function without mutating it.
 	Verify() error
 	Base() *ObjectBase
 }
 
+// Annotation is a note a front end attaches to an object or, using Annotate,
+// to an operation, for example a #pragma, a provenance note or a TODO marker.
+// Annotations are preserved by serialization and have no semantics of their
+// own.
+type Annotation struct {
+	Key   NameID // Like "pragma".
+	Value string // Like "omp parallel".
+}
+
+func (a Annotation) String() string { return fmt.Sprintf("%v %q", a.Key, a.Value) }
+
 // ObjectBase collects fields common to all objects.
 type ObjectBase struct {
-	Comment NameID
+	Annotations []Annotation
+	Comment     NameID
 	Linkage
 	NameID   NameID
 	Package  NameID
+	TLS      bool // Thread local storage, for example C _Thread_local. Valid only for data.
 	TypeID   TypeID
 	TypeName NameID
 	token.Position
@@ -126,10 +149,53 @@ func newObjectBase(p token.Position, nm, tnm NameID, typ TypeID, l Linkage) Obje
 // Base implements Object.
 func (o *ObjectBase) Base() *ObjectBase { return o }
 
+// AliasDefinition binds its NameID to another symbol, Target, for example to
+// represent C __attribute__((alias)) or versioned library symbols. References
+// to an alias refer to Target at Offset bytes from its start.
+//
+// Target is resolved first among the objects with internal linkage of the
+// translation unit of the alias, then among the objects with external
+// linkage. Target may be another alias, the linker resolves such chains and
+// sets Index and Offset to those of the final target.
+type AliasDefinition struct {
+	Index int // A negative value or the object index of Target as resolved by the linker.
+	ObjectBase
+	Offset uintptr
+	Target NameID
+}
+
+// NewAliasDefinition returns a newly created AliasDefinition.
+func NewAliasDefinition(p token.Position, name, typeName NameID, typ TypeID, l Linkage, target NameID, offset uintptr) *AliasDefinition {
+	return &AliasDefinition{
+		Index:      -1,
+		ObjectBase: newObjectBase(p, name, typeName, typ, l),
+		Offset:     offset,
+		Target:     target,
+	}
+}
+
+// Verify implements Object.
+func (a *AliasDefinition) Verify() error {
+	if a.TypeID == 0 {
+		return fmt.Errorf("%s: missing type", a.Position)
+	}
+
+	if a.Target == 0 {
+		return fmt.Errorf("%s: missing alias target", a.Position)
+	}
+
+	if a.Linkage < ExternalLinkage || a.Linkage > WeakLinkage {
+		return fmt.Errorf("%s: invalid linkage %v", a.Position, a.Linkage)
+	}
+
+	return nil
+}
+
 // DataDefinition represents a variable definition and an optional initializer
 // value.
 type DataDefinition struct {
 	ObjectBase
+	ReadOnly bool // The data is never modified, like a C string literal. See MergeConstants.
 	Value
 }
 
@@ -141,15 +207,79 @@ func NewDataDefinition(p token.Position, name, typeName NameID, typ TypeID, l Li
 	}
 }
 
-// Verify implements Object.
-func (d *DataDefinition) Verify() error { return nil }
+// Verify implements Object. Verify checks that d.Value, if any, is
+// compatible with d.TypeID.
+func (d *DataDefinition) Verify() (err error) {
+	if d.Value == nil {
+		return nil
+	}
+
+	v := verifiers.Get().(*Verifier)
+	err = v.verifyData(d)
+	verifiers.Put(v)
+	return err
+}
+
+// Attributes is a set of function attributes, like those of the GCC
+// __attribute__ specifier.
+type Attributes int
+
+// Attributes values.
+const (
+	AttrAlwaysInline Attributes = 1 << iota // Inline the function regardless of the cost.
+	AttrConst                               // The result depends only on the arguments, the function does not access memory.
+	AttrInline                              // Inlining the function is desirable.
+	AttrNaked                               // No prologue and epilogue. The function cannot declare variables.
+	AttrNoreturn                            // The function never returns. Its body contains no Return operation.
+	AttrPure                                // The function has no side effects.
+
+	attrAll = 1<<iota - 1
+)
+
+var attrNames = []string{"always_inline", "const", "inline", "naked", "noreturn", "pure"}
+
+// String implements fmt.Stringer. The attributes are listed as in the GCC
+// __attribute__ specifier, for example "noreturn,pure".
+func (a Attributes) String() string {
+	var s []string
+	for i, v := range attrNames {
+		if a&(1<<uint(i)) != 0 {
+			s = append(s, v)
+		}
+	}
+	if a&^attrAll != 0 {
+		s = append(s, fmt.Sprintf("%#x", int(a&^attrAll)))
+	}
+	return strings.Join(s, ",")
+}
+
+func (a Attributes) verify() error {
+	switch {
+	case a&^attrAll != 0:
+		return fmt.Errorf("invalid function attributes %#x", int(a))
+	case a&AttrNoreturn != 0 && a&(AttrConst|AttrPure) != 0:
+		return fmt.Errorf("noreturn function cannot be %s", a&(AttrConst|AttrPure))
+	}
+	return nil
+}
 
 // FunctionDefinition represents a function definition.
+//
+// A nested function has a non zero StaticChain. Its Body can access the static
+// chain using the Chain operation. Nested functions are called using a function
+// pointer produced by the Closure operation.
+//
+// The body of a function having the AttrNoreturn attribute ends with a Panic
+// or RestoreContext operation instead of a Return operation.
 type FunctionDefinition struct {
-	Arguments []NameID // May be nil.
-	Body      []Operation
+	Arguments  []NameID // May be nil.
+	Attributes Attributes
+	Body       []Operation
+	Files      []string          // File table of Positions. May be nil.
+	Positions  []CompactPosition // Compressed positions of Body, see CompressPositions. May be nil.
 	ObjectBase
-	Results []NameID // May be nil.
+	Results     []NameID // May be nil.
+	StaticChain TypeID   // Pointer type of the static chain of a nested function or zero.
 }
 
 // NewFunctionDefinition returns a newly created FunctionDefinition.
@@ -161,40 +291,461 @@ func NewFunctionDefinition(p token.Position, name, typeName NameID, typ TypeID,
 	}
 }
 
-// Verify implements Object.
+// Verify implements Object. Verify expands the positions of f, see
+// CompressPositions, before editing its body.
 func (f *FunctionDefinition) Verify() (err error) {
+	v := verifiers.Get().(*Verifier)
+	err = v.Verify(f)
+	v.Reset()
+	verifiers.Put(v)
+	return err
+}
+
+// Validate is like Verify but it never mutates f. Problems reported by Verify
+// are reported by Validate as well, additionally opts, if not nil, may turn
+// some warnings into errors.
+func (f *FunctionDefinition) Validate(opts *VerifyOptions) (err error) {
+	v := verifiers.Get().(*Verifier)
+	err = v.Validate(f, opts)
+	v.Reset()
+	verifiers.Put(v)
+	return err
+}
+
+// VerifyOptions amend the checks performed by Validate.
+type VerifyOptions struct {
+	OutOfBounds       bool // Report constant indices out of the bounds of BoundsChecked Elements as errors.
+	UnreachableLabels bool // Report labels not reachable by any control flow path as errors.
+	UnusedVariables   bool // Report declared but never referenced variables as errors.
+}
+
+// VerifyError describes an ill-formed operation of a function body. It is
+// returned by Verify together with some context to help debugging the
+// producer of the IR.
+type VerifyError struct {
+	Function NameID
+	IP       int // Index of Op in the function body.
+	Msg      string
+	Op       Operation
+	Stack    []TypeID // Evaluation stack before executing Op, if known.
+	Window   []string // String forms of the operations around Op.
+	WindowIP int      // Index of the operation Window[0] in the function body.
+}
+
+// Error implements error.
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s\n%s:%#x: %v", e.Msg, e.Function, e.IP, e.Op)
+}
+
+// Context returns e.Window, one operation per line prefixed by its index in
+// the function body, with the failing operation marked by "=>", followed by
+// the evaluation stack, if known.
+func (e *VerifyError) Context() string {
+	var buf buffer.Bytes
+	for i, v := range e.Window {
+		ip := e.WindowIP + i
+		mark := "  "
+		if ip == e.IP {
+			mark = "=>"
+		}
+		fmt.Fprintf(&buf, "%s %#05x%s\n", mark, ip, v)
+	}
+	if e.Stack != nil {
+		a := make([]string, len(e.Stack))
+		for i, v := range e.Stack {
+			a[i] = v.Abbrev()
+		}
+		fmt.Fprintf(&buf, "stack: [%s]\n", strings.Join(a, " "))
+	}
+	s := string(buf.Bytes())
+	buf.Close()
+	return s
+}
+
+// Verifier verifies FunctionDefinitions. Verifying many functions using the
+// same Verifier avoids reallocating its internal state, including its
+// TypeCache, for every function. A Verifier is not safe for concurrent use by
+// multiple goroutines.
+type Verifier struct {
+	// Types resolves the TypeIDs of the verified functions. NewVerifier
+	// sets it to a new TypeCache.
+	Types Types
+	// Window is the number of operations before and after the failing
+	// one included in a VerifyError. NewVerifier sets it to 3.
+	Window int
+	verifier
+}
+
+// NewVerifier returns a newly created Verifier.
+func NewVerifier() *Verifier {
+	tc := TypeCache{}
+	return &Verifier{
+		Types:  tc,
+		Window: 3,
+		verifier: verifier{
+			labels:    map[int]int{},
+			phi:       map[int][]TypeID{},
+			typeCache: tc,
+		},
+	}
+}
+
+// Reset discards the per function state of v while keeping the allocated
+// memory for reuse. Verify resets v automatically, calling Reset explicitly
+// is needed only to release the reference to the last verified function.
+func (v *Verifier) Reset() {
+	v.blockLevel = 0
+	v.blockValueLevel = 0
+	v.function = nil
+	v.ip = 0
+	v.options = nil
+	for k := range v.labels {
+		delete(v.labels, k)
+	}
+	for k := range v.phi {
+		delete(v.phi, k)
+	}
+	v.phiArena = v.phiArena[:0]
+	v.stack = nil
+	v.variables = v.variables[:0]
+}
+
+// Verify checks if f is well-formed. Verify may mutate f, see
+// FunctionDefinition.Verify. Problems with a particular operation of f.Body
+// are reported as a *VerifyError.
+func (v *Verifier) Verify(f *FunctionDefinition) error {
+	v.Reset()
+	v.Types = newTypes(v.Types)
+	v.typeCache = v.Types
+	v.window = v.Window
+	return v.verifyFunction(f)
+}
+
+// Validate is like Verify but it never mutates f, see
+// FunctionDefinition.Validate.
+func (v *Verifier) Validate(f *FunctionDefinition, opts *VerifyOptions) error {
+	v.Reset()
+	v.Types = newTypes(v.Types)
+	v.typeCache = v.Types
+	v.window = v.Window
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	v.options = opts
+	return v.verifyFunction(f)
+}
+
+// VerifyAll verifies objs using up to parallelism concurrently running
+// goroutines, each having its own Verifier sharing a SyncTypeCache with the
+// others. Non positive parallelism means
+// runtime.GOMAXPROCS(0). VerifyAll returns nil if all objects are well formed.
+// Otherwise the result has the same length as objs and its items are the
+// errors returned by verifying the respective objects.
+func VerifyAll(objs []Object, parallelism int) []error {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > len(objs) {
+		parallelism = len(objs)
+	}
+	var (
+		failed int32
+		next   int64 = -1
+		r            = make([]error, len(objs))
+		types  SyncTypeCache
+		wg     sync.WaitGroup
+	)
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+
+			v := NewVerifier()
+			v.Types = &types
+			v.typeCache = v.Types // Used by verifyData.
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(objs) {
+					return
+				}
+
+				var err error
+				switch x := objs[i].(type) {
+				case *FunctionDefinition:
+					err = v.Verify(x)
+				case *DataDefinition:
+					if x.Value != nil {
+						err = v.verifyData(x)
+					}
+				default:
+					err = x.Verify()
+				}
+				if err != nil {
+					r[i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if failed == 0 {
+		return nil
+	}
+
+	return r
+}
+
+func (ver *verifier) verifyData(d *DataDefinition) error {
+	if d.TypeID == 0 {
+		return fmt.Errorf("%s: missing type", d.Position)
+	}
+
+	t, err := ver.typeCache.Type(d.TypeID)
+	if err != nil {
+		return fmt.Errorf("%s: %v", d.Position, err)
+	}
+
+	if !IsComplete(t) {
+		return fmt.Errorf("%s: %s has incomplete type %s", d.Position, d.NameID, d.TypeID)
+	}
+
+	if err := ver.verifyValue(t, d.Value); err != nil {
+		return fmt.Errorf("%s: invalid initializer of %s: %v", d.Position, d.NameID, err)
+	}
+
+	return nil
+}
+
+// VerifyValue checks that v is a valid initializer of an object of type t
+// using tc, which may be nil. Besides the compatibility of the values with
+// the types they initialize, the indices of designated values are checked to
+// be in the bounds of the arrays and in the range of the fields of the
+// structs and unions they initialize. DataDefinition.Verify, the verification
+// of VariableDeclarations and the linker check initializers this way.
+func VerifyValue(v Value, t TypeID, tc Types) error {
+	tc = newTypes(tc)
+	typ, err := tc.Type(t)
+	if err != nil {
+		return err
+	}
+
+	ver := &verifier{typeCache: tc}
+	return ver.verifyValue(typ, v)
+}
+
+// verifyValue checks that v is a valid initializer of a variable of type t.
+func (ver *verifier) verifyValue(t Type, v Value) error {
+	k := t.Kind()
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case *AddressValue:
+		if k == Pointer || isIntegral(k) {
+			return nil
+		}
+	case *Complex64Value, *Complex128Value:
+		switch k {
+		case Complex64, Complex128, Complex256:
+			return nil
+		}
+	case *CompositeValue:
+		return ver.verifyComposite(t, x)
+	case *DesignatedValue:
+		return fmt.Errorf("designated value outside of a composite value")
+	case *DifferenceValue:
+		if x.A == nil || x.B == nil {
+			return fmt.Errorf("missing address of a difference value")
+		}
+
+		if isIntegral(k) {
+			return nil
+		}
+	case *Float128Value, *Float32Value, *Float64Value:
+		if f, ok := x.(*Float128Value); ok && f.Value == nil {
+			return fmt.Errorf("missing float128 value")
+		}
+
+		switch k {
+		case Float16, Float32, Float64, Float128:
+			return nil
+		}
+	case *Int128Value:
+		switch {
+		case x.Value == nil:
+			return fmt.Errorf("missing int128 value")
+		case x.Value.Cmp(int128Min) < 0 || x.Value.Cmp(uint128Limit) >= 0:
+			return fmt.Errorf("int128 value %v out of range", x.Value)
+		}
+
+		switch k {
+		case Int128, Uint128:
+			return nil
+		}
+	case *Int32Value, *Int64Value, *Uint32Value, *Uint64Value:
+		if k == Pointer || isIntegral(k) {
+			return nil
+		}
+	case *StringValue:
+		switch k {
+		case Pointer:
+			return nil
+		case Array:
+			a := t.(*ArrayType)
+			switch a.Item.Kind() {
+			case Int8, Uint8:
+				if n := int64(len(dict.S(int(x.StringID)))) - int64(x.Offset); n > a.Items {
+					return fmt.Errorf("string of length %v does not fit %s", n, t.ID())
+				}
+
+				return nil
+			}
+		}
+	case *WideStringValue:
+		switch k {
+		case Pointer:
+			return nil
+		case Array:
+			a := t.(*ArrayType)
+			switch a.Item.Kind() {
+			case Int16, Int32, Uint16, Uint32:
+				if n := int64(len(x.Value)); n > a.Items {
+					return fmt.Errorf("wide string of length %v does not fit %s", n, t.ID())
+				}
+
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected value %T", x)
+	}
+
+	return fmt.Errorf("cannot initialize %s using %T", t.ID(), v)
+}
+
+func (ver *verifier) verifyComposite(t Type, v *CompositeValue) error {
+	switch x := t.(type) {
+	case *ArrayType:
+		var index int64
+		for _, v := range v.Values {
+			if d, ok := v.(*DesignatedValue); ok {
+				index = int64(d.Index)
+				v = d.Value
+			}
+			if index < 0 || index >= x.Items {
+				return fmt.Errorf("index %v out of bounds of %s", index, t.ID())
+			}
+
+			if err := ver.verifyValue(x.Item, v); err != nil {
+				return fmt.Errorf("[%v]: %v", index, err)
+			}
+
+			index++
+		}
+		return nil
+	case *StructOrUnionType:
+		if x.Kind() == Union && len(v.Values) > 1 {
+			return fmt.Errorf("too many values for %s", t.ID())
+		}
+
+		var index int
+		for _, v := range v.Values {
+			if d, ok := v.(*DesignatedValue); ok {
+				index = d.Index
+				v = d.Value
+			}
+			if index < 0 || index >= len(x.Fields) {
+				return fmt.Errorf("field index %v out of range of %s", index, t.ID())
+			}
+
+			if err := ver.verifyValue(x.Fields[index], v); err != nil {
+				return fmt.Errorf("field #%v: %v", index, err)
+			}
+
+			index++
+		}
+		return nil
+	}
+
+	// Braces around a scalar initializer.
+	switch len(v.Values) {
+	case 0:
+		return nil
+	case 1:
+		return ver.verifyValue(t, v.Values[0])
+	}
+
+	return fmt.Errorf("too many values for %s", t.ID())
+}
+
+func (ver *verifier) verifyFunction(f *FunctionDefinition) error {
+	if err := f.Attributes.verify(); err != nil {
+		return err
+	}
+
+	noreturn := f.Attributes&AttrNoreturn != 0
 	switch len(f.Body) {
 	case 0:
 		return fmt.Errorf("function body cannot be empty")
 	case 1:
 		switch f.Body[0].(type) {
-		case *Return, *Panic:
+		case *Return:
+			if noreturn {
+				return fmt.Errorf("return in noreturn function")
+			}
+
+			return nil
+		case *Panic:
 			return nil
 		}
 
 		return fmt.Errorf("invalid operation")
 	}
 
-	unconvert(&f.Body)
-	ver := &verifier{
-		function:  f,
-		labels:    map[int]int{},
-		typeCache: TypeCache{},
+	if f.TLS {
+		return fmt.Errorf("function cannot have thread local storage")
 	}
+
+	if f.StaticChain != 0 && ver.typeCache.MustType(f.StaticChain).Kind() != Pointer {
+		return fmt.Errorf("static chain must be a pointer type, have %s", f.StaticChain)
+	}
+
+	if ver.options == nil {
+		f.ExpandPositions() // The body is edited below.
+		unconvert(&f.Body)
+	}
+	ver.function = f
 	var op Operation
 	for ver.ip, op = range f.Body {
 		switch x := op.(type) {
+		case *Alloca:
+			switch {
+			case f.Attributes&AttrNaked != 0:
+				return ver.errorAt(f, ver.ip, nil, "alloca in naked function")
+			case ver.blockLevel == 0:
+				return ver.errorAt(f, ver.ip, nil, "alloca outside of a scope")
+			}
 		case *BeginScope:
 			ver.blockLevel++
 		case *EndScope:
 			if ver.blockLevel == 0 {
-				return fmt.Errorf("unbalanced end scope\n%s:%#x: %v", f.NameID, ver.ip, op)
+				return ver.errorAt(f, ver.ip, nil, "unbalanced end scope")
 			}
 
 			ver.blockLevel--
 			if ver.blockLevel == 0 {
+				if noreturn {
+					switch f.Body[ver.ip-1].(type) {
+					case *Panic, *RestoreContext:
+						// ok
+					default:
+						return ver.errorAt(f, ver.ip, nil, "missing panic before end of noreturn function")
+					}
+
+					break
+				}
+
 				if _, ok := f.Body[ver.ip-1].(*Return); !ok {
-					return fmt.Errorf("missing return before end of function\n%s:%#x: %v", f.NameID, ver.ip, op)
+					return ver.errorAt(f, ver.ip, nil, "missing return before end of function")
 				}
 			}
 
@@ -204,15 +755,27 @@ func (f *FunctionDefinition) Verify() (err error) {
 				n = x.Number
 			}
 			if _, ok := ver.labels[n]; ok {
-				return fmt.Errorf("label redefined\n%s:%#x: %v", f.NameID, ver.ip, op)
+				return ver.errorAt(f, ver.ip, nil, "label redefined")
 			}
 
 			ver.labels[n] = ver.ip
+		case *Return:
+			if noreturn {
+				return ver.errorAt(f, ver.ip, nil, "return in noreturn function")
+			}
 		case *VariableDeclaration:
+			if f.Attributes&AttrNaked != 0 {
+				return ver.errorAt(f, ver.ip, nil, "variable declaration in naked function")
+			}
+
 			if g, e := x.Index, len(ver.variables); g != e {
 				return fmt.Errorf("invalid variable declaration operation index, got %v, expected %v", g, e)
 			}
 
+			if t, err := ver.typeCache.Type(x.TypeID); err == nil && !IsComplete(t) {
+				return ver.errorAt(f, ver.ip, nil, fmt.Sprintf("variable of incomplete type %s", x.TypeID))
+			}
+
 			ver.variables = append(ver.variables, x.TypeID)
 		}
 	}
@@ -222,6 +785,7 @@ func (f *FunctionDefinition) Verify() (err error) {
 	}
 
 	computedGotos := false
+	var addressed []int // ip of labels which address is taken.
 	for ip, op := range f.Body {
 		var nm NameID
 		var num int
@@ -235,6 +799,14 @@ func (f *FunctionDefinition) Verify() (err error) {
 		case *JmpP:
 			computedGotos = true
 			continue
+		case *LabelAddr:
+			l, ok := ver.labels[labelKey(x.NameID, x.Number)]
+			if !ok {
+				return ver.errorAt(f, ip, nil, "undefined label")
+			}
+
+			addressed = append(addressed, l)
+			continue
 		case *Switch:
 			for _, v := range x.Labels {
 				nm, num = v.NameID, v.Number
@@ -243,7 +815,7 @@ func (f *FunctionDefinition) Verify() (err error) {
 					n = num
 				}
 				if _, ok := ver.labels[n]; !ok {
-					return fmt.Errorf("undefined branch target\n%s:%#x: %v", f.NameID, ip, op)
+					return ver.errorAt(f, ip, nil, "undefined branch target")
 				}
 			}
 			continue
@@ -256,31 +828,32 @@ func (f *FunctionDefinition) Verify() (err error) {
 			n = num
 		}
 		if _, ok := ver.labels[n]; !ok {
-			return fmt.Errorf("undefined branch target\n%s:%#x: %v", f.NameID, ip, op)
+			return ver.errorAt(f, ip, nil, "undefined branch target")
 		}
 	}
 
-	p := buffer.CGet(len(f.Body))
-	ipFlags := *p
-
-	defer buffer.Put(p)
-
-	phi := map[int][]TypeID{}
+	if n := len(f.Body); cap(ver.ipFlags) < n {
+		ver.ipFlags = make([]byte, n)
+	}
+	ipFlags := ver.ipFlags[:len(f.Body)]
+	for i := range ipFlags {
+		ipFlags[i] = 0
+	}
+	phi := ver.phi
 	var g func(int, []TypeID) error
 	g = func(ip int, stack []TypeID) error {
 		for {
 			//fmt.Printf("# %#05x %v ; %v\n", ip, stack, f.Body[ip].Pos())
-			op := f.Body[ip]
 			if ipFlags[ip] != 0 {
 				switch ex, ok := phi[ip]; {
 				case ok:
 					if g, e := len(stack), len(ex); g != e {
-						return fmt.Errorf("evaluation stacks depth differs %v %v\n%s:%#x: %v", stack, ex, f.NameID, ip, op)
+						return ver.errorAt(f, ip, stack, fmt.Sprintf("evaluation stacks depth differs %v %v", stack, ex))
 					}
 
 					for i, v := range stack {
 						if g, e := v, ex[i]; g != e {
-							return fmt.Errorf("evaluation stacks differ %v %v\n%s:%#x: %v", stack, ex, f.NameID, ip, v)
+							return ver.errorAt(f, ip, stack, fmt.Sprintf("evaluation stacks differ %v %v", stack, ex))
 						}
 					}
 
@@ -295,10 +868,13 @@ func (f *FunctionDefinition) Verify() (err error) {
 			ver.ip = ip
 			ver.stack = stack
 			if err := f.Body[ip].verify(ver); err != nil {
-				return fmt.Errorf("%s\n%s:%#x: %v", err, f.NameID, ip, op)
+				return ver.errorAt(f, ip, stack, err.Error())
 			}
 
 			stack = ver.stack
+			if ver.stackHook != nil {
+				ver.stackHook(stack)
+			}
 		outer:
 			switch x := f.Body[ip].(type) {
 			case *Jmp:
@@ -314,9 +890,12 @@ func (f *FunctionDefinition) Verify() (err error) {
 					if n == 0 {
 						n = v.Number
 					}
-					if err := g(ver.labels[n], append([]TypeID(nil), stack...)); err != nil {
+					s := ver.copyStack(stack)
+					if err := g(ver.labels[n], s); err != nil {
 						return err
 					}
+
+					ver.freeStack(s)
 				}
 				n := -int(x.Default.NameID)
 				if n == 0 {
@@ -333,7 +912,9 @@ func (f *FunctionDefinition) Verify() (err error) {
 					switch {
 					case y.Value != 0: // Always taken.
 						ipFlags[ip-1] = 0
-						f.Body[ip] = &Jmp{NameID: x.NameID, Number: x.Number, Position: x.Position}
+						if ver.options == nil {
+							f.Body[ip] = &Jmp{NameID: x.NameID, Number: x.Number, Position: x.Position}
+						}
 						ip = ver.labels[n]
 						continue
 					default: // Never taken.
@@ -343,9 +924,12 @@ func (f *FunctionDefinition) Verify() (err error) {
 					}
 				}
 
-				if err := g(ver.labels[n], append([]TypeID(nil), stack...)); err != nil {
+				s := ver.copyStack(stack)
+				if err := g(ver.labels[n], s); err != nil {
 					return err
 				}
+
+				ver.freeStack(s)
 			case *Jz:
 				n := -int(x.NameID)
 				if n == 0 {
@@ -355,7 +939,9 @@ func (f *FunctionDefinition) Verify() (err error) {
 					switch {
 					case y.Value == 0: // Always taken.
 						ipFlags[ip-1] = 0
-						f.Body[ip] = &Jmp{NameID: x.NameID, Number: x.Number, Position: x.Position}
+						if ver.options == nil {
+							f.Body[ip] = &Jmp{NameID: x.NameID, Number: x.Number, Position: x.Position}
+						}
 						ip = ver.labels[n]
 						continue
 					default: // Never taken.
@@ -365,18 +951,23 @@ func (f *FunctionDefinition) Verify() (err error) {
 					}
 				}
 
-				if err := g(ver.labels[n], append([]TypeID(nil), stack...)); err != nil {
+				s := ver.copyStack(stack)
+				if err := g(ver.labels[n], s); err != nil {
 					return err
 				}
+
+				ver.freeStack(s)
 			case *Label:
-				phi[ip] = append([]TypeID(nil), stack...)
-			case *Return, *Panic:
+				n := len(ver.phiArena)
+				ver.phiArena = append(ver.phiArena, stack...)
+				phi[ip] = ver.phiArena[n:len(ver.phiArena):len(ver.phiArena)]
+			case *Return, *Panic, *RestoreContext:
 				return nil
 			}
 			ip++
 		}
 	}
-	if err := g(0, nil); err != nil {
+	if err := g(0, ver.copyStack(nil)); err != nil {
 		return err
 	}
 
@@ -392,6 +983,18 @@ func (f *FunctionDefinition) Verify() (err error) {
 		}
 	}
 
+	// Any label which address is taken is a potential target of a JmpP
+	// and must not be removed as unreachable.
+	for _, v := range addressed {
+		if err := g(v, phi[v]); err != nil {
+			return err
+		}
+	}
+
+	if o := ver.options; o != nil {
+		return ver.warnings(f, o)
+	}
+
 	w := 0
 	for ip, op := range f.Body {
 		switch op.(type) {
@@ -409,15 +1012,127 @@ func (f *FunctionDefinition) Verify() (err error) {
 	return nil
 }
 
+// warnings reports the problems selected by o as errors.
+func (ver *verifier) warnings(f *FunctionDefinition, o *VerifyOptions) error {
+	if o.OutOfBounds {
+		for ip, op := range f.Body {
+			x, ok := op.(*Element)
+			if !ok || ip == 0 || ver.ipFlags[ip] == 0 {
+				continue
+			}
+
+			var i int64
+			switch y := f.Body[ip-1].(type) {
+			case *Const32:
+				i = int64(y.Value)
+				if !isSigned(ver.typeCache.MustType(y.TypeID).Kind()) {
+					i = int64(uint32(y.Value))
+				}
+			case *Const64:
+				if i = y.Value; i < 0 && !isSigned(ver.typeCache.MustType(y.TypeID).Kind()) {
+					continue
+				}
+			default:
+				continue
+			}
+			if x.Neg {
+				i = -i
+			}
+			if x.outOfBounds(i) {
+				return ver.errorAt(f, ip, nil, fmt.Sprintf("index %v out of bounds of %v items", i, x.Items))
+			}
+		}
+	}
+	if o.UnreachableLabels {
+		for ip, op := range f.Body {
+			if _, ok := op.(*Label); ok && ver.ipFlags[ip] == 0 {
+				return ver.errorAt(f, ip, nil, "unreachable label")
+			}
+		}
+	}
+	if o.UnusedVariables {
+		used := make([]bool, len(ver.variables))
+		for _, op := range f.Body {
+			if x, ok := op.(*Variable); ok && x.Index >= 0 && x.Index < len(used) {
+				used[x.Index] = true
+			}
+		}
+		for ip, op := range f.Body {
+			if x, ok := op.(*VariableDeclaration); ok && !used[x.Index] {
+				return ver.errorAt(f, ip, nil, "variable declared and not used")
+			}
+		}
+	}
+	return nil
+}
+
+// errorAt returns a VerifyError of the operation at ip of f.
+func (ver *verifier) errorAt(f *FunctionDefinition, ip int, stack []TypeID, msg string) *VerifyError {
+	e := &VerifyError{Function: f.NameID, IP: ip, Msg: msg, Op: f.Body[ip]}
+	if stack != nil {
+		e.Stack = append([]TypeID{}, stack...)
+	}
+	lo, hi := mathutil.Max(0, ip-ver.window), mathutil.Min(len(f.Body), ip+ver.window+1)
+	e.WindowIP = lo
+	for _, op := range f.Body[lo:hi] {
+		e.Window = append(e.Window, fmt.Sprint(op))
+	}
+	return e
+}
+
+// stackReserve is the extra capacity of evaluation stacks allocated by the
+// verifier.
+const stackReserve = 16
+
 type verifier struct {
 	blockLevel      int
 	blockValueLevel int
+	free            [][]TypeID // Stacks available for reuse.
 	function        *FunctionDefinition
 	ip              int
-	labels          map[int]int // nm (<0) or num (>=0): ip
+	ipFlags         []byte
+	labels          map[int]int      // nm (<0) or num (>=0): ip
+	options         *VerifyOptions   // Non nil when validating.
+	phi             map[int][]TypeID // ip: stack at label
+	phiArena        []TypeID
+	pointers        map[TypeID]Type // element: pointer to element
 	stack           []TypeID
-	typeCache       TypeCache
+	stackHook       func([]TypeID) // Called with the evaluation stack after every operation if not nil.
+	typeCache       Types
 	variables       []TypeID
+	window          int // Verifier.Window.
+}
+
+// copyStack returns a copy of s, reusing a previously freed stack, if
+// available.
+func (v *verifier) copyStack(s []TypeID) []TypeID {
+	var r []TypeID
+	switch n := len(v.free); {
+	case n != 0:
+		r = v.free[n-1][:0]
+		v.free = v.free[:n-1]
+	default:
+		r = make([]TypeID, 0, len(s)+stackReserve)
+	}
+	return append(r, s...)
+}
+
+// freeStack makes s available for reuse by copyStack.
+func (v *verifier) freeStack(s []TypeID) { v.free = append(v.free, s) }
+
+// pointer returns t.Pointer() avoiding repeated allocations for the same t.
+func (v *verifier) pointer(t Type) Type {
+	id := t.ID()
+	if p := v.pointers[id]; p != nil {
+		return p
+	}
+
+	if v.pointers == nil {
+		v.pointers = map[TypeID]Type{}
+	}
+	p := t.Pointer()
+	v.pointers[id] = p
+	return p
 }
 
 func (v *verifier) binop(t TypeID) error {
@@ -431,10 +1146,128 @@ func (v *verifier) binop(t TypeID) error {
 		return fmt.Errorf("mismatched operand types: %s and %s", a, b)
 	}
 
+	if v.typeCache.MustType(a).Kind() == Vector {
+		return fmt.Errorf("operation does not support vector type %s", a)
+	}
+
 	v.stack = append(v.stack[:n-2], a)
 	return nil
 }
 
+// results verifies the types of the evaluation stack items reserved for the
+// results of a call of a function of type t. A struct, union or array result
+// item may be a pointer to the result type instead, see Call.
+func (v *verifier) results(t *FunctionType, items []TypeID) error {
+	for i, r := range t.Results {
+		g, e := items[i], r.ID()
+		if g == e {
+			continue
+		}
+
+		switch r.Kind() {
+		case Array, Struct, Union:
+			if g == v.pointer(r).ID() {
+				continue
+			}
+		}
+
+		return fmt.Errorf("mismatched result #%v, got %s, expected %s", i, g, e)
+	}
+	return nil
+}
+
+// varArgs verifies the types va of the variadic arguments of a call of a
+// function of type t with arguments of types args.
+func (v *verifier) varArgs(t *FunctionType, args, va []TypeID) error {
+	if va == nil {
+		return nil
+	}
+
+	if !t.Variadic {
+		return fmt.Errorf("variadic argument types passed to non variadic function type %s", t.ID())
+	}
+
+	if g, e := len(va), len(args)-len(t.Arguments); g != e {
+		return fmt.Errorf("expected %v variadic argument types, have %v", e, g)
+	}
+
+	for i, e := range va {
+		if g := args[len(t.Arguments)+i]; g != e {
+			return fmt.Errorf("invalid variadic argument #%v type, got %s, expected %s", i, g, e)
+		}
+
+		switch v.typeCache.MustType(e).Kind() {
+		case Int8, Int16, Uint8, Uint16, Float16, Float32:
+			return fmt.Errorf("variadic argument #%v type %s is not promoted", i, e)
+		}
+	}
+	return nil
+}
+
+// overflowOp is like binop but t must be an integral type and an int32
+// overflow flag is pushed after the result.
+func (v *verifier) overflowOp(t TypeID) error {
+	if !isIntegral(v.typeCache.MustType(t).Kind()) {
+		return fmt.Errorf("expected integral type, have %s", t)
+	}
+
+	if err := v.binop(t); err != nil {
+		return err
+	}
+
+	if a := v.stack[len(v.stack)-1]; a != t {
+		return fmt.Errorf("mismatched types %s and %s", a, t)
+	}
+
+	v.stack = append(v.stack, idInt32)
+	return nil
+}
+
+// bitop verifies an integer unary operation of type t, the result type is t.
+func (v *verifier) bitop(t TypeID) error {
+	if !isIntegral(v.typeCache.MustType(t).Kind()) {
+		return fmt.Errorf("expected integral type, have %s", t)
+	}
+
+	n := len(v.stack)
+	if n == 0 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if a := v.stack[n-1]; a != t {
+		return fmt.Errorf("mismatched types %s and %s", a, t)
+	}
+
+	return nil
+}
+
+// elementwise is like binop but t may be also a vector type, the operation is
+// then performed on the respective items of the operands. Vector items must
+// be integers if integer is true.
+func (v *verifier) elementwise(t TypeID, integer bool) error {
+	n := len(v.stack)
+	if n < 2 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	a, b := v.stack[n-2], v.stack[n-1]
+	x, ok := v.typeCache.MustType(a).(*VectorType)
+	if !ok {
+		return v.binop(t)
+	}
+
+	if a != b {
+		return fmt.Errorf("mismatched operand types: %s and %s", a, b)
+	}
+
+	if integer && !isIntegral(x.Item.Kind()) {
+		return fmt.Errorf("expected a vector of integers, have %s", a)
+	}
+
+	v.stack = v.stack[:n-1]
+	return nil
+}
+
 func (v *verifier) unop(int bool) error {
 	n := len(v.stack)
 	if n == 0 {
@@ -448,11 +1281,13 @@ func (v *verifier) unop(int bool) error {
 		Int16,
 		Int32,
 		Int64,
+		Int128,
 
 		Uint8,
 		Uint16,
 		Uint32,
-		Uint64:
+		Uint64,
+		Uint128:
 
 		// ok
 	case
@@ -470,15 +1305,104 @@ func (v *verifier) unop(int bool) error {
 	return nil
 }
 
+func (v *verifier) divMode(m DivMode, t TypeID) error {
+	switch m {
+	case DivDefault:
+		return nil
+	case DivPanic, DivTrap, DivZero:
+		if isIntegral(v.typeCache.MustType(t).Kind()) {
+			return nil
+		}
+
+		return fmt.Errorf("division mode %v requires an integer type, have %s", m, t)
+	default:
+		return fmt.Errorf("invalid division mode %v", m)
+	}
+}
+
+func (v *verifier) convertFlags(from, to TypeID, r Rounding, o OutOfRange) error {
+	if r < RoundDefault || r > RoundDown {
+		return fmt.Errorf("invalid rounding mode %v", r)
+	}
+
+	if o < OutOfRangeUndefined || o > OutOfRangeTrap {
+		return fmt.Errorf("invalid out of range semantics %v", o)
+	}
+
+	f := v.typeCache.MustType(from).Kind()
+	t := v.typeCache.MustType(to).Kind()
+	switch {
+	case (f == Float16) != (t == Float16) && f != Float32 && t != Float32:
+		return fmt.Errorf("float16 converts only to and from float32, have %s to %s", from, to)
+	case r != RoundDefault && !(isFloating(f) && isIntegral(t) || isIntegral(f) && isFloating(t)):
+		return fmt.Errorf("rounding mode %v requires a conversion between a floating point and an integer type, have %s to %s", r, from, to)
+	case o != OutOfRangeUndefined && !(isFloating(f) && isIntegral(t)):
+		return fmt.Errorf("out of range semantics %v require a conversion of a floating point type to an integer type, have %s to %s", o, from, to)
+	}
+	return nil
+}
+
+func (v *verifier) divFlags(t TypeID, exact, nonZero, noOverflow bool) error {
+	k := v.typeCache.MustType(t).Kind()
+	switch {
+	case (exact || nonZero) && !isIntegral(k):
+		return fmt.Errorf("division flags require an integer type, have %s", t)
+	case noOverflow && !isSigned(k):
+		return fmt.Errorf("no overflow division flag requires a signed integer type, have %s", t)
+	}
+	return nil
+}
+
+func (v *verifier) overflow(o Overflow, t TypeID) error {
+	switch o {
+	case OverflowUndefined:
+		return nil
+	case OverflowWrap, OverflowTrap:
+		u := v.typeCache.MustType(t)
+		if x, ok := u.(*VectorType); ok {
+			u = x.Item
+		}
+		switch u.Kind() {
+		case Int8, Int16, Int32, Int64, Int128:
+			return nil
+		}
+
+		return fmt.Errorf("overflow semantics %v require a signed integer type, have %s", o, t)
+	default:
+		return fmt.Errorf("invalid overflow semantics %v", o)
+	}
+}
+
 func (v *verifier) relop(t TypeID) error {
 	if err := v.binop(0); err != nil {
 		return err
 	}
 
+	if g, e := v.stack[len(v.stack)-1], t; g != e {
+		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
+	}
+
 	v.stack[len(v.stack)-1] = idInt32
 	return nil
 }
 
+// ordered verifies an ordered comparison of operands of type t which
+// signedness is asserted by *signed. An unset *signed is derived from t when
+// not validating.
+func (v *verifier) ordered(t TypeID, signed *bool) error {
+	if err := v.relop(t); err != nil {
+		return err
+	}
+
+	switch s := isSigned(v.typeCache.MustType(t).Kind()); {
+	case *signed && !s:
+		return fmt.Errorf("signed comparison of type %s", t)
+	case s && v.options == nil:
+		*signed = true
+	}
+	return nil
+}
+
 func (v *verifier) branch() error {
 	n := len(v.stack)
 	if n < 1 {
@@ -0,0 +1,86 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package irfuzz
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cznic/ir"
+	"github.com/cznic/xc"
+)
+
+func TestGenerator(t *testing.T) {
+	m, err := ir.NewMemoryModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := ir.NameID(xc.Dict.SID("main"))
+	for seed := int64(0); seed < 200; seed++ {
+		f, err := NewGenerator(seed).Function(name)
+		if err != nil {
+			t.Fatal(seed, err)
+		}
+
+		in, err := ir.NewInterpreter([]ir.Object{f}, m)
+		if err != nil {
+			t.Fatal(seed, err)
+		}
+
+		in.Limit = 1e6
+		if _, err := in.Call(name); err != nil {
+			t.Fatal(seed, err)
+		}
+
+		var buf bytes.Buffer
+		if err := ir.WriteAssembly(&buf, []ir.Object{f}); err != nil {
+			t.Fatal(seed, err)
+		}
+
+		if Verify(buf.Bytes()) != 1 {
+			t.Fatalf("%v\n%s", seed, buf.Bytes())
+		}
+	}
+}
+
+func FuzzType(f *testing.F) {
+	for _, v := range []string{"int32", "*int8", "[3]uint16", "struct{a int32:3,b int8}", "union{a int8}", "func(int32,...)*int8", "<4>float32"} {
+		f.Add([]byte(v))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) { Type(data) })
+}
+
+func FuzzReadFrom(f *testing.F) {
+	g, err := NewGenerator(1).Function(ir.NameID(xc.Dict.SID("main")))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := (ir.Objects{{g}}).WriteTo(&buf); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) { ReadFrom(data) })
+}
+
+func FuzzVerify(f *testing.F) {
+	for seed := int64(0); seed < 4; seed++ {
+		g, err := NewGenerator(seed).Function(ir.NameID(xc.Dict.SID("main")))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := ir.WriteAssembly(&buf, []ir.Object{g}); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) { Verify(data) })
+}
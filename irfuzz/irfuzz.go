@@ -0,0 +1,275 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package irfuzz generates random IR and provides fuzzing entry points of the
+// ir package.
+//
+// The Type, ReadFrom and Verify functions have the signature required by
+// go-fuzz[0], for example
+//
+//	$ go-fuzz-build -func ReadFrom github.com/cznic/ir/irfuzz
+//
+// The tests of this package wrap them for the native fuzzing of go test.
+//
+//  [0]: https://github.com/dvyukov/go-fuzz
+package irfuzz
+
+import (
+	"bytes"
+	"math/rand"
+
+	"github.com/cznic/ir"
+	"github.com/cznic/xc"
+)
+
+var (
+	idInt32     = ir.TypeID(xc.Dict.SID("int32"))
+	idFuncInt32 = ir.TypeID(xc.Dict.SID("func()int32"))
+)
+
+// Generator produces random, but valid, function definitions. Generated
+// functions take no arguments and return an int32. They compute using local
+// variables, nested scopes, conditionals and loops with a bounded number of
+// iterations, so executing them terminates and does not panic.
+type Generator struct {
+	MaxDepth      int // Maximum nesting of expressions and statements.
+	MaxStatements int // Maximum number of statements of a block.
+
+	b     *ir.FunctionBuilder
+	depth int
+	live  []int // Indices of the variables in scope.
+	loops []int // Indices of the loop counters in scope, not assignable.
+	rnd   *rand.Rand
+}
+
+// NewGenerator returns a newly created Generator using seed.
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		MaxDepth:      4,
+		MaxStatements: 6,
+		rnd:           rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Function returns a newly generated function definition of name with
+// external linkage. The error, if any, is the result of the verification of
+// the function, a non nil error is a bug of the Generator or of the verifier.
+func (g *Generator) Function(name ir.NameID) (*ir.FunctionDefinition, error) {
+	g.b = ir.NewFunctionBuilder(&ir.FunctionDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: name, TypeID: idFuncInt32},
+	})
+	g.depth = 0
+	g.live = g.live[:0]
+	g.loops = g.loops[:0]
+	g.declarations()
+	g.block()
+	g.b.Result(0, true)
+	g.expr(0)
+	g.b.Store(idInt32)
+	g.b.Drop(idInt32)
+	return g.b.Finish()
+}
+
+func (g *Generator) declarations() {
+	for n := 1 + g.rnd.Intn(3); n > 0; n-- {
+		var v ir.Value
+		if g.rnd.Intn(2) == 0 {
+			v = &ir.Int32Value{Value: g.constant()}
+		}
+		g.live = append(g.live, g.b.Declare(idInt32, 0, v))
+	}
+}
+
+func (g *Generator) constant() int32 {
+	switch g.rnd.Intn(4) {
+	case 0:
+		return int32(g.rnd.Uint32())
+	default:
+		return int32(g.rnd.Intn(21) - 10)
+	}
+}
+
+func (g *Generator) block() {
+	for n := g.rnd.Intn(g.MaxStatements + 1); n > 0; n-- {
+		g.statement()
+	}
+}
+
+func (g *Generator) assignable() (int, bool) {
+	var a []int
+next:
+	for _, v := range g.live {
+		for _, w := range g.loops {
+			if v == w {
+				continue next
+			}
+		}
+		a = append(a, v)
+	}
+	if len(a) == 0 {
+		return 0, false
+	}
+
+	return a[g.rnd.Intn(len(a))], true
+}
+
+func (g *Generator) statement() {
+	k := 0
+	if g.depth < g.MaxDepth {
+		k = g.rnd.Intn(5)
+	}
+	g.depth++
+
+	defer func() { g.depth-- }()
+
+	switch k {
+	case 0, 1: // Assignment.
+		v, ok := g.assignable()
+		if !ok {
+			return
+		}
+
+		g.b.Variable(v, true)
+		g.expr(0)
+		g.b.Store(idInt32)
+		g.b.Drop(idInt32)
+	case 2: // Conditional, with an optional else branch.
+		g.expr(0)
+		els := g.b.NewLabel()
+		g.b.JzTo(els)
+		g.block()
+		if g.rnd.Intn(2) == 0 {
+			g.b.Label(els)
+			return
+		}
+
+		end := g.b.NewLabel()
+		g.b.JmpTo(end)
+		g.b.Label(els)
+		g.block()
+		g.b.Label(end)
+	case 3: // Nested scope.
+		n := len(g.live)
+		g.b.BeginScope()
+		g.declarations()
+		g.block()
+		g.b.EndScope()
+		g.live = g.live[:n]
+	case 4: // Loop with a bounded number of iterations.
+		n, m := len(g.live), len(g.loops)
+		g.b.BeginScope()
+		c := g.b.Declare(idInt32, 0, &ir.Int32Value{Value: int32(g.rnd.Intn(4))})
+		g.live = append(g.live, c)
+		g.loops = append(g.loops, c)
+		top := g.b.NewLabel()
+		end := g.b.NewLabel()
+		g.b.Label(top)
+		g.b.Variable(c, false)
+		g.b.JzTo(end)
+		g.block()
+		g.b.Variable(c, true)
+		g.b.Variable(c, false)
+		g.b.Const32(idInt32, 1)
+		g.b.Sub(idInt32)
+		g.b.Store(idInt32)
+		g.b.Drop(idInt32)
+		g.b.JmpTo(top)
+		g.b.Label(end)
+		g.b.EndScope()
+		g.live = g.live[:n]
+		g.loops = g.loops[:m]
+	}
+}
+
+func (g *Generator) expr(depth int) {
+	k := g.rnd.Intn(2)
+	if depth < g.MaxDepth {
+		k = g.rnd.Intn(16)
+	}
+	switch k {
+	case 0:
+		g.b.Const32(idInt32, g.constant())
+	case 1:
+		g.b.Variable(g.live[g.rnd.Intn(len(g.live))], false)
+	case 2:
+		g.expr(depth + 1)
+		g.b.Neg(idInt32)
+	case 3:
+		g.expr(depth + 1)
+		g.b.Cpl(idInt32)
+	case 4:
+		g.expr(depth + 1)
+		g.b.Bool(idInt32)
+		g.b.Not()
+	default:
+		g.expr(depth + 1)
+		g.expr(depth + 1)
+		switch k {
+		case 5:
+			g.b.Add(idInt32)
+		case 6:
+			g.b.Sub(idInt32)
+		case 7:
+			g.b.Mul(idInt32)
+		case 8:
+			g.b.And(idInt32)
+		case 9:
+			g.b.Or(idInt32)
+		case 10:
+			g.b.Xor(idInt32)
+		case 11:
+			g.b.Eq(idInt32)
+		case 12:
+			g.b.Neq(idInt32)
+		case 13:
+			g.b.Lt(idInt32)
+		case 14:
+			g.b.Geq(idInt32)
+		default:
+			g.b.Gt(idInt32)
+		}
+	}
+}
+
+// Type is a go-fuzz entry point of the type specifier parser. It returns 1 if
+// data is a valid type specifier and 0 otherwise.
+func Type(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	if _, err := (ir.TypeCache{}).Type(ir.TypeID(xc.Dict.ID(data))); err != nil {
+		return 0
+	}
+
+	return 1
+}
+
+// ReadFrom is a go-fuzz entry point of decoding object files. It returns 1 if
+// data decodes successfully and 0 otherwise.
+func ReadFrom(data []byte) int {
+	var o ir.Objects
+	if _, err := o.ReadFrom(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+
+	return 1
+}
+
+// Verify is a go-fuzz entry point of the verifier. Data is the textual
+// assembly form of the objects to verify, see ir.Parse. It returns 1 if all
+// the objects are valid and 0 otherwise.
+func Verify(data []byte) int {
+	objs, err := ir.Parse("fuzz", data)
+	if err != nil {
+		return 0
+	}
+
+	for _, v := range objs {
+		if err := v.Verify(); err != nil {
+			return 0
+		}
+	}
+	return 1
+}
@@ -0,0 +1,434 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+var (
+	_ json.Marshaler   = (Objects)(nil)
+	_ json.Unmarshaler = (*Objects)(nil)
+
+	jsonTypes = map[string]reflect.Type{} // Name: struct type of an Object, Operation or Value.
+
+	bigFloatType = reflect.TypeOf((*big.Float)(nil))
+	nameIDType   = reflect.TypeOf(NameID(0))
+	runesType    = reflect.TypeOf([]rune(nil))
+	stringIDType = reflect.TypeOf(StringID(0))
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	typeIDType   = reflect.TypeOf(TypeID(0))
+)
+
+func init() {
+	for _, v := range append([]interface{}{
+		&AliasDefinition{},
+		&DataDefinition{},
+		&FunctionDefinition{},
+
+		&AddressValue{},
+		&Complex128Value{},
+		&Complex64Value{},
+		&CompositeValue{},
+		&DesignatedValue{},
+		&DifferenceValue{},
+		&Float128Value{},
+		&Float32Value{},
+		&Float64Value{},
+		&Int128Value{},
+		&Int32Value{},
+		&Int64Value{},
+		&StringValue{},
+		&Uint32Value{},
+		&Uint64Value{},
+		&WideStringValue{},
+	}, operations()...) {
+		t := reflect.TypeOf(v).Elem()
+		jsonTypes[t.Name()] = t
+	}
+}
+
+func operations() (r []interface{}) {
+	for _, v := range opcodes {
+		if v != nil {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// MarshalJSON implements json.Marshaler.
+//
+// The JSON form of o is an array of translation units, every translation unit
+// is an array of objects. Objects, operations and values are JSON objects
+// with a "Kind" member holding the Go type name, for example "Add" or
+// "Int32Value", and a member per exported field of the Go type, embedded
+// structs other than token.Position are flattened. NameIDs, StringIDs and
+// TypeIDs are represented by their strings, for example a TypeID by the type
+// specifier "*int8". Linkages, overflow and division modes are represented by
+// their names, token.Positions by objects, complex numbers by an array of the
+// real and imaginary part, non finite floating point numbers by the strings
+// "NaN", "+Inf" and "-Inf", big.Floats by their shortest decimal string and
+// big.Ints by their decimal string.
+func (o Objects) MarshalJSON() ([]byte, error) {
+	v, err := jsonEncode(reflect.ValueOf([][]Object(o)))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (o *Objects) UnmarshalJSON(b []byte) error {
+	var r [][]Object
+	if err := jsonDecode(b, reflect.ValueOf(&r).Elem()); err != nil {
+		return err
+	}
+
+	*o = r
+	return nil
+}
+
+func jsonEncode(v reflect.Value) (interface{}, error) {
+	switch t := v.Type(); t {
+	case bigFloatType:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		return v.Interface().(*big.Float).Text('g', -1), nil
+	case bigIntType:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		return v.Interface().(*big.Int).String(), nil
+	case nameIDType, stringIDType, typeIDType:
+		if v.Int() == 0 {
+			return "", nil
+		}
+
+		return v.Interface().(fmt.Stringer).String(), nil
+	case positionType:
+		return v.Interface(), nil
+	case runesType:
+		return string(v.Interface().([]rune)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		e := v.Elem()
+		if e.Kind() != reflect.Ptr || e.IsNil() || jsonTypes[e.Elem().Type().Name()] != e.Elem().Type() {
+			return nil, fmt.Errorf("unsupported type %T", e.Interface())
+		}
+
+		m := map[string]interface{}{"Kind": e.Elem().Type().Name()}
+		if err := jsonEncodeFields(m, e.Elem()); err != nil {
+			return nil, err
+		}
+
+		return m, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		return jsonEncode(v.Elem())
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		if err := jsonEncodeFields(m, v); err != nil {
+			return nil, err
+		}
+
+		return m, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		r := make([]interface{}, v.Len())
+		for i := range r {
+			var err error
+			if r[i], err = jsonEncode(v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type().Implements(stringerType) {
+			return v.Interface().(fmt.Stringer).String(), nil
+		}
+
+		return v.Int(), nil
+	case reflect.Float32, reflect.Float64:
+		return jsonFloat(v.Float()), nil
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return []interface{}{jsonFloat(real(c)), jsonFloat(imag(c))}, nil
+	case reflect.Bool, reflect.String,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Interface(), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func jsonEncodeFields(m map[string]interface{}, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Type != positionType {
+			if err := jsonEncodeFields(m, v.Field(i)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		x, err := jsonEncode(v.Field(i))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+		}
+
+		m[f.Name] = x
+	}
+	return nil
+}
+
+func jsonFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return f
+}
+
+func jsonDecode(b []byte, v reflect.Value) error {
+	if string(b) == "null" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch t := v.Type(); t {
+	case bigFloatType:
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		f, _, err := big.ParseFloat(s, 0, Float128Prec, big.ToNearestEven)
+		if err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(f))
+		return nil
+	case bigIntType:
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+
+		v.Set(reflect.ValueOf(n))
+		return nil
+	case nameIDType, stringIDType, typeIDType:
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		var n int
+		if s != "" {
+			n = dict.SID(s)
+		}
+		v.SetInt(int64(n))
+		return nil
+	case positionType:
+		var p token.Position
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(p))
+		return nil
+	case runesType:
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf([]rune(s)))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		var k struct{ Kind string }
+		if err := json.Unmarshal(b, &k); err != nil {
+			return err
+		}
+
+		t := jsonTypes[k.Kind]
+		if t == nil || !reflect.PtrTo(t).Implements(v.Type()) {
+			return fmt.Errorf("unexpected kind %q", k.Kind)
+		}
+
+		p := reflect.New(t)
+		if err := jsonDecodeFields(b, p.Elem()); err != nil {
+			return err
+		}
+
+		v.Set(p)
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := jsonDecode(b, p.Elem()); err != nil {
+			return err
+		}
+
+		v.Set(p)
+		return nil
+	case reflect.Struct:
+		return jsonDecodeFields(b, v)
+	case reflect.Slice:
+		var a []json.RawMessage
+		if err := json.Unmarshal(b, &a); err != nil {
+			return err
+		}
+
+		s := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i, w := range a {
+			if err := jsonDecode(w, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type().Implements(stringerType) {
+			var s string
+			if err := json.Unmarshal(b, &s); err != nil {
+				return err
+			}
+
+			for i := 0; i < 256; i++ {
+				v.SetInt(int64(i))
+				if v.Interface().(fmt.Stringer).String() == s {
+					return nil
+				}
+			}
+			return fmt.Errorf("invalid %s %q", v.Type().Name(), s)
+		}
+	case reflect.Float32, reflect.Float64:
+		f, err := jsonDecodeFloat(b)
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+		return nil
+	case reflect.Complex64, reflect.Complex128:
+		var a []json.RawMessage
+		if err := json.Unmarshal(b, &a); err != nil {
+			return err
+		}
+
+		if len(a) != 2 {
+			return fmt.Errorf("invalid complex number %s", b)
+		}
+
+		re, err := jsonDecodeFloat(a[0])
+		if err != nil {
+			return err
+		}
+
+		im, err := jsonDecodeFloat(a[1])
+		if err != nil {
+			return err
+		}
+
+		v.SetComplex(complex(re, im))
+		return nil
+	}
+	return json.Unmarshal(b, v.Addr().Interface())
+}
+
+func jsonDecodeFields(b []byte, v reflect.Value) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	return jsonDecodeFieldMap(m, v)
+}
+
+func jsonDecodeFieldMap(m map[string]json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Type != positionType {
+			if err := jsonDecodeFieldMap(m, v.Field(i)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		b, ok := m[f.Name]
+		if !ok {
+			continue
+		}
+
+		if err := jsonDecode(b, v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+func jsonDecodeFloat(b []byte) (float64, error) {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		switch s {
+		case "NaN":
+			return math.NaN(), nil
+		case "+Inf":
+			return math.Inf(1), nil
+		case "-Inf":
+			return math.Inf(-1), nil
+		}
+		return 0, fmt.Errorf("invalid floating point number %q", s)
+	}
+
+	var f float64
+	err := json.Unmarshal(b, &f)
+	return f, err
+}
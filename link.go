@@ -7,20 +7,24 @@ package ir
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"fmt"
+	"go/token"
 	"io"
+	"reflect"
 	"runtime"
 	"runtime/debug"
 	"sort"
-	"strconv"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cznic/internal/buffer"
 )
 
 const (
-	binaryVersion = 2 // Compatibility version of Objects.
+	binaryVersion = 5 // Compatibility version of Objects.
 )
 
 var (
@@ -56,62 +60,210 @@ func (c *counter) Write(b []byte) (int, error) {
 // Objects represent []Object implementing io.ReaderFrom and io.WriterTo.
 type Objects [][]Object
 
-// ReadFrom reads o from r.
+// ReadFrom reads o from r. The objects must have been written for the host
+// target.
 func (o *Objects) ReadFrom(r io.Reader) (n int64, err error) {
-	var c counter
+	return o.ReadFromTarget(r, HostTarget())
+}
+
+// ReadFromTarget is like ReadFrom but the objects must have been written for
+// target t.
+func (o *Objects) ReadFromTarget(r io.Reader, t Target) (n int64, err error) {
+	return o.readFrom(r, dict, t)
+}
+
+func (o *Objects) readFrom(r io.Reader, d Dictionary, t Target) (n int64, err error) {
 	*o = nil
-	r = io.TeeReader(r, &c)
-	gr, err := gzip.NewReader(r)
-	if err != nil {
-		return 0, err
+	var or ObjectReader
+	if err := or.init(r, d, t); err != nil {
+		return int64(or.c), err
 	}
 
-	if len(gr.Header.Extra) < len(magic) || !bytes.Equal(gr.Header.Extra[:len(magic)], magic) {
-		return int64(c), fmt.Errorf("unrecognized file format")
+	for {
+		unit, obj, err := or.Next()
+		if err != nil {
+			if err != io.EOF {
+				return int64(or.c), err
+			}
+
+			for len(*o) < or.units {
+				*o = append(*o, nil)
+			}
+			return int64(or.c), nil
+		}
+
+		for len(*o) <= unit {
+			*o = append(*o, nil)
+		}
+		(*o)[unit] = append((*o)[unit], obj)
 	}
+}
 
-	buf := gr.Header.Extra[len(magic):]
-	a := bytes.Split(buf, []byte{'|'})
-	if len(a) != 3 {
-		return int64(c), fmt.Errorf("corrupted file")
+// decode reads v, written by encode for target t, from r.
+func decode(r io.Reader, t Target, v interface{}) (n int64, err error) {
+	var c counter
+	dec, err := newDecoder(r, t, dict, &c)
+	if err != nil {
+		return int64(c), err
 	}
 
-	if s := string(a[0]); s != runtime.GOOS {
-		return int64(c), fmt.Errorf("invalid platform %q", s)
+	if err = dec.decode(v); err != nil {
+		return int64(c), err
 	}
 
-	if s := string(a[1]); s != runtime.GOARCH {
-		return int64(c), fmt.Errorf("invalid architecture %q", s)
+	return int64(c), dec.verify()
+}
+
+// newDecoder returns a gob decoder of the stream written by encode for target
+// t to r. Identifiers are registered in d. The bytes read from r are counted
+// in c.
+func newDecoder(r io.Reader, t Target, d Dictionary, c *counter) (*decoder, error) {
+	r = io.TeeReader(r, c)
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
 	}
 
-	v, err := strconv.ParseUint(string(a[2]), 10, 64)
+	m, err := parseManifest(gr.Header.Extra)
 	if err != nil {
-		return int64(c), err
+		return nil, err
+	}
+
+	if s := m.Target.OS; s != t.OS {
+		return nil, fmt.Errorf("invalid platform %q", s)
 	}
 
-	if v != binaryVersion {
-		return int64(c), fmt.Errorf("invalid version number %v", v)
+	if s := m.Target.Arch; s != t.Arch {
+		return nil, fmt.Errorf("invalid architecture %q", s)
 	}
 
-	err = gob.NewDecoder(gr).Decode(o)
-	return int64(c), err
+	if ver := m.BinaryVersion; ver != binaryVersion {
+		return nil, fmt.Errorf("invalid version number %v", ver)
+	}
+
+	dec := &decoder{Manifest: m, dict: d, r: gr}
+	if _, ok := m.Checksums["sha256"]; ok {
+		dec.h = sha256.New()
+		dec.r = io.TeeReader(gr, dec.h)
+	}
+	dec.Decoder = gob.NewDecoder(dec.r)
+	return dec, nil
+}
+
+// WriteOptions amend Objects.WriteToOptions.
+type WriteOptions struct {
+	Checksum        bool      // Record the sha256 checksum of the data in the manifest. The uncompressed data are buffered in memory.
+	Features        []string  // Feature flags recorded in the manifest.
+	ModTime         time.Time // Recorded modification time, may be zero.
+	Producer        string    // Producer name recorded in the manifest.
+	ProducerVersion string    // Producer version recorded in the manifest.
+	StripPositions  bool      // Do not write any positions.
+	Target          Target    // Recorded instead of the host target, field by field, if not empty.
 }
 
 // WriteTo writes o to w.
 func (o Objects) WriteTo(w io.Writer) (n int64, err error) {
+	return o.WriteToOptions(w, &WriteOptions{ModTime: time.Now()})
+}
+
+// WriteToOptions writes o to w as amended by opts. Unlike WriteTo, it does not
+// record the current time, so writing the same objects using the same options
+// produces the same bytes. Positions are a frequent source of differences in
+// otherwise identical builds, opts.StripPositions removes them. WriteToOptions
+// does not mutate o.
+func (o Objects) WriteToOptions(w io.Writer, opts *WriteOptions) (n int64, err error) {
+	return o.writeTo(w, dict, opts)
+}
+
+func (o Objects) writeTo(w io.Writer, d Dictionary, opts *WriteOptions) (n int64, err error) {
+	if opts.StripPositions {
+		o = o.stripPositions()
+	}
+	return encodeFunc(w, d, opts, "IR objects", func(enc *encoder) error {
+		// The number of translation units, then per unit the number of
+		// objects and per object its header and the object itself. See
+		// ObjectReader.
+		if err := enc.encode(len(o)); err != nil {
+			return err
+		}
+
+		for _, v := range o {
+			if err := enc.encode(len(v)); err != nil {
+				return err
+			}
+
+			for _, v := range packPositions(v) {
+				b := v.Base()
+				if err := enc.encode(&objectHeader{b.Linkage, b.NameID, b.Package, b.TypeID}); err != nil {
+					return err
+				}
+
+				if err := enc.encode(&v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// encode writes v to w as a gzipped gob stream, recording its Manifest in the
+// gzip header.
+func encode(w io.Writer, opts *WriteOptions, comment string, v interface{}) (n int64, err error) {
+	return encodeFunc(w, dict, opts, comment, func(enc *encoder) error { return enc.encode(v) })
+}
+
+// encodeFunc is like encode but the gob stream is written by f. Identifiers
+// are resolved using d.
+func encodeFunc(w io.Writer, d Dictionary, opts *WriteOptions, comment string, f func(*encoder) error) (n int64, err error) {
+	goos, goarch := runtime.GOOS, runtime.GOARCH
+	if opts.Target.OS != "" {
+		goos = opts.Target.OS
+	}
+	if opts.Target.Arch != "" {
+		goarch = opts.Target.Arch
+	}
+	m := &Manifest{
+		BinaryVersion:   binaryVersion,
+		Producer:        opts.Producer,
+		ProducerVersion: opts.ProducerVersion,
+		Target:          Target{OS: goos, Arch: goarch},
+		Version:         manifestVersion,
+	}
+	var data bytes.Buffer
+	if opts.Checksum {
+		// The manifest precedes the data, which must be complete to sum them.
+		if err := f(newEncoder(&data, d)); err != nil {
+			return 0, err
+		}
+
+		sum := sha256.Sum256(data.Bytes())
+		m.Checksums = map[string]string{"sha256": hex.EncodeToString(sum[:])}
+	}
+	if len(opts.Features) != 0 {
+		m.Features = append([]string(nil), opts.Features...)
+		sort.Strings(m.Features)
+	}
+	extra, err := m.extra()
+	if err != nil {
+		return 0, err
+	}
+
 	var c counter
 	gw := gzip.NewWriter(io.MultiWriter(w, &c))
-	gw.Header.Comment = "IR objects"
-	var buf buffer.Bytes
-	buf.Write(magic)
-	fmt.Fprintf(&buf, fmt.Sprintf("%s|%s|%v", runtime.GOOS, runtime.GOARCH, binaryVersion))
-	gw.Header.Extra = buf.Bytes()
-	buf.Close()
-	gw.Header.ModTime = time.Now()
+	gw.Header.Comment = comment
+	gw.Header.Extra = extra
+	gw.Header.ModTime = opts.ModTime
 	gw.Header.OS = 255 // Unknown OS.
-	enc := gob.NewEncoder(gw)
-	if err := enc.Encode(o); err != nil {
-		return int64(c), err
+	switch {
+	case opts.Checksum:
+		if _, err := gw.Write(data.Bytes()); err != nil {
+			return int64(c), err
+		}
+	default:
+		if err := f(newEncoder(gw, d)); err != nil {
+			return int64(c), err
+		}
 	}
 
 	if err := gw.Close(); err != nil {
@@ -121,10 +273,52 @@ func (o Objects) WriteTo(w io.Writer) (n int64, err error) {
 	return int64(c), nil
 }
 
+// stripPositions returns a copy of o with all positions removed. Objects and
+// operations are copied shallowly.
+func (o Objects) stripPositions() Objects {
+	r := make(Objects, len(o))
+	for i, v := range o {
+		r[i] = make([]Object, len(v))
+		for j, v := range v {
+			switch x := v.(type) {
+			case *AliasDefinition:
+				y := *x
+				y.Position = token.Position{}
+				v = &y
+			case *DataDefinition:
+				y := *x
+				y.Position = token.Position{}
+				v = &y
+			case *FunctionDefinition:
+				y := *x
+				y.Position = token.Position{}
+				y.Files = nil
+				y.Positions = nil
+				y.Body = make([]Operation, len(x.Body))
+				for k, op := range x.Body {
+					p := reflect.New(reflect.TypeOf(op).Elem())
+					p.Elem().Set(reflect.ValueOf(op).Elem())
+					if f := p.Elem().FieldByName("Position"); f.IsValid() {
+						f.Set(reflect.ValueOf(token.Position{}))
+					}
+					y.Body[k] = p.Interface().(Operation)
+				}
+				v = &y
+			}
+			r[i][j] = v
+		}
+	}
+	return r
+}
+
 // LinkMain returns all objects transitively referenced from function _start or
 // an error, if any. Linking may mutate passed objects. It's the caller
 // responsibility to ensure all translationUnits were produced for the same
-// architecture and platform.
+// architecture and platform. Undefined references, multiple definitions and
+// type mismatches are reported as a LinkError. The later definitions of an
+// internal name defined more than once in a translation unit, for example
+// after concatenating preprocessed sources, are renamed together with the
+// references to them.
 //
 // LinkMain panics when passed no data.
 func LinkMain(translationUnits ...[]Object) (_ []Object, err error) {
@@ -142,18 +336,159 @@ func LinkMain(translationUnits ...[]Object) (_ []Object, err error) {
 			}
 		}()
 	}
-	l := newLinker(translationUnits)
+	l := newLinker(translationUnits, HostTarget(), TypeCache{})
 	l.linkMain()
+	if len(l.errors) != 0 {
+		return nil, l.errors
+	}
+
 	return l.out, nil
 }
 
+// LinkMapEntry describes an object produced by the linker.
+type LinkMapEntry struct {
+	Index int // Of the object in the linker output.
+	Linkage
+	NameID    NameID
+	Object    Object
+	Size      int64 // Of data according to the host memory model, the length of a function body, zero otherwise.
+	TypeID    TypeID
+	Unit      int // Index of the originating translation unit.
+	UnitIndex int // Index of the object in its translation unit.
+}
+
+// LinkMap lists the objects produced by the linker, see LinkMainWithMap.
+type LinkMap []LinkMapEntry
+
+// String returns m as a table, for example
+//
+//	index unit kind     linkage         size type           name
+//	0     1    function ExternalLinkage 8    func()         _start
+//	1     0    function ExternalLinkage 13   func()int32    main
+//	2     0    data     InternalLinkage 4    int32          x
+func (m LinkMap) String() string {
+	var buf buffer.Bytes
+	w := tabwriter.NewWriter(&buf, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "index\tunit\tkind\tlinkage\tsize\ttype\tname\n")
+	for _, v := range m {
+		k := "alias"
+		switch v.Object.(type) {
+		case *DataDefinition:
+			k = "data"
+		case *FunctionDefinition:
+			k = "function"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%s\t%v\t%v\t%s\t%s\n", v.Index, v.Unit, k, v.Linkage, v.Size, v.TypeID.Abbrev(), v.NameID)
+	}
+	w.Flush()
+	r := string(buf.Bytes())
+	buf.Close()
+	return r
+}
+
+// LinkMainWithMap is like LinkMain but it additionally returns a LinkMap of
+// the resulting objects, for example to debug undefined references.
+func LinkMainWithMap(translationUnits ...[]Object) (_ []Object, _ LinkMap, err error) {
+	if !Testing {
+		defer func() {
+			switch x := recover().(type) {
+			case nil:
+				// nop
+			case error:
+				if err == nil {
+					err = x
+				}
+			default:
+				err = fmt.Errorf("ir.LinkMainWithMap PANIC: %v", x)
+			}
+		}()
+	}
+	l := newLinker(translationUnits, HostTarget(), TypeCache{})
+	l.linkMain()
+	if len(l.errors) != 0 {
+		return nil, nil, l.errors
+	}
+
+	m, err := l.linkMap()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.out, m, nil
+}
+
+// linkMap returns the LinkMap of l.out.
+func (l *linker) linkMap() (LinkMap, error) {
+	mm, err := NewMemoryModel()
+	if err != nil {
+		return nil, err
+	}
+
+	r := make(LinkMap, len(l.out))
+	for i, v := range l.out {
+		b := v.Base()
+		r[i] = LinkMapEntry{Index: i, Linkage: b.Linkage, NameID: b.NameID, Object: v, TypeID: b.TypeID, Unit: -1, UnitIndex: -1}
+		switch x := v.(type) {
+		case *DataDefinition:
+			if t := l.typeCache.MustType(x.TypeID); IsComplete(t) {
+				r[i].Size = mm.Sizeof(t)
+			}
+		case *FunctionDefinition:
+			r[i].Size = int64(len(x.Body))
+		}
+	}
+	for unit, v := range l.defined {
+		for j, v := range v {
+			if v != 0 && l.out[v-1] == l.in[unit][j] {
+				r[v-1].Unit = unit
+				r[v-1].UnitIndex = j
+			}
+		}
+	}
+	return r, nil
+}
+
 // LinkLib returns all objects with external linkage defined in
 // translationUnits.  Linking may mutate passed objects. It's the caller
 // responsibility to ensure all translationUnits were produced for the same
-// architecture and platform.
+// architecture and platform. Undefined references, multiple definitions and
+// type mismatches are reported as a LinkError.
 //
 // LinkLib panics when passed no data.
 func LinkLib(translationUnits ...[]Object) (_ []Object, err error) {
+	return LinkLibWithOptions(nil, translationUnits...)
+}
+
+// LinkLibOptions amend LinkLibWithOptions.
+type LinkLibOptions struct {
+	Roots  []NameID // External names to keep when GC is set.
+	GC     bool     // Keep only Roots and the objects transitively referenced from them.
+	Target Target   // Of the linked objects, determines the sizes of common data. The host target if zero.
+	Types  Types    // Resolves the TypeIDs of the linked objects, a new TypeCache if nil. Not used by Linker.FinalizeLib.
+}
+
+// types returns the Types selected by o, which may be nil.
+func (o *LinkLibOptions) types() Types {
+	if o == nil {
+		return TypeCache{}
+	}
+
+	return newTypes(o.Types)
+}
+
+// target returns the link target selected by o, which may be nil.
+func (o *LinkLibOptions) target() Target {
+	if o == nil || o.Target == (Target{}) {
+		return HostTarget()
+	}
+
+	return o.Target
+}
+
+// LinkLibWithOptions is like LinkLib as amended by opts, which may be nil. If
+// opts.GC is set, only the definitions of opts.Roots and their transitive
+// dependencies are returned. An undefined root is reported as a LinkError.
+func LinkLibWithOptions(opts *LinkLibOptions, translationUnits ...[]Object) (_ []Object, err error) {
 	if !Testing {
 		defer func() {
 			switch x := recover().(type) {
@@ -184,37 +519,257 @@ search:
 	if !ok {
 		translationUnits = append(translationUnits, main)
 	}
-	l := newLinker(translationUnits)
-	l.link()
+	l := newLinker(translationUnits, opts.target(), opts.types())
+	switch {
+	case opts != nil && opts.GC:
+		l.linkRoots(opts.Roots)
+	default:
+		l.link()
+	}
+	if len(l.errors) != 0 {
+		return nil, l.errors
+	}
+
 	return l.out, nil
 }
 
+// SymbolInfo describes a symbol reported by Symbols.
+type SymbolInfo struct {
+	Linkage
+	Object   Object         // The defining object, nil for an undefined symbol.
+	Position token.Position // Of the definition or of the first reference.
+	Size     int64          // Size of defined data according to the host memory model, zero otherwise.
+	TypeID   TypeID         // Of the definition or of the first reference. Zero for a reference by an AddressValue.
+	Unit     int            // Index of the translation unit of the definition or of the first reference.
+}
+
+// Symbols returns the external names defined in translationUnits and those
+// referred to but not defined by them, including weak external references,
+// without linking. Of multiple definitions of a name the one with
+// ExternalLinkage is reported, otherwise the first one. Multiple definitions
+// of a name with ExternalLinkage, except of data without a value and of panic
+// stubs, are reported as a LinkError.
+func Symbols(translationUnits ...[]Object) (defined, undefined map[NameID]SymbolInfo, err error) {
+	m, err := NewMemoryModel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tentative := func(o Object) bool {
+		switch x := o.(type) {
+		case *DataDefinition:
+			return x.Value == nil
+		case *FunctionDefinition:
+			return isPanicStub(x)
+		}
+		return false
+	}
+	var errors LinkError
+	tc := TypeCache{}
+	defined = map[NameID]SymbolInfo{}
+	for unit, v := range translationUnits {
+		for _, v := range v {
+			b := v.Base()
+			switch b.Linkage {
+			case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+				// ok
+			default:
+				continue
+			}
+
+			if def, ok := defined[b.NameID]; ok {
+				switch {
+				case b.Linkage != ExternalLinkage, tentative(v) && def.Linkage == ExternalLinkage:
+					continue
+				case def.Linkage != ExternalLinkage, tentative(def.Object):
+					// Replace def.
+				default:
+					errors = append(errors, &LinkDiagnostic{NameID: b.NameID, Position: b.Position, Msg: fmt.Sprintf("multiple definitions of %s\n\t%s: previous definition", b.NameID, def.Position)})
+					continue
+				}
+			}
+
+			s := SymbolInfo{Linkage: b.Linkage, Object: v, Position: b.Position, TypeID: b.TypeID, Unit: unit}
+			if _, ok := v.(*DataDefinition); ok {
+				t, err := tc.Type(b.TypeID)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if IsComplete(t) {
+					s.Size = m.Sizeof(t)
+				}
+			}
+			defined[b.NameID] = s
+		}
+	}
+
+	undefined = map[NameID]SymbolInfo{}
+	for unit, v := range translationUnits {
+		for _, v := range v {
+			references(v, func(l Linkage, nm NameID, t TypeID, pos token.Position) {
+				switch l {
+				case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage, WeakExternalLinkage:
+					// ok
+				default:
+					return
+				}
+
+				if _, ok := defined[nm]; ok {
+					return
+				}
+
+				var buf buffer.Bytes
+				buf.Write(dict.S(idBuiltinPrefix))
+				buf.Write(dict.S(int(nm)))
+				_, ok := defined[NameID(dict.ID(buf.Bytes()))]
+				buf.Close()
+				if ok {
+					return
+				}
+
+				if ref, ok := undefined[nm]; ok && (ref.Linkage != WeakExternalLinkage || l == WeakExternalLinkage) {
+					return
+				}
+
+				undefined[nm] = SymbolInfo{Linkage: l, Position: pos, TypeID: t, Unit: unit}
+			})
+		}
+	}
+	if len(errors) != 0 {
+		err = errors
+	}
+	return defined, undefined, err
+}
+
+// LinkDiagnostic describes a single problem found by the linker.
+type LinkDiagnostic struct {
+	NameID   NameID // The offending name.
+	Position token.Position
+	Msg      string
+}
+
+// Error implements error.
+func (d *LinkDiagnostic) Error() string {
+	if !d.Position.IsValid() {
+		return d.Msg
+	}
+
+	return fmt.Sprintf("%s: %s", d.Position, d.Msg)
+}
+
+// LinkError is the error returned by LinkMain and LinkLib. It lists all
+// undefined references, multiple definitions and type mismatches found, not
+// only the first one.
+type LinkError []*LinkDiagnostic
+
+// Error implements error.
+func (e LinkError) Error() string {
+	var buf buffer.Bytes
+	for i, v := range e {
+		if i != 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(v.Error())
+	}
+	s := string(buf.Bytes())
+	buf.Close()
+	return s
+}
+
+// mergeCommon returns the definition to keep of two definitions of the same
+// external data name of which at least one has CommonLinkage or false if they
+// clash. Weak definitions yield to the other one. A definition with an
+// initializer wins over one without, a definition with ExternalLinkage wins
+// over a tentative one and of two tentative definitions the larger one on
+// target t is kept. At most one of the definitions may have an initializer.
+func mergeCommon(def, x *DataDefinition, tc Types, t Target) (*DataDefinition, bool, error) {
+	switch {
+	case def.Value != nil && x.Value != nil:
+		return nil, false, nil
+	case x.Linkage.weak():
+		return def, true, nil
+	case def.Linkage.weak():
+		return x, true, nil
+	}
+
+	rank := func(d *DataDefinition) int {
+		switch {
+		case d.Value != nil:
+			return 2
+		case d.Linkage == ExternalLinkage:
+			return 1
+		}
+		return 0
+	}
+	switch rd, rx := rank(def), rank(x); {
+	case rx > rd:
+		return x, true, nil
+	case rx < rd || def.TypeID == x.TypeID:
+		return def, true, nil
+	}
+
+	switch {
+	case !IsComplete(tc.MustType(x.TypeID)):
+		return def, true, nil
+	case !IsComplete(tc.MustType(def.TypeID)):
+		return x, true, nil
+	}
+
+	m, err := NewMemoryModelFor(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if m.Sizeof(tc.MustType(x.TypeID)) > m.Sizeof(tc.MustType(def.TypeID)) {
+		return x, true, nil
+	}
+
+	return def, true, nil
+}
+
 type extern struct {
 	unit  int
 	index int
 }
 
-type intern struct {
-	NameID
-	unit int
-}
-
 type linker struct {
-	defined   map[extern]int    // unit, unit index: out index
-	extern    map[NameID]extern // name: unit, unit index
+	aliases   map[*AliasDefinition]bool // Aliases being resolved.
+	clone     bool                      // Link copies of the objects of in, see Linker.
+	defined   [][]int                   // unit, unit index: out index + 1
+	errors    LinkError                 // Problems found so far.
+	extern    map[NameID]extern         // name: unit, unit index
 	in        [][]Object
-	intern    map[intern]int // name, unit: unit index
+	intern    []map[NameID]int // unit, name: unit index
 	out       []Object
-	typeCache TypeCache
+	target    Target // Of the linked objects.
+	typeCache Types
 }
 
-func newLinker(in [][]Object) *linker {
+func newLinker(in [][]Object, t Target, tc Types) *linker {
+	var n int
+	for _, v := range in {
+		n += len(v)
+	}
 	l := &linker{
-		defined:   map[extern]int{},
-		extern:    map[NameID]extern{},
+		aliases:   map[*AliasDefinition]bool{},
+		defined:   make([][]int, len(in)),
+		extern:    make(map[NameID]extern, n),
 		in:        in,
-		intern:    map[intern]int{},
-		typeCache: TypeCache{},
+		intern:    make([]map[NameID]int, len(in)),
+		target:    t,
+		typeCache: tc,
+	}
+	for unit, v := range in {
+		renameInternal(v, unit)
+		l.defined[unit] = make([]int, len(v))
+		var n int
+		for _, v := range v {
+			if v.Base().Linkage == InternalLinkage {
+				n++
+			}
+		}
+		l.intern[unit] = make(map[NameID]int, n)
 	}
 
 	l.collectSymbols()
@@ -223,205 +778,226 @@ func newLinker(in [][]Object) *linker {
 
 func (l *linker) collectSymbols() {
 	for unit, v := range l.in {
-		for i, v := range v {
-			switch x := v.(type) {
-			case *DataDefinition:
-				switch x.Linkage {
-				case ExternalLinkage:
-					switch ex, ok := l.extern[x.NameID]; {
-					case ok:
-						switch def := l.in[ex.unit][ex.index].(type) {
-						case *DataDefinition:
-							if x.TypeID != def.TypeID {
-								panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
-							}
-
-							if x.Value != nil && def.Value == nil {
-								def.Value = x.Value
-							}
-						default:
-							panic(fmt.Errorf("ir.linker internal error %T\n%s", def, debug.Stack()))
+		for i := range v {
+			l.collect(unit, i)
+		}
+	}
+}
+
+// collect adds the definition of object i of unit to the symbol tables.
+func (l *linker) collect(unit, i int) {
+	switch x := l.in[unit][i].(type) {
+	case *DataDefinition:
+		switch x.Linkage {
+		case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+			switch ex, ok := l.extern[x.NameID]; {
+			case ok:
+				switch def := l.in[ex.unit][ex.index].(type) {
+				case *DataDefinition:
+					if x.TLS != def.TLS {
+						l.errorf(x.Position, x.NameID, "thread local storage mismatch of %s\n\t%s: previous definition", x.NameID, def.Position)
+						break
+					}
+
+					if x.Linkage == CommonLinkage || def.Linkage == CommonLinkage {
+						switch keep, ok, err := mergeCommon(def, x, l.typeCache, l.target); {
+						case err != nil:
+							l.errorf(x.Position, x.NameID, "cannot merge common %s: %v\n\t%s: previous definition", x.NameID, err, def.Position)
+						case !ok:
+							l.errorf(x.Position, x.NameID, "multiple definitions of %s\n\t%s: previous definition", x.NameID, def.Position)
+						case keep == x:
+							l.extern[x.NameID] = extern{unit: unit, index: i}
 						}
-					default:
-						l.extern[x.NameID] = extern{unit: unit, index: i}
+						break
 					}
-				case InternalLinkage:
-					k := intern{x.NameID, unit}
-					switch _, ok := l.intern[k]; {
-					case ok:
-						panic(fmt.Errorf("ir.linker TODO: %T(%v)\n%s", x, x, debug.Stack()))
-					default:
-						l.intern[k] = i
+
+					t, err := l.typeCache.Composite(def.TypeID, x.TypeID)
+					if err != nil {
+						l.errorf(x.Position, x.NameID, "incompatible redefinition of %s: %v\n\t%s: previous definition: %v", x.NameID, x.TypeID, def.Position, def.TypeID)
+						break
+					}
+
+					def.TypeID = t
+					switch {
+					case x.Linkage.weak():
+						// Keep def.
+					case def.Linkage.weak():
+						l.extern[x.NameID] = extern{unit: unit, index: i}
+					case x.Value != nil && def.Value == nil:
+						def.Value = x.Value
+					case x.Value != nil:
+						l.errorf(x.Position, x.NameID, "multiple definitions of %s\n\t%s: previous definition", x.NameID, def.Position)
 					}
 				default:
-					panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
+					l.errorf(x.Position, x.NameID, "%s redefined as data\n\t%s: previous definition", x.NameID, def.Base().Position)
 				}
-			case *FunctionDefinition:
-				switch x.Linkage {
-				case ExternalLinkage:
-					switch ex, ok := l.extern[x.NameID]; {
-					case ok:
-						switch def := l.in[ex.unit][ex.index].(type) {
-						case *FunctionDefinition:
-							if x.TypeID != def.TypeID {
-								// accept new def is f()T, while existing def if f(X,Y,Z...)T
-								xt := l.typeCache.MustType(x.TypeID).(*FunctionType)
-								dt := l.typeCache.MustType(def.TypeID).(*FunctionType)
-								if len(xt.Results) != len(dt.Results) {
-									panic(fmt.Errorf("incompatible external redefinition of %s\n\t%s: %v\n\t%s: %v", x.NameID, x.Position, xt, def.Position, dt))
-								}
-
-								for i, xr := range xt.Results {
-									if dr := dt.Results[i]; xr.ID() != dr.ID() {
-										panic(fmt.Errorf("incompatible external redefinition of %s\n\t%s: %v\n\t%s: %v", x.NameID, x.Position, xt, def.Position, dt))
-									}
-								}
-
-								if g, e := len(xt.Arguments), len(dt.Arguments); g != e && g != 0 && e != 0 {
-									panic(fmt.Errorf("incompatible external redefinition of %s\n\t%s: %v\n\t%s: %v", x.NameID, x.Position, xt, def.Position, dt))
-								}
-							}
-
-							if len(def.Body) != 1 {
-								break
-							}
-
-							if _, ok := def.Body[0].(*Panic); ok {
-								l.extern[x.NameID] = extern{unit: unit, index: i}
-								break
-							}
-
-							panic(fmt.Errorf("%s: ir.linker internal error %s\n%s", x.Position, x.NameID, debug.Stack()))
-						default:
-							panic(fmt.Errorf("ir.linker internal error %T\n%s", def, debug.Stack()))
+			default:
+				l.extern[x.NameID] = extern{unit: unit, index: i}
+			}
+		case InternalLinkage:
+			switch _, ok := l.intern[unit][x.NameID]; {
+			case ok:
+				l.errorf(x.Position, x.NameID, "multiple definitions of %s", x.NameID)
+			default:
+				l.intern[unit][x.NameID] = i
+			}
+		default:
+			panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
+		}
+	case *FunctionDefinition:
+		switch x.Linkage {
+		case ExternalLinkage, OnceLinkage, WeakLinkage:
+			switch ex, ok := l.extern[x.NameID]; {
+			case ok:
+				switch def := l.in[ex.unit][ex.index].(type) {
+				case *FunctionDefinition:
+					if x.Linkage.weak() || def.Linkage.weak() {
+						if !l.typeCache.Compatible(x.TypeID, def.TypeID) {
+							l.errorf(x.Position, x.NameID, "incompatible redefinition of %s: %v\n\t%s: previous definition: %v", x.NameID, x.TypeID, def.Position, def.TypeID)
+							break
 						}
-					default:
-						l.extern[x.NameID] = extern{unit: unit, index: i}
+
+						if !x.Linkage.weak() {
+							l.extern[x.NameID] = extern{unit: unit, index: i}
+						}
+						break
 					}
-				case InternalLinkage:
-					k := intern{x.NameID, unit}
-					switch _, ok := l.intern[k]; {
-					case ok:
-						panic(fmt.Errorf("TODO: %T(%v)\n%s", x, x, debug.Stack()))
-					default:
-						l.intern[k] = i
+
+					if !l.typeCache.Compatible(x.TypeID, def.TypeID) {
+						l.errorf(x.Position, x.NameID, "incompatible external redefinition of %s: %v\n\t%s: previous definition: %v", x.NameID, x.TypeID, def.Position, def.TypeID)
+						break
+					}
+
+					switch {
+					case isPanicStub(def):
+						l.extern[x.NameID] = extern{unit: unit, index: i}
+					case !isPanicStub(x):
+						l.errorf(x.Position, x.NameID, "multiple definitions of %s\n\t%s: previous definition", x.NameID, def.Position)
 					}
 				default:
-					panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
+					l.errorf(x.Position, x.NameID, "%s redefined as function\n\t%s: previous definition", x.NameID, def.Base().Position)
+				}
+			default:
+				l.extern[x.NameID] = extern{unit: unit, index: i}
+			}
+		case InternalLinkage:
+			switch _, ok := l.intern[unit][x.NameID]; {
+			case ok:
+				l.errorf(x.Position, x.NameID, "multiple definitions of %s", x.NameID)
+			default:
+				l.intern[unit][x.NameID] = i
+			}
+		case CommonLinkage:
+			l.errorf(x.Position, x.NameID, "invalid linkage %v of function %s", x.Linkage, x.NameID)
+		default:
+			panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
+		}
+	case *AliasDefinition:
+		switch x.Linkage {
+		case ExternalLinkage, OnceLinkage, WeakLinkage:
+			switch ex, ok := l.extern[x.NameID]; {
+			case ok:
+				def := l.in[ex.unit][ex.index].Base()
+				switch {
+				case x.Linkage.weak():
+					// Keep def.
+				case def.Linkage.weak():
+					l.extern[x.NameID] = extern{unit: unit, index: i}
+				default:
+					l.errorf(x.Position, x.NameID, "multiple definitions of %s\n\t%s: previous definition", x.NameID, def.Position)
 				}
 			default:
-				panic(fmt.Errorf("ir.linker internal error: %T(%v)\n%s", x, x, debug.Stack()))
+				l.extern[x.NameID] = extern{unit: unit, index: i}
 			}
+		case InternalLinkage:
+			switch _, ok := l.intern[unit][x.NameID]; {
+			case ok:
+				l.errorf(x.Position, x.NameID, "multiple definitions of %s", x.NameID)
+			default:
+				l.intern[unit][x.NameID] = i
+			}
+		case CommonLinkage:
+			l.errorf(x.Position, x.NameID, "invalid linkage %v of alias %s", x.Linkage, x.NameID)
+		default:
+			panic(fmt.Errorf("ir.linker internal error\n%s", debug.Stack()))
 		}
+	default:
+		panic(fmt.Errorf("ir.linker internal error: %T(%v)\n%s", x, x, debug.Stack()))
 	}
 }
 
-func (l *linker) initializer(op *VariableDeclaration, v Value) {
+func (l *linker) initializer(e extern, op *VariableDeclaration, v Value) {
 	switch x := v.(type) {
 	case
 		*Complex128Value,
+		*Complex64Value,
+		*Float128Value,
 		*Float32Value,
 		*Float64Value,
+		*Int128Value,
 		*Int32Value,
 		*Int64Value,
 		*StringValue,
+		*Uint32Value,
+		*Uint64Value,
 		*WideStringValue,
 		nil:
 		// ok
 	case *AddressValue:
-		switch x.Linkage {
-		case ExternalLinkage:
-			e, ok := l.extern[x.NameID]
-			if !ok {
-				panic(fmt.Errorf("%s: ir.linker undefined extern %s", op.Position, x.NameID))
-			}
-
-			x.Index = l.define(e)
-		default:
-			panic(fmt.Errorf("ir.linker internal error %s\n%s", x.Linkage, debug.Stack()))
-		}
+		l.address(e, op.Position, x)
 	case *CompositeValue:
 		for _, v := range x.Values {
-			l.initializer(op, v)
+			l.initializer(e, op, v)
 		}
+	case *DesignatedValue:
+		l.initializer(e, op, x.Value)
+	case *DifferenceValue:
+		l.initializer(e, op, x.A)
+		l.initializer(e, op, x.B)
 	default:
 		panic(fmt.Errorf("ir.linker internal error: %T %v\n%s", x, op, debug.Stack()))
 	}
 }
 
-func (l *linker) checkCalls(p *[]Operation) {
-	s := *p
-	w := 0
-	var static []int
-	for i, v := range s {
-		switch x := v.(type) {
-		case *Arguments:
-			if i != 0 {
-				switch y := s[i-1].(type) {
-				case *Global:
-					switch l.out[y.Index].(type) {
-					case *FunctionDefinition:
-						x.FunctionPointer = false
-						static = append(static, y.Index)
-						s[w-1] = x
-						continue
-					}
-				}
-			}
-
-			x.FunctionPointer = true
-			static = append(static, -1)
-		case *Call:
-			panic(fmt.Errorf("TODO\n%s", debug.Stack()))
-		case *CallFP:
-			n := len(static)
-			index := static[n-1]
-			static = static[:n-1]
-			if index < 0 {
-				break
-			}
-
-			t := l.typeCache.MustType(x.TypeID).(*PointerType).Element
-			v = &Call{Arguments: x.Arguments, Index: index, TypeID: t.ID(), Position: x.Position, Comma: x.Comma}
-		}
-
-		s[w] = v
-		w++
-	}
-	*p = s[:w]
-}
-
 func (l *linker) defineFunc(e extern, f *FunctionDefinition) (r int) {
 	r = len(l.out)
-	l.defined[e] = r
+	l.defined[e.unit][e.index] = r + 1
 	l.out = append(l.out, f)
-	unconvert(&f.Body)
-	for ip, v := range f.Body {
+	f.ExpandPositions() // The body is compacted below.
+	s := f.Body
+	w := 0
+	var static []int
+	for ip, v := range s {
 		switch x := v.(type) {
 		case
 			*Add,
+			*AddOv,
 			*AllocResult,
+			*Alloca,
 			*And,
+			*Annotate,
 			*Argument,
-			*Arguments,
 			*BeginScope,
 			*Bool,
-			*Call,
-			*CallFP,
+			*Bswap,
+			*Chain,
+			*Clz,
 			*Const32,
 			*Const64,
 			*ConstC128,
-			*Convert,
 			*Copy,
 			*Cpl,
+			*Ctz,
 			*Div,
 			*Drop,
 			*Dup,
 			*Element,
 			*EndScope,
 			*Eq,
+			*Fence,
 			*Field,
 			*FieldValue,
+			*Free,
 			*Geq,
 			*Gt,
 			*Jmp,
@@ -429,61 +1005,95 @@ func (l *linker) defineFunc(e extern, f *FunctionDefinition) (r int) {
 			*Jnz,
 			*Jz,
 			*Label,
+			*LabelAddr,
 			*Leq,
 			*Load,
 			*Lsh,
 			*Lt,
 			*Mul,
+			*MulOv,
 			*Neg,
 			*Neq,
+			*New,
 			*Nil,
 			*Not,
 			*Or,
 			*Panic,
+			*Popcnt,
 			*PostIncrement,
 			*PreIncrement,
 			*PtrDiff,
 			*Rem,
+			*RestoreContext,
 			*Result,
 			*Return,
 			*Rsh,
+			*SaveContext,
+			*Select,
 			*Store,
 			*StringConst,
 			*Sub,
-			*Switch,
+			*SubOv,
 			*Variable,
-			*Xor:
+			*Xor,
+			*Zero:
 			// nop
-		case *Const:
-			switch v := x.Value.(type) {
-			case *AddressValue:
-				switch v.Linkage {
-				case ExternalLinkage:
-					switch ex, ok := l.extern[v.NameID]; {
-					case ok:
-						v.Index = l.define(ex)
-					default:
-						panic(fmt.Errorf("ir.linker TODO\n%s", debug.Stack()))
+		case *Arguments:
+			if w != 0 {
+				switch y := s[w-1].(type) {
+				case *Global:
+					if y.Index < 0 { // Undefined.
+						break
 					}
-				case InternalLinkage:
-					switch ex, ok := l.intern[intern{v.NameID, e.unit}]; {
-					case ok:
-						v.Index = l.define(extern{unit: e.unit, index: ex})
-					default:
-						panic(fmt.Errorf("ir.linker TODO\n%s", debug.Stack()))
+
+					switch l.out[y.Index].(type) {
+					case *FunctionDefinition:
+						x.FunctionPointer = false
+						static = append(static, y.Index)
+						s[w-1] = x
+						continue
 					}
-				default:
-					panic(fmt.Errorf("internal error\n%s", debug.Stack()))
 				}
+			}
+
+			x.FunctionPointer = true
+			static = append(static, -1)
+		case *Call:
+			panic(fmt.Errorf("TODO\n%s", debug.Stack()))
+		case *CallFP:
+			n := len(static)
+			index := static[n-1]
+			static = static[:n-1]
+			if index < 0 {
+				break
+			}
+
+			t := l.typeCache.MustType(x.TypeID).(*PointerType).Element
+			if def := l.out[index].(*FunctionDefinition); !l.typeCache.Compatible(def.TypeID, t.ID()) {
+				l.errorf(x.Position, def.NameID, "call of %s through incompatible function pointer type %v\n\t%s: definition: %v", def.NameID, x.TypeID, def.Position, def.TypeID)
+			}
+			v = &Call{Arguments: x.Arguments, Index: index, TypeID: t.ID(), VarArgTypes: x.VarArgTypes, Position: x.Position, Comma: x.Comma}
+		case *Closure:
+			switch ex, ok := l.intern[e.unit][x.NameID]; {
+			case ok:
+				x.Index = l.define(extern{e.unit, ex})
+			default:
+				l.undefined(x.Position, x.NameID)
+				x.Index = -1
+			}
+		case *Const:
+			switch v := x.Value.(type) {
+			case *AddressValue:
+				l.address(e, x.Position, v)
 			default:
 				panic(fmt.Errorf("%s: ir.linker %T\n%s", x.Position, v, debug.Stack()))
 			}
 		case *Global:
 			switch x.Linkage {
-			case ExternalLinkage:
+			case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
 				switch ex, ok := l.extern[x.NameID]; {
 				case ok:
-					x.Index = l.define(ex)
+					x.Index, x.Offset = l.define(ex), l.offset(ex)
 				default:
 					var buf buffer.Bytes
 					buf.Write(dict.S(idBuiltinPrefix))
@@ -492,79 +1102,133 @@ func (l *linker) defineFunc(e extern, f *FunctionDefinition) (r int) {
 					buf.Close()
 					switch ex, ok := l.extern[nm]; {
 					case ok:
-						x.Index = l.define(ex)
+						x.Index, x.Offset = l.define(ex), l.offset(ex)
 					default:
-						panic(fmt.Errorf("%v: ir.linker undefined external global %v", x.Position, x.NameID))
+						l.undefined(x.Position, x.NameID)
+						x.Index = -1
 					}
 				}
+			case WeakExternalLinkage:
+				x.Index = -1
+				if ex, ok := l.extern[x.NameID]; ok {
+					x.Index, x.Offset = l.define(ex), l.offset(ex)
+				}
 			case InternalLinkage:
-				switch ex, ok := l.intern[intern{x.NameID, e.unit}]; {
+				switch ex, ok := l.intern[e.unit][x.NameID]; {
 				case ok:
-					x.Index = l.define(extern{e.unit, ex})
+					ex := extern{e.unit, ex}
+					x.Index, x.Offset = l.define(ex), l.offset(ex)
 				default:
-					panic(fmt.Errorf("%v: ir.linker undefined global %v", x.Position, x.NameID))
+					l.undefined(x.Position, x.NameID)
+					x.Index = -1
 				}
 			default:
 				panic(fmt.Errorf("internal error\n%s", debug.Stack()))
 			}
+			if x.Index >= 0 && l.out[x.Index].Base().TLS != x.TLS {
+				l.errorf(x.Position, x.NameID, "thread local storage mismatch of %s", x.NameID)
+			}
+		case *Convert:
+			if x.TypeID == x.Result {
+				continue
+			}
+		case *Switch:
+			for _, v := range x.Values {
+				if a, ok := v.(*AddressValue); ok {
+					l.address(e, x.Position, a)
+				}
+			}
 		case *VariableDeclaration:
-			l.initializer(x, x.Value)
+			if x.Value != nil {
+				if err := VerifyValue(x.Value, x.TypeID, l.typeCache); err != nil {
+					l.errorf(x.Position, f.NameID, "invalid initializer of variable #%v of %s: %v", x.Index, f.NameID, err)
+				}
+			}
+			l.initializer(e, x, x.Value)
 		default:
 			panic(fmt.Errorf("ir.linker internal error: %T %s %#05x %v\n%s", x, f.NameID, ip, x, debug.Stack()))
 		}
+
+		s[w] = v
+		w++
 	}
-	l.checkCalls(&f.Body)
+	f.Body = s[:w]
 	return r
 }
 
+// address resolves v referenced from unit e.unit.
+func (l *linker) address(e extern, pos token.Position, v *AddressValue) {
+	switch v.Linkage {
+	case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+		switch ex, ok := l.extern[v.NameID]; {
+		case ok:
+			v.Index = l.define(ex)
+			v.Offset += l.offset(ex)
+		default:
+			l.undefined(pos, v.NameID)
+			v.Index = -1
+		}
+	case WeakExternalLinkage:
+		v.Index = -1
+		if ex, ok := l.extern[v.NameID]; ok {
+			v.Index = l.define(ex)
+			v.Offset += l.offset(ex)
+		}
+	case InternalLinkage:
+		switch ex, ok := l.intern[e.unit][v.NameID]; {
+		case ok:
+			ex := extern{unit: e.unit, index: ex}
+			v.Index = l.define(ex)
+			v.Offset += l.offset(ex)
+		default:
+			l.undefined(pos, v.NameID)
+			v.Index = -1
+		}
+	default:
+		panic(fmt.Errorf("internal error\n%s", debug.Stack()))
+	}
+}
+
 func (l *linker) defineData(e extern, d *DataDefinition) (r int) {
 	r = len(l.out)
-	l.defined[e] = r
+	l.defined[e.unit][e.index] = r + 1
 	l.out = append(l.out, d)
+	if d.Value != nil {
+		if err := VerifyValue(d.Value, d.TypeID, l.typeCache); err != nil {
+			l.errorf(d.Position, d.NameID, "invalid initializer of %s: %v", d.NameID, err)
+		}
+	}
 	var f func(Value)
 	f = func(v Value) {
 		switch x := v.(type) {
 		case nil:
 		// nop
 		case *AddressValue:
-			switch x.Linkage {
-			case ExternalLinkage:
-				switch ex, ok := l.extern[x.NameID]; {
-				case ok:
-					x.Index = l.define(ex)
-				default:
-					panic(fmt.Errorf("%s: ir.linker undefined external address %q", d.Position, x.NameID))
-				}
-			case InternalLinkage:
-				switch ex, ok := l.intern[intern{x.NameID, e.unit}]; {
-				case ok:
-					x.Index = l.define(extern{unit: e.unit, index: ex})
-				default:
-					switch {
-					case Testing:
-						for k, v := range l.intern {
-							fmt.Printf("%q: %v\n", k.NameID, v)
-						}
-						fallthrough
-					default:
-						panic(fmt.Errorf("%s: ir.linker undefined address %q", d.Position, x.NameID))
-					}
-				}
-			default:
-				panic(fmt.Errorf("internal error\n%s", debug.Stack()))
+			l.address(e, d.Position, x)
+			if x.Index >= 0 && l.out[x.Index].Base().TLS {
+				l.errorf(d.Position, d.NameID, "address of thread local %s is not a constant", x.NameID)
 			}
 		case *CompositeValue:
 			for _, v := range x.Values {
 				f(v)
 			}
+		case *DesignatedValue:
+			f(x.Value)
+		case *DifferenceValue:
+			f(x.A)
+			f(x.B)
 		case
 			*Complex128Value,
 			*Complex64Value,
+			*Float128Value,
 			*Float32Value,
 			*Float64Value,
+			*Int128Value,
 			*Int32Value,
 			*Int64Value,
 			*StringValue,
+			*Uint32Value,
+			*Uint64Value,
 			*WideStringValue:
 			// ok, nop.
 		default:
@@ -575,12 +1239,28 @@ func (l *linker) defineData(e extern, d *DataDefinition) (r int) {
 	return r
 }
 
+// define returns the output index of the object e, adding it to the output
+// first if necessary. Aliases resolve to the index of their final target.
 func (l *linker) define(e extern) int {
-	if i, ok := l.defined[e]; ok {
-		return i
+	if i := l.defined[e.unit][e.index]; i != 0 {
+		if a, ok := l.out[i-1].(*AliasDefinition); ok {
+			if l.aliases[a] {
+				l.errorf(a.Position, a.NameID, "alias cycle involving %s", a.NameID)
+			}
+			return a.Index
+		}
+
+		return i - 1
 	}
 
-	switch x := l.in[e.unit][e.index].(type) {
+	o := l.in[e.unit][e.index]
+	if l.clone {
+		o = CloneObject(o)
+		l.in[e.unit][e.index] = o
+	}
+	switch x := o.(type) {
+	case *AliasDefinition:
+		return l.defineAlias(e, x)
 	case *DataDefinition:
 		return l.defineData(e, x)
 	case *FunctionDefinition:
@@ -590,14 +1270,66 @@ func (l *linker) define(e extern) int {
 	}
 }
 
+func (l *linker) defineAlias(e extern, a *AliasDefinition) int {
+	l.defined[e.unit][e.index] = len(l.out) + 1
+	l.out = append(l.out, a)
+	a.Index = -1
+	ex, ok := l.intern[e.unit][a.Target]
+	t := extern{unit: e.unit, index: ex}
+	if !ok {
+		if t, ok = l.extern[a.Target]; !ok {
+			l.undefined(a.Position, a.Target)
+			return -1
+		}
+	}
+
+	l.aliases[a] = true
+	a.Index = l.define(t)
+	a.Offset += l.offset(t)
+	delete(l.aliases, a)
+	return a.Index
+}
+
+// offset returns the offset of the alias e from its final target or zero if e
+// is not an alias.
+func (l *linker) offset(e extern) uintptr {
+	if a, ok := l.in[e.unit][e.index].(*AliasDefinition); ok {
+		return a.Offset
+	}
+
+	return 0
+}
+
+func (l *linker) errorf(pos token.Position, nm NameID, format string, arg ...interface{}) {
+	l.errors = append(l.errors, &LinkDiagnostic{NameID: nm, Position: pos, Msg: fmt.Sprintf(format, arg...)})
+}
+
+func (l *linker) undefined(pos token.Position, nm NameID) {
+	l.errorf(pos, nm, "undefined reference to %s", nm)
+}
+
 func (l *linker) linkMain() {
 	start, ok := l.extern[NameID(idStart)]
 	if !ok {
-		panic(fmt.Errorf("ir.linker _start undefined (forgotten crt0?)"))
+		l.errorf(token.Position{}, NameID(idStart), "_start undefined (forgotten crt0?)")
+		return
 	}
+
 	l.define(start)
 }
 
+func (l *linker) linkRoots(roots []NameID) {
+	for _, nm := range roots {
+		e, ok := l.extern[nm]
+		if !ok {
+			l.undefined(token.Position{}, nm)
+			continue
+		}
+
+		l.define(e)
+	}
+}
+
 func (l *linker) link() {
 	var a []int
 	for k := range l.extern {
@@ -4,9 +4,9 @@ package ir
 
 import "fmt"
 
-const _Linkage_name = "ExternalLinkageInternalLinkage"
+const _Linkage_name = "ExternalLinkageInternalLinkageOnceLinkageWeakLinkageWeakExternalLinkageCommonLinkage"
 
-var _Linkage_index = [...]uint8{0, 15, 30}
+var _Linkage_index = [...]uint8{0, 15, 30, 41, 52, 71, 84}
 
 func (i Linkage) String() string {
 	i -= 1
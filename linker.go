@@ -0,0 +1,292 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Linker links translation units incrementally, for example in a build daemon
+// relinking a program after recompiling one of its translation units. The
+// symbols of a translation unit are collected when it is added and the
+// resolution of every external name is kept between links, so linking again
+// after ReplaceUnit resolves only the names defined by the replaced unit.
+//
+// The units are copied when added, see CloneObject. Linking works on copies
+// of only the linked objects, so neither the objects passed to a Linker nor
+// its state are mutated by linking. The zero value is ready to use. A Linker
+// is not safe for concurrent use by multiple goroutines.
+type Linker struct {
+	// Types resolves the TypeIDs of the linked objects for all links. A
+	// new TypeCache is used if nil.
+	Types Types
+
+	defs   map[NameID][]extern  // name: external definitions in link order.
+	dirty  map[NameID]bool      // Names to resolve before linking.
+	errors map[NameID]LinkError // name: problems found resolving it.
+	extern map[NameID]extern    // name: resolved definition.
+	stub   *linkerUnit          // Defines main if no unit does, see LinkLib.
+	target Target               // Of the resolution of the names.
+	units  []*linkerUnit
+	work   [][]Object // unit: objects, resolving a name updates copies of its data definitions.
+}
+
+// linkerUnit is a translation unit added to a Linker.
+type linkerUnit struct {
+	extern []int          // Indices of the objects not having internal linkage.
+	intern map[NameID]int // name: index of the object having internal linkage.
+	main   bool           // The unit defines function main.
+	objs   []Object
+}
+
+func newLinkerUnit(objs []Object, unit int) (*linkerUnit, error) {
+	u := &linkerUnit{intern: map[NameID]int{}, objs: make([]Object, len(objs))}
+	for i, v := range objs {
+		switch v.(type) {
+		case *AliasDefinition, *DataDefinition, *FunctionDefinition:
+			u.objs[i] = CloneObject(v)
+		default:
+			return nil, fmt.Errorf("unexpected object %T", v)
+		}
+	}
+	renameInternal(u.objs, unit)
+	for i, v := range u.objs {
+		switch b := v.Base(); b.Linkage {
+		case InternalLinkage:
+			u.intern[b.NameID] = i
+		default:
+			u.extern = append(u.extern, i)
+			if _, ok := v.(*FunctionDefinition); ok && b.NameID == idMain {
+				u.main = true
+			}
+		}
+	}
+	return u, nil
+}
+
+func (l *Linker) init() {
+	if l.defs == nil {
+		l.defs = map[NameID][]extern{}
+		l.dirty = map[NameID]bool{}
+		l.errors = map[NameID]LinkError{}
+		l.extern = map[NameID]extern{}
+	}
+	l.Types = newTypes(l.Types)
+}
+
+// AddUnit adds the translation unit unit to l. Units are numbered from zero
+// in the order they were added.
+func (l *Linker) AddUnit(unit []Object) error {
+	u, err := newLinkerUnit(unit, len(l.units))
+	if err != nil {
+		return err
+	}
+
+	l.init()
+	l.units = append(l.units, u)
+	l.work = append(l.work, append([]Object(nil), u.objs...))
+	l.addDefs(len(l.units) - 1)
+	return nil
+}
+
+// ReplaceUnit replaces the translation unit number i of l by unit.
+func (l *Linker) ReplaceUnit(i int, unit []Object) error {
+	if i < 0 || i >= len(l.units) {
+		return fmt.Errorf("translation unit %v out of range", i)
+	}
+
+	u, err := newLinkerUnit(unit, i)
+	if err != nil {
+		return err
+	}
+
+	for _, j := range l.units[i].extern {
+		nm := l.units[i].objs[j].Base().NameID
+		a := l.defs[nm][:0]
+		for _, v := range l.defs[nm] {
+			if v.unit != i {
+				a = append(a, v)
+			}
+		}
+		l.defs[nm] = a
+		l.dirty[nm] = true
+	}
+	l.units[i] = u
+	l.work[i] = append([]Object(nil), u.objs...)
+	l.addDefs(i)
+	return nil
+}
+
+// addDefs adds the external definitions of unit to l.defs.
+func (l *Linker) addDefs(unit int) {
+	for _, i := range l.units[unit].extern {
+		nm := l.units[unit].objs[i].Base().NameID
+		a := l.defs[nm]
+		j := sort.Search(len(a), func(j int) bool { return a[j].unit > unit })
+		a = append(a, extern{})
+		copy(a[j+1:], a[j:])
+		a[j] = extern{unit, i}
+		l.defs[nm] = a
+		l.dirty[nm] = true
+	}
+}
+
+// resolve resolves the names defined by the units added or replaced since the
+// last call.
+func (l *Linker) resolve() {
+	for nm := range l.dirty {
+		delete(l.dirty, nm)
+		delete(l.errors, nm)
+		delete(l.extern, nm)
+		a := l.defs[nm]
+		if len(a) == 0 {
+			delete(l.defs, nm)
+			continue
+		}
+
+		for _, e := range a {
+			if d, ok := l.units[e.unit].objs[e.index].(*DataDefinition); ok {
+				c := *d // Collecting may update the type and value of d.
+				l.work[e.unit][e.index] = &c
+			}
+		}
+		k := &linker{extern: map[NameID]extern{}, in: l.work, target: l.target, typeCache: l.Types}
+		for _, e := range a {
+			k.collect(e.unit, e.index)
+		}
+		if e, ok := k.extern[nm]; ok {
+			l.extern[nm] = e
+		}
+		if len(k.errors) != 0 {
+			l.errors[nm] = k.errors
+		}
+	}
+}
+
+// Units returns the number of translation units of l.
+func (l *Linker) Units() int { return len(l.units) }
+
+// Finalize links the translation units of l like LinkMain.
+func (l *Linker) Finalize() (_ []Object, err error) {
+	if !Testing {
+		defer func() {
+			switch x := recover().(type) {
+			case nil:
+				// nop
+			case error:
+				if err == nil {
+					err = x
+				}
+			default:
+				err = fmt.Errorf("ir.Linker.Finalize PANIC: %v", x)
+			}
+		}()
+	}
+	return l.finalize(false, HostTarget(), nil)
+}
+
+// FinalizeLib links the translation units of l like LinkLibWithOptions
+// amended by opts, which may be nil.
+func (l *Linker) FinalizeLib(opts *LinkLibOptions) (_ []Object, err error) {
+	if !Testing {
+		defer func() {
+			switch x := recover().(type) {
+			case nil:
+				// nop
+			case error:
+				if err == nil {
+					err = x
+				}
+			default:
+				err = fmt.Errorf("ir.Linker.FinalizeLib PANIC: %v", x)
+			}
+		}()
+	}
+	return l.finalize(true, opts.target(), opts)
+}
+
+func (l *Linker) finalize(lib bool, t Target, opts *LinkLibOptions) ([]Object, error) {
+	l.init()
+	if t != l.target {
+		l.target = t
+		for nm := range l.defs {
+			l.dirty[nm] = true
+		}
+	}
+	l.resolve()
+	n := len(l.units)
+	k := &linker{
+		aliases:   map[*AliasDefinition]bool{},
+		clone:     true,
+		defined:   make([][]int, n, n+1),
+		extern:    l.extern,
+		in:        make([][]Object, n, n+1),
+		intern:    make([]map[NameID]int, n, n+1),
+		target:    t,
+		typeCache: l.Types,
+	}
+	for unit, u := range l.units {
+		k.defined[unit] = make([]int, len(u.objs))
+		k.in[unit] = append([]Object(nil), l.work[unit]...) // Linking replaces the linked objects by their copies.
+		k.intern[unit] = u.intern                           // Not mutated when linking.
+	}
+
+	// Report the problems ordered by the first definitions of their names.
+	var names []NameID
+	for nm := range l.errors {
+		names = append(names, nm)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := l.defs[names[i]][0], l.defs[names[j]][0]
+		return a.unit < b.unit || a.unit == b.unit && a.index < b.index
+	})
+	for _, nm := range names {
+		k.errors = append(k.errors, l.errors[nm]...)
+	}
+
+	if lib {
+		ok := false
+		for _, v := range l.units {
+			ok = ok || v.main
+		}
+		if !ok {
+			if l.stub == nil {
+				u, err := newLinkerUnit(main, -1)
+				if err != nil {
+					return nil, err
+				}
+
+				l.stub = u
+			}
+
+			// The stub unit is the last one, collecting its symbols
+			// must not change the resolution kept by l.
+			k.extern = make(map[NameID]extern, len(l.extern)+1)
+			for nm, e := range l.extern {
+				k.extern[nm] = e
+			}
+			k.defined = append(k.defined, make([]int, len(l.stub.objs)))
+			k.in = append(k.in, append([]Object(nil), l.stub.objs...))
+			k.intern = append(k.intern, l.stub.intern)
+			for _, i := range l.stub.extern {
+				k.collect(n, i)
+			}
+		}
+	}
+	switch {
+	case !lib:
+		k.linkMain()
+	case opts != nil && opts.GC:
+		k.linkRoots(opts.Roots)
+	default:
+		k.link()
+	}
+	if len(k.errors) != 0 {
+		return nil, k.errors
+	}
+
+	return k.out, nil
+}
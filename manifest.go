@@ -0,0 +1,136 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	manifestVersion = 1 // Format version of Manifest.
+)
+
+// Manifest describes the provenance of serialized Objects, a Module or a
+// TypeCache. It is recorded in the gzip header of the stream, so it can be
+// read without decoding the data, see ReadManifest.
+//
+// The manifest is stored as a JSON object, fields added in the future are
+// ignored by older readers. Version changes only when the manifest cannot be
+// understood by older readers anymore.
+type Manifest struct {
+	BinaryVersion   int               // Compatibility version of the data.
+	Checksums       map[string]string // Algorithm: hex encoded checksum of the uncompressed data, like "sha256": "e3b0...". May be nil.
+	Features        []string          // Feature flags recorded by the producer, sorted. May be nil.
+	Producer        string            // Name of the producer, like "ccgo". May be empty.
+	ProducerVersion string            // Version of the producer. May be empty.
+	Target          Target            // Target of the data.
+	Version         int               // Format version of the manifest. Zero for the legacy format.
+}
+
+// ReadManifest reads the gzip header of the data written to r by WriteTo or
+// WriteToOptions of Objects or a Module and returns its manifest. Data written
+// by older versions of this package have a manifest with zero Version and no
+// producer, feature flags and checksums.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(gr.Header.Extra)
+}
+
+// HasFeature reports whether the feature flag s is recorded in m.
+func (m *Manifest) HasFeature(s string) bool {
+	for _, v := range m.Features {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseManifest returns the manifest stored in the gzip header extra field
+// b. The legacy format is magic followed by "GOOS|GOARCH|version".
+func parseManifest(b []byte) (*Manifest, error) {
+	if len(b) < len(magic) || !bytes.Equal(b[:len(magic)], magic) {
+		return nil, fmt.Errorf("unrecognized file format")
+	}
+
+	b = b[len(magic):]
+	if len(b) != 0 && b[0] == '{' {
+		var m Manifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("corrupted file: %v", err)
+		}
+
+		if m.Version < 1 || m.Version > manifestVersion {
+			return nil, fmt.Errorf("unsupported manifest version %v", m.Version)
+		}
+
+		return &m, nil
+	}
+
+	a := bytes.Split(b, []byte{'|'})
+	if len(a) != 3 {
+		return nil, fmt.Errorf("corrupted file")
+	}
+
+	ver, err := strconv.ParseUint(string(a[2]), 10, 31)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{BinaryVersion: int(ver), Target: Target{OS: string(a[0]), Arch: string(a[1])}}, nil
+}
+
+// extra returns the gzip header extra field storing m.
+func (m *Manifest) extra() ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte(nil), magic...), b...), nil
+}
+
+// decoder is a gob decoder of a stream written by encode. It computes the
+// checksums of the data it reads.
+type decoder struct {
+	*gob.Decoder
+	Manifest *Manifest
+
+	dict   Dictionary  // Identifiers are registered in dict.
+	global map[int]int // ID in the global dictionary: ID in dict.
+	h      hash.Hash   // Nil if there is no known checksum.
+	ids    []int       // Placeholder index: ID.
+	r      io.Reader
+}
+
+// verify reads the rest of the data and checks its checksums, if any.
+func (d *decoder) verify() error {
+	if d.h == nil {
+		return nil
+	}
+
+	if _, err := io.Copy(ioutil.Discard, d.r); err != nil {
+		return err
+	}
+
+	if g, e := hex.EncodeToString(d.h.Sum(nil)), d.Manifest.Checksums["sha256"]; g != e {
+		return fmt.Errorf("corrupted file: sha256 checksum mismatch")
+	}
+
+	return nil
+}
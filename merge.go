@@ -0,0 +1,504 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Merge combines the translation units of o and other into a single
+// translation unit, for example before linking an amalgamated build.
+//
+// Objects with internal linkage never clash. If needed, they are renamed,
+// together with all references to them, to keep their names unique in the
+// result. Multiple definitions of an external name are resolved the same way
+// the linker does: OnceLinkage and WeakLinkage definitions and definitions
+// consisting only of a Panic operation yield to other definitions and an
+// external DataDefinition without a value adopts the value of another
+// definition of the same type. CommonLinkage definitions are merged like the
+// linker merges them for target, the host target if zero, see
+// LinkLibOptions.Target. All other clashes are handled according to policy.
+//
+// Merge may mutate the passed objects.
+func (o Objects) Merge(other Objects, policy ConflictPolicy, target Target) ([]Object, error) {
+	if target == (Target{}) {
+		target = HostTarget()
+	}
+
+	var (
+		extern = map[NameID]int{}      // name: index in r
+		intern = map[NameID]struct{}{} // Names of internal objects in r.
+		r      []Object
+	)
+	units := append(append(Objects(nil), o...), other...)
+	for unit, objs := range units {
+		var renameIntern, renameExtern map[NameID]NameID
+		for _, v := range objs {
+			b := v.Base()
+			switch b.Linkage {
+			case InternalLinkage:
+				if _, ok := intern[b.NameID]; ok {
+					nm := uniqueName(b.NameID, unit, func(nm NameID) bool { _, ok := intern[nm]; return ok })
+					if renameIntern == nil {
+						renameIntern = map[NameID]NameID{}
+					}
+					renameIntern[b.NameID] = nm
+					b.NameID = nm
+				}
+				intern[b.NameID] = struct{}{}
+				r = append(r, v)
+			case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
+				i, ok := extern[b.NameID]
+				if !ok {
+					extern[b.NameID] = len(r)
+					r = append(r, v)
+					break
+				}
+
+				keep, err := mergeExtern(r[i], v, target)
+				if err != nil {
+					switch policy {
+					case ConflictError:
+						return nil, err
+					case ConflictKeepFirst:
+						keep = r[i]
+					case ConflictRename:
+						nm := uniqueName(b.NameID, unit, func(nm NameID) bool { _, ok := extern[nm]; return ok })
+						if renameExtern == nil {
+							renameExtern = map[NameID]NameID{}
+						}
+						renameExtern[b.NameID] = nm
+						b.NameID = nm
+						extern[nm] = len(r)
+						r = append(r, v)
+						continue
+					default:
+						return nil, fmt.Errorf("invalid conflict policy %v", policy)
+					}
+				}
+
+				r[i] = keep
+			default:
+				return nil, fmt.Errorf("%s: invalid linkage %v of %s", b.Position, b.Linkage, b.NameID)
+			}
+		}
+		if renameIntern != nil || renameExtern != nil {
+			renameReferences(objs, renameIntern, renameExtern)
+		}
+	}
+	return r, nil
+}
+
+// mergeExtern returns the object to keep of two definitions of the same
+// external name or an error if they clash. Common data are sized for t.
+func mergeExtern(def, x Object, t Target) (Object, error) {
+	db, xb := def.Base(), x.Base()
+	if db.TLS != xb.TLS {
+		return nil, fmt.Errorf("thread local storage mismatch of %s\n\t%s\n\t%s", xb.NameID, db.Position, xb.Position)
+	}
+
+	switch {
+	case xb.Linkage.weak():
+		return def, nil
+	case db.Linkage.weak():
+		return x, nil
+	}
+
+	switch d := def.(type) {
+	case *DataDefinition:
+		if x, ok := x.(*DataDefinition); ok && (x.Linkage == CommonLinkage || d.Linkage == CommonLinkage) {
+			keep, ok, err := mergeCommon(d, x, TypeCache{}, t)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				return keep, nil
+			}
+
+			break
+		}
+
+		if x, ok := x.(*DataDefinition); ok && x.TypeID == d.TypeID {
+			switch {
+			case x.Value == nil:
+				return d, nil
+			case d.Value == nil:
+				d.Value = x.Value
+				return d, nil
+			}
+		}
+	case *FunctionDefinition:
+		if x, ok := x.(*FunctionDefinition); ok {
+			switch {
+			case isPanicStub(x):
+				return d, nil
+			case isPanicStub(d):
+				return x, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("multiple definitions of %s\n\t%s\n\t%s", xb.NameID, db.Position, xb.Position)
+}
+
+func isPanicStub(f *FunctionDefinition) bool {
+	if len(f.Body) != 1 {
+		return false
+	}
+
+	_, ok := f.Body[0].(*Panic)
+	return ok
+}
+
+// uniqueName returns a name derived from nm and unit for which used returns
+// false.
+func uniqueName(nm NameID, unit int, used func(NameID) bool) NameID {
+	for i := 0; ; i++ {
+		s := fmt.Sprintf("%s.%d", nm, unit)
+		if i != 0 {
+			s = fmt.Sprintf("%s.%d.%d", nm, unit, i)
+		}
+		if r := NameID(dict.SID(s)); !used(r) {
+			return r
+		}
+	}
+}
+
+// renameInternal renames all but the first definition of every internal name
+// defined more than once in the translation unit objs, which legally happens
+// after concatenating preprocessed sources, and updates the references to
+// them. An object refers to the nearest preceding definition of the name in
+// the same file, as given by the Position.Filename of the definitions, or to
+// the nearest following one if there is none. Objects in a file having no
+// such definition, for example a static function of a header included by
+// several of the concatenated sources, refer likewise to the nearest
+// definition in any file.
+func renameInternal(objs []Object, unit int) {
+	defs := map[NameID][]int{} // name: indices in objs
+	dup := false
+	for i, v := range objs {
+		if b := v.Base(); b.Linkage == InternalLinkage {
+			a := defs[b.NameID]
+			dup = dup || len(a) != 0
+			defs[b.NameID] = append(a, i)
+		}
+	}
+	if !dup {
+		return
+	}
+
+	used := func(nm NameID) bool { _, ok := defs[nm]; return ok }
+	for i, v := range objs {
+		b := v.Base()
+		a := defs[b.NameID]
+		if b.Linkage != InternalLinkage || len(a) < 2 || a[0] != i {
+			continue
+		}
+
+		nm := b.NameID
+		names := map[int]NameID{i: nm} // index in objs: name
+		for _, j := range a[1:] {
+			n := uniqueName(nm, unit, used)
+			defs[n] = nil
+			names[j] = n
+			objs[j].Base().NameID = n
+		}
+		for j, v := range objs {
+			var same []int
+			for _, k := range a {
+				if objs[k].Base().Filename == v.Base().Filename {
+					same = append(same, k)
+				}
+			}
+			if len(same) == 0 {
+				same = a
+			}
+			k := same[0]
+			for _, l := range same {
+				if l <= j {
+					k = l
+				}
+			}
+			if k != i {
+				renameReferences([]Object{v}, map[NameID]NameID{nm: names[k]}, nil)
+			}
+		}
+	}
+}
+
+// renameReferences updates the references to renamed objects in objs.
+func renameReferences(objs []Object, intern, extern map[NameID]NameID) {
+	rename := func(l Linkage, nm *NameID) {
+		m := extern
+		if l == InternalLinkage {
+			m = intern
+		}
+		if n, ok := m[*nm]; ok {
+			*nm = n
+		}
+	}
+	var value func(Value)
+	value = func(v Value) {
+		switch x := v.(type) {
+		case *AddressValue:
+			rename(x.Linkage, &x.NameID)
+		case *CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *DesignatedValue:
+			value(x.Value)
+		case *DifferenceValue:
+			value(x.A)
+			value(x.B)
+		}
+	}
+	for _, v := range objs {
+		switch x := v.(type) {
+		case *AliasDefinition:
+			if n, ok := intern[x.Target]; ok {
+				x.Target = n
+				break
+			}
+
+			rename(ExternalLinkage, &x.Target)
+		case *DataDefinition:
+			value(x.Value)
+		case *FunctionDefinition:
+			for _, op := range x.Body {
+				switch y := op.(type) {
+				case *Closure:
+					rename(InternalLinkage, &y.NameID)
+				case *Const:
+					value(y.Value)
+				case *Global:
+					rename(y.Linkage, &y.NameID)
+				case *Switch:
+					for _, v := range y.Values {
+						value(v)
+					}
+				case *VariableDeclaration:
+					value(y.Value)
+				}
+			}
+		}
+	}
+}
+
+// MergeConstants merges identical read-only data of objs, which must be
+// linked, ie. produced by LinkMain or LinkLib. Of the DataDefinitions with
+// internal linkage, the ReadOnly flag set, no thread local storage and the
+// same type and value only the first one is kept, the indices and names of
+// all references to the others are updated accordingly. Data referring to
+// merged data may become identical as well and it is merged in turn. Mutable
+// data is never merged, front ends opt out of merging by not setting
+// ReadOnly.
+//
+// Identical string literals share their StringID already. A StringValue
+// which string is a suffix of another string used by a StringValue or a
+// StringConst is changed to refer to the longer string at the respective
+// offset, so backends emit the common tail only once.
+//
+// MergeConstants mutates objs.
+func MergeConstants(objs []Object) ([]Object, error) {
+	for {
+		keys := map[string]int{}
+		repl := map[int]int{} // Index of a merged object: index of the kept object.
+		for i, v := range objs {
+			d, ok := v.(*DataDefinition)
+			if !ok || !d.ReadOnly || d.TLS || d.Linkage != InternalLinkage {
+				continue
+			}
+
+			k := fmt.Sprintf("%v %v", d.TypeID, d.Value)
+			if j, ok := keys[k]; ok {
+				repl[i] = j
+				continue
+			}
+
+			keys[k] = i
+		}
+		if len(repl) == 0 {
+			break
+		}
+
+		var err error
+		if objs, err = mergeRemap(objs, repl); err != nil {
+			return nil, err
+		}
+	}
+	mergeStrings(objs)
+	return objs, nil
+}
+
+// mergeRemap removes the objects replaced according to repl from objs and
+// updates all references.
+func mergeRemap(objs []Object, repl map[int]int) ([]Object, error) {
+	index := make([]int, len(objs)) // Old index: new index.
+	var r []Object
+	for i, v := range objs {
+		if _, ok := repl[i]; ok {
+			continue
+		}
+
+		index[i] = len(r)
+		r = append(r, v)
+	}
+	for i, j := range repl {
+		index[i] = index[j]
+	}
+
+	var err error
+	seen := map[interface{}]bool{}
+	ref := func(p interface{}, i *int, nm *NameID) {
+		if *i < 0 || seen[p] {
+			return
+		}
+
+		seen[p] = true
+		if *i >= len(objs) {
+			if err == nil {
+				err = fmt.Errorf("invalid object index %v", *i)
+			}
+			return
+		}
+
+		if j, ok := repl[*i]; ok && nm != nil {
+			*nm = objs[j].Base().NameID
+		}
+		*i = index[*i]
+	}
+	var value func(Value)
+	value = func(v Value) {
+		switch x := v.(type) {
+		case *AddressValue:
+			ref(x, &x.Index, &x.NameID)
+		case *CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *DesignatedValue:
+			value(x.Value)
+		case *DifferenceValue:
+			value(x.A)
+			value(x.B)
+		}
+	}
+	for _, v := range r {
+		switch x := v.(type) {
+		case *AliasDefinition:
+			ref(x, &x.Index, &x.Target)
+		case *DataDefinition:
+			value(x.Value)
+		case *FunctionDefinition:
+			for _, op := range x.Body {
+				switch y := op.(type) {
+				case *Call:
+					ref(y, &y.Index, nil)
+				case *Closure:
+					ref(y, &y.Index, nil)
+				case *Const:
+					value(y.Value)
+				case *Global:
+					ref(y, &y.Index, &y.NameID)
+				case *Switch:
+					for _, v := range y.Values {
+						value(v)
+					}
+				case *VariableDeclaration:
+					value(y.Value)
+				}
+			}
+		}
+	}
+	return r, err
+}
+
+// mergeStrings changes the StringValues of objs which string is a suffix of
+// another used string to refer to the longer one.
+func mergeStrings(objs []Object) {
+	var values []*StringValue
+	used := map[StringID]bool{}
+	var value func(Value)
+	value = func(v Value) {
+		switch x := v.(type) {
+		case *CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *DesignatedValue:
+			value(x.Value)
+		case *StringValue:
+			values = append(values, x)
+			used[x.StringID] = true
+		}
+	}
+	for _, v := range objs {
+		switch x := v.(type) {
+		case *DataDefinition:
+			value(x.Value)
+		case *FunctionDefinition:
+			for _, op := range x.Body {
+				switch y := op.(type) {
+				case *Const:
+					value(y.Value)
+				case *StringConst:
+					used[y.Value] = true
+				case *Switch:
+					for _, v := range y.Values {
+						value(v)
+					}
+				case *VariableDeclaration:
+					value(y.Value)
+				}
+			}
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	// Sorting the strings by their reversed bytes makes a string
+	// immediately precede the strings it is a suffix of.
+	type str struct {
+		id StringID
+		r  []byte // Reversed.
+	}
+	a := make([]str, 0, len(used))
+	for id := range used {
+		s := dict.S(int(id))
+		r := make([]byte, len(s))
+		for i, c := range s {
+			r[len(s)-1-i] = c
+		}
+		a = append(a, str{id, r})
+	}
+	sort.Slice(a, func(i, j int) bool { return bytes.Compare(a[i].r, a[j].r) < 0 })
+	target := map[StringID]StringID{}
+	for i := len(a) - 2; i >= 0; i-- {
+		if !bytes.HasPrefix(a[i+1].r, a[i].r) {
+			continue
+		}
+
+		t := a[i+1].id
+		if u, ok := target[t]; ok {
+			t = u
+		}
+		target[a[i].id] = t
+	}
+	seen := map[*StringValue]bool{}
+	for _, v := range values {
+		t, ok := target[v.StringID]
+		if !ok || seen[v] {
+			continue
+		}
+
+		seen[v] = true
+		v.Offset += uintptr(len(dict.S(int(t))) - len(dict.S(int(v.StringID))))
+		v.StringID = t
+	}
+}
@@ -5,6 +5,7 @@
 package ir
 
 import (
+	"encoding/binary"
 	"fmt"
 	"runtime"
 
@@ -24,19 +25,60 @@ type MemoryModelItem struct {
 	Size        uint
 	Align       uint
 	StructAlign uint
+	Endianness  Endianness // Byte order of values of the type kind.
 }
 
 // MemoryModel defines properties of types. A valid memory model must provide
-// model items for all type kinds except Array, Struct and Union. Methods of
-// invalid models may panic. Memory model instances are not modified by this
-// package and safe for concurrent use by multiple goroutines as long as any of
-// them does not modify them either.
+// model items for all type kinds except Array, Struct, Union, Function,
+// Float16, Int128 and Uint128. A model without the Float16 item does not
+// support float16 values, a model without the Int128 and Uint128 items does
+// not support 128 bit integers.
+// Methods of invalid models may panic. Memory model instances are not
+// modified by this package and safe for concurrent use by multiple goroutines
+// as long as any of them does not modify them either.
+//
+// Function designators are never sized by themselves, only pointers to them
+// are. If the Function item is present, it defines the properties of function
+// types, otherwise the Pointer item is used. Models of Harvard architecture
+// targets can thus omit the Function item instead of making up a code space
+// size.
+//
+// Vector types are sized by their items and are aligned to their size rounded
+// up to a power of two. The optional Vector item limits the alignments of
+// vector types to its Align and StructAlign, its Size is ignored.
+//
+// The byte order of the target is the Endianness of the Pointer item, see
+// ByteOrder.
 type MemoryModel map[TypeKind]MemoryModelItem
 
+var requiredModelItems = []TypeKind{
+	Int8, Int16, Int32, Int64,
+	Uint8, Uint16, Uint32, Uint64,
+	Float32, Float64, Float128,
+	Complex64, Complex128, Complex256,
+	Pointer,
+}
+
+// Target identifies the platform IR is produced for.
+type Target struct {
+	OS   string // Like runtime.GOOS.
+	Arch string // Like runtime.GOARCH.
+}
+
+// HostTarget returns the Target of the current platform.
+func HostTarget() Target { return Target{OS: runtime.GOOS, Arch: runtime.GOARCH} }
+
+func (t Target) String() string { return t.OS + "/" + t.Arch }
+
 // NewMemoryModel returns a new MemoryModel for the current architecture and
 // platform or an error, if any.
-func NewMemoryModel() (MemoryModel, error) {
-	switch arch := runtime.GOARCH; arch {
+func NewMemoryModel() (MemoryModel, error) { return NewMemoryModelFor(HostTarget()) }
+
+// NewMemoryModelFor returns a new MemoryModel for target t or an error, if
+// any.
+func NewMemoryModelFor(t Target) (MemoryModel, error) {
+	var m MemoryModel
+	switch arch := t.Arch; arch {
 	case
 		"386",
 		"arm",
@@ -49,7 +91,7 @@ func NewMemoryModel() (MemoryModel, error) {
 		"s390x",
 		"sparc":
 
-		return MemoryModel{
+		m = MemoryModel{
 			Int8:  MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
 			Int16: MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
 			Int32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
@@ -60,6 +102,7 @@ func NewMemoryModel() (MemoryModel, error) {
 			Uint32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Uint64: MemoryModelItem{Align: 4, Size: 8, StructAlign: 4},
 
+			Float16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
 			Float32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Float64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 4},
 			Float128: MemoryModelItem{Align: 8, Size: 16, StructAlign: 4},
@@ -70,24 +113,27 @@ func NewMemoryModel() (MemoryModel, error) {
 
 			Pointer:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Function: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-		}, nil
+		}
 
 	case
 		"amd64p32",
 		"mips64p32",
 		"mips64p32le":
 
-		return MemoryModel{
-			Int8:  MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
-			Int16: MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
-			Int32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-			Int64: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+		m = MemoryModel{
+			Int8:   MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+			Int16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+			Int32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+			Int64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Int128: MemoryModelItem{Align: 16, Size: 16, StructAlign: 16},
 
-			Uint8:  MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
-			Uint16: MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
-			Uint32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-			Uint64: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Uint8:   MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+			Uint16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+			Uint32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+			Uint64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Uint128: MemoryModelItem{Align: 16, Size: 16, StructAlign: 16},
 
+			Float16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
 			Float32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Float64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
 			Float128: MemoryModelItem{Align: 8, Size: 16, StructAlign: 8},
@@ -98,7 +144,7 @@ func NewMemoryModel() (MemoryModel, error) {
 
 			Pointer:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Function: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-		}, nil
+		}
 
 	case
 		"amd64",
@@ -109,17 +155,20 @@ func NewMemoryModel() (MemoryModel, error) {
 		"ppc64",
 		"sparc64":
 
-		return MemoryModel{
-			Int8:  MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
-			Int16: MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
-			Int32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-			Int64: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+		m = MemoryModel{
+			Int8:   MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+			Int16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+			Int32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+			Int64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Int128: MemoryModelItem{Align: 16, Size: 16, StructAlign: 16},
 
-			Uint8:  MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
-			Uint16: MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
-			Uint32: MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
-			Uint64: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Uint8:   MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+			Uint16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+			Uint32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+			Uint64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+			Uint128: MemoryModelItem{Align: 16, Size: 16, StructAlign: 16},
 
+			Float16:  MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
 			Float32:  MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
 			Float64:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
 			Float128: MemoryModelItem{Align: 8, Size: 16, StructAlign: 8},
@@ -130,69 +179,262 @@ func NewMemoryModel() (MemoryModel, error) {
 
 			Pointer:  MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
 			Function: MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
-		}, nil
+		}
 	default:
 		return nil, fmt.Errorf("unknown or unsupported architecture %s", arch)
 	}
+
+	switch t.Arch {
+	case "armbe", "arm64be", "mips", "mips64", "mips64p32", "ppc", "ppc64", "s390", "s390x", "sparc", "sparc64":
+		for k, v := range m {
+			v.Endianness = BigEndian
+			m[k] = v
+		}
+	}
+	return m, nil
+}
+
+// Validate returns an error, if any, if m is not a valid memory model.
+func (m MemoryModel) Validate() error {
+	for _, k := range requiredModelItems {
+		if _, ok := m[k]; !ok {
+			return fmt.Errorf("missing model item for %s", k)
+		}
+	}
+	for k, v := range m {
+		switch k {
+		case Array, Struct, Union:
+			return fmt.Errorf("unexpected model item for %s", k)
+		}
+
+		if v.Size == 0 && k != Vector {
+			return fmt.Errorf("invalid size of %s: %v", k, v.Size)
+		}
+
+		if v.Align == 0 || v.Align&(v.Align-1) != 0 {
+			return fmt.Errorf("invalid alignment of %s: %v", k, v.Align)
+		}
+
+		if v.StructAlign == 0 || v.StructAlign&(v.StructAlign-1) != 0 {
+			return fmt.Errorf("invalid struct field alignment of %s: %v", k, v.StructAlign)
+		}
+
+		if v.Endianness != LittleEndian && v.Endianness != BigEndian {
+			return fmt.Errorf("invalid endianness of %s: %v", k, v.Endianness)
+		}
+	}
+	return nil
+}
+
+func (m MemoryModel) item(k TypeKind) MemoryModelItem {
+	if k == Void {
+		panic(fmt.Errorf("void is an incomplete type"))
+	}
+
+	item, ok := m[k]
+	if !ok && k == Function {
+		item, ok = m[Pointer]
+	}
+	if !ok {
+		panic(fmt.Errorf("missing model item for %s", k))
+	}
+
+	return item
+}
+
+func incomplete(t Type) {
+	panic(fmt.Errorf("%s is an incomplete type", t.ID()))
+}
+
+// ByteOrder returns the byte order of m, that of its Pointer item.
+func (m MemoryModel) ByteOrder() binary.ByteOrder {
+	if m.item(Pointer).Endianness == BigEndian {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
+
+// EncodeInt stores the len(b) least significant bytes of n in b using the
+// byte order of type kind k.
+func (m MemoryModel) EncodeInt(b []byte, k TypeKind, n uint64) {
+	if m.item(k).Endianness == BigEndian {
+		for i := len(b) - 1; i >= 0; i-- {
+			b[i] = byte(n)
+			n >>= 8
+		}
+		return
+	}
+
+	for i := range b {
+		b[i] = byte(n)
+		n >>= 8
+	}
+}
+
+// DecodeInt returns the unsigned integer stored in b using the byte order of
+// type kind k. It is the inverse of EncodeInt.
+func (m MemoryModel) DecodeInt(b []byte, k TypeKind) uint64 {
+	var n uint64
+	if m.item(k).Endianness == BigEndian {
+		for _, v := range b {
+			n = n<<8 | uint64(v)
+		}
+		return n
+	}
+
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n
 }
 
 // Alignof computes the memory alignment requirements of t. Zero is returned
-// for a struct/union type with no fields.
+// for a struct/union type with no fields. Alignof panics with an error if t is
+// not complete, see IsComplete.
+//
+// The alignment of a struct or union is the greatest StructAlignof of its
+// fields, so a struct or union containing a float64 is aligned to 4 bytes on
+// 386, where a float64 variable is aligned to 8 bytes, and its size is a
+// multiple of 4 bytes, like in the C ABI.
 func (m MemoryModel) Alignof(t Type) int {
 	switch x := t.(type) {
 	case *ArrayType:
+		if x.Items < 0 {
+			incomplete(t)
+		}
+
 		return mathutil.Max(1, m.Alignof(x.Item))
 	case *StructOrUnionType:
-		var r int
-		for _, v := range x.Fields {
-			if a := m.Alignof(v); a > r {
-				r = a
-			}
-		}
-		return mathutil.Max(1, r)
+		return mathutil.Max(1, m.StructAlignof(x))
+	case *VectorType:
+		return m.vectorAlign(x, m[Vector].Align)
 	default:
-		item, ok := m[t.Kind()]
-		if !ok {
-			panic(fmt.Errorf("missing model item for %s", t.Kind()))
-		}
-
+		item := m.item(t.Kind())
 		return int(item.Align)
 	}
 }
 
 // Layout computes the memory layout of t.
+//
+// Adjacent bit fields are packed the way GCC does: a bit field is allocated at
+// the lowest available bit that does not make it cross a boundary of the
+// alignment unit of its declared type. The Offset and Size of a bit field
+// describe the storage unit of the declared type that contains the bit field,
+// BitOffset and Bits locate the bit field within that unit.
 func (m MemoryModel) Layout(t *StructOrUnionType) []FieldProperties {
 	if len(t.Fields) == 0 {
 		return nil
 	}
 
+	r, _ := m.layout(t)
+	return r
+}
+
+// FieldLayout is a node of the field tree computed by NestedLayout.
+type FieldLayout struct {
+	FieldProperties                // Offset is relative to the start of the outermost struct/union.
+	Anonymous       bool           // An unnamed struct/union field, its fields are accessible as fields of the enclosing struct/union.
+	Fields          []*FieldLayout // Fields of a struct/union typed field.
+	Name            NameID         // Zero or "_" if the field has no name.
+	Path            []int          // Field indices selecting the field in the outermost struct/union, see Offsetof.
+	Type            Type
+}
+
+// NestedLayout is like Layout but it computes the layout of the fields of the
+// struct/union typed fields as well, recursively. The returned root describes
+// t, the Offset of every field is absolute, ie. relative to the start of t.
+func (m MemoryModel) NestedLayout(t *StructOrUnionType) *FieldLayout {
+	r := &FieldLayout{FieldProperties: FieldProperties{Size: m.Sizeof(t)}, Type: t}
+	m.nestedLayout(r)
+	return r
+}
+
+func (m MemoryModel) nestedLayout(f *FieldLayout) {
+	t, ok := f.Type.(*StructOrUnionType)
+	if !ok {
+		return
+	}
+
+	for i, v := range m.Layout(t) {
+		v.Offset += f.Offset
+		g := &FieldLayout{FieldProperties: v, Path: append(f.Path[:len(f.Path):len(f.Path)], i), Type: t.Fields[i]}
+		if i < len(t.Names) {
+			g.Name = t.Names[i]
+		}
+		if _, ok := g.Type.(*StructOrUnionType); ok && (g.Name == 0 || g.Name == idBlank) {
+			g.Anonymous = true
+		}
+		m.nestedLayout(g)
+		f.Fields = append(f.Fields, g)
+	}
+}
+
+// Field returns the field of f named nm or nil if there is none. The fields
+// of anonymous members are searched as well, so Path of the result tells
+// which anonymous members the field comes from.
+func (f *FieldLayout) Field(nm NameID) *FieldLayout {
+	for _, v := range f.Fields {
+		if v.Name == nm && !v.Anonymous {
+			return v
+		}
+	}
+	for _, v := range f.Fields {
+		if v.Anonymous {
+			if r := v.Field(nm); r != nil {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+func (m MemoryModel) layout(t *StructOrUnionType) ([]FieldProperties, int64) {
 	r := make([]FieldProperties, len(t.Fields))
 	switch t.Kind() {
 	case Struct:
-		var off int64
+		var off int64 // In bits.
 		for i, v := range t.Fields {
 			sz := m.Sizeof(v)
-			a := m.StructAlignof(v)
-			z := off
-			if a != 0 {
-				off = roundup(off, int64(a))
+			a := int64(m.StructAlignof(v))
+			if a == 0 {
+				a = 1
 			}
-			if off != z {
-				r[i-1].Padding = int(off - z)
+			if i < len(t.Bits) && t.Bits[i] != 0 {
+				bits := int64(t.Bits[i])
+				unit := off / (8 * a) * a
+				if off+bits > 8*(unit+sz) {
+					off = roundup(off, 8*a)
+					unit = off / 8
+				}
+				r[i] = FieldProperties{Offset: unit, Size: sz, BitOffset: int(off - 8*unit), Bits: int(bits)}
+				off += bits
+				continue
 			}
+
+			off = roundup(roundup(off, 8)/8, a)
 			r[i] = FieldProperties{Offset: off, Size: sz}
-			off += sz
+			off = 8 * (off + sz)
 		}
-		z := off
-		off = roundup(off, int64(m.Alignof(t)))
-		if off != z {
-			r[len(r)-1].Padding = int(off - z)
+		sz := roundup(roundup(off, 8)/8, int64(m.Alignof(t)))
+		for i := range r {
+			next := sz
+			if i+1 < len(r) {
+				next = r[i+1].Offset
+			}
+			if n := next - r[i].Offset - r[i].Size; n > 0 {
+				r[i].Padding = int(n)
+			}
 		}
+		return r, sz
 	case Union:
 		var sz int64
 		for i, v := range t.Fields {
 			n := m.Sizeof(v)
 			r[i] = FieldProperties{Size: n}
+			if i < len(t.Bits) {
+				r[i].Bits = t.Bits[i]
+			}
 			if n > sz {
 				sz = n
 			}
@@ -201,14 +443,52 @@ func (m MemoryModel) Layout(t *StructOrUnionType) []FieldProperties {
 		for i, v := range r {
 			r[i].Padding = int(sz - v.Size)
 		}
+		return r, sz
 	}
-	return r
+	panic("internal error")
+}
+
+// Offsetof computes the offset, relative to the start of t, of the object
+// selected by path. Every path element is a field index when the current type
+// is a struct or union, or an item index when the current type is an array,
+// so that the C expression offsetof(T, a.b[2].c) translates to a path of the
+// indices of a, b, 2 and c.
+func (m MemoryModel) Offsetof(t Type, path ...int) (int64, error) {
+	var off int64
+	for _, i := range path {
+		switch x := t.(type) {
+		case *ArrayType:
+			if i < 0 || int64(i) >= x.Items {
+				return 0, fmt.Errorf("array index %v out of bounds of %s", i, x)
+			}
+
+			off += int64(i) * m.Sizeof(x.Item)
+			t = x.Item
+		case *StructOrUnionType:
+			if i < 0 || i >= len(x.Fields) {
+				return 0, fmt.Errorf("invalid field index %v of %s", i, x)
+			}
+
+			off += m.Layout(x)[i].Offset
+			t = x.Fields[i]
+		default:
+			return 0, fmt.Errorf("cannot select index %v of %s", i, t)
+		}
+	}
+	return off, nil
 }
 
-// Sizeof computes the memory size of t.
+// Sizeof computes the memory size of t. Sizeof panics with an error if t is
+// not complete, see IsComplete.
 func (m MemoryModel) Sizeof(t Type) int64 {
 	switch x := t.(type) {
 	case *ArrayType:
+		if x.Items < 0 {
+			incomplete(t)
+		}
+
+		return m.Sizeof(x.Item) * x.Items
+	case *VectorType:
 		return m.Sizeof(x.Item) * x.Items
 	case *StructOrUnionType:
 		if len(x.Fields) == 0 {
@@ -217,6 +497,11 @@ func (m MemoryModel) Sizeof(t Type) int64 {
 
 		switch t.Kind() {
 		case Struct:
+			if x.Bits != nil {
+				_, sz := m.layout(x)
+				return sz
+			}
+
 			var off int64
 			for _, v := range x.Fields {
 				sz := m.Sizeof(v)
@@ -237,11 +522,7 @@ func (m MemoryModel) Sizeof(t Type) int64 {
 			return roundup(sz, int64(m.Alignof(t)))
 		}
 	default:
-		item, ok := m[t.Kind()]
-		if !ok {
-			panic(fmt.Errorf("missing model item for %s", t.Kind()))
-		}
-
+		item := m.item(t.Kind())
 		return int64(item.Size)
 	}
 	panic("internal error")
@@ -249,10 +530,15 @@ func (m MemoryModel) Sizeof(t Type) int64 {
 
 // StructAlignof computes the memory alignment requirements of t when its
 // instance is a struct field. Zero is returned for a struct/union type with no
-// fields.
+// fields. StructAlignof panics with an error if t is not complete, see
+// IsComplete.
 func (m MemoryModel) StructAlignof(t Type) int {
 	switch x := t.(type) {
 	case *ArrayType:
+		if x.Items < 0 {
+			incomplete(t)
+		}
+
 		return m.StructAlignof(x.Item)
 	case *StructOrUnionType:
 		var r int
@@ -262,22 +548,89 @@ func (m MemoryModel) StructAlignof(t Type) int {
 			}
 		}
 		return r
+	case *VectorType:
+		return m.vectorAlign(x, m[Vector].StructAlign)
 	default:
-		item, ok := m[t.Kind()]
-		if !ok {
-			panic(fmt.Errorf("missing model item for %s", t.Kind()))
-		}
-
+		item := m.item(t.Kind())
 		return int(item.StructAlign)
 	}
 }
 
+// vectorAlign returns the size of t rounded up to a power of two, limited to
+// max if max is not zero.
+func (m MemoryModel) vectorAlign(t *VectorType, max uint) int {
+	sz := m.Sizeof(t)
+	r := 1
+	for int64(r) < sz {
+		r <<= 1
+	}
+	if max != 0 && r > int(max) {
+		r = int(max)
+	}
+	return r
+}
+
 // FieldProperties describe a struct/union field.
 type FieldProperties struct {
-	Offset  int64 // Relative to start of the struct/union.
-	Size    int64 // Field size for copying.
-	Padding int   // Adjustment to enforce proper alignment.
+	Offset    int64 // Relative to start of the struct/union.
+	Size      int64 // Field size for copying.
+	Padding   int   // Adjustment to enforce proper alignment.
+	BitOffset int   // Bit field only: first bit of the field in the storage unit at Offset.
+	Bits      int   // Bit field only: field width, zero if the field is not a bit field.
 }
 
 // Sizeof returns the sum of f.Size and f.Padding.
 func (f *FieldProperties) Sizeof() int64 { return f.Size + int64(f.Padding) }
+
+// FrameLayout describes the storage a function needs at run time.
+type FrameLayout struct {
+	Align        int     // Alignment of the local variables area.
+	MaxStack     int     // Maximum number of evaluation stack items.
+	MaxStackSize int64   // Maximum total size of the evaluation stack items.
+	Size         int64   // Size of the local variables area.
+	Variables    []int64 // Offsets of the local variables within the area, by index.
+}
+
+// FrameInfo returns the frame layout of f. The local variables are laid out in
+// the order of their indices, each properly aligned. The evaluation stack
+// figures are computed by verifying f, so FrameInfo may mutate f, see
+// FunctionDefinition.Verify. If tc is nil, a temporary TypeCache is used.
+func FrameInfo(f *FunctionDefinition, m MemoryModel, tc Types) (FrameLayout, error) {
+	tc = newTypes(tc)
+	r := FrameLayout{Align: 1}
+	v := NewVerifier()
+	v.Types = tc
+	v.stackHook = func(s []TypeID) {
+		if len(s) > r.MaxStack {
+			r.MaxStack = len(s)
+		}
+		var n int64
+		for _, t := range s {
+			n += m.Sizeof(tc.MustType(t))
+		}
+		if n > r.MaxStackSize {
+			r.MaxStackSize = n
+		}
+	}
+	if err := v.Verify(f); err != nil {
+		return FrameLayout{}, err
+	}
+
+	for _, op := range f.Body {
+		x, ok := op.(*VariableDeclaration)
+		if !ok {
+			continue
+		}
+
+		t := tc.MustType(x.TypeID)
+		a := mathutil.Max(1, m.Alignof(t))
+		if a > r.Align {
+			r.Align = a
+		}
+		r.Size = roundup(r.Size, int64(a))
+		r.Variables = append(r.Variables, r.Size)
+		r.Size += m.Sizeof(t)
+	}
+	r.Size = roundup(r.Size, int64(r.Align))
+	return r, nil
+}
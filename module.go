@@ -0,0 +1,74 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"io"
+	"time"
+)
+
+var (
+	_ io.ReaderFrom = (*Module)(nil)
+	_ io.WriterTo   = (*Module)(nil)
+)
+
+// Module is a translation unit, or a linked program, together with metadata
+// describing it, for example the source files, the compiler flags, the
+// language dialect or the version of the producer.
+//
+// The serialized form of a Module is not compatible with that of Objects.
+type Module struct {
+	Metadata map[NameID]Value // Like "producer": &StringValue{...}. May be nil.
+	Objects  []Object
+}
+
+// Meta returns the metadata value of name or nil if there is none.
+func (m *Module) Meta(name string) Value { return m.Metadata[NameID(dict.SID(name))] }
+
+// SetMeta sets the metadata value of name to v. A nil v removes the value.
+func (m *Module) SetMeta(name string, v Value) {
+	nm := NameID(dict.SID(name))
+	if v == nil {
+		delete(m.Metadata, nm)
+		return
+	}
+
+	if m.Metadata == nil {
+		m.Metadata = map[NameID]Value{}
+	}
+	m.Metadata[nm] = v
+}
+
+// ReadFrom reads m from r. The module must have been written for the host
+// target.
+func (m *Module) ReadFrom(r io.Reader) (n int64, err error) {
+	return m.ReadFromTarget(r, HostTarget())
+}
+
+// ReadFromTarget is like ReadFrom but the module must have been written for
+// target t.
+func (m *Module) ReadFromTarget(r io.Reader, t Target) (n int64, err error) {
+	*m = Module{}
+	if n, err = decode(r, t, m); err != nil {
+		return n, err
+	}
+
+	return n, unpackPositions(m.Objects)
+}
+
+// WriteTo writes m to w.
+func (m *Module) WriteTo(w io.Writer) (n int64, err error) {
+	return m.WriteToOptions(w, &WriteOptions{ModTime: time.Now()})
+}
+
+// WriteToOptions is like Objects.WriteToOptions but it writes m.
+func (m *Module) WriteToOptions(w io.Writer, opts *WriteOptions) (n int64, err error) {
+	o := *m
+	if opts.StripPositions {
+		o.Objects = Objects{m.Objects}.stripPositions()[0]
+	}
+	o.Objects = packPositions(o.Objects)
+	return encode(w, opts, "IR module", &o)
+}
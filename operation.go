@@ -15,14 +15,21 @@ const opw = 16
 
 var (
 	_ Operation = (*Add)(nil)
+	_ Operation = (*AddOv)(nil)
 	_ Operation = (*AllocResult)(nil)
+	_ Operation = (*Alloca)(nil)
 	_ Operation = (*And)(nil)
+	_ Operation = (*Annotate)(nil)
 	_ Operation = (*Argument)(nil)
 	_ Operation = (*Arguments)(nil)
 	_ Operation = (*BeginScope)(nil)
 	_ Operation = (*Bool)(nil)
+	_ Operation = (*Bswap)(nil)
 	_ Operation = (*Call)(nil)
 	_ Operation = (*CallFP)(nil)
+	_ Operation = (*Chain)(nil)
+	_ Operation = (*Closure)(nil)
+	_ Operation = (*Clz)(nil)
 	_ Operation = (*Const)(nil)
 	_ Operation = (*Const32)(nil)
 	_ Operation = (*Const64)(nil)
@@ -30,14 +37,17 @@ var (
 	_ Operation = (*Convert)(nil)
 	_ Operation = (*Copy)(nil)
 	_ Operation = (*Cpl)(nil)
+	_ Operation = (*Ctz)(nil)
 	_ Operation = (*Div)(nil)
 	_ Operation = (*Drop)(nil)
 	_ Operation = (*Dup)(nil)
 	_ Operation = (*Element)(nil)
 	_ Operation = (*EndScope)(nil)
 	_ Operation = (*Eq)(nil)
+	_ Operation = (*Fence)(nil)
 	_ Operation = (*Field)(nil)
 	_ Operation = (*FieldValue)(nil)
+	_ Operation = (*Free)(nil)
 	_ Operation = (*Geq)(nil)
 	_ Operation = (*Global)(nil)
 	_ Operation = (*Gt)(nil)
@@ -46,31 +56,40 @@ var (
 	_ Operation = (*Jnz)(nil)
 	_ Operation = (*Jz)(nil)
 	_ Operation = (*Label)(nil)
+	_ Operation = (*LabelAddr)(nil)
 	_ Operation = (*Leq)(nil)
 	_ Operation = (*Load)(nil)
 	_ Operation = (*Lsh)(nil)
 	_ Operation = (*Lt)(nil)
 	_ Operation = (*Mul)(nil)
+	_ Operation = (*MulOv)(nil)
 	_ Operation = (*Neg)(nil)
 	_ Operation = (*Neq)(nil)
+	_ Operation = (*New)(nil)
 	_ Operation = (*Nil)(nil)
 	_ Operation = (*Not)(nil)
 	_ Operation = (*Or)(nil)
 	_ Operation = (*Panic)(nil)
+	_ Operation = (*Popcnt)(nil)
 	_ Operation = (*PostIncrement)(nil)
 	_ Operation = (*PreIncrement)(nil)
 	_ Operation = (*PtrDiff)(nil)
 	_ Operation = (*Rem)(nil)
+	_ Operation = (*RestoreContext)(nil)
 	_ Operation = (*Result)(nil)
 	_ Operation = (*Return)(nil)
 	_ Operation = (*Rsh)(nil)
+	_ Operation = (*SaveContext)(nil)
+	_ Operation = (*Select)(nil)
 	_ Operation = (*Store)(nil)
 	_ Operation = (*StringConst)(nil)
 	_ Operation = (*Sub)(nil)
+	_ Operation = (*SubOv)(nil)
 	_ Operation = (*Switch)(nil)
 	_ Operation = (*Variable)(nil)
 	_ Operation = (*VariableDeclaration)(nil)
 	_ Operation = (*Xor)(nil)
+	_ Operation = (*Zero)(nil)
 )
 
 // Operation is a unit of execution.
@@ -80,9 +99,11 @@ type Operation interface {
 }
 
 // Add operation adds the top stack item (b) and the previous one (a) and
-// replaces both operands with a + b.
+// replaces both operands with a + b. If the operands are vectors, the
+// operation is performed element-wise.
 type Add struct {
-	TypeID TypeID // Operands type.
+	Overflow Overflow // Semantics of a signed integer overflow.
+	TypeID   TypeID   // Operands type.
 	token.Position
 }
 
@@ -94,15 +115,44 @@ func (o *Add) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	if err := v.overflow(o.Overflow, o.TypeID); err != nil {
+		return err
+	}
+
+	return v.elementwise(o.TypeID, false)
 }
 
 func (o *Add) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "add", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "add"+o.Overflow.suffix(), o.TypeID, o.Position)
+}
+
+// AddOv operation computes a + b of the top stack item (b) and the previous
+// one (a), which must be of the same integral type, and replaces both operands
+// with the result, wrapped to the operand type, followed by an int32 flag
+// which is non zero if the exact result is not representable in the operand
+// type.
+type AddOv struct {
+	TypeID TypeID // Operands type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *AddOv) Pos() token.Position { return o.Position }
+
+func (o *AddOv) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.overflowOp(o.TypeID)
+}
+
+func (o *AddOv) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "addOv", o.TypeID, o.Position)
 }
 
 // AllocResult operation reserves evaluation stack space for a result of type
-// TypeID.
+// TypeID, see Call.
 type AllocResult struct {
 	TypeID   TypeID
 	TypeName NameID
@@ -125,7 +175,61 @@ func (o *AllocResult) String() string {
 	return fmt.Sprintf("\t%-*s\t%v\t; %s %s", opw, "allocResult", o.TypeID, o.TypeName, o.Position)
 }
 
+// Alloca operation pops the size in bytes, its type must be Size, which must
+// be an integral type, allocates memory of that size in the stack frame of the
+// function and pushes a pointer to it to the evaluation stack. The memory is
+// released when the innermost scope enclosing the operation ends, so Alloca
+// can implement both C99 variable length arrays and the alloca function. The
+// content of the memory is undefined.
+//
+// An Alloca operation must be enclosed in a scope and it cannot be used in a
+// naked function.
+type Alloca struct {
+	Size   TypeID // Type of the size operand.
+	TypeID TypeID // Pointer type of the result.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Alloca) Pos() token.Position { return o.Position }
+
+func (o *Alloca) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	if v.typeCache.MustType(o.TypeID).Kind() != Pointer {
+		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
+	}
+
+	if o.Size == 0 {
+		return fmt.Errorf("missing size type")
+	}
+
+	if !isIntegral(v.typeCache.MustType(o.Size).Kind()) {
+		return fmt.Errorf("size must be an integral type, have %v", o.Size)
+	}
+
+	n := len(v.stack)
+	if n == 0 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if g, e := v.stack[n-1], o.Size; g != e {
+		return fmt.Errorf("mismatched size type, got %s, expected %s", g, e)
+	}
+
+	v.stack[n-1] = o.TypeID
+	return nil
+}
+
+func (o *Alloca) String() string {
+	return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "alloca", o.TypeID, o.Size, o.Position)
+}
+
 // And operation replaces TOS with the bitwise and of the top two stack items.
+// If the operands are vectors of integers, the operation is performed
+// element-wise.
 type And struct {
 	TypeID TypeID // Operands type.
 	token.Position
@@ -139,13 +243,42 @@ func (o *And) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	return v.elementwise(o.TypeID, true)
 }
 
 func (o *And) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "and", o.TypeID, o.Position)
 }
 
+// Annotate operation attaches Annotations to the operation following it. It
+// does not change the evaluation stack and has no run time effect.
+type Annotate struct {
+	Annotations []Annotation
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Annotate) Pos() token.Position { return o.Position }
+
+func (o *Annotate) verify(v *verifier) error {
+	if len(o.Annotations) == 0 {
+		return fmt.Errorf("missing annotations")
+	}
+
+	return nil
+}
+
+func (o *Annotate) String() string {
+	s := ""
+	for i, v := range o.Annotations {
+		if i != 0 {
+			s += ", "
+		}
+		s += v.String()
+	}
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "annotate", s, o.Position)
+}
+
 // Argument pushes argument Index, or its address, to the evaluation stack.
 type Argument struct {
 	Address bool
@@ -169,7 +302,7 @@ func (o *Argument) verify(v *verifier) error {
 
 	t := args[o.Index]
 	if o.Address {
-		t = t.Pointer()
+		t = v.pointer(t)
 	}
 	if g, e := o.TypeID, t.ID(); g != e {
 		return fmt.Errorf("have %s, expected type %s", g, e)
@@ -229,7 +362,11 @@ func (o *BeginScope) verify(v *verifier) error {
 }
 
 func (o *BeginScope) String() string {
-	return fmt.Sprintf("\t%-*s\t\t; %s", opw, "beginScope", o.Position)
+	s := ""
+	if o.Value {
+		s = "value"
+	}
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "beginScope", s, o.Position)
 }
 
 // Bool operation converts TOS to a bool (ie. an int32) such that the result
@@ -264,14 +401,51 @@ func (o *Bool) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "bool", o.TypeID, o.Position)
 }
 
+// Bswap operation replaces TOS with TOS having the order of its bytes
+// reversed. The operand must be an integer type of at least 16 bits.
+type Bswap struct {
+	TypeID TypeID // Operand type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Bswap) Pos() token.Position { return o.Position }
+
+func (o *Bswap) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	switch v.typeCache.MustType(o.TypeID).Kind() {
+	case Int8, Uint8:
+		return fmt.Errorf("invalid operand type: %s", o.TypeID)
+	}
+
+	return v.bitop(o.TypeID)
+}
+
+func (o *Bswap) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "bswap", o.TypeID, o.Position)
+}
+
 // Call operation performs a static function call. The evaluation stack
 // contains the space reseved for function results, if any, and any function
 // arguments. On return all arguments are removed from the stack.
+//
+// The space for the results is reserved in order, result #0 first, typically
+// by AllocResult. On return the items hold the results, the last result is
+// at TOS. The function sets its results using Result. Instead of the space
+// for a struct, union or array result the caller can push a pointer to
+// memory of the result type, for example the address of a variable. The
+// result is then stored through the pointer, which stays on the stack. Using
+// such pointers multiple aggregate results can be assigned without moving
+// them through the evaluation stack.
 type Call struct {
-	Arguments int    // Actual number of arguments passed to function.
-	Comma     bool   // The call operation is produced by the C comma operator for a void function.
-	Index     int    // A negative value or an function object index as resolved by the linker.
-	TypeID    TypeID // Type of the function.
+	Arguments   int      // Actual number of arguments passed to function.
+	Comma       bool     // The call operation is produced by the C comma operator for a void function.
+	Index       int      // A negative value or an function object index as resolved by the linker.
+	TypeID      TypeID   // Type of the function.
+	VarArgTypes []TypeID // Promoted types of the variadic arguments of a C-variadic function. May be nil.
 	token.Position
 }
 
@@ -298,10 +472,8 @@ func (o *Call) verify(v *verifier) error {
 		return fmt.Errorf("evaluation stack underflow")
 	}
 
-	for i, r := range results {
-		if g, e := v.stack[ap-len(results)+i], r.ID(); g != e {
-			return fmt.Errorf("mismatched result #%v, got %s, expected %s", i, g, e)
-		}
+	if err := v.results(t.(*FunctionType), v.stack[ap-len(results):ap]); err != nil {
+		return err
 	}
 
 	args := t.(*FunctionType).Arguments
@@ -312,13 +484,17 @@ func (o *Call) verify(v *verifier) error {
 
 		at := args[i]
 		if at.Kind() == Array {
-			at = at.(*ArrayType).Item.Pointer()
+			at = v.pointer(at.(*ArrayType).Item)
 		}
 		if g, e := val, at.ID(); g != e {
 			return fmt.Errorf("invalid argument #%v type, got %v, expected %s", i, g, e)
 		}
 	}
 
+	if err := v.varArgs(t.(*FunctionType), v.stack[ap:], o.VarArgTypes); err != nil {
+		return err
+	}
+
 	v.stack = v.stack[:ap]
 	return nil
 }
@@ -332,17 +508,19 @@ func (o *Call) String() string {
 	if o.Index >= 0 {
 		s = fmt.Sprintf("#%v, ", o.Index)
 	}
-	return fmt.Sprintf("\t%-*s\t%s%v, %s\t; %s", opw, "call"+sc, s, o.Arguments, o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s%v, %s%s\t; %s", opw, "call"+sc, s, o.Arguments, o.TypeID, varArgs(o.VarArgTypes), o.Position)
 }
 
 // CallFP operation performs a function pointer call. The evaluation stack
 // contains the space reseved for function results, if any, the function
 // pointer and any function arguments. On return all arguments and the function
-// pointer are removed from the stack.
+// pointer are removed from the stack. The results are passed as described for
+// Call.
 type CallFP struct {
-	Arguments int    // Actual number of arguments passed to function.
-	Comma     bool   // The call FP operation is produced by the C comma operator for a void function.
-	TypeID    TypeID // Type of the function pointer.
+	Arguments   int      // Actual number of arguments passed to function.
+	Comma       bool     // The call FP operation is produced by the C comma operator for a void function.
+	TypeID      TypeID   // Type of the function pointer.
+	VarArgTypes []TypeID // Promoted types of the variadic arguments of a C-variadic function. May be nil.
 	token.Position
 }
 
@@ -375,11 +553,9 @@ func (o *CallFP) verify(v *verifier) error {
 		return fmt.Errorf("evaluation stack underflow")
 	}
 
-	for i, r := range results {
-		// | #0 | fp |
-		if g, e := v.stack[fp-len(results)+i], r.ID(); g != e {
-			return fmt.Errorf("mismatched result #%v, got %s, expected %s", i, g, e)
-		}
+	// | #0 | fp |
+	if err := v.results(t.(*FunctionType), v.stack[fp-len(results):fp]); err != nil {
+		return err
 	}
 
 	args := t.(*FunctionType).Arguments
@@ -390,13 +566,17 @@ func (o *CallFP) verify(v *verifier) error {
 
 		at := args[i]
 		if at.Kind() == Array {
-			at = at.(*ArrayType).Item.Pointer()
+			at = v.pointer(at.(*ArrayType).Item)
 		}
 		if g, e := val, at.ID(); g != e {
 			return fmt.Errorf("invalid argument #%v type, got %v, expected %s", i, g, e)
 		}
 	}
 
+	if err := v.varArgs(t.(*FunctionType), v.stack[fp+1:], o.VarArgTypes); err != nil {
+		return err
+	}
+
 	v.stack = v.stack[:fp]
 	return nil
 }
@@ -406,7 +586,108 @@ func (o *CallFP) String() string {
 	if o.Comma {
 		sc = "(,)"
 	}
-	return fmt.Sprintf("\t%-*s\t%v, %s\t; %s", opw, "callfp"+sc, o.Arguments, o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%v, %s%s\t; %s", opw, "callfp"+sc, o.Arguments, o.TypeID, varArgs(o.VarArgTypes), o.Position)
+}
+
+// Chain operation pushes the static chain of the current function, see
+// FunctionDefinition.StaticChain, to the evaluation stack.
+type Chain struct {
+	TypeID TypeID // Pointer type of the static chain.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Chain) Pos() token.Position { return o.Position }
+
+func (o *Chain) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	if g, e := o.TypeID, v.function.StaticChain; g != e {
+		return fmt.Errorf("mismatched static chain type, got %s, expected %s", g, e)
+	}
+
+	v.stack = append(v.stack, o.TypeID)
+	return nil
+}
+
+func (o *Chain) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "chain", o.TypeID, o.Position)
+}
+
+// Closure operation replaces the static chain at TOS with a pointer to the
+// nested function NameID bound to that static chain, for example a
+// trampoline. Calling the resulting function pointer using CallFP passes the
+// bound static chain to the nested function. Nested functions always have
+// internal linkage.
+type Closure struct {
+	Chain  TypeID // Pointer type of the static chain.
+	Index  int    // A negative value or an function object index as resolved by the linker.
+	NameID NameID // Name of the nested function.
+	TypeID TypeID // Type of the result, a pointer to function.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Closure) Pos() token.Position { return o.Position }
+
+func (o *Closure) verify(v *verifier) error {
+	if o.TypeID == 0 || o.Chain == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	if o.NameID == 0 {
+		return fmt.Errorf("missing nested function name")
+	}
+
+	t := v.typeCache.MustType(o.TypeID)
+	if t.Kind() != Pointer || t.(*PointerType).Element.Kind() != Function {
+		return fmt.Errorf("expected pointer to function type, have %s", o.TypeID)
+	}
+
+	n := len(v.stack)
+	if n == 0 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if g, e := v.stack[n-1], o.Chain; g != e {
+		return fmt.Errorf("mismatched static chain type, got %s, expected %s", g, e)
+	}
+
+	v.stack[n-1] = o.TypeID
+	return nil
+}
+
+func (o *Closure) String() string {
+	s := ""
+	if o.Index >= 0 {
+		s = fmt.Sprintf("#%v, ", o.Index)
+	}
+	return fmt.Sprintf("\t%-*s\t%s%v, %s, %s\t; %s", opw, "closure", s, o.NameID, o.Chain, o.TypeID, o.Position)
+}
+
+// Clz operation replaces TOS with the number of its leading zero bits, in the
+// operand type. The result is the bit width of the operand type if TOS is
+// zero.
+type Clz struct {
+	TypeID TypeID // Operand type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Clz) Pos() token.Position { return o.Position }
+
+func (o *Clz) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.bitop(o.TypeID)
+}
+
+func (o *Clz) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "clz", o.TypeID, o.Position)
 }
 
 // Const operation pushes a constant value on the evaluation stack.
@@ -507,9 +788,17 @@ func (o *ConstC128) String() string {
 }
 
 // Convert operation converts TOS to the result type.
+//
+// Rounding, valid only for conversions between a floating point type and an
+// integer type, selects the rounding mode of the conversion. OutOfRange,
+// valid only for conversions of a floating point type to an integer type,
+// determines the result when the rounded value is not representable in the
+// result type.
 type Convert struct {
-	Result TypeID // Conversion type.
-	TypeID TypeID // Operand type.
+	OutOfRange OutOfRange
+	Result     TypeID // Conversion type.
+	Rounding   Rounding
+	TypeID     TypeID // Operand type.
 	token.Position
 }
 
@@ -530,18 +819,26 @@ func (o *Convert) verify(v *verifier) error {
 		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
 	}
 
+	if err := v.convertFlags(o.TypeID, o.Result, o.Rounding, o.OutOfRange); err != nil {
+		return err
+	}
+
 	v.stack[n-1] = o.Result
 	return nil
 }
 
 func (o *Convert) String() string {
-	return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "convert", o.TypeID, o.Result, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "convert"+convertModifiers(o.Rounding, o.OutOfRange), o.TypeID, o.Result, o.Position)
 }
 
 // Copy assigns source, which address is at TOS, to dest, which address is the
-// previous stack item. The source address is removed from the stack.
+// previous stack item. The source address is removed from the stack. For the
+// qualifier flags see Load.
 type Copy struct {
-	TypeID TypeID // Operand type.
+	Atomic   bool
+	Restrict bool
+	TypeID   TypeID // Operand type.
+	Volatile bool
 	token.Position
 }
 
@@ -559,7 +856,7 @@ func (o *Copy) verify(v *verifier) error {
 	}
 
 	t := v.typeCache.MustType(o.TypeID)
-	t = t.Pointer()
+	t = v.pointer(t)
 	if g, e := v.stack[n-2], t.ID(); g != e {
 		return fmt.Errorf("mismatched destination type, got %s, expected %s", g, e)
 	}
@@ -573,7 +870,7 @@ func (o *Copy) verify(v *verifier) error {
 }
 
 func (o *Copy) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "copy", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "copy"+qualifiers(o.Atomic, o.Restrict, o.Volatile), o.TypeID, o.Position)
 }
 
 // Cpl operation replaces TOS with ^TOS (bitwise complement).
@@ -597,11 +894,43 @@ func (o *Cpl) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "cpl", o.TypeID, o.Position)
 }
 
-// Div operation subtracts the top stack item (b) and the previous one (a) and
-// replaces both operands with a / b. The operation panics if operands are
-// integers and b == 0.
+// Ctz operation replaces TOS with the number of its trailing zero bits, in the
+// operand type. The result is the bit width of the operand type if TOS is
+// zero.
+type Ctz struct {
+	TypeID TypeID // Operand type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Ctz) Pos() token.Position { return o.Position }
+
+func (o *Ctz) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.bitop(o.TypeID)
+}
+
+func (o *Ctz) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "ctz", o.TypeID, o.Position)
+}
+
+// Div operation divides the previous stack item (a) by the top stack item (b)
+// and replaces both operands with a / b. If the operands are integers and b ==
+// 0 or the division overflows, the result is determined by Mode.
+//
+// The Exact, NonZero and NoOverflow flags, valid only for integer operands,
+// assert properties of the operands the producer has proven. A back end may
+// omit the respective checks, the result is undefined if the assertion does
+// not hold.
 type Div struct {
-	TypeID TypeID // Operands type.
+	Exact      bool // a is a multiple of b.
+	Mode       DivMode
+	NoOverflow bool   // The operands are not the minimum value of a signed type and -1.
+	NonZero    bool   // b != 0.
+	TypeID     TypeID // Operands type.
 	token.Position
 }
 
@@ -613,11 +942,19 @@ func (o *Div) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if err := v.divMode(o.Mode, o.TypeID); err != nil {
+		return err
+	}
+
+	if err := v.divFlags(o.TypeID, o.Exact, o.NonZero, o.NoOverflow); err != nil {
+		return err
+	}
+
 	return v.binop(o.TypeID)
 }
 
 func (o *Div) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "div", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "div"+divModifiers(o.Mode, o.Exact, o.NonZero, o.NoOverflow), o.TypeID, o.Position)
 }
 
 // Drop operation removes one item from the evaluation stack.
@@ -693,14 +1030,34 @@ func (o *Dup) String() string {
 }
 
 // Element replaces a pointer and index with the indexed element or its address.
+//
+// A BoundsChecked Element indexes an array of Items items unless ItemsUnknown
+// is set. Indices out of the bounds of the array are reported by Validate, when
+// constant, and by the Interpreter at run time. The index may be equal to Items
+// when only the address is computed. Zero Items is a valid extent, only the
+// address of a zero-length array, eg. a GNU [0] or a flexible array member, can
+// be computed.
 type Element struct {
-	Address   bool
-	IndexType TypeID
-	Neg       bool   // Negate the index expression.
-	TypeID    TypeID // The indexed type.
+	Address       bool
+	BoundsChecked bool
+	IndexType     TypeID
+	Items         int64  // Number of items of the indexed array if BoundsChecked.
+	ItemsUnknown  bool   // The number of items of a BoundsChecked array is not known.
+	Neg           bool   // Negate the index expression.
+	TypeID        TypeID // The indexed type.
 	token.Position
 }
 
+// outOfBounds reports whether index i is out of the bounds of the array
+// indexed by o, if known.
+func (o *Element) outOfBounds(i int64) bool {
+	if !o.BoundsChecked || o.ItemsUnknown {
+		return false
+	}
+
+	return i < 0 || i > o.Items || i == o.Items && !o.Address
+}
+
 // Pos implements Operation.
 func (o *Element) Pos() token.Position { return o.Position }
 
@@ -713,6 +1070,10 @@ func (o *Element) verify(v *verifier) error {
 		return fmt.Errorf("missing index type")
 	}
 
+	if o.BoundsChecked && !o.ItemsUnknown && o.Items < 0 {
+		return fmt.Errorf("invalid number of items of a bounds checked element: %v", o.Items)
+	}
+
 	switch t := v.typeCache.MustType(o.IndexType); t.Kind() {
 	case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64:
 		// ok
@@ -747,11 +1108,19 @@ func (o *Element) String() string {
 	if o.Neg {
 		s = "-"
 	}
+	m := "element"
+	n := ""
+	if o.BoundsChecked {
+		m += "(checked)"
+		if !o.ItemsUnknown {
+			n = fmt.Sprintf(", %v", o.Items)
+		}
+	}
 	switch {
 	case o.Address:
-		return fmt.Sprintf("\t%-*s\t&[%s%v], %v\t; %s", opw, "element", s, o.IndexType, o.TypeID, o.Position)
+		return fmt.Sprintf("\t%-*s\t&[%s%v], %v%s\t; %s", opw, m, s, o.IndexType, o.TypeID, n, o.Position)
 	default:
-		return fmt.Sprintf("\t%-*s\t[%s%v], %v\t; %s", opw, "element", s, o.IndexType, o.TypeID, o.Position)
+		return fmt.Sprintf("\t%-*s\t[%s%v], %v%s\t; %s", opw, m, s, o.IndexType, o.TypeID, n, o.Position)
 	}
 }
 
@@ -778,7 +1147,11 @@ func (o *EndScope) verify(v *verifier) error {
 }
 
 func (o *EndScope) String() string {
-	return fmt.Sprintf("\t%-*s\t\t; %s", opw, "endScope", o.Position)
+	s := ""
+	if o.Value {
+		s = "value"
+	}
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "endScope", s, o.Position)
 }
 
 // Eq operation compares the top stack item (b) and the previous one (a) and
@@ -804,6 +1177,30 @@ func (o *Eq) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "eq", o.TypeID, o.Position)
 }
 
+// Fence operation prevents reordering of memory accesses across it. Scope
+// determines whether it is a full memory barrier or whether it constrains
+// only the compiler. Fence does not change the evaluation stack.
+type Fence struct {
+	Scope FenceScope
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Fence) Pos() token.Position { return o.Position }
+
+func (o *Fence) verify(v *verifier) error {
+	switch o.Scope {
+	case FenceFull, FenceCompiler:
+		return nil
+	}
+
+	return fmt.Errorf("invalid fence scope %v", o.Scope)
+}
+
+func (o *Fence) String() string {
+	return fmt.Sprintf("\t%-*s\t\t; %s", opw, "fence"+o.Scope.suffix(), o.Position)
+}
+
 // Field replaces a struct/union pointer at TOS with its field by index, or its
 // address.
 type Field struct {
@@ -847,7 +1244,7 @@ func (o *Field) verify(v *verifier) error {
 
 	t = st.Fields[o.Index]
 	if o.Address {
-		t = t.Pointer()
+		t = v.pointer(t)
 	}
 	v.stack[n-1] = t.ID()
 	return nil
@@ -904,10 +1301,49 @@ func (o *FieldValue) String() string {
 	return fmt.Sprintf("\t%-*s\t#%v, %v\t; %s", opw, "fieldvalue", o.Index, o.TypeID, o.Position)
 }
 
+// Free operation releases heap memory allocated by New. The pointer to the
+// memory is popped from the evaluation stack.
+type Free struct {
+	TypeID TypeID // Pointer type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Free) Pos() token.Position { return o.Position }
+
+func (o *Free) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	n := len(v.stack)
+	if n == 0 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if g, e := v.stack[n-1], o.TypeID; g != e {
+		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
+	}
+
+	if v.typeCache.MustType(o.TypeID).Kind() != Pointer {
+		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
+	}
+
+	v.stack = v.stack[:n-1]
+	return nil
+}
+
+func (o *Free) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "free", o.TypeID, o.Position)
+}
+
 // Geq operation compares the top stack item (b) and the previous one (a) and
 // replaces both operands with a non zero int32 value if a >= b or zero
-// otherwise.
+// otherwise. Operands of a signed integer type are compared as signed, other
+// operands, including pointers, as unsigned. Signed may be set only for
+// operands of a signed integer type, Verify sets it for them.
 type Geq struct {
+	Signed bool   // Signed integer comparison.
 	TypeID TypeID // Operands type.
 	token.Position
 }
@@ -920,7 +1356,7 @@ func (o *Geq) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.relop(o.TypeID)
+	return v.ordered(o.TypeID, &o.Signed)
 }
 
 func (o *Geq) String() string {
@@ -934,6 +1370,8 @@ type Global struct {
 	Index   int // A negative value or an object index as resolved by the linker.
 	Linkage
 	NameID   NameID
+	Offset   uintptr // Set by the linker when NameID resolves to an alias with an offset.
+	TLS      bool    // NameID refers to data with thread local storage.
 	TypeID   TypeID
 	TypeName NameID
 	token.Position
@@ -947,7 +1385,7 @@ func (o *Global) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	if o.Linkage != ExternalLinkage && o.Linkage != InternalLinkage {
+	if o.Linkage < ExternalLinkage || o.Linkage > CommonLinkage {
 		return fmt.Errorf("invalid linkage")
 	}
 
@@ -966,13 +1404,24 @@ func (o *Global) String() string {
 	if o.Index >= 0 {
 		s = fmt.Sprintf("#%v, ", o.Index)
 	}
-	return fmt.Sprintf("\t%-*s\t%s, %s\t; %s %s", opw, "global", s+addr(o.Address)+o.NameID.String(), o.TypeID, o.TypeName, o.Position)
+	s += addr(o.Address) + o.NameID.String()
+	if o.Offset != 0 {
+		s += fmt.Sprintf("+%v", o.Offset)
+	}
+	m := "global"
+	if o.TLS {
+		m += "(tls)"
+	}
+	return fmt.Sprintf("\t%-*s\t%s, %s\t; %s %s", opw, m, s, o.TypeID, o.TypeName, o.Position)
 }
 
 // Gt operation compares the top stack item (b) and the previous one (a) and
 // replaces both operands with a non zero int32 value if a > b or zero
-// otherwise.
+// otherwise. Operands of a signed integer type are compared as signed, other
+// operands, including pointers, as unsigned. Signed may be set only for
+// operands of a signed integer type, Verify sets it for them.
 type Gt struct {
+	Signed bool   // Signed integer comparison.
 	TypeID TypeID // Operands type.
 	token.Position
 }
@@ -985,7 +1434,7 @@ func (o *Gt) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.relop(o.TypeID)
+	return v.ordered(o.TypeID, &o.Signed)
 }
 
 func (o *Gt) String() string {
@@ -1050,11 +1499,14 @@ func (o *JmpP) String() string {
 
 // Jnz operation performs a branch to a named or numbered label if the top of
 // the stack is non zero. The TOS type must be int32 and the operation removes
-// TOS.
+// TOS. Taken and NotTaken are execution counts of a profile, see
+// Interpreter.AttachProfile and OrderBlocks.
 type Jnz struct {
-	LOp    bool // This operation is an artifact of || or &&.
-	NameID NameID
-	Number int
+	LOp      bool // This operation is an artifact of || or &&.
+	NameID   NameID
+	NotTaken int64
+	Number   int
+	Taken    int64
 	token.Position
 }
 
@@ -1078,10 +1530,13 @@ func (o *Jnz) String() string {
 
 // Jz operation performs a branch to a named or numbered label if the top of
 // the stack is zero. The TOS type must be int32 and the operation removes TOS.
+// For Taken and NotTaken see Jnz.
 type Jz struct {
-	LOp    bool // This operation is an artifact of || or && or the conditional operator.
-	NameID NameID
-	Number int
+	LOp      bool // This operation is an artifact of || or && or the conditional operator.
+	NameID   NameID
+	NotTaken int64
+	Number   int
+	Taken    int64
 	token.Position
 }
 
@@ -1104,9 +1559,12 @@ func (o *Jz) String() string {
 }
 
 // Label operation declares a named or numbered branch target. A valid Label
-// must have a non zero NameID or non negative Number.
+// must have a non zero NameID or non negative Number. Count is the number of
+// times the control reached the label in a profile, see
+// Interpreter.AttachProfile.
 type Label struct {
 	Cond   bool // This operation is an artifact of the conditional operator.
+	Count  int64
 	LAnd   bool // This operation is an artifact of &&.
 	LOr    bool // This operation is an artifact of ||.
 	NameID NameID
@@ -1162,10 +1620,49 @@ func (o *Label) str() string {
 	}
 }
 
+// LabelAddr operation pushes the address of a named or numbered label of the
+// current function, the GNU C &&label. The address can be used only as the
+// target of a JmpP in the same function. TypeID must be *struct{}.
+type LabelAddr struct {
+	NameID NameID
+	Number int
+	TypeID TypeID
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *LabelAddr) Pos() token.Position { return o.Position }
+
+func (o *LabelAddr) verify(v *verifier) error {
+	t := v.typeCache.MustType(o.TypeID)
+	if t.Kind() != Pointer || t.(*PointerType).Element.ID() != idVoid {
+		return fmt.Errorf("invalid label address type, expected *%v, have %s", idVoid, o.TypeID)
+	}
+
+	if o.NameID == 0 && o.Number < 0 {
+		return fmt.Errorf("invalid label")
+	}
+
+	v.stack = append(v.stack, o.TypeID)
+	return nil
+}
+
+func (o *LabelAddr) String() string {
+	switch {
+	case o.NameID != 0:
+		return fmt.Sprintf("\t%-*s\t&&%v, %s\t; %s", opw, "labeladdr", o.NameID, o.TypeID, o.Position)
+	default:
+		return fmt.Sprintf("\t%-*s\t&&%v, %s\t; %s", opw, "labeladdr", o.Number, o.TypeID, o.Position)
+	}
+}
+
 // Leq operation compares the top stack item (b) and the previous one (a) and
 // replaces both operands with a non zero int32 value if a <= b or zero
-// otherwise.
+// otherwise. Operands of a signed integer type are compared as signed, other
+// operands, including pointers, as unsigned. Signed may be set only for
+// operands of a signed integer type, Verify sets it for them.
 type Leq struct {
+	Signed bool   // Signed integer comparison.
 	TypeID TypeID // Operands type.
 	token.Position
 }
@@ -1178,7 +1675,7 @@ func (o *Leq) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.relop(o.TypeID)
+	return v.ordered(o.TypeID, &o.Signed)
 }
 
 func (o *Leq) String() string {
@@ -1186,8 +1683,18 @@ func (o *Leq) String() string {
 }
 
 // Load replaces a pointer at TOS by its pointee.
+//
+// Memory accessing operations, Copy, Load, PostIncrement, PreIncrement and
+// Store, have qualifier flags. A volatile access must not be removed,
+// duplicated or reordered with respect to other volatile accesses. An atomic
+// access is indivisible. A restrict access is made through a pointer which is
+// the only means of accessing the pointee, like a C restrict qualified
+// pointer.
 type Load struct {
-	TypeID TypeID // Pointer type.
+	Atomic   bool
+	Restrict bool
+	TypeID   TypeID // Pointer type.
+	Volatile bool
 	token.Position
 }
 
@@ -1213,19 +1720,25 @@ func (o *Load) verify(v *verifier) error {
 		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
 	}
 
-	v.stack[n-1] = pt.(*PointerType).Element.ID()
+	e := pt.(*PointerType).Element
+	if !IsComplete(e) {
+		return fmt.Errorf("cannot load incomplete type %s", e.ID())
+	}
+
+	v.stack[n-1] = e.ID()
 	return nil
 }
 
 func (o *Load) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "load", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "load"+qualifiers(o.Atomic, o.Restrict, o.Volatile), o.TypeID, o.Position)
 }
 
-// Lsh operation uses the top stack item (b), which must be an int32, and the
-// previous one (a), which must be an integral type and replaces both operands
-// with a << b.
+// Lsh operation uses the top stack item (b), which must be of type
+// CountType, and the previous one (a), which must be an integral type and
+// replaces both operands with a << b.
 type Lsh struct {
-	TypeID TypeID // Operand (a) type.
+	CountType TypeID // Shift count (b) type, an integral type. Zero means int32.
+	TypeID    TypeID // Operand (a) type.
 	token.Position
 }
 
@@ -1243,11 +1756,13 @@ func (o *Lsh) verify(v *verifier) error {
 		Int16,
 		Int32,
 		Int64,
+		Int128,
 
 		Uint8,
 		Uint16,
 		Uint32,
-		Uint64:
+		Uint64,
+		Uint128:
 		// ok
 	default:
 		return fmt.Errorf("left operand of a shift must be an integral type")
@@ -1262,7 +1777,15 @@ func (o *Lsh) verify(v *verifier) error {
 		return fmt.Errorf("mismatched operand type, got %s, expected %s", g, e)
 	}
 
-	if g, e := v.stack[n-1], idInt32; g != e {
+	c := o.CountType
+	if c == 0 {
+		c = idInt32
+	}
+	if !isIntegral(v.typeCache.MustType(c).Kind()) {
+		return fmt.Errorf("shift count must be an integral type, got %s", c)
+	}
+
+	if g, e := v.stack[n-1], c; g != e {
 		return fmt.Errorf("mismatched shift count type, got %s, expected %s", g, e)
 	}
 
@@ -1271,13 +1794,20 @@ func (o *Lsh) verify(v *verifier) error {
 }
 
 func (o *Lsh) String() string {
+	if o.CountType != 0 {
+		return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "lsh", o.TypeID, o.CountType, o.Position)
+	}
+
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "lsh", o.TypeID, o.Position)
 }
 
 // Lt operation compares the top stack item (b) and the previous one (a) and
 // replaces both operands with a non zero int32 value if a < b or zero
-// otherwise.
+// otherwise. Operands of a signed integer type are compared as signed, other
+// operands, including pointers, as unsigned. Signed may be set only for
+// operands of a signed integer type, Verify sets it for them.
 type Lt struct {
+	Signed bool   // Signed integer comparison.
 	TypeID TypeID // Operands type.
 	token.Position
 }
@@ -1290,7 +1820,7 @@ func (o *Lt) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.relop(o.TypeID)
+	return v.ordered(o.TypeID, &o.Signed)
 }
 
 func (o *Lt) String() string {
@@ -1298,9 +1828,11 @@ func (o *Lt) String() string {
 }
 
 // Mul operation subtracts the top stack item (b) and the previous one (a) and
-// replaces both operands with a * b.
+// replaces both operands with a * b. If the operands are vectors, the
+// operation is performed element-wise.
 type Mul struct {
-	TypeID TypeID // Operands type.
+	Overflow Overflow // Semantics of a signed integer overflow.
+	TypeID   TypeID   // Operands type.
 	token.Position
 }
 
@@ -1312,16 +1844,46 @@ func (o *Mul) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	if err := v.overflow(o.Overflow, o.TypeID); err != nil {
+		return err
+	}
+
+	return v.elementwise(o.TypeID, false)
 }
 
 func (o *Mul) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "mul", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "mul"+o.Overflow.suffix(), o.TypeID, o.Position)
+}
+
+// MulOv operation computes a * b of the top stack item (b) and the previous
+// one (a), which must be of the same integral type, and replaces both operands
+// with the result, wrapped to the operand type, followed by an int32 flag
+// which is non zero if the exact result is not representable in the operand
+// type.
+type MulOv struct {
+	TypeID TypeID // Operands type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *MulOv) Pos() token.Position { return o.Position }
+
+func (o *MulOv) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.overflowOp(o.TypeID)
+}
+
+func (o *MulOv) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "mulOv", o.TypeID, o.Position)
 }
 
 // Neg operation replaces TOS with 0-TOS.
 type Neg struct {
-	TypeID TypeID // Operand type.
+	Overflow Overflow // Semantics of a signed integer overflow.
+	TypeID   TypeID   // Operand type.
 	token.Position
 }
 
@@ -1333,11 +1895,15 @@ func (o *Neg) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if err := v.overflow(o.Overflow, o.TypeID); err != nil {
+		return err
+	}
+
 	return v.unop(false)
 }
 
 func (o *Neg) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "neg", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "neg"+o.Overflow.suffix(), o.TypeID, o.Position)
 }
 
 // Neq operation compares the top stack item (b) and the previous one (a) and
@@ -1363,6 +1929,73 @@ func (o *Neq) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "neq", o.TypeID, o.Position)
 }
 
+// New operation allocates zero initialized heap memory and pushes a pointer
+// to it to the evaluation stack. If Size is zero, the size of the allocated
+// memory is the size of the element type of TypeID. Otherwise the size in
+// bytes is popped from the evaluation stack first, its type must be Size,
+// which must be an integral type.
+//
+// Back ends are free to implement New and Free using their own allocator,
+// the memory must not be released by calling a C library function.
+type New struct {
+	Size   TypeID // Type of the dynamic size operand or zero.
+	TypeID TypeID // Pointer type of the result.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *New) Pos() token.Position { return o.Position }
+
+func (o *New) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	if v.typeCache.MustType(o.TypeID).Kind() != Pointer {
+		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
+	}
+
+	if o.Size != 0 {
+		switch v.typeCache.MustType(o.Size).Kind() {
+		case
+			Int8,
+			Int16,
+			Int32,
+			Int64,
+
+			Uint8,
+			Uint16,
+			Uint32,
+			Uint64:
+			// ok
+		default:
+			return fmt.Errorf("size must be an integral type, have %v", o.Size)
+		}
+
+		n := len(v.stack)
+		if n == 0 {
+			return fmt.Errorf("evaluation stack underflow")
+		}
+
+		if g, e := v.stack[n-1], o.Size; g != e {
+			return fmt.Errorf("mismatched size type, got %s, expected %s", g, e)
+		}
+
+		v.stack = v.stack[:n-1]
+	}
+
+	v.stack = append(v.stack, o.TypeID)
+	return nil
+}
+
+func (o *New) String() string {
+	if o.Size != 0 {
+		return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "new", o.TypeID, o.Size, o.Position)
+	}
+
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "new", o.TypeID, o.Position)
+}
+
 // Nil pushes a typed nil to TOS.
 type Nil struct {
 	TypeID TypeID // Pointer type.
@@ -1412,6 +2045,8 @@ func (o *Not) String() string {
 }
 
 // Or operation replaces TOS with the bitwise or of the top two stack items.
+// If the operands are vectors of integers, the operation is performed
+// element-wise.
 type Or struct {
 	TypeID TypeID // Operands type.
 	token.Position
@@ -1425,7 +2060,7 @@ func (o *Or) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	return v.elementwise(o.TypeID, true)
 }
 
 func (o *Or) String() string {
@@ -1446,16 +2081,41 @@ func (o *Panic) String() string {
 	return fmt.Sprintf("\t%-*s\t\t; %s", opw, "panic", o.Position)
 }
 
+// Popcnt operation replaces TOS with the number of its one bits, in the
+// operand type.
+type Popcnt struct {
+	TypeID TypeID // Operand type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Popcnt) Pos() token.Position { return o.Position }
+
+func (o *Popcnt) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.bitop(o.TypeID)
+}
+
+func (o *Popcnt) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "popcnt", o.TypeID, o.Position)
+}
+
 // PostIncrement operation adds Delta to the value pointed to by address at TOS
 // and replaces TOS by the value pointee had before the increment. If Bits is
 // non zero then the effective operand type is BitFieldType and the bit field
-// starts at bit BitOffset.
+// starts at bit BitOffset. For the qualifier flags see Load.
 type PostIncrement struct {
+	Atomic       bool
 	BitFieldType TypeID
 	BitOffset    int
 	Bits         int
 	Delta        int
+	Restrict     bool
 	TypeID       TypeID // Operand type.
+	Volatile     bool
 	token.Position
 }
 
@@ -1467,6 +2127,10 @@ func (o *PostIncrement) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if o.Atomic && o.Bits != 0 {
+		return fmt.Errorf("atomic bit field access")
+	}
+
 	n := len(v.stack)
 	if n == 0 {
 		return fmt.Errorf("evaluation stack underflow")
@@ -1500,19 +2164,22 @@ func (o *PostIncrement) String() string {
 	if o.Bits != 0 {
 		s = fmt.Sprintf(":%d@%d:%v", o.Bits, o.BitOffset, o.BitFieldType)
 	}
-	return fmt.Sprintf("\t%-*s\t%v\t; %s", opw, o.TypeID.String()+s+"++", o.Delta, o.Position)
+	return fmt.Sprintf("\t%-*s\t%v%s\t; %s", opw, o.TypeID.String()+s+"++", o.Delta, incQualifiers(o.Atomic, o.Restrict, o.Volatile), o.Position)
 }
 
 // PreIncrement operation adds Delta to the value pointed to by address at TOS
 // and replaces TOS by the new value of the pointee. If Bits is non zero then
 // the effective operand type is BitFieldType and the bit field starts at bit
-// BitOffset.
+// BitOffset. For the qualifier flags see Load.
 type PreIncrement struct {
+	Atomic       bool
 	BitFieldType TypeID
 	BitOffset    int
 	Bits         int
 	Delta        int
+	Restrict     bool
 	TypeID       TypeID // Operand type.
+	Volatile     bool
 	token.Position
 }
 
@@ -1524,6 +2191,10 @@ func (o *PreIncrement) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if o.Atomic && o.Bits != 0 {
+		return fmt.Errorf("atomic bit field access")
+	}
+
 	n := len(v.stack)
 	if n == 0 {
 		return fmt.Errorf("evaluation stack underflow")
@@ -1558,7 +2229,7 @@ func (o *PreIncrement) String() string {
 	if o.Bits != 0 {
 		s = fmt.Sprintf(":%d@%d:%v", o.Bits, o.BitOffset, o.BitFieldType)
 	}
-	return fmt.Sprintf("\t%-*s%s\t%v\t; %s", opw, "++"+o.TypeID.String(), s, o.Delta, o.Position)
+	return fmt.Sprintf("\t%-*s%s\t%v%s\t; %s", opw, "++"+o.TypeID.String(), s, o.Delta, incQualifiers(o.Atomic, o.Restrict, o.Volatile), o.Position)
 }
 
 // PtrDiff operation subtracts the top stack item (b) and the previous one (a)
@@ -1603,9 +2274,13 @@ func (o *PtrDiff) String() string {
 }
 
 // Rem operation divides the top stack item (b) and the previous one (a) and
-// replaces both operands with a % b. The operation panics if b == 0.
+// replaces both operands with a % b. If b == 0 or the division overflows, the
+// result is determined by Mode. For the NoOverflow and NonZero flags see Div.
 type Rem struct {
-	TypeID TypeID // Operands type.
+	Mode       DivMode
+	NoOverflow bool
+	NonZero    bool
+	TypeID     TypeID // Operands type.
 	token.Position
 }
 
@@ -1617,15 +2292,69 @@ func (o *Rem) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if err := v.divMode(o.Mode, o.TypeID); err != nil {
+		return err
+	}
+
+	if err := v.divFlags(o.TypeID, false, o.NonZero, o.NoOverflow); err != nil {
+		return err
+	}
+
 	return v.binop(o.TypeID)
 }
 
 func (o *Rem) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "rem", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "rem"+divModifiers(o.Mode, false, o.NonZero, o.NoOverflow), o.TypeID, o.Position)
+}
+
+// RestoreContext operation pops an int32 value at TOS and the preceding
+// pointer to an execution context saved by SaveContext, and resumes execution
+// after that SaveContext operation, which then pushes the value, or 1 if the
+// value is zero. It is the counterpart of C longjmp. The function executing
+// the SaveContext operation must be still active. Execution never continues
+// after a RestoreContext operation.
+type RestoreContext struct {
+	TypeID TypeID // Pointer type of the context.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *RestoreContext) Pos() token.Position { return o.Position }
+
+func (o *RestoreContext) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	n := len(v.stack)
+	if n < 2 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if g, e := v.stack[n-1], idInt32; g != e {
+		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
+	}
+
+	if g, e := v.stack[n-2], o.TypeID; g != e {
+		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
+	}
+
+	if v.typeCache.MustType(o.TypeID).Kind() != Pointer {
+		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
+	}
+
+	v.stack = v.stack[:n-2]
+	return nil
+}
+
+func (o *RestoreContext) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "restoreContext", o.TypeID, o.Position)
 }
 
 // Result pushes a function result by index, or its address, to the evaluation
-// stack.
+// stack. A struct, union or array result is typically set by a Store or Copy
+// through its address, which works the same regardless of how the caller
+// reserved the result, see Call.
 type Result struct {
 	Address bool
 	Index   int
@@ -1648,7 +2377,7 @@ func (o *Result) verify(v *verifier) error {
 
 	t := results[o.Index]
 	if o.Address {
-		t = t.Pointer()
+		t = v.pointer(t)
 	}
 	if g, e := o.TypeID, t.ID(); g != e {
 		return fmt.Errorf("expected type %s", e)
@@ -1683,11 +2412,12 @@ func (o *Return) String() string {
 	return fmt.Sprintf("\t%-*s\t\t; %s", opw, "return", o.Position)
 }
 
-// Rsh operation uses the top stack item (b), which must be an int32, and the
-// previous one (a), which must be an integral type and replaces both operands
-// with a >> b.
+// Rsh operation uses the top stack item (b), which must be of type
+// CountType, and the previous one (a), which must be an integral type and
+// replaces both operands with a >> b.
 type Rsh struct {
-	TypeID TypeID // Operand (a) type.
+	CountType TypeID // Shift count (b) type, an integral type. Zero means int32.
+	TypeID    TypeID // Operand (a) type.
 	token.Position
 }
 
@@ -1705,11 +2435,13 @@ func (o *Rsh) verify(v *verifier) error {
 		Int16,
 		Int32,
 		Int64,
+		Int128,
 
 		Uint8,
 		Uint16,
 		Uint32,
-		Uint64:
+		Uint64,
+		Uint128:
 		// ok
 	default:
 		return fmt.Errorf("left operand of a shift must be an integral type")
@@ -1724,7 +2456,15 @@ func (o *Rsh) verify(v *verifier) error {
 		return fmt.Errorf("mismatched operand type, got %s, expected %s", g, e)
 	}
 
-	if g, e := v.stack[n-1], idInt32; g != e {
+	c := o.CountType
+	if c == 0 {
+		c = idInt32
+	}
+	if !isIntegral(v.typeCache.MustType(c).Kind()) {
+		return fmt.Errorf("shift count must be an integral type, got %s", c)
+	}
+
+	if g, e := v.stack[n-1], c; g != e {
 		return fmt.Errorf("mismatched shift count type, got %s, expected %s", g, e)
 	}
 
@@ -1733,16 +2473,108 @@ func (o *Rsh) verify(v *verifier) error {
 }
 
 func (o *Rsh) String() string {
+	if o.CountType != 0 {
+		return fmt.Sprintf("\t%-*s\t%s, %s\t; %s", opw, "rsh", o.TypeID, o.CountType, o.Position)
+	}
+
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "rsh", o.TypeID, o.Position)
 }
 
+// SaveContext operation pops a pointer at TOS and saves the execution context
+// to the memory it points to. Then it pushes an int32 zero. When the context
+// is later restored by RestoreContext, execution resumes after the
+// SaveContext operation with the value passed to RestoreContext pushed
+// instead. It is the counterpart of C setjmp.
+//
+// A SaveContext operation is a restore point, the evaluation stack must not
+// hold any items other than the pointer, so it's well defined when execution
+// resumes.
+type SaveContext struct {
+	TypeID TypeID // Pointer type of the context.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *SaveContext) Pos() token.Position { return o.Position }
+
+func (o *SaveContext) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	n := len(v.stack)
+	if n == 0 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if n != 1 {
+		return fmt.Errorf("non empty evaluation stack at a restore point: %v", v.stack[:n-1])
+	}
+
+	if g, e := v.stack[0], o.TypeID; g != e {
+		return fmt.Errorf("mismatched types, got %s, expected %s", g, e)
+	}
+
+	if v.typeCache.MustType(o.TypeID).Kind() != Pointer {
+		return fmt.Errorf("expected a pointer type, have %v", o.TypeID)
+	}
+
+	v.stack[0] = idInt32
+	return nil
+}
+
+func (o *SaveContext) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "saveContext", o.TypeID, o.Position)
+}
+
+// Select operation pops an int32 condition at TOS and the two preceding values
+// a and b, and pushes a if the condition is non zero or b otherwise. Both
+// values are always evaluated.
+type Select struct {
+	TypeID TypeID // Type of a and b.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Select) Pos() token.Position { return o.Position }
+
+func (o *Select) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	n := len(v.stack)
+	if n < 3 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	if g, e := v.stack[n-1], idInt32; g != e {
+		return fmt.Errorf("unexpected condition type, got %s, expected %s", g, e)
+	}
+
+	if a, b := v.stack[n-3], v.stack[n-2]; a != o.TypeID || b != o.TypeID {
+		return fmt.Errorf("mismatched operand types, got %s and %s, expected %s", a, b, o.TypeID)
+	}
+
+	v.stack = v.stack[:n-2]
+	return nil
+}
+
+func (o *Select) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "select", o.TypeID, o.Position)
+}
+
 // Store operation stores a TOS value at address in the preceding stack
 // position.  The address is removed from the evaluation stack.  If Bits is non
-// zero then the destination is a bit field starting at bit BitOffset.
+// zero then the destination is a bit field starting at bit BitOffset. For the
+// qualifier flags see Load.
 type Store struct {
+	Atomic    bool
 	BitOffset int
 	Bits      int
+	Restrict  bool
 	TypeID    TypeID // Type of the value.
+	Volatile  bool
 	token.Position
 }
 
@@ -1754,6 +2586,10 @@ func (o *Store) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if o.Atomic && o.Bits != 0 {
+		return fmt.Errorf("atomic bit field access")
+	}
+
 	if len(v.stack) < 2 {
 		return fmt.Errorf("evaluation stack underflow")
 	}
@@ -1778,7 +2614,7 @@ func (o *Store) String() string {
 	if o.Bits != 0 {
 		s = fmt.Sprintf(":%d@%d", o.Bits, o.BitOffset)
 	}
-	return fmt.Sprintf("\t%-*s\t%s%s\t; %s", opw, "store", o.TypeID, s, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s%s\t; %s", opw, "store"+qualifiers(o.Atomic, o.Restrict, o.Volatile), o.TypeID, s, o.Position)
 }
 
 // StringConst operation pushes a string value on the evaluation stack.
@@ -1805,9 +2641,11 @@ func (o *StringConst) String() string {
 }
 
 // Sub operation subtracts the top stack item (b) and the previous one (a) and
-// replaces both operands with a - b.
+// replaces both operands with a - b. If the operands are vectors, the
+// operation is performed element-wise.
 type Sub struct {
-	TypeID TypeID // Operands type.
+	Overflow Overflow // Semantics of a signed integer overflow.
+	TypeID   TypeID   // Operands type.
 	token.Position
 }
 
@@ -1819,15 +2657,47 @@ func (o *Sub) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	if err := v.overflow(o.Overflow, o.TypeID); err != nil {
+		return err
+	}
+
+	return v.elementwise(o.TypeID, false)
 }
 
 func (o *Sub) String() string {
-	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "sub", o.TypeID, o.Position)
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "sub"+o.Overflow.suffix(), o.TypeID, o.Position)
+}
+
+// SubOv operation computes a - b of the top stack item (b) and the previous
+// one (a), which must be of the same integral type, and replaces both operands
+// with the result, wrapped to the operand type, followed by an int32 flag
+// which is non zero if the exact result is not representable in the operand
+// type.
+type SubOv struct {
+	TypeID TypeID // Operands type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *SubOv) Pos() token.Position { return o.Position }
+
+func (o *SubOv) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	return v.overflowOp(o.TypeID)
+}
+
+func (o *SubOv) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "subOv", o.TypeID, o.Position)
 }
 
 // Switch jumps to a label according to a value at TOS or to a default label.
-// The value at TOS is removed from the evaluation stack.
+// The value at TOS is removed from the evaluation stack. Case values of
+// integer operands are Int32Values or Uint32Values, or Int64Values or
+// Uint64Values for 64 bit operands. Case values of pointer operands are
+// AddressValues or StringValues.
 type Switch struct {
 	Default Label
 	Labels  []Label
@@ -1861,22 +2731,27 @@ func (o *Switch) verify(v *verifier) error {
 		return fmt.Errorf("mismatched operand types: %s and %s", g, e)
 	}
 
+	k := v.typeCache.MustType(o.TypeID).Kind()
 	for _, v := range o.Values {
 		switch x := v.(type) {
-		case *Int32Value:
-			switch o.TypeID {
-			case idInt32, idUint32:
+		case *Int32Value, *Uint32Value:
+			switch k {
+			case Int8, Int16, Int32, Uint8, Uint16, Uint32:
 				// ok
 			default:
 				return fmt.Errorf("invalid switch case value of type %v", o.TypeID)
 			}
-		case *Int64Value:
-			switch o.TypeID {
-			case idInt64, idUint64:
+		case *Int64Value, *Uint64Value:
+			switch k {
+			case Int64, Uint64:
 				// ok
 			default:
 				return fmt.Errorf("invalid switch case value of type %v", o.TypeID)
 			}
+		case *AddressValue, *StringValue:
+			if k != Pointer {
+				return fmt.Errorf("invalid switch case value of type %v", o.TypeID)
+			}
 		default:
 			return fmt.Errorf("unsupported switch case value %T", x)
 		}
@@ -1897,7 +2772,7 @@ func (o *Switch) String() string {
 			l = o.Labels[i]
 		}
 		switch x := v.(type) {
-		case *Int32Value, *Int64Value:
+		case *AddressValue, *Int32Value, *Int64Value, *StringValue, *Uint32Value, *Uint64Value:
 			fmt.Fprintf(&buf, "\n\tcase %v:", x)
 		default:
 			panic(fmt.Errorf("unsupported switch case value %T", x))
@@ -1931,7 +2806,7 @@ func (o *Variable) verify(v *verifier) error {
 
 	t := v.typeCache.MustType(v.variables[o.Index])
 	if o.Address {
-		t = t.Pointer()
+		t = v.pointer(t)
 	}
 	if g, e := o.TypeID, t.ID(); g != e {
 		return fmt.Errorf("expected type %s", e)
@@ -1964,6 +2839,17 @@ func (o *VariableDeclaration) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
+	if o.Value != nil {
+		t, err := v.typeCache.Type(o.TypeID)
+		if err != nil {
+			return err
+		}
+
+		if err := v.verifyValue(t, o.Value); err != nil {
+			return fmt.Errorf("invalid initializer: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -1979,6 +2865,8 @@ func (o *VariableDeclaration) String() string {
 }
 
 // Xor operation replaces TOS with the bitwise xor of the top two stack items.
+// If the operands are vectors of integers, the operation is performed
+// element-wise.
 type Xor struct {
 	TypeID TypeID // Operands type.
 	token.Position
@@ -1992,9 +2880,41 @@ func (o *Xor) verify(v *verifier) error {
 		return fmt.Errorf("missing type")
 	}
 
-	return v.binop(o.TypeID)
+	return v.elementwise(o.TypeID, true)
 }
 
 func (o *Xor) String() string {
 	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "xor", o.TypeID, o.Position)
 }
+
+// Zero sets all bytes of the object, which address is at TOS, to zero. The
+// address is left on the stack.
+type Zero struct {
+	TypeID TypeID // Operand type.
+	token.Position
+}
+
+// Pos implements Operation.
+func (o *Zero) Pos() token.Position { return o.Position }
+
+func (o *Zero) verify(v *verifier) error {
+	if o.TypeID == 0 {
+		return fmt.Errorf("missing type")
+	}
+
+	n := len(v.stack)
+	if n < 1 {
+		return fmt.Errorf("evaluation stack underflow")
+	}
+
+	t := v.typeCache.MustType(o.TypeID)
+	if g, e := v.stack[n-1], v.pointer(t).ID(); g != e {
+		return fmt.Errorf("mismatched operand type, got %s, expected %s", g, e)
+	}
+
+	return nil
+}
+
+func (o *Zero) String() string {
+	return fmt.Sprintf("\t%-*s\t%s\t; %s", opw, "zero", o.TypeID, o.Position)
+}
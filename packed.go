@@ -0,0 +1,358 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+)
+
+// Opcode identifies the kind of an operation in a PackedBody.
+type Opcode byte
+
+// Opcodes of the operations defined by this package. The values are stable,
+// they are part of the meaning of a PackedBody, so opcodes of new operations
+// are only ever appended.
+const (
+	_ Opcode = iota
+	OpAdd
+	OpAllocResult
+	OpAnd
+	OpArgument
+	OpArguments
+	OpBeginScope
+	OpBool
+	OpCall
+	OpCallFP
+	OpConst
+	OpConst32
+	OpConst64
+	OpConstC128
+	OpConvert
+	OpCopy
+	OpCpl
+	OpDiv
+	OpDrop
+	OpDup
+	OpElement
+	OpEndScope
+	OpEq
+	OpField
+	OpFieldValue
+	OpGeq
+	OpGlobal
+	OpGt
+	OpJmp
+	OpJmpP
+	OpJnz
+	OpJz
+	OpLabel
+	OpLeq
+	OpLoad
+	OpLsh
+	OpLt
+	OpMul
+	OpNeg
+	OpNeq
+	OpNil
+	OpNot
+	OpOr
+	OpPanic
+	OpPostIncrement
+	OpPreIncrement
+	OpPtrDiff
+	OpRem
+	OpResult
+	OpReturn
+	OpRsh
+	OpStore
+	OpStringConst
+	OpSub
+	OpSwitch
+	OpVariable
+	OpVariableDeclaration
+	OpXor
+	OpNew
+	OpFree
+	OpChain
+	OpClosure
+	OpZero
+	OpSelect
+	OpAddOv
+	OpMulOv
+	OpSubOv
+	OpBswap
+	OpClz
+	OpCtz
+	OpPopcnt
+	OpSaveContext
+	OpRestoreContext
+	OpAlloca
+	OpLabelAddr
+	OpFence
+	OpAnnotate
+)
+
+const (
+	operandExtra = iota
+	operandInt
+	operandPosition
+	operandSkip
+	operandUint
+)
+
+var (
+	opcodes = [...]Operation{ // Opcode: operation
+		OpAdd:                 &Add{},
+		OpAddOv:               &AddOv{},
+		OpAllocResult:         &AllocResult{},
+		OpAlloca:              &Alloca{},
+		OpAnd:                 &And{},
+		OpAnnotate:            &Annotate{},
+		OpArgument:            &Argument{},
+		OpArguments:           &Arguments{},
+		OpBeginScope:          &BeginScope{},
+		OpBool:                &Bool{},
+		OpBswap:               &Bswap{},
+		OpCall:                &Call{},
+		OpCallFP:              &CallFP{},
+		OpChain:               &Chain{},
+		OpClosure:             &Closure{},
+		OpClz:                 &Clz{},
+		OpConst:               &Const{},
+		OpConst32:             &Const32{},
+		OpConst64:             &Const64{},
+		OpConstC128:           &ConstC128{},
+		OpConvert:             &Convert{},
+		OpCopy:                &Copy{},
+		OpCpl:                 &Cpl{},
+		OpCtz:                 &Ctz{},
+		OpDiv:                 &Div{},
+		OpDrop:                &Drop{},
+		OpDup:                 &Dup{},
+		OpElement:             &Element{},
+		OpEndScope:            &EndScope{},
+		OpEq:                  &Eq{},
+		OpFence:               &Fence{},
+		OpField:               &Field{},
+		OpFieldValue:          &FieldValue{},
+		OpFree:                &Free{},
+		OpGeq:                 &Geq{},
+		OpGlobal:              &Global{},
+		OpGt:                  &Gt{},
+		OpJmp:                 &Jmp{},
+		OpJmpP:                &JmpP{},
+		OpJnz:                 &Jnz{},
+		OpJz:                  &Jz{},
+		OpLabel:               &Label{},
+		OpLabelAddr:           &LabelAddr{},
+		OpLeq:                 &Leq{},
+		OpLoad:                &Load{},
+		OpLsh:                 &Lsh{},
+		OpLt:                  &Lt{},
+		OpMul:                 &Mul{},
+		OpMulOv:               &MulOv{},
+		OpNeg:                 &Neg{},
+		OpNeq:                 &Neq{},
+		OpNew:                 &New{},
+		OpNil:                 &Nil{},
+		OpNot:                 &Not{},
+		OpOr:                  &Or{},
+		OpPanic:               &Panic{},
+		OpPopcnt:              &Popcnt{},
+		OpPostIncrement:       &PostIncrement{},
+		OpPreIncrement:        &PreIncrement{},
+		OpPtrDiff:             &PtrDiff{},
+		OpRem:                 &Rem{},
+		OpRestoreContext:      &RestoreContext{},
+		OpResult:              &Result{},
+		OpReturn:              &Return{},
+		OpRsh:                 &Rsh{},
+		OpSaveContext:         &SaveContext{},
+		OpSelect:              &Select{},
+		OpStore:               &Store{},
+		OpStringConst:         &StringConst{},
+		OpSub:                 &Sub{},
+		OpSubOv:               &SubOv{},
+		OpSwitch:              &Switch{},
+		OpVariable:            &Variable{},
+		OpVariableDeclaration: &VariableDeclaration{},
+		OpXor:                 &Xor{},
+		OpZero:                &Zero{},
+	}
+
+	opcodeOperands [][]int                 // Opcode: operand kind per struct field.
+	opcodeTypes    []reflect.Type          // Opcode: operation struct type.
+	typeOpcodes    map[reflect.Type]Opcode // Operation struct type: Opcode.
+)
+
+func init() {
+	opcodeOperands = make([][]int, len(opcodes))
+	opcodeTypes = make([]reflect.Type, len(opcodes))
+	typeOpcodes = make(map[reflect.Type]Opcode, len(opcodes))
+	for i, v := range opcodes {
+		if v == nil {
+			continue
+		}
+
+		t := reflect.TypeOf(v).Elem()
+		opcodeTypes[i] = t
+		typeOpcodes[t] = Opcode(i)
+		a := make([]int, t.NumField())
+		for j := range a {
+			f := t.Field(j)
+			switch {
+			case f.PkgPath != "":
+				a[j] = operandSkip
+			case f.Type == reflect.TypeOf(token.Position{}):
+				a[j] = operandPosition
+			default:
+				switch f.Type.Kind() {
+				case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+					a[j] = operandInt
+				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+					a[j] = operandUint
+				default:
+					a[j] = operandExtra
+				}
+			}
+		}
+		opcodeOperands[i] = a
+	}
+}
+
+// String implements fmt.Stringer.
+func (o Opcode) String() string {
+	if int(o) < len(opcodeTypes) && opcodeTypes[o] != nil {
+		return opcodeTypes[o].Name()
+	}
+
+	return fmt.Sprintf("Opcode(%d)", o)
+}
+
+// PackedBody is a compact representation of a function body. Instead of a
+// pointer to a separately allocated operation and an interface header per
+// operation, a PackedBody stores one Opcode per operation and the operands of
+// all operations in a few shared slices. Identical positions are stored only
+// once.
+//
+// A PackedBody is meant for keeping many function bodies in memory. Use Pack
+// and Unpack to convert from and to []Operation. Operands stored in Extra are
+// shared, not copied, by Pack and Unpack.
+type PackedBody struct {
+	Codes         []Opcode
+	Extra         []interface{}    // Operands not representable by an int64, for example Values.
+	Operands      []int64          // Integral, boolean and identifier operands.
+	PositionTable []token.Position // Distinct positions.
+	Positions     []int32          // Index into PositionTable, one per operation.
+}
+
+// Pack returns body in the compact form or an error, if any.
+func Pack(body []Operation) (*PackedBody, error) {
+	p := &PackedBody{
+		Codes:     make([]Opcode, len(body)),
+		Positions: make([]int32, len(body)),
+	}
+	m := map[token.Position]int32{}
+	for i, op := range body {
+		v := reflect.ValueOf(op)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return nil, fmt.Errorf("unsupported operation %T", op)
+		}
+
+		v = v.Elem()
+		code, ok := typeOpcodes[v.Type()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operation %T", op)
+		}
+
+		p.Codes[i] = code
+		for j, k := range opcodeOperands[code] {
+			f := v.Field(j)
+			switch k {
+			case operandExtra:
+				p.Extra = append(p.Extra, f.Interface())
+			case operandInt:
+				switch f.Kind() {
+				case reflect.Bool:
+					var n int64
+					if f.Bool() {
+						n = 1
+					}
+					p.Operands = append(p.Operands, n)
+				default:
+					p.Operands = append(p.Operands, f.Int())
+				}
+			case operandPosition:
+				pos := f.Interface().(token.Position)
+				n, ok := m[pos]
+				if !ok {
+					n = int32(len(p.PositionTable))
+					m[pos] = n
+					p.PositionTable = append(p.PositionTable, pos)
+				}
+				p.Positions[i] = n
+			case operandUint:
+				p.Operands = append(p.Operands, int64(f.Uint()))
+			}
+		}
+	}
+	return p, nil
+}
+
+// Len returns the number of operations in p.
+func (p *PackedBody) Len() int { return len(p.Codes) }
+
+// Unpack returns p converted back to []Operation or an error, if any.
+func (p *PackedBody) Unpack() ([]Operation, error) {
+	r := make([]Operation, len(p.Codes))
+	var extra, operands int
+	for i, code := range p.Codes {
+		if int(code) >= len(opcodeTypes) || opcodeTypes[code] == nil {
+			return nil, fmt.Errorf("invalid opcode %v", code)
+		}
+
+		v := reflect.New(opcodeTypes[code])
+		e := v.Elem()
+		for j, k := range opcodeOperands[code] {
+			f := e.Field(j)
+			switch k {
+			case operandExtra:
+				if extra >= len(p.Extra) {
+					return nil, fmt.Errorf("corrupted packed body")
+				}
+
+				if x := p.Extra[extra]; x != nil {
+					f.Set(reflect.ValueOf(x))
+				}
+				extra++
+			case operandInt, operandUint:
+				if operands >= len(p.Operands) {
+					return nil, fmt.Errorf("corrupted packed body")
+				}
+
+				n := p.Operands[operands]
+				operands++
+				switch f.Kind() {
+				case reflect.Bool:
+					f.SetBool(n != 0)
+				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+					f.SetUint(uint64(n))
+				default:
+					f.SetInt(n)
+				}
+			case operandPosition:
+				if i >= len(p.Positions) || int(p.Positions[i]) >= len(p.PositionTable) {
+					return nil, fmt.Errorf("corrupted packed body")
+				}
+
+				f.Set(reflect.ValueOf(p.PositionTable[p.Positions[i]]))
+			}
+		}
+		r[i] = v.Interface().(Operation)
+	}
+	return r, nil
+}
@@ -0,0 +1,1255 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/cznic/internal/buffer"
+)
+
+// WriteAssembly writes objs to w in the textual assembly form accepted by
+// Parse. Every object starts with a header line
+//
+//	alias	Linkage, name, type, target[+offset]	; typeName position
+//	data	Linkage, name, type[, value]	; typeName position
+//	func	Linkage, name, type, (arguments), (results)[, chain type]	; typeName position
+//
+// where data with thread local storage is introduced by "data(tls)" instead
+// of "data", read-only data by "data(ro)" and read-only data with thread
+// local storage by "data(tls,ro)", and the function attributes, if any, follow "func" in
+// parenthesis, like in "func(noreturn)". The header of a function definition is followed by the String
+// forms of the operations of its body, one per line except for Switch.
+func WriteAssembly(w io.Writer, objs []Object) error {
+	var buf buffer.Bytes
+
+	defer buf.Close()
+
+	for _, v := range objs {
+		buf.Reset()
+		switch x := v.(type) {
+		case *AliasDefinition:
+			fmt.Fprintf(&buf, "alias\t%v, %v, %v, %v", x.Linkage, x.NameID, x.TypeID, x.Target)
+			if x.Offset != 0 {
+				fmt.Fprintf(&buf, "+%v", x.Offset)
+			}
+			fmt.Fprintf(&buf, "\t; %s %s\n", x.TypeName, x.Position)
+		case *DataDefinition:
+			s := "data"
+			switch {
+			case x.TLS && x.ReadOnly:
+				s += "(tls,ro)"
+			case x.TLS:
+				s += "(tls)"
+			case x.ReadOnly:
+				s += "(ro)"
+			}
+			fmt.Fprintf(&buf, "%s\t%v, %v, %v", s, x.Linkage, x.NameID, x.TypeID)
+			if x.Value != nil {
+				fmt.Fprintf(&buf, ", %v", x.Value)
+			}
+			fmt.Fprintf(&buf, "\t; %s %s\n", x.TypeName, x.Position)
+		case *FunctionDefinition:
+			s := "func"
+			if x.Attributes != 0 {
+				s += "(" + x.Attributes.String() + ")"
+			}
+			fmt.Fprintf(&buf, "%s\t%v, %v, %v, (%s), (%s)", s, x.Linkage, x.NameID, x.TypeID, joinNames(x.Arguments), joinNames(x.Results))
+			if x.StaticChain != 0 {
+				fmt.Fprintf(&buf, ", chain %v", x.StaticChain)
+			}
+			fmt.Fprintf(&buf, "\t; %s %s\n", x.TypeName, x.Position)
+			for _, op := range x.Body {
+				fmt.Fprintf(&buf, "%v\n", op)
+			}
+		default:
+			return fmt.Errorf("unsupported object %T", x)
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinNames(a []NameID) string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = v.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// ParseFile parses the named file, see Parse.
+func ParseFile(filename string) ([]Object, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(filename, b)
+}
+
+// Parse parses src, the textual assembly form of a translation unit as
+// produced by WriteAssembly, and returns its objects or an error, if any.
+// The filename is used only for reporting errors.
+//
+// Information not present in the textual form is not recovered. For example,
+// the linkage of Global operations is determined by the existence of an
+// object with internal linkage of the same name in src and position offsets
+// are always zero.
+func Parse(filename string, src []byte) (r []Object, err error) {
+	p := &asmParser{
+		filename:  filename,
+		internals: map[NameID]bool{},
+		lines:     strings.Split(string(src), "\n"),
+		typeCache: TypeCache{},
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			r = nil
+			err = fmt.Errorf("%s:%d: %v", p.filename, p.line+1, e)
+		}
+	}()
+
+	p.parse()
+	for _, v := range p.fix {
+		*v.Linkage = ExternalLinkage
+		if p.internals[v.NameID] {
+			*v.Linkage = InternalLinkage
+		}
+	}
+	return p.objs, nil
+}
+
+type linkageFix struct {
+	*Linkage
+	NameID
+}
+
+type asmParser struct {
+	filename  string
+	fix       []linkageFix // Linkages to resolve after parsing all objects.
+	internals map[NameID]bool
+	line      int
+	lines     []string
+	objs      []Object
+	typeCache TypeCache
+}
+
+func (p *asmParser) err(s string, args ...interface{}) { panic(fmt.Errorf(s, args...)) }
+
+func (p *asmParser) parse() {
+	var f *FunctionDefinition
+	for ; p.line < len(p.lines); p.line++ {
+		s := strings.TrimRight(p.lines[p.line], "\r")
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		fields, comment := splitLine(s)
+		switch {
+		case fields[0] == "alias":
+			f = nil
+			p.objs = append(p.objs, p.aliasDefinition(fields, comment))
+		case fields[0] == "data", strings.HasPrefix(fields[0], "data("):
+			f = nil
+			p.objs = append(p.objs, p.dataDefinition(fields, comment))
+		case fields[0] == "func", strings.HasPrefix(fields[0], "func("):
+			f = p.functionDefinition(fields, comment)
+			p.objs = append(p.objs, f)
+		case f == nil:
+			p.err("unexpected %q", s)
+		case fields[0] != "":
+			f.Body = append(f.Body, p.label(fields[0], comment))
+		default:
+			if len(fields) < 2 {
+				p.err("missing operation")
+			}
+
+			var args string
+			if len(fields) > 2 {
+				args = fields[2]
+			}
+			f.Body = append(f.Body, p.operation(strings.TrimSpace(fields[1]), args, comment))
+		}
+	}
+}
+
+// splitLine returns the tab separated fields of s and the text following the
+// semicolon of the last field, if any.
+func splitLine(s string) ([]string, string) {
+	fields := strings.Split(s, "\t")
+	n := len(fields)
+	if n > 1 && strings.HasPrefix(fields[n-1], ";") {
+		return fields[:n-1], strings.TrimSpace(fields[n-1][1:])
+	}
+
+	return fields, ""
+}
+
+// splitOperands splits s at commas not nested in brackets or quotes.
+func splitOperands(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	var r []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case ',':
+			if depth == 0 {
+				r = append(r, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(r, strings.TrimSpace(s[start:]))
+}
+
+// comment returns the type name and position encoded in the comment c.
+func (p *asmParser) comment(c string) (NameID, token.Position) {
+	i := strings.LastIndex(c, " ")
+	return p.name(strings.TrimSpace(c[:i+1])), p.position(c[i+1:])
+}
+
+func (p *asmParser) position(s string) (r token.Position) {
+	if s == "" || s == "-" {
+		return r
+	}
+
+	a := strings.Split(s, ":")
+	var n []int
+	for len(a) != 0 && len(n) < 2 {
+		v, err := strconv.Atoi(a[len(a)-1])
+		if err != nil {
+			break
+		}
+
+		n = append([]int{v}, n...)
+		a = a[:len(a)-1]
+	}
+	r.Filename = strings.Join(a, ":")
+	switch len(n) {
+	case 1:
+		r.Line = n[0]
+	case 2:
+		r.Line, r.Column = n[0], n[1]
+	}
+	return r
+}
+
+func (p *asmParser) name(s string) NameID {
+	if s == "" {
+		return 0
+	}
+
+	return NameID(dict.SID(s))
+}
+
+func (p *asmParser) typ(s string) TypeID {
+	if s == "" {
+		p.err("missing type")
+	}
+
+	id := TypeID(dict.SID(s))
+	if _, err := p.typeCache.Type(id); err != nil {
+		p.err("%v", err)
+	}
+
+	return id
+}
+
+// signed reports whether t, which was already parsed by typ, is a signed
+// integer type.
+func (p *asmParser) signed(t TypeID) bool {
+	u, err := p.typeCache.Type(t)
+	return err == nil && isSigned(u.Kind())
+}
+
+func (p *asmParser) int(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		p.err("%v", err)
+	}
+
+	return n
+}
+
+// index parses "#n", returning n and whether the operand was prefixed by &.
+func (p *asmParser) index(s string) (int, bool) {
+	addr := strings.HasPrefix(s, "&")
+	if addr {
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "#") {
+		p.err("expected index, got %q", s)
+	}
+
+	return p.int(s[1:]), addr
+}
+
+func (p *asmParser) linkage(s string) Linkage {
+	for l := Linkage(0); l <= CommonLinkage; l++ {
+		if l.String() == s {
+			return l
+		}
+	}
+	p.err("invalid linkage %q", s)
+	panic("unreachable")
+}
+
+func (p *asmParser) operands(s string, min, max int) []string {
+	a := splitOperands(s)
+	if len(a) < min || len(a) > max {
+		p.err("invalid number of operands in %q", s)
+	}
+
+	return a
+}
+
+func (p *asmParser) aliasDefinition(fields []string, comment string) *AliasDefinition {
+	if len(fields) < 2 {
+		p.err("missing alias definition")
+	}
+
+	a := p.operands(fields[1], 4, 4)
+	d := &AliasDefinition{Index: -1, ObjectBase: ObjectBase{Linkage: p.linkage(a[0]), NameID: p.name(a[1]), TypeID: p.typ(a[2])}}
+	d.TypeName, d.Position = p.comment(comment)
+	d.Target, d.Offset = p.nameOffset(a[3])
+	if d.Linkage == InternalLinkage {
+		p.internals[d.NameID] = true
+	}
+	return d
+}
+
+// nameOffset parses name[+offset].
+func (p *asmParser) nameOffset(s string) (NameID, uintptr) {
+	i := strings.LastIndexByte(s, '+')
+	if i < 0 {
+		return p.name(s), 0
+	}
+
+	n, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		p.err("invalid offset %q", s)
+	}
+
+	return p.name(s[:i]), uintptr(n)
+}
+
+func (p *asmParser) dataDefinition(fields []string, comment string) *DataDefinition {
+	if len(fields) < 2 {
+		p.err("missing data definition")
+	}
+
+	a := p.operands(fields[1], 3, 4)
+	d := &DataDefinition{ObjectBase: ObjectBase{Linkage: p.linkage(a[0]), NameID: p.name(a[1]), TypeID: p.typ(a[2])}}
+	switch fields[0] {
+	case "data":
+		// nop
+	case "data(tls)":
+		d.TLS = true
+	case "data(ro)":
+		d.ReadOnly = true
+	case "data(tls,ro)":
+		d.TLS = true
+		d.ReadOnly = true
+	default:
+		p.err("unexpected %q", fields[0])
+	}
+	d.TypeName, d.Position = p.comment(comment)
+	if len(a) == 4 {
+		d.Value = p.value(a[3], p.typeCache.MustType(d.TypeID))
+	}
+	if d.Linkage == InternalLinkage {
+		p.internals[d.NameID] = true
+	}
+	return d
+}
+
+func (p *asmParser) functionDefinition(fields []string, comment string) *FunctionDefinition {
+	if len(fields) < 2 {
+		p.err("missing function definition")
+	}
+
+	a := p.operands(fields[1], 5, 6)
+	f := &FunctionDefinition{ObjectBase: ObjectBase{Linkage: p.linkage(a[0]), NameID: p.name(a[1]), TypeID: p.typ(a[2])}}
+	f.TypeName, f.Position = p.comment(comment)
+	f.Attributes = p.attributes(fields[0][len("func"):])
+	f.Arguments = p.names(a[3])
+	f.Results = p.names(a[4])
+	if len(a) == 6 {
+		if !strings.HasPrefix(a[5], "chain ") {
+			p.err("unexpected %q", a[5])
+		}
+
+		f.StaticChain = p.typ(strings.TrimSpace(a[5][len("chain "):]))
+	}
+	if f.Linkage == InternalLinkage {
+		p.internals[f.NameID] = true
+	}
+	return f
+}
+
+// attributes parses an optional parenthesized list of function attributes.
+func (p *asmParser) attributes(s string) Attributes {
+	if s == "" {
+		return 0
+	}
+
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		p.err("expected parenthesized list, got %q", s)
+	}
+
+	var r Attributes
+next:
+	for _, v := range strings.Split(s[1:len(s)-1], ",") {
+		for i, nm := range attrNames {
+			if nm == v {
+				r |= 1 << uint(i)
+				continue next
+			}
+		}
+
+		p.err("unknown function attribute %q", v)
+	}
+	return r
+}
+
+// types parses a parenthesized list of types.
+func (p *asmParser) types(s string) []TypeID {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		p.err("expected parenthesized list, got %q", s)
+	}
+
+	r := []TypeID{}
+	for _, v := range splitOperands(s[1 : len(s)-1]) {
+		r = append(r, p.typ(v))
+	}
+	return r
+}
+
+func (p *asmParser) names(s string) []NameID {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		p.err("expected parenthesized list, got %q", s)
+	}
+
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil
+	}
+
+	var r []NameID
+	for _, v := range strings.Split(s, ",") {
+		r = append(r, p.name(strings.TrimSpace(v)))
+	}
+	return r
+}
+
+func (p *asmParser) label(s, comment string) *Label {
+	if !strings.HasSuffix(s, ":") {
+		p.err("unexpected %q", s)
+	}
+
+	s = s[:len(s)-1]
+	l := &Label{Position: p.position(comment)}
+	if i := strings.Index(s, "("); i >= 0 {
+		switch s[i:] {
+		case "(&&)":
+			l.LAnd = true
+		case "(||)":
+			l.LOr = true
+		case "(a?b:c)":
+			l.Cond = true
+		case "(nop)":
+			l.Nop = true
+		default:
+			p.err("invalid label modifier %q", s[i:])
+		}
+		s = s[:i]
+	}
+	p.labelTarget(s, &l.NameID, &l.Number)
+	return l
+}
+
+func (p *asmParser) labelTarget(s string, nm *NameID, number *int) {
+	if n, err := strconv.Atoi(s); err == nil {
+		*number = n
+		return
+	}
+
+	*nm = p.name(s)
+}
+
+// bitField parses ":bits@offset".
+func (p *asmParser) bitField(s string) (bits, off int, rest string) {
+	if !strings.HasPrefix(s, ":") {
+		p.err("invalid bit field %q", s)
+	}
+
+	s = s[1:]
+	i := strings.Index(s, "@")
+	if i < 0 {
+		p.err("invalid bit field %q", s)
+	}
+
+	bits = p.int(s[:i])
+	s = s[i+1:]
+	j := strings.Index(s, ":")
+	if j < 0 {
+		return bits, p.int(s), ""
+	}
+
+	return bits, p.int(s[:j]), s[j+1:]
+}
+
+func (p *asmParser) increment(mnemonic, args, comment string) Operation {
+	var t, bits string
+	pre := strings.HasPrefix(mnemonic, "++")
+	switch {
+	case pre:
+		t = mnemonic[2:]
+	default:
+		t = mnemonic[:len(mnemonic)-2]
+	}
+	if i := strings.Index(t, ":"); i >= 0 {
+		t, bits = strings.TrimSpace(t[:i]), strings.TrimSpace(t[i:])
+	}
+	var b, off int
+	var bt TypeID
+	if bits != "" {
+		var rest string
+		b, off, rest = p.bitField(bits)
+		bt = p.typ(rest)
+	}
+	pos := p.position(comment)
+	a := strings.Fields(args)
+	if len(a) == 0 {
+		p.err("missing delta")
+	}
+
+	var atomic, restrict, volatile bool
+	for _, v := range a[1:] {
+		switch v {
+		case "atomic":
+			atomic = true
+		case "restrict":
+			restrict = true
+		case "volatile":
+			volatile = true
+		default:
+			p.err("invalid qualifier %q", v)
+		}
+	}
+	if pre {
+		return &PreIncrement{Atomic: atomic, BitFieldType: bt, BitOffset: off, Bits: b, Delta: p.int(a[0]), Restrict: restrict, TypeID: p.typ(t), Volatile: volatile, Position: pos}
+	}
+
+	return &PostIncrement{Atomic: atomic, BitFieldType: bt, BitOffset: off, Bits: b, Delta: p.int(a[0]), Restrict: restrict, TypeID: p.typ(t), Volatile: volatile, Position: pos}
+}
+
+func (p *asmParser) operation(mnemonic, args, comment string) Operation {
+	if strings.HasPrefix(mnemonic, "++") || strings.HasSuffix(mnemonic, "++") {
+		return p.increment(mnemonic, args, comment)
+	}
+
+	var mods string
+	if i := strings.Index(mnemonic, "("); i >= 0 {
+		mnemonic, mods = mnemonic[:i], mnemonic[i:]
+	}
+	has := func(s string) bool { return strings.Contains(mods, s) }
+	var pos token.Position
+	switch mnemonic {
+	case "allocResult", "global", "varDecl":
+		// Handled below.
+	default:
+		pos = p.position(comment)
+	}
+	typ := func() TypeID { return p.typ(p.operands(args, 1, 1)[0]) }
+	switch mnemonic {
+	case "addOv":
+		return &AddOv{TypeID: typ(), Position: pos}
+	case "add":
+		return &Add{Overflow: p.overflow(mods), TypeID: typ(), Position: pos}
+	case "allocResult":
+		o := &AllocResult{TypeID: typ()}
+		o.TypeName, o.Position = p.comment(comment)
+		return o
+	case "alloca":
+		a := p.operands(args, 2, 2)
+		return &Alloca{TypeID: p.typ(a[0]), Size: p.typ(a[1]), Position: pos}
+	case "and":
+		return &And{TypeID: typ(), Position: pos}
+	case "annotate":
+		o := &Annotate{Position: pos}
+		for _, v := range splitOperands(args) {
+			i := strings.IndexByte(v, ' ')
+			if i < 0 {
+				p.err("invalid annotation %q", v)
+			}
+
+			s, err := strconv.Unquote(strings.TrimSpace(v[i+1:]))
+			if err != nil {
+				p.err("invalid annotation %q: %v", v, err)
+			}
+
+			o.Annotations = append(o.Annotations, Annotation{Key: p.name(v[:i]), Value: s})
+		}
+		return o
+	case "argument":
+		a := p.operands(args, 2, 2)
+		n, addr := p.index(a[0])
+		return &Argument{Address: addr, Index: n, TypeID: p.typ(a[1]), Position: pos}
+	case "arguments":
+		return &Arguments{FunctionPointer: strings.TrimSpace(args) == "fp", Position: pos}
+	case "beginScope":
+		return &BeginScope{Value: strings.TrimSpace(args) == "value", Position: pos}
+	case "bool":
+		return &Bool{TypeID: typ(), Position: pos}
+	case "bswap":
+		return &Bswap{TypeID: typ(), Position: pos}
+	case "call":
+		a := p.operands(args, 2, 4)
+		index := -1
+		if strings.HasPrefix(a[0], "#") {
+			index, _ = p.index(a[0])
+			a = a[1:]
+		}
+		o := &Call{Arguments: p.int(a[0]), Comma: has("(,)"), Index: index, TypeID: p.typ(a[1]), Position: pos}
+		if len(a) > 2 {
+			o.VarArgTypes = p.types(a[2])
+		}
+		return o
+	case "callfp":
+		a := p.operands(args, 2, 3)
+		o := &CallFP{Arguments: p.int(a[0]), Comma: has("(,)"), TypeID: p.typ(a[1]), Position: pos}
+		if len(a) > 2 {
+			o.VarArgTypes = p.types(a[2])
+		}
+		return o
+	case "chain":
+		return &Chain{TypeID: typ(), Position: pos}
+	case "clz":
+		return &Clz{TypeID: typ(), Position: pos}
+	case "closure":
+		a := p.operands(args, 3, 4)
+		index := -1
+		if len(a) == 4 {
+			index, _ = p.index(a[0])
+			a = a[1:]
+		}
+		return &Closure{Chain: p.typ(a[1]), Index: index, NameID: p.name(a[0]), TypeID: p.typ(a[2]), Position: pos}
+	case "const":
+		return p.constant(has("(nop)"), args, pos)
+	case "convert":
+		a := p.operands(args, 2, 2)
+		o := &Convert{TypeID: p.typ(a[0]), Result: p.typ(a[1]), Position: pos}
+		o.Rounding, o.OutOfRange = p.convertModifiers(mods)
+		return o
+	case "copy":
+		return &Copy{Atomic: has("atomic"), Restrict: has("restrict"), TypeID: typ(), Volatile: has("volatile"), Position: pos}
+	case "cpl":
+		return &Cpl{TypeID: typ(), Position: pos}
+	case "ctz":
+		return &Ctz{TypeID: typ(), Position: pos}
+	case "div":
+		o := &Div{TypeID: typ(), Position: pos}
+		o.Mode, o.Exact, o.NonZero, o.NoOverflow = p.divModifiers(mods)
+		return o
+	case "drop":
+		return &Drop{Comma: has("(,)"), LOp: has("(nop)"), TypeID: typ(), Position: pos}
+	case "dup":
+		return &Dup{TypeID: typ(), Position: pos}
+	case "element":
+		a := p.operands(args, 2, 3)
+		s := a[0]
+		addr := strings.HasPrefix(s, "&")
+		if addr {
+			s = s[1:]
+		}
+		if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+			p.err("invalid element index %q", a[0])
+		}
+
+		s = s[1 : len(s)-1]
+		neg := strings.HasPrefix(s, "-")
+		if neg {
+			s = s[1:]
+		}
+		o := &Element{Address: addr, BoundsChecked: has("checked"), IndexType: p.typ(s), Neg: neg, TypeID: p.typ(a[1]), Position: pos}
+		switch {
+		case len(a) == 3:
+			if !o.BoundsChecked {
+				p.err("unexpected element items %q", a[2])
+			}
+			o.Items = int64(p.int(a[2]))
+		case o.BoundsChecked:
+			o.ItemsUnknown = true
+		}
+		return o
+	case "endScope":
+		return &EndScope{Value: strings.TrimSpace(args) == "value", Position: pos}
+	case "eq":
+		return &Eq{TypeID: typ(), Position: pos}
+	case "fence":
+		o := &Fence{Position: pos}
+		if has("compiler") {
+			o.Scope = FenceCompiler
+		}
+		return o
+	case "field":
+		a := p.operands(args, 2, 2)
+		n, addr := p.index(a[0])
+		return &Field{Address: addr, Index: n, TypeID: p.typ(a[1]), Position: pos}
+	case "fieldvalue":
+		a := p.operands(args, 2, 2)
+		n, _ := p.index(a[0])
+		return &FieldValue{Index: n, TypeID: p.typ(a[1]), Position: pos}
+	case "free":
+		return &Free{TypeID: typ(), Position: pos}
+	case "geq":
+		t := typ()
+		return &Geq{Signed: p.signed(t), TypeID: t, Position: pos}
+	case "global":
+		a := p.operands(args, 2, 3)
+		o := &Global{Index: -1, TLS: has("tls")}
+		if len(a) == 3 {
+			o.Index, _ = p.index(a[0])
+			a = a[1:]
+		}
+		nm := a[0]
+		if strings.HasPrefix(nm, "&") {
+			o.Address = true
+			nm = nm[1:]
+		}
+		o.NameID, o.Offset = p.nameOffset(nm)
+		o.TypeID = p.typ(a[1])
+		o.TypeName, o.Position = p.comment(comment)
+		p.fix = append(p.fix, linkageFix{&o.Linkage, o.NameID})
+		return o
+	case "gt":
+		t := typ()
+		return &Gt{Signed: p.signed(t), TypeID: t, Position: pos}
+	case "jmp":
+		if strings.TrimSpace(args) == "(sp)" {
+			return &JmpP{Position: pos}
+		}
+
+		o := &Jmp{Cond: has("(nop)"), Position: pos}
+		p.labelTarget(strings.TrimSpace(args), &o.NameID, &o.Number)
+		return o
+	case "jnz":
+		o := &Jnz{LOp: has("(nop)"), Position: pos}
+		p.labelTarget(strings.TrimSpace(args), &o.NameID, &o.Number)
+		return o
+	case "jz":
+		o := &Jz{LOp: has("(nop)"), Position: pos}
+		p.labelTarget(strings.TrimSpace(args), &o.NameID, &o.Number)
+		return o
+	case "labeladdr":
+		a := p.operands(args, 2, 2)
+		if !strings.HasPrefix(a[0], "&&") {
+			p.err("invalid label address %q", a[0])
+		}
+
+		o := &LabelAddr{TypeID: p.typ(a[1]), Position: pos}
+		p.labelTarget(a[0][2:], &o.NameID, &o.Number)
+		return o
+	case "leq":
+		t := typ()
+		return &Leq{Signed: p.signed(t), TypeID: t, Position: pos}
+	case "load":
+		return &Load{Atomic: has("atomic"), Restrict: has("restrict"), TypeID: typ(), Volatile: has("volatile"), Position: pos}
+	case "lsh":
+		a := p.operands(args, 1, 2)
+		o := &Lsh{TypeID: p.typ(a[0]), Position: pos}
+		if len(a) == 2 {
+			o.CountType = p.typ(a[1])
+		}
+		return o
+	case "lt":
+		t := typ()
+		return &Lt{Signed: p.signed(t), TypeID: t, Position: pos}
+	case "mulOv":
+		return &MulOv{TypeID: typ(), Position: pos}
+	case "mul":
+		return &Mul{Overflow: p.overflow(mods), TypeID: typ(), Position: pos}
+	case "neg":
+		return &Neg{Overflow: p.overflow(mods), TypeID: typ(), Position: pos}
+	case "neq":
+		return &Neq{TypeID: typ(), Position: pos}
+	case "new":
+		a := p.operands(args, 1, 2)
+		o := &New{TypeID: p.typ(a[0]), Position: pos}
+		if len(a) == 2 {
+			o.Size = p.typ(a[1])
+		}
+		return o
+	case "nil":
+		return &Nil{TypeID: typ(), Position: pos}
+	case "not":
+		return &Not{Position: pos}
+	case "or":
+		return &Or{TypeID: typ(), Position: pos}
+	case "panic":
+		return &Panic{Position: pos}
+	case "popcnt":
+		return &Popcnt{TypeID: typ(), Position: pos}
+	case "ptrDiff":
+		a := p.operands(args, 2, 2)
+		return &PtrDiff{PtrType: p.typ(a[0]), TypeID: p.typ(a[1]), Position: pos}
+	case "rem":
+		o := &Rem{TypeID: typ(), Position: pos}
+		var exact bool
+		if o.Mode, exact, o.NonZero, o.NoOverflow = p.divModifiers(mods); exact {
+			p.err("invalid modifier %q", mods)
+		}
+		return o
+	case "restoreContext":
+		return &RestoreContext{TypeID: typ(), Position: pos}
+	case "result":
+		a := p.operands(args, 2, 2)
+		n, addr := p.index(a[0])
+		return &Result{Address: addr, Index: n, TypeID: p.typ(a[1]), Position: pos}
+	case "return":
+		return &Return{Position: pos}
+	case "rsh":
+		a := p.operands(args, 1, 2)
+		o := &Rsh{TypeID: p.typ(a[0]), Position: pos}
+		if len(a) == 2 {
+			o.CountType = p.typ(a[1])
+		}
+		return o
+	case "saveContext":
+		return &SaveContext{TypeID: typ(), Position: pos}
+	case "select":
+		return &Select{TypeID: typ(), Position: pos}
+	case "store":
+		s := strings.TrimSpace(args)
+		o := &Store{Atomic: has("atomic"), Restrict: has("restrict"), Volatile: has("volatile"), Position: pos}
+		if i := strings.Index(s, ":"); i >= 0 {
+			o.Bits, o.BitOffset, _ = p.bitField(s[i:])
+			s = s[:i]
+		}
+		o.TypeID = p.typ(s)
+		return o
+	case "subOv":
+		return &SubOv{TypeID: typ(), Position: pos}
+	case "sub":
+		return &Sub{Overflow: p.overflow(mods), TypeID: typ(), Position: pos}
+	case "switch":
+		return p.switchOp(typ(), pos)
+	case "varDecl":
+		return p.varDecl(args, comment)
+	case "variable":
+		a := p.operands(args, 2, 2)
+		n, addr := p.index(a[0])
+		return &Variable{Address: addr, Index: n, TypeID: p.typ(a[1]), Position: pos}
+	case "xor":
+		return &Xor{TypeID: typ(), Position: pos}
+	case "zero":
+		return &Zero{TypeID: typ(), Position: pos}
+	}
+	p.err("unknown operation %q", mnemonic)
+	panic("unreachable")
+}
+
+func (p *asmParser) overflow(mods string) Overflow {
+	for o := OverflowUndefined; o <= OverflowTrap; o++ {
+		if o.suffix() == mods {
+			return o
+		}
+	}
+	p.err("invalid modifier %q", mods)
+	panic("unreachable")
+}
+
+func (p *asmParser) convertModifiers(mods string) (r Rounding, o OutOfRange) {
+	if mods == "" {
+		return r, o
+	}
+
+	if len(mods) < 2 || mods[0] != '(' || mods[len(mods)-1] != ')' {
+		p.err("invalid modifier %q", mods)
+	}
+
+next:
+	for _, v := range strings.Split(mods[1:len(mods)-1], ",") {
+		for n := RoundNearestEven; n <= RoundDown; n++ {
+			if n.suffix() == "("+v+")" {
+				r = n
+				continue next
+			}
+		}
+		for n := OutOfRangeSaturate; n <= OutOfRangeTrap; n++ {
+			if n.suffix() == "("+v+")" {
+				o = n
+				continue next
+			}
+		}
+		p.err("invalid modifier %q", mods)
+	}
+	return r, o
+}
+
+func (p *asmParser) divModifiers(mods string) (m DivMode, exact, nonZero, noOverflow bool) {
+	if mods == "" {
+		return m, false, false, false
+	}
+
+	if len(mods) < 2 || mods[0] != '(' || mods[len(mods)-1] != ')' {
+		p.err("invalid modifier %q", mods)
+	}
+
+next:
+	for _, v := range strings.Split(mods[1:len(mods)-1], ",") {
+		switch v {
+		case "exact":
+			exact = true
+		case "noov":
+			noOverflow = true
+		case "nonzero":
+			nonZero = true
+		default:
+			for n := DivPanic; n <= DivZero; n++ {
+				if n.suffix() == "("+v+")" {
+					m = n
+					continue next
+				}
+			}
+			p.err("invalid modifier %q", mods)
+		}
+	}
+	return m, exact, nonZero, noOverflow
+}
+
+func (p *asmParser) constant(lop bool, args string, pos token.Position) Operation {
+	a := p.operands(args, 2, 2)
+	v, id := a[0], p.typ(a[1])
+	t := p.typeCache.MustType(id)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			p.err("%v", err)
+		}
+
+		return &StringConst{TypeID: id, Value: StringID(dict.SID(s)), Position: pos}
+	case strings.HasPrefix(v, "0x"):
+		n, err := strconv.ParseUint(v[2:], 16, 64)
+		if err != nil {
+			p.err("%v", err)
+		}
+
+		switch t.Kind() {
+		case Int64, Uint64, Float64:
+			if !lop {
+				return &Const64{TypeID: id, Value: int64(n), Position: pos}
+			}
+		}
+		return &Const32{LOp: lop, TypeID: id, Value: int32(n), Position: pos}
+	}
+
+	switch t.Kind() {
+	case Complex64, Complex128, Complex256:
+		var c complex128
+		if _, err := fmt.Sscan(v, &c); err != nil {
+			p.err("%v", err)
+		}
+
+		return &ConstC128{TypeID: id, Value: c, Position: pos}
+	}
+
+	return &Const{TypeID: id, Value: p.value(v, t), Position: pos}
+}
+
+func (p *asmParser) switchOp(t TypeID, pos token.Position) Operation {
+	o := &Switch{TypeID: t, Position: pos}
+	for p.line+1 < len(p.lines) {
+		s := strings.TrimRight(p.lines[p.line+1], "\r")
+		fields, comment := splitLine(s)
+		if len(fields) != 3 || fields[0] != "" || !strings.HasPrefix(fields[2], "goto ") {
+			break
+		}
+
+		p.line++
+		l := Label{Position: p.position(comment)}
+		p.labelTarget(strings.TrimSpace(fields[2][len("goto "):]), &l.NameID, &l.Number)
+		switch c := fields[1]; {
+		case c == "default:":
+			o.Default = l
+			return o
+		case strings.HasPrefix(c, "case ") && strings.HasSuffix(c, ":"):
+			o.Values = append(o.Values, p.value(c[len("case "):len(c)-1], p.typeCache.MustType(t)))
+			o.Labels = append(o.Labels, l)
+		default:
+			p.err("unexpected %q", s)
+		}
+	}
+	p.err("missing switch default")
+	panic("unreachable")
+}
+
+func (p *asmParser) varDecl(args, comment string) Operation {
+	a := p.operands(args, 3, 3)
+	n, _ := p.index(a[0])
+	o := &VariableDeclaration{Index: n, NameID: p.name(a[1])}
+	o.TypeName, o.Position = p.comment(comment)
+	s := a[2]
+	if _, err := p.typeCache.Type(TypeID(dict.SID(s))); err == nil || !strings.HasSuffix(s, ")") {
+		o.TypeID = p.typ(s)
+		return o
+	}
+
+	// type(value)
+	depth := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		switch s[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth--; depth == 0 {
+				o.TypeID = p.typ(s[:i])
+				o.Value = p.value(s[i+1:len(s)-1], p.typeCache.MustType(o.TypeID))
+				return o
+			}
+		}
+	}
+	p.err("invalid variable declaration %q", s)
+	panic("unreachable")
+}
+
+// value parses the String form of a Value. The type t determines the kind of
+// the numeric values.
+func (p *asmParser) value(s string, t Type) Value {
+	s = strings.TrimSpace(s)
+	k := t.Kind()
+	switch {
+	case s == "":
+		p.err("missing value")
+	case s[0] == '{':
+		if s[len(s)-1] != '}' {
+			p.err("invalid composite value %q", s)
+		}
+
+		r := &CompositeValue{}
+		var index int
+		for _, v := range splitOperands(s[1 : len(s)-1]) {
+			designated := false
+			if i := designator(v); i >= 0 {
+				index = p.int(v[:i])
+				v = v[i+1:]
+				designated = true
+			}
+			var item Value
+			switch x := t.(type) {
+			case *ArrayType:
+				item = p.value(v, x.Item)
+			case *StructOrUnionType:
+				if index < 0 || index >= len(x.Fields) {
+					p.err("field index %v out of range", index)
+				}
+
+				item = p.value(v, x.Fields[index])
+			default:
+				item = p.value(v, t)
+			}
+			if designated {
+				item = &DesignatedValue{Index: index, Value: item}
+			}
+			r.Values = append(r.Values, item)
+			index++
+		}
+		return r
+	case s[0] == '"':
+		if i := strings.LastIndex(s, `"+`); i > 0 {
+			if str, err := strconv.Unquote(s[:i+1]); err == nil {
+				return &StringValue{Offset: uintptr(p.int(s[i+2:])), StringID: StringID(dict.SID(str))}
+			}
+		}
+
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			p.err("%v", err)
+		}
+
+		return &WideStringValue{Value: []rune(str)}
+	case s[0] == '(':
+		switch k {
+		case Complex64, Complex128, Complex256:
+			var c complex128
+			if _, err := fmt.Sscan(s, &c); err != nil {
+				p.err("%v", err)
+			}
+
+			if k == Complex64 {
+				return &Complex64Value{Value: complex64(c)}
+			}
+
+			return &Complex128Value{Value: c}
+		}
+
+		if strings.HasPrefix(s, "((") {
+			return p.differenceValue(s)
+		}
+
+		return p.addressValue(s)
+	}
+
+	switch k {
+	case Float128:
+		n, _, err := big.ParseFloat(s, 0, Float128Prec, big.ToNearestEven)
+		if err != nil {
+			p.err("%v", err)
+			n = new(big.Float)
+		}
+
+		return &Float128Value{Value: n}
+	case Float16, Float32, Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			p.err("%v", err)
+		}
+
+		if k != Float64 {
+			return &Float32Value{Value: float32(n)}
+		}
+
+		return &Float64Value{Value: n}
+	case Int128, Uint128:
+		n, ok := new(big.Int).SetString(strings.TrimSuffix(s, "u"), 10)
+		if !ok {
+			p.err("invalid integer %s", s)
+			n = new(big.Int)
+		}
+
+		return &Int128Value{Value: n}
+	}
+
+	if strings.HasSuffix(s, "u") {
+		n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			p.err("%v", err)
+		}
+
+		switch {
+		case k == Int64, k == Uint64, n != uint64(uint32(n)):
+			return &Uint64Value{Value: n}
+		default:
+			return &Uint32Value{Value: uint32(n)}
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.err("%v", err)
+	}
+
+	switch {
+	case k == Int64, k == Uint64, n != int64(int32(n)):
+		return &Int64Value{Value: n}
+	default:
+		return &Int32Value{Value: int32(n)}
+	}
+}
+
+// designator returns the index of the colon of a "n: value" composite item or
+// -1.
+func designator(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ':':
+			return i
+		case c < '0' || c > '9':
+			return -1
+		}
+	}
+	return -1
+}
+
+func (p *asmParser) differenceValue(s string) Value {
+	i := strings.Index(s, ") - (")
+	if i < 0 || s[len(s)-1] != ')' {
+		p.err("invalid difference value %q", s)
+	}
+
+	a, _ := p.addressValue(s[1 : i+1]).(*AddressValue)
+	b, _ := p.addressValue(s[i+4 : len(s)-1]).(*AddressValue)
+	return &DifferenceValue{A: a, B: b}
+}
+
+func (p *asmParser) addressValue(s string) Value {
+	if s[len(s)-1] != ')' {
+		p.err("invalid address value %q", s)
+	}
+
+	a := p.operands(s[1:len(s)-1], 2, 3)
+	r := &AddressValue{}
+	target := a[len(a)-1]
+	switch idx := a[0]; {
+	case len(a) == 3:
+		r.Index = p.int(idx)
+		r.NameID = p.name(a[1])
+		if !strings.HasPrefix(target, "&&") {
+			p.err("invalid label address %q", target)
+		}
+
+		target = target[2:]
+		p.fix = append(p.fix, linkageFix{&r.Linkage, r.NameID})
+	case strings.HasPrefix(idx, "extern "):
+		r.Linkage = ExternalLinkage
+		r.Index = p.int(idx[len("extern "):])
+	case strings.HasPrefix(idx, "weak "):
+		r.Linkage = WeakExternalLinkage
+		r.Index = p.int(idx[len("weak "):])
+	case strings.HasPrefix(idx, "none "):
+		r.Index = p.int(idx[len("none "):])
+	default:
+		r.Linkage = InternalLinkage
+		r.Index = p.int(idx)
+	}
+	if len(a) == 2 && r.Linkage != InternalLinkage {
+		if !strings.HasPrefix(target, "&") {
+			p.err("invalid address %q", target)
+		}
+
+		target = target[1:]
+	}
+	i := strings.LastIndex(target, "+")
+	if i < 0 {
+		p.err("invalid address %q", target)
+	}
+
+	nm := p.name(target[:i])
+	r.Offset = uintptr(p.int(target[i+1:]))
+	if len(a) == 3 {
+		r.Label = nm
+		return r
+	}
+
+	r.NameID = nm
+	return r
+}
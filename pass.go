@@ -0,0 +1,129 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// PassEliminateDeadStores removes dead stores to local variables, see
+	// EliminateDeadStores.
+	PassEliminateDeadStores = &Pass{Name: "stores", Function: EliminateDeadStores}
+
+	// PassEliminateLoads removes reloads of stored values, see
+	// EliminateLoads.
+	PassEliminateLoads = &Pass{Name: "loads", Function: EliminateLoads}
+
+	// PassFoldConstants folds constant expressions, see FoldConstants.
+	PassFoldConstants = &Pass{Name: "fold", Function: FoldConstants}
+
+	// PassMergeConstants merges identical read-only data of linked
+	// objects, see MergeConstants.
+	PassMergeConstants = &Pass{Name: "constants", Module: MergeConstants}
+
+	// PassOrderBlocks reorders basic blocks using a profile, see
+	// OrderBlocks.
+	PassOrderBlocks = &Pass{Name: "blocks", Function: OrderBlocks}
+
+	// PassUnconvert removes conversions of a type to itself.
+	PassUnconvert = &Pass{Name: "unconvert", Function: func(f *FunctionDefinition) error {
+		unconvert(&f.Body)
+		return nil
+	}}
+
+	// PassVerify verifies functions. As a side effect it removes
+	// unreachable code and turns branches on constant conditions into
+	// jumps, see FunctionDefinition.Verify.
+	PassVerify = &Pass{Name: "verify", Function: func(f *FunctionDefinition) error { return f.Verify() }}
+)
+
+// Pass is a named transformation of objects. If Function is not nil, it is
+// applied to every FunctionDefinition, then, if Module is not nil, it is
+// applied to all objects.
+type Pass struct {
+	Function func(*FunctionDefinition) error
+	Module   func([]Object) ([]Object, error)
+	Name     string
+}
+
+// PassTiming records the time spent running a Pass.
+type PassTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// PassManager runs a configurable sequence of Passes.
+type PassManager struct {
+	passes  []*Pass
+	timings []PassTiming
+}
+
+// NewPassManager returns a newly created PassManager running passes in
+// order.
+func NewPassManager(passes ...*Pass) *PassManager {
+	return &PassManager{passes: passes}
+}
+
+// NewPassManagerO returns a newly created PassManager running a preset
+// sequence of passes for the optimization level, which is 0, 1 or 2, in the
+// spirit of the -O option of C compilers. Level 0 only verifies, level 1
+// additionally folds constants. Level 2 additionally eliminates reloads of
+// stored values and dead stores. Verifying, which every level starts with,
+// also removes redundant conversions, see PassUnconvert.
+func NewPassManagerO(level int) (*PassManager, error) {
+	switch level {
+	case 0:
+		return NewPassManager(PassVerify), nil
+	case 1:
+		return NewPassManager(PassVerify, PassFoldConstants), nil
+	case 2:
+		return NewPassManager(PassVerify, PassFoldConstants, PassEliminateLoads, PassEliminateDeadStores), nil
+	default:
+		return nil, fmt.Errorf("invalid optimization level %v", level)
+	}
+}
+
+// Add appends passes to the sequence run by m.
+func (m *PassManager) Add(passes ...*Pass) { m.passes = append(m.passes, passes...) }
+
+// Passes returns the names of the passes run by m, in order.
+func (m *PassManager) Passes() []string {
+	r := make([]string, len(m.passes))
+	for i, v := range m.passes {
+		r[i] = v.Name
+	}
+	return r
+}
+
+// Run runs all passes of m over objs, in order, and returns the resulting
+// objects or the first error encountered. Run may mutate objs.
+func (m *PassManager) Run(objs []Object) ([]Object, error) {
+	m.timings = m.timings[:0]
+	for _, p := range m.passes {
+		t0 := time.Now()
+		if p.Function != nil {
+			for _, v := range objs {
+				if f, ok := v.(*FunctionDefinition); ok {
+					if err := p.Function(f); err != nil {
+						return nil, fmt.Errorf("%s: %s: %v", p.Name, f.NameID, err)
+					}
+				}
+			}
+		}
+		if p.Module != nil {
+			var err error
+			if objs, err = p.Module(objs); err != nil {
+				return nil, fmt.Errorf("%s: %v", p.Name, err)
+			}
+		}
+		m.timings = append(m.timings, PassTiming{Name: p.Name, Duration: time.Since(t0)})
+	}
+	return objs, nil
+}
+
+// Timings returns the time spent in every pass by the last call of Run.
+func (m *PassManager) Timings() []PassTiming { return m.timings }
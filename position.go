@@ -0,0 +1,359 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	_ Object = (*functionGob)(nil)
+
+	positionType = reflect.TypeOf(token.Position{})
+)
+
+// CompactPosition is a token.Position with the file name replaced by an index
+// into a file table.
+type CompactPosition struct {
+	File   int32 // Index into the file table. Negative if the position is not valid.
+	Offset int32
+	Line   int32
+	Column int32
+}
+
+// CompressPositions moves the positions of all operations in f.Body to
+// f.Positions, replacing file names by indices into f.Files, and clears the
+// positions of the operations. Compressed positions use less than half of the
+// memory and make the gob encoding of f significantly smaller.
+//
+// While the positions are compressed, the String method of operations does not
+// show their positions, use f.BodyPosition instead. CompressPositions is a nop if
+// the positions of f are already compressed.
+func (f *FunctionDefinition) CompressPositions() {
+	if f.Positions != nil || len(f.Body) == 0 {
+		return
+	}
+
+	m := map[string]int32{}
+	for i, v := range f.Files {
+		m[v] = int32(i)
+	}
+	f.Positions = make([]CompactPosition, len(f.Body))
+	for i, op := range f.Body {
+		p := op.Pos()
+		c := CompactPosition{File: -1}
+		if p.IsValid() {
+			n, ok := m[p.Filename]
+			if !ok {
+				n = int32(len(f.Files))
+				m[p.Filename] = n
+				f.Files = append(f.Files, p.Filename)
+			}
+			c = CompactPosition{File: n, Offset: int32(p.Offset), Line: int32(p.Line), Column: int32(p.Column)}
+		}
+		f.Positions[i] = c
+		setPosition(op, token.Position{})
+	}
+}
+
+// ExpandPositions reverts the effect of CompressPositions.
+func (f *FunctionDefinition) ExpandPositions() {
+	if f.Positions == nil {
+		return
+	}
+
+	for i, op := range f.Body {
+		setPosition(op, f.BodyPosition(i))
+	}
+	f.Files = nil
+	f.Positions = nil
+}
+
+// BodyPosition returns the position of f.Body[ip] whether the positions of f are
+// compressed or not. Passes editing the body of f expand its positions first,
+// BodyPosition returns an invalid position if f.Positions does not cover ip.
+func (f *FunctionDefinition) BodyPosition(ip int) token.Position {
+	if f.Positions == nil {
+		return f.Body[ip].Pos()
+	}
+
+	if ip < 0 || ip >= len(f.Positions) {
+		return token.Position{}
+	}
+
+	c := f.Positions[ip]
+	if c.File < 0 || int(c.File) >= len(f.Files) {
+		return token.Position{}
+	}
+
+	return token.Position{Filename: f.Files[c.File], Offset: int(c.Offset), Line: int(c.Line), Column: int(c.Column)}
+}
+
+// LineEntry is an item of a line table, see LineTable.
+type LineEntry struct {
+	IP       int // Index of the first operation having Position.
+	Position token.Position
+}
+
+// LineTable returns the positions of the operations of f as a list of entries
+// ordered by IP. An entry covers all the operations up to the IP of the next
+// entry. Operations without a valid position are covered by the preceding
+// entry. The table allows debuggers and error reporters to map operations to
+// source positions without retaining the positions of the operations, see
+// also CompressPositions.
+func LineTable(f *FunctionDefinition) []LineEntry {
+	var r []LineEntry
+	for ip := range f.Body {
+		p := f.BodyPosition(ip)
+		if !p.IsValid() {
+			continue
+		}
+
+		if n := len(r); n != 0 && r[n-1].Position == p {
+			continue
+		}
+
+		r = append(r, LineEntry{IP: ip, Position: p})
+	}
+	return r
+}
+
+// LinePosition returns the position of the operation at ip using line table t
+// or an invalid position if ip is not covered by t.
+func LinePosition(t []LineEntry, ip int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].IP > ip })
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return t[i-1].Position
+}
+
+// LineIPs returns, in ascending order, the IPs of the entries of line table t
+// having position filename:line. It's the reverse of LinePosition, for
+// example for setting breakpoints.
+func LineIPs(t []LineEntry, filename string, line int) []int {
+	var r []int
+	for _, v := range t {
+		if v.Position.Line == line && v.Position.Filename == filename {
+			r = append(r, v.IP)
+		}
+	}
+	return r
+}
+
+// PathRule rewrites the file names starting with the path From, see
+// RemapPositions.
+type PathRule struct {
+	From string // Path to replace, like "/tmp/sandbox/src".
+	To   string // Replacement of From, like "/home/user/project".
+}
+
+// apply returns the file name s rewritten by r and whether r applies to s. The
+// rule applies only to complete path elements, "/a/b" rewrites "/a/b" and
+// "/a/b/c.c", but not "/a/bc.c".
+func (r PathRule) apply(s string) (string, bool) {
+	if r.From == "" || !strings.HasPrefix(s, r.From) {
+		return s, false
+	}
+
+	if rest := s[len(r.From):]; rest != "" && rest[0] != '/' && rest[0] != '\\' && !strings.HasSuffix(r.From, "/") && !strings.HasSuffix(r.From, "\\") {
+		return s, false
+	}
+
+	return r.To + s[len(r.From):], true
+}
+
+// RemapPositions rewrites the file names of all positions in objects, the
+// positions of the objects, of the operations, including the labels of Switch
+// operations, and the file tables of compressed function positions. The first
+// of rules applying to a file name rewrites it, other file names are kept.
+// Build systems use RemapPositions to replace the paths of a build sandbox by
+// the paths of the sources for reproducible builds or for tools consuming IR
+// produced on other machines.
+func RemapPositions(objects []Object, rules []PathRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	m := map[string]string{}
+	remap := func(p *token.Position) bool {
+		if p.Filename == "" {
+			return false
+		}
+
+		s, ok := m[p.Filename]
+		if !ok {
+			s = p.Filename
+			for _, r := range rules {
+				var ok bool
+				if s, ok = r.apply(p.Filename); ok {
+					break
+				}
+			}
+			m[p.Filename] = s
+		}
+		if s == p.Filename {
+			return false
+		}
+
+		p.Filename = s
+		return true
+	}
+	seen := map[Operation]bool{}
+	for _, o := range objects {
+		remap(&o.Base().Position)
+		f, ok := o.(*FunctionDefinition)
+		if !ok {
+			continue
+		}
+
+		for i := range f.Files {
+			p := token.Position{Filename: f.Files[i]}
+			remap(&p)
+			f.Files[i] = p.Filename
+		}
+		for _, op := range f.Body {
+			if seen[op] {
+				continue
+			}
+
+			seen[op] = true
+			if p := op.Pos(); remap(&p) {
+				setPosition(op, p)
+			}
+			if x, ok := op.(*Switch); ok {
+				remap(&x.Default.Position)
+				for i := range x.Labels {
+					remap(&x.Labels[i].Position)
+				}
+			}
+		}
+	}
+}
+
+// functionGob is the gob form of a FunctionDefinition which operations have
+// positions. Gob encoding the positions of the operations directly would
+// repeat the file name for every operation. Instead, the positions are
+// removed from the operations of F and stored in Files and Pos.
+type functionGob struct {
+	F     *FunctionDefinition
+	Files []string
+	Pos   []int32 // Per operation: file index or -1 if not valid, line, column and offset, lines and offsets delta encoded.
+}
+
+// Base implements Object.
+func (g *functionGob) Base() *ObjectBase { return &g.F.ObjectBase }
+
+// Verify implements Object.
+func (g *functionGob) Verify() error { return g.F.Verify() }
+
+// packPositions returns objs with the function definitions having positions
+// replaced by their gob form. Objects and operations are copied shallowly.
+func packPositions(objs []Object) []Object {
+	r := make([]Object, len(objs))
+	for i, v := range objs {
+		r[i] = v
+		f, ok := v.(*FunctionDefinition)
+		if !ok || f.Positions != nil {
+			continue
+		}
+
+		g := &functionGob{}
+		m := map[string]int32{}
+		body := make([]Operation, len(f.Body))
+		var line, off int
+		valid := false
+		for j, op := range f.Body {
+			p := op.Pos()
+			if !p.IsValid() {
+				g.Pos = append(g.Pos, -1, 0, 0, 0)
+				body[j] = op
+				continue
+			}
+
+			valid = true
+			n, ok := m[p.Filename]
+			if !ok {
+				n = int32(len(g.Files))
+				m[p.Filename] = n
+				g.Files = append(g.Files, p.Filename)
+			}
+			g.Pos = append(g.Pos, n, int32(p.Line-line), int32(p.Column), int32(p.Offset-off))
+			line, off = p.Line, p.Offset
+			c := reflect.New(reflect.TypeOf(op).Elem())
+			c.Elem().Set(reflect.ValueOf(op).Elem())
+			body[j] = c.Interface().(Operation)
+			setPosition(body[j], token.Position{})
+		}
+		if !valid {
+			continue
+		}
+
+		h := *f
+		h.Body = body
+		g.F = &h
+		r[i] = g
+	}
+	return r
+}
+
+// unpackPositions reverts the effect of packPositions in place.
+func unpackPositions(objs []Object) error {
+	for i, v := range objs {
+		g, ok := v.(*functionGob)
+		if !ok {
+			continue
+		}
+
+		f := g.F
+		if f == nil || len(g.Pos) != 4*len(f.Body) {
+			return fmt.Errorf("corrupted function positions")
+		}
+
+		var line, off int
+		for j, op := range f.Body {
+			a := g.Pos[4*j : 4*j+4]
+			if a[0] < 0 {
+				continue
+			}
+
+			if int(a[0]) >= len(g.Files) {
+				return fmt.Errorf("corrupted function positions")
+			}
+
+			line += int(a[1])
+			off += int(a[3])
+			setPosition(op, token.Position{Filename: g.Files[a[0]], Offset: off, Line: line, Column: int(a[2])})
+		}
+		objs[i] = f
+	}
+	return nil
+}
+
+// setPosition sets the position of op to p.
+func setPosition(op Operation, p token.Position) {
+	v := reflect.ValueOf(op).Elem()
+	if code, ok := typeOpcodes[v.Type()]; ok {
+		for i, k := range opcodeOperands[code] {
+			if k == operandPosition {
+				v.Field(i).Set(reflect.ValueOf(p))
+				return
+			}
+		}
+		return
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Type() == positionType && f.CanSet() {
+			f.Set(reflect.ValueOf(p))
+			return
+		}
+	}
+}
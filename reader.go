@@ -0,0 +1,131 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"io"
+)
+
+// objectHeader precedes every object written by Objects.WriteTo.
+type objectHeader struct {
+	Linkage Linkage
+	NameID  NameID
+	Package NameID
+	TypeID  TypeID
+}
+
+// ObjectReader reads the objects written by Objects.WriteTo one at a time.
+// Unlike Objects.ReadFrom, it materializes only the objects accepted by its
+// Filter, so a linker or an analysis tool reading a large library does not
+// have to decode the functions it does not use.
+type ObjectReader struct {
+	// Filter, if not nil, is called before an object of translation
+	// unit unit is decoded. Only the Linkage, NameID, Package and TypeID
+	// fields of b are set. Objects for which Filter returns false are
+	// skipped.
+	Filter func(unit int, b *ObjectBase) bool
+
+	c     counter
+	dec   *decoder
+	err   error
+	left  int // Objects left in the current unit.
+	unit  int // Index of the current unit.
+	units int
+}
+
+// NewObjectReader returns an ObjectReader reading from r the objects written
+// for target t.
+func NewObjectReader(r io.Reader, t Target) (*ObjectReader, error) {
+	or := &ObjectReader{}
+	if err := or.init(r, dict, t); err != nil {
+		return nil, err
+	}
+
+	return or, nil
+}
+
+func (r *ObjectReader) init(rd io.Reader, d Dictionary, t Target) (err error) {
+	if r.dec, err = newDecoder(rd, t, d, &r.c); err != nil {
+		return err
+	}
+
+	r.unit = -1
+	if err := r.decode(&r.units); err != nil {
+		return err
+	}
+
+	if r.units < 0 {
+		return fmt.Errorf("corrupted file")
+	}
+
+	return nil
+}
+
+// Manifest returns the manifest of the data read by r.
+func (r *ObjectReader) Manifest() *Manifest { return r.dec.Manifest }
+
+// decode reads v from the gob stream, a nil v discards the value.
+func (r *ObjectReader) decode(v interface{}) error { return r.dec.decode(v) }
+
+// Next returns the next object accepted by r.Filter and the index of its
+// translation unit. At the end of the input Next returns io.EOF.
+func (r *ObjectReader) Next() (unit int, o Object, err error) {
+	if r.err != nil {
+		return 0, nil, r.err
+	}
+
+	defer func() { r.err = err }()
+
+	for {
+		for r.left == 0 {
+			if r.unit+1 >= r.units {
+				if err := r.dec.verify(); err != nil {
+					return 0, nil, err
+				}
+
+				return 0, nil, io.EOF
+			}
+
+			r.unit++
+			if err := r.decode(&r.left); err != nil {
+				return 0, nil, err
+			}
+
+			if r.left < 0 {
+				return 0, nil, fmt.Errorf("corrupted file")
+			}
+		}
+
+		var h objectHeader
+		if err := r.decode(&h); err != nil {
+			return 0, nil, err
+		}
+
+		r.left--
+		if r.Filter != nil && !r.Filter(r.unit, &ObjectBase{Linkage: h.Linkage, NameID: h.NameID, Package: h.Package, TypeID: h.TypeID}) {
+			if err := r.decode(nil); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		}
+
+		a := make([]Object, 1)
+		if err := r.decode(&a[0]); err != nil {
+			return 0, nil, err
+		}
+
+		if a[0] == nil {
+			return 0, nil, fmt.Errorf("corrupted file")
+		}
+
+		if err := unpackPositions(a); err != nil {
+			return 0, nil, err
+		}
+
+		return r.unit, a[0], nil
+	}
+}
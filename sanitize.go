@@ -0,0 +1,207 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"go/token"
+)
+
+// SanitizePolicy selects the run time checks inserted by Sanitize.
+type SanitizePolicy struct {
+	Bounds bool // Panic on Element constant indices out of the bounds of the indexed array.
+	Nil    bool // Panic on Load and Store through nil pointers.
+}
+
+// Pass returns a Pass applying Sanitize with policy p.
+func (p SanitizePolicy) Pass() *Pass {
+	return &Pass{Name: "sanitize", Function: func(f *FunctionDefinition) error { return Sanitize(f, p) }}
+}
+
+// Sanitize inserts into f the run time checks selected by p. A failing check
+// executes a Panic, so any backend supporting Panic gets a memory safety
+// debug mode for free.
+//
+// A nil check compares the pointer operand of a Load to Nil right before the
+// Load. The pointer operand of a Store is checked where it becomes the top of
+// the evaluation stack, ie. before the stored value is computed. Pointers
+// known to be not nil, the addresses of variables, arguments, results,
+// globals and string constants, results of Alloca and the addresses of their
+// fields and elements, are not checked.
+//
+// A bounds check applies to an Element with a constant index into a pointer
+// converted from a pointer to an array of known size. If the index is out of
+// the bounds of the array, the Element is preceded by a Panic. The index may
+// be equal to the number of array items when only the address is computed.
+func Sanitize(f *FunctionDefinition, p SanitizePolicy) error {
+	if !p.Bounds && !p.Nil {
+		return nil
+	}
+
+	c, err := newSSABuilder(f, false)
+	if err != nil {
+		return err
+	}
+
+	ips := map[Operation]int{}
+	number := 0
+	for ip, op := range f.Body {
+		ips[op] = ip
+		if x, ok := op.(*Label); ok && x.NameID == 0 && x.Number >= number {
+			number = x.Number + 1
+		}
+	}
+
+	checks := map[int][]Operation{} // ip: Checks to insert before ip.
+	nilCheck := func(ip int, t TypeID, pos token.Position) {
+		checks[ip] = append(checks[ip],
+			&Dup{TypeID: t, Position: pos},
+			&Nil{TypeID: t, Position: pos},
+			&Neq{TypeID: t, Position: pos},
+			&Jnz{Number: number, Position: pos},
+			&Panic{Position: pos},
+			&Label{Number: number, Position: pos},
+		)
+		number++
+	}
+	checked := map[*SSAValue]bool{}
+	for _, b := range c.Blocks {
+		if b.Unreachable {
+			continue
+		}
+
+		for _, v := range b.Values {
+			switch x := v.Op.(type) {
+			case *Element:
+				if p.Bounds && sanitizeOutOfBounds(c.v.typeCache, x, v) {
+					ip := ips[x]
+					checks[ip] = append(checks[ip], &Panic{Position: x.Position})
+				}
+			case *Load:
+				if p.Nil && !sanitizeNotNil(v.Args[0], map[*SSAValue]bool{}) {
+					nilCheck(ips[x], x.TypeID, x.Position)
+				}
+			case *Store:
+				ptr := v.Args[0]
+				if !p.Nil || checked[ptr] || sanitizeNotNil(ptr, map[*SSAValue]bool{}) {
+					break
+				}
+
+				checked[ptr] = true
+				if ip := c.top(ptr, ips); ip >= 0 {
+					nilCheck(ip, ptr.TypeID, x.Position)
+				}
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	body := make([]Operation, 0, len(f.Body))
+	for ip, op := range f.Body {
+		body = append(append(body, checks[ip]...), op)
+	}
+	f.Body = body
+	return nil
+}
+
+// top returns the ip before which v is the top of the evaluation stack or -1
+// if it is not known.
+func (c *ssaBuilder) top(v *SSAValue, ips map[Operation]int) int {
+	if v.Phi {
+		s := c.entry[v.Block]
+		if n := len(s); n != 0 && c.resolve(s[n-1]) == v {
+			return c.ips[v.Block.ID][0] + 1
+		}
+
+		return -1
+	}
+
+	if v.Op == nil {
+		return -1
+	}
+
+	if ip, ok := ips[v.Op]; ok {
+		return ip + 1
+	}
+
+	return -1
+}
+
+func sanitizeNotNil(v *SSAValue, m map[*SSAValue]bool) bool {
+	if m[v] {
+		return true
+	}
+
+	m[v] = true
+	if v.Phi {
+		for _, a := range v.Args {
+			if !sanitizeNotNil(a, m) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch x := v.Op.(type) {
+	case *Alloca, *StringConst:
+		return true
+	case *Argument:
+		return x.Address
+	case *Convert:
+		return sanitizeNotNil(v.Args[0], m)
+	case *Element:
+		return x.Address && sanitizeNotNil(v.Args[0], m)
+	case *Field:
+		return x.Address && sanitizeNotNil(v.Args[0], m)
+	case *Global:
+		return x.Address
+	case *Result:
+		return x.Address
+	case *Variable:
+		return x.Address
+	}
+	return false
+}
+
+func sanitizeOutOfBounds(tc Types, x *Element, v *SSAValue) bool {
+	cv, ok := v.Args[0].Op.(*Convert)
+	if !ok {
+		return false
+	}
+
+	pt, ok := tc.MustType(cv.TypeID).(*PointerType)
+	if !ok {
+		return false
+	}
+
+	at, ok := pt.Element.(*ArrayType)
+	if !ok || at.Items < 0 {
+		return false
+	}
+
+	if et, ok := tc.MustType(x.TypeID).(*PointerType); !ok || et.Element.ID() != at.Item.ID() {
+		return false
+	}
+
+	var n int64
+	switch y := v.Args[1].Op.(type) {
+	case *Const32:
+		n = int64(y.Value)
+		if !isSigned(tc.MustType(y.TypeID).Kind()) {
+			n = int64(uint32(y.Value))
+		}
+	case *Const64:
+		if n = y.Value; n < 0 && !isSigned(tc.MustType(y.TypeID).Kind()) {
+			return false
+		}
+	default:
+		return false
+	}
+	if x.Neg {
+		n = -n
+	}
+	return n < 0 || n > at.Items || n == at.Items && !x.Address
+}
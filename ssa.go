@@ -0,0 +1,1153 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// SSAFunc is the static single assignment form of a FunctionDefinition, see
+// ToSSA.
+type SSAFunc struct {
+	Blocks   []*SSABlock         // In layout order, Blocks[0] is the entry block.
+	Function *FunctionDefinition // The function the SSAFunc was produced from.
+
+	nextID int
+}
+
+// SSABlock is a basic block of an SSAFunc. A block starts at the function
+// start, at a Label or after a branch, Panic, RestoreContext or Return. A
+// block not ending in Jmp, JmpP, Panic, RestoreContext, Return or Switch
+// falls through to the next block in layout order.
+type SSABlock struct {
+	ID     int
+	Phis   []*SSAValue
+	Preds  []*SSABlock
+	Succs  []*SSABlock // Branch targets first, then the fall through block, if any.
+	Values []*SSAValue // Operations in execution order, a Label, if any, is the first one.
+
+	// Unreachable blocks keep only their BeginScope, EndScope, Return and
+	// VariableDeclaration operations, which are needed to lower the
+	// function.
+	Unreachable bool
+}
+
+// SSAValue is an operation of an SSABlock, a phi node or a result of an
+// operation producing more than one value, like AddOv.
+type SSAValue struct {
+	Args   []*SSAValue // Operands, the former top of the evaluation stack is the last one.
+	Block  *SSABlock
+	ID     int
+	Index  int       // Result index of a result of a multi-valued operation, which is its only argument.
+	Op     Operation // Nil for phis and results of multi-valued operations.
+	Phi    bool      // Args are the incoming values from the respective Block.Preds.
+	TypeID TypeID    // Zero if the value has no or multiple results.
+}
+
+func (v *SSAValue) String() string {
+	var b bytes.Buffer
+	if v.TypeID != 0 {
+		fmt.Fprintf(&b, "v%v = ", v.ID)
+	}
+	switch {
+	case v.Phi:
+		fmt.Fprintf(&b, "phi %v", v.TypeID)
+	case v.Op == nil:
+		fmt.Fprintf(&b, "result #%v %v", v.Index, v.TypeID)
+	default:
+		s := fmt.Sprint(v.Op)
+		if i := strings.Index(s, "\t;"); i >= 0 {
+			s = s[:i]
+		}
+		b.WriteString(strings.Join(strings.Fields(s), " "))
+	}
+	for i, a := range v.Args {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " v%v", a.ID)
+	}
+	return b.String()
+}
+
+func (f *SSAFunc) String() string {
+	var b bytes.Buffer
+	for _, v := range f.Blocks {
+		fmt.Fprintf(&b, "b%v:", v.ID)
+		for _, p := range v.Preds {
+			fmt.Fprintf(&b, " b%v", p.ID)
+		}
+		if v.Unreachable {
+			b.WriteString(" ; unreachable")
+		}
+		b.WriteByte('\n')
+		for _, w := range v.Phis {
+			fmt.Fprintf(&b, "\t%s\n", w)
+		}
+		for _, w := range v.Values {
+			fmt.Fprintf(&b, "\t%s\n", w)
+		}
+	}
+	return b.String()
+}
+
+func (f *SSAFunc) newValue(b *SSABlock, op Operation, args ...*SSAValue) *SSAValue {
+	v := &SSAValue{Args: args, Block: b, ID: f.nextID, Op: op}
+	f.nextID++
+	return v
+}
+
+// ToSSA returns the SSA form of f or an error, if any. The items of the
+// evaluation stack become SSAValues, Dup and Drop operations disappear and
+// Arguments operations are implied by Call and CallFP. Scalar local
+// variables, which address is only used to load or store them, are promoted
+// to SSAValues as well. ToSSA does not mutate f except for expanding its
+// positions, see FunctionDefinition.ExpandPositions. The operations of f are
+// shared by the result.
+func ToSSA(f *FunctionDefinition) (*SSAFunc, error) {
+	c, err := newSSABuilder(f, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SSAFunc, nil
+}
+
+func newSSABuilder(f *FunctionDefinition, promote bool) (*ssaBuilder, error) {
+	f.ExpandPositions()
+	v := NewVerifier()
+	if err := v.Validate(f, nil); err != nil {
+		return nil, err
+	}
+
+	c := &ssaBuilder{SSAFunc: &SSAFunc{Function: f}, dups: map[*SSAValue]int{}, entry: map[*SSABlock][]*SSAValue{}, v: &v.verifier}
+	c.blocks()
+	if err := c.values(); err != nil {
+		return nil, err
+	}
+
+	if promote {
+		c.promote()
+	}
+	c.prune()
+	return c, nil
+}
+
+type ssaBuilder struct {
+	*SSAFunc
+	dups  map[*SSAValue]int         // Number of Dups of a value.
+	entry map[*SSABlock][]*SSAValue // Evaluation stack at block start.
+	ips   [][2]int                  // Block ID: [first ip, last ip + 1].
+	rpo   []*SSABlock
+	repl  map[*SSAValue]*SSAValue
+	v     *verifier
+	zeros map[TypeID]*SSAValue
+}
+
+// ssaTerminator reports whether op ends a basic block.
+func ssaTerminator(op Operation) bool {
+	switch op.(type) {
+	case *Jmp, *JmpP, *Jnz, *Jz, *Panic, *RestoreContext, *Return, *Switch:
+		return true
+	}
+	return false
+}
+
+// blocks splits the function body into basic blocks and computes their
+// edges and reverse postorder.
+func (c *ssaBuilder) blocks() {
+	body := c.Function.Body
+	labels := map[int]*SSABlock{}
+	for ip, op := range body {
+		l, ok := op.(*Label)
+		if ok || ip == 0 || ssaTerminator(body[ip-1]) {
+			b := &SSABlock{ID: len(c.Blocks)}
+			c.Blocks = append(c.Blocks, b)
+			c.ips = append(c.ips, [2]int{ip, len(body)})
+			if n := len(c.ips); n > 1 {
+				c.ips[n-2][1] = ip
+			}
+		}
+		if ok {
+			labels[labelKey(l.NameID, l.Number)] = c.Blocks[len(c.Blocks)-1]
+		}
+	}
+
+	// Like in the verifier, a JmpP may jump to any label which address is
+	// taken and, as AddressValue can refer to them, to any named label.
+	var addressed []*SSABlock
+	computedGotos := false
+	for _, op := range body {
+		switch x := op.(type) {
+		case *JmpP:
+			computedGotos = true
+		case *LabelAddr:
+			addressed = append(addressed, labels[labelKey(x.NameID, x.Number)])
+		}
+	}
+	if computedGotos {
+		for _, op := range body {
+			if x, ok := op.(*Label); ok && x.NameID != 0 {
+				addressed = append(addressed, labels[labelKey(x.NameID, x.Number)])
+			}
+		}
+	}
+
+	for i, b := range c.Blocks {
+		add := func(s *SSABlock) {
+			for _, v := range b.Succs {
+				if v == s {
+					return
+				}
+			}
+			b.Succs = append(b.Succs, s)
+		}
+		next := func() {
+			if i+1 < len(c.Blocks) {
+				add(c.Blocks[i+1])
+			}
+		}
+		switch x := body[c.ips[i][1]-1].(type) {
+		case *Jmp:
+			add(labels[labelKey(x.NameID, x.Number)])
+		case *JmpP:
+			for _, v := range addressed {
+				add(v)
+			}
+		case *Jnz:
+			add(labels[labelKey(x.NameID, x.Number)])
+			next()
+		case *Jz:
+			add(labels[labelKey(x.NameID, x.Number)])
+			next()
+		case *Panic, *RestoreContext, *Return:
+			// nop
+		case *Switch:
+			for _, v := range x.Labels {
+				add(labels[labelKey(v.NameID, v.Number)])
+			}
+			add(labels[labelKey(x.Default.NameID, x.Default.Number)])
+		default:
+			next()
+		}
+	}
+
+	seen := map[*SSABlock]bool{}
+	var po []*SSABlock
+	var dfs func(*SSABlock)
+	dfs = func(b *SSABlock) {
+		if seen[b] {
+			return
+		}
+
+		seen[b] = true
+		for _, v := range b.Succs {
+			dfs(v)
+		}
+		po = append(po, b)
+	}
+	dfs(c.Blocks[0])
+	for _, v := range addressed {
+		dfs(v)
+	}
+	for i := len(po) - 1; i >= 0; i-- {
+		c.rpo = append(c.rpo, po[i])
+	}
+	for _, b := range c.Blocks {
+		if !seen[b] {
+			b.Succs = nil
+			b.Unreachable = true
+			continue
+		}
+
+		for _, v := range b.Succs {
+			v.Preds = append(v.Preds, b)
+		}
+	}
+}
+
+// values converts the operations of the blocks to SSAValues.
+func (c *ssaBuilder) values() error {
+	body := c.Function.Body
+	for _, b := range c.Blocks {
+		if !b.Unreachable {
+			continue
+		}
+
+		for _, op := range body[c.ips[b.ID][0]:c.ips[b.ID][1]] {
+			switch op.(type) {
+			case *BeginScope, *EndScope, *Return, *VariableDeclaration:
+				b.Values = append(b.Values, c.newValue(b, op))
+			}
+		}
+	}
+
+	exit := map[*SSABlock][]*SSAValue{} // Evaluation stack at block end.
+	merge := map[*SSABlock]bool{}       // Block has phis for the evaluation stack items.
+	for _, b := range c.rpo {
+		var stack []*SSAValue
+		switch {
+		case len(b.Preds) == 1 && exit[b.Preds[0]] != nil:
+			stack = append(stack, exit[b.Preds[0]]...)
+		default:
+			merge[b] = true
+			for _, p := range b.Preds {
+				if s := exit[p]; s != nil {
+					for _, v := range s {
+						phi := c.newValue(b, nil)
+						phi.Phi = true
+						phi.TypeID = v.TypeID
+						b.Phis = append(b.Phis, phi)
+						stack = append(stack, phi)
+					}
+					break
+				}
+			}
+		}
+		c.entry[b] = append([]*SSAValue(nil), stack...)
+		for ip := c.ips[b.ID][0]; ip < c.ips[b.ID][1]; ip++ {
+			var err error
+			if stack, err = c.op(b, stack, ip); err != nil {
+				return err
+			}
+		}
+		if stack == nil {
+			stack = []*SSAValue{}
+		}
+		exit[b] = stack
+	}
+
+	for _, b := range c.rpo {
+		if !merge[b] {
+			continue
+		}
+
+		for _, p := range b.Preds {
+			s := exit[p]
+			if len(s) != len(b.Phis) {
+				return c.v.errorAt(c.Function, c.ips[b.ID][0], nil, "evaluation stacks depth differs")
+			}
+
+			for i, v := range b.Phis {
+				v.Args = append(v.Args, s[i])
+			}
+		}
+	}
+	return nil
+}
+
+// op appends the SSAValue of the operation at ip to b and returns the updated
+// evaluation stack.
+func (c *ssaBuilder) op(b *SSABlock, stack []*SSAValue, ip int) ([]*SSAValue, error) {
+	op := c.Function.Body[ip]
+	types := make([]TypeID, len(stack))
+	for i, v := range stack {
+		types[i] = v.TypeID
+	}
+	c.v.ip = ip
+	c.v.stack = append([]TypeID(nil), types...)
+	switch op.(type) {
+	case *BeginScope, *EndScope:
+		// Verified by Validate.
+	default:
+		if err := op.verify(c.v); err != nil {
+			return nil, c.v.errorAt(c.Function, ip, types, err.Error())
+		}
+	}
+
+	n := len(stack)
+	switch op.(type) {
+	case *Arguments:
+		return stack, nil
+	case *Dup:
+		c.dups[stack[n-1]]++
+		return append(stack, stack[n-1]), nil
+	case *Drop:
+		return stack[:n-1], nil
+	}
+
+	pops, err := ssaPops(op, types, c.v.typeCache)
+	if err != nil {
+		return nil, c.v.errorAt(c.Function, ip, types, err.Error())
+	}
+
+	results := c.v.stack[n-pops:]
+	v := c.newValue(b, op, append([]*SSAValue(nil), stack[n-pops:]...)...)
+	b.Values = append(b.Values, v)
+	stack = stack[:n-pops]
+	switch len(results) {
+	case 0:
+		// nop
+	case 1:
+		v.TypeID = results[0]
+		stack = append(stack, v)
+	default:
+		for i, t := range results {
+			r := c.newValue(b, nil, v)
+			r.Index = i
+			r.TypeID = t
+			b.Values = append(b.Values, r)
+			stack = append(stack, r)
+		}
+	}
+	return stack, nil
+}
+
+// ssaPops returns the number of items of the evaluation stack, which has
+// items of types, consumed by op.
+func ssaPops(op Operation, types []TypeID, tc Types) (int, error) {
+	switch x := op.(type) {
+	case
+		*AllocResult,
+		*Annotate,
+		*Argument,
+		*BeginScope,
+		*Chain,
+		*Const,
+		*Const32,
+		*Const64,
+		*ConstC128,
+		*EndScope,
+		*Fence,
+		*Global,
+		*Jmp,
+		*Label,
+		*LabelAddr,
+		*Nil,
+		*Panic,
+		*Result,
+		*Return,
+		*StringConst,
+		*Variable,
+		*VariableDeclaration:
+
+		return 0, nil
+	case
+		*Alloca,
+		*Bool,
+		*Bswap,
+		*Closure,
+		*Clz,
+		*Convert,
+		*Cpl,
+		*Ctz,
+		*Field,
+		*FieldValue,
+		*Free,
+		*JmpP,
+		*Jnz,
+		*Jz,
+		*Load,
+		*Neg,
+		*Not,
+		*Popcnt,
+		*PostIncrement,
+		*PreIncrement,
+		*SaveContext,
+		*Switch,
+		*Zero:
+
+		return 1, nil
+	case
+		*Add,
+		*AddOv,
+		*And,
+		*Copy,
+		*Div,
+		*Element,
+		*Eq,
+		*Geq,
+		*Gt,
+		*Leq,
+		*Lsh,
+		*Lt,
+		*Mul,
+		*MulOv,
+		*Neq,
+		*Or,
+		*PtrDiff,
+		*Rem,
+		*RestoreContext,
+		*Rsh,
+		*Store,
+		*Sub,
+		*SubOv,
+		*Xor:
+
+		return 2, nil
+	case *Select:
+		return 3, nil
+	case *New:
+		if x.Size != 0 {
+			return 1, nil
+		}
+
+		return 0, nil
+	case *Call:
+		return len(tc.MustType(x.TypeID).(*FunctionType).Results) + x.Arguments, nil
+	case *CallFP:
+		t := tc.MustType(types[len(types)-1-x.Arguments]).(*PointerType).Element.(*FunctionType)
+		return len(t.Results) + 1 + x.Arguments, nil
+	}
+	return 0, fmt.Errorf("unsupported operation %T", op)
+}
+
+// promote replaces the loads and stores of the local variables, which
+// address is only used to load or store them, by the stored values.
+func (c *ssaBuilder) promote() {
+	decls := map[int]*VariableDeclaration{}
+	for _, op := range c.Function.Body {
+		switch x := op.(type) {
+		case *SaveContext:
+			return
+		case *VariableDeclaration:
+			switch x.Value.(type) {
+			case nil, *Float32Value, *Float64Value, *Int32Value, *Int64Value, *Uint32Value, *Uint64Value:
+				switch k := c.v.typeCache.MustType(x.TypeID).Kind(); {
+				case k == Int128, k == Uint128:
+					// Not promoted, zero has no constant of the type.
+				case isIntegral(k), k == Float32, k == Float64, k == Pointer:
+					decls[x.Index] = x
+				}
+			}
+		}
+	}
+	if len(decls) == 0 {
+		return
+	}
+
+	type use struct {
+		v *SSAValue
+		i int
+	}
+	uses := map[*SSAValue][]use{}
+	addr := map[*SSAValue]int{} // Value: variable index.
+	phis := map[*SSAValue]int{} // Phi: variable index or -1 if not yet known.
+	for _, b := range c.rpo {
+		for _, v := range b.Phis {
+			phis[v] = -1
+			for i, a := range v.Args {
+				uses[a] = append(uses[a], use{v, i})
+			}
+		}
+		for _, v := range b.Values {
+			for i, a := range v.Args {
+				uses[a] = append(uses[a], use{v, i})
+			}
+			if x, ok := v.Op.(*Variable); ok && x.Address && decls[x.Index] != nil {
+				addr[v] = x.Index
+			}
+		}
+	}
+
+	// Find the phis merging addresses of a single variable.
+	for changed := true; changed; {
+		changed = false
+		for v, n := range phis {
+			k := n
+			for _, a := range v.Args {
+				j, ok := addr[a]
+				if !ok {
+					j, ok = phis[a]
+				}
+				if !ok || j >= 0 && k >= 0 && j != k {
+					delete(phis, v)
+					changed = true
+					break
+				}
+
+				if j >= 0 {
+					k = j
+				}
+			}
+			if _, ok := phis[v]; ok && k != n {
+				phis[v] = k
+				changed = true
+			}
+		}
+	}
+	for v, n := range phis {
+		if n >= 0 {
+			addr[v] = n
+		}
+	}
+
+	for v, n := range addr {
+		for _, u := range uses[v] {
+			ok := false
+			switch x := u.v.Op.(type) {
+			case nil:
+				_, ok = addr[u.v]
+			case *Load:
+				ok = !x.Atomic && !x.Volatile
+			case *Store:
+				ok = u.i == 0 && !x.Atomic && x.Bits == 0 && !x.Volatile
+			}
+			if !ok {
+				delete(decls, n)
+				break
+			}
+		}
+	}
+	for v, n := range addr {
+		if decls[n] == nil {
+			delete(addr, v)
+		}
+	}
+	var vars []int
+	for k := range decls {
+		vars = append(vars, k)
+	}
+	sort.Ints(vars)
+
+	c.repl = map[*SSAValue]*SSAValue{}
+	exit := map[*SSABlock]map[int]*SSAValue{} // Variable values at block end.
+	phi := map[*SSAValue]int{}                // Phi of a variable: variable index.
+	for _, b := range c.rpo {
+		defs := map[int]*SSAValue{}
+		switch {
+		case len(b.Preds) == 1 && exit[b.Preds[0]] != nil:
+			for k, v := range exit[b.Preds[0]] {
+				defs[k] = v
+			}
+		case len(b.Preds) != 0:
+			for _, k := range vars {
+				v := c.newValue(b, nil)
+				v.Phi = true
+				v.TypeID = decls[k].TypeID
+				b.Phis = append(b.Phis, v)
+				defs[k] = v
+				phi[v] = k
+			}
+		}
+		w := 0
+		for _, v := range b.Phis {
+			if _, ok := addr[v]; !ok {
+				b.Phis[w] = v
+				w++
+			}
+		}
+		b.Phis = b.Phis[:w]
+		read := func(v *SSAValue, k int) {
+			d := defs[k]
+			if d == nil {
+				d = c.zero(decls[k].TypeID)
+				defs[k] = d
+			}
+			c.repl[v] = d
+		}
+		var values []*SSAValue
+		for _, v := range b.Values {
+			switch x := v.Op.(type) {
+			case *Load:
+				if k, ok := addr[v.Args[0]]; ok {
+					read(v, k)
+					continue
+				}
+			case *Store:
+				if k, ok := addr[v.Args[0]]; ok {
+					defs[k] = v.Args[1]
+					c.repl[v] = v.Args[1]
+					continue
+				}
+			case *Variable:
+				if decls[x.Index] != nil {
+					if !x.Address {
+						read(v, x.Index)
+					}
+					continue
+				}
+			case *VariableDeclaration:
+				if d := decls[x.Index]; d != nil && x.Value != nil {
+					values = append(values, v)
+					v = c.newValue(b, &Const{TypeID: x.TypeID, Value: x.Value, Position: x.Position})
+					v.TypeID = x.TypeID
+					defs[x.Index] = v
+				}
+			}
+			values = append(values, v)
+		}
+		b.Values = values
+		exit[b] = defs
+	}
+
+	for _, b := range c.rpo {
+		for _, v := range b.Phis {
+			k, ok := phi[v]
+			if !ok {
+				continue
+			}
+
+			for _, p := range b.Preds {
+				v.Args = append(v.Args, exit[p][k]) // Nil if undefined.
+			}
+		}
+	}
+}
+
+// zero returns a zero value of type t defined at the start of the entry
+// block.
+func (c *ssaBuilder) zero(t TypeID) *SSAValue {
+	if v := c.zeros[t]; v != nil {
+		return v
+	}
+
+	var op Operation
+	switch c.v.typeCache.MustType(t).Kind() {
+	case Pointer:
+		op = &Nil{TypeID: t}
+	case Float64, Int64, Uint64:
+		op = &Const64{TypeID: t}
+	default:
+		op = &Const32{TypeID: t}
+	}
+	b := c.Blocks[0]
+	v := c.newValue(b, op)
+	v.TypeID = t
+	if c.zeros == nil {
+		c.zeros = map[TypeID]*SSAValue{}
+	}
+	c.zeros[t] = v
+	return v
+}
+
+func (c *ssaBuilder) resolve(v *SSAValue) *SSAValue {
+	for {
+		r, ok := c.repl[v]
+		if !ok {
+			return v
+		}
+
+		v = r
+	}
+}
+
+// prune removes phis which have only one incoming value other than the phi
+// itself and the undefined value, then it replaces the operands of all values
+// by their replacements.
+func (c *ssaBuilder) prune() {
+	if c.repl == nil {
+		c.repl = map[*SSAValue]*SSAValue{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range c.rpo {
+			for _, v := range b.Phis {
+				if _, ok := c.repl[v]; ok {
+					continue
+				}
+
+				var r *SSAValue
+				trivial := true
+				for _, a := range v.Args {
+					if a == nil {
+						continue
+					}
+
+					if a = c.resolve(a); a == v || a == r {
+						continue
+					}
+
+					if r != nil {
+						trivial = false
+						break
+					}
+
+					r = a
+				}
+				if !trivial {
+					continue
+				}
+
+				if r == nil {
+					r = c.zero(v.TypeID)
+				}
+				c.repl[v] = r
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range c.rpo {
+		w := 0
+		for _, v := range b.Phis {
+			if _, ok := c.repl[v]; ok {
+				continue
+			}
+
+			for i, a := range v.Args {
+				if a == nil {
+					a = c.zero(v.TypeID)
+				}
+				v.Args[i] = c.resolve(a)
+			}
+			b.Phis[w] = v
+			w++
+		}
+		b.Phis = b.Phis[:w]
+		for _, v := range b.Values {
+			for i, a := range v.Args {
+				v.Args[i] = c.resolve(a)
+			}
+		}
+	}
+
+	if len(c.zeros) == 0 {
+		return
+	}
+
+	var zeros []*SSAValue
+	for _, v := range c.zeros {
+		zeros = append(zeros, v)
+	}
+	sort.Slice(zeros, func(i, j int) bool { return zeros[i].ID < zeros[j].ID })
+	b := c.Blocks[0]
+	i := 0
+	if len(b.Values) != 0 {
+		if _, ok := b.Values[0].Op.(*BeginScope); ok {
+			i = 1
+		}
+	}
+	b.Values = append(b.Values[:i], append(zeros, b.Values[i:]...)...)
+}
+
+// Lower returns a FunctionDefinition equivalent to f or an error, if any.
+// Values not used exactly once, in evaluation stack order and in the block
+// defining them, phis included, are kept in new local variables declared at
+// the start of the function. Lower fails if a result of an operation
+// producing more than one value, other than the first one, is not used that
+// way.
+func (f *SSAFunc) Lower() (*FunctionDefinition, error) {
+	l := &ssaLowerer{
+		SSAFunc: f,
+		inline:  map[*SSAValue]bool{},
+		phiIn:   map[*SSAValue]int{},
+		tc:      TypeCache{},
+		uses:    map[*SSAValue]int{},
+		vars:    map[*SSAValue]int{},
+	}
+	pos := map[*SSAValue]int{}
+	user := map[*SSAValue]*SSAValue{}
+	phiArg := map[*SSAValue]bool{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Phis {
+			for _, a := range v.Args {
+				l.uses[a]++
+				phiArg[a] = true
+			}
+		}
+		for i, v := range b.Values {
+			pos[v] = i
+			if _, ok := v.Op.(*VariableDeclaration); ok {
+				l.nvars++
+			}
+			for _, a := range v.Args {
+				l.uses[a]++
+				user[a] = v
+			}
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if u := user[v]; v.TypeID != 0 && l.uses[v] == 1 && !phiArg[v] && u.Block == b && pos[u] > pos[v] {
+				l.inline[v] = true
+			}
+		}
+	}
+
+	var body []Operation
+	for _, b := range f.Blocks {
+		for {
+			ops, spill, err := l.block(b)
+			if err != nil {
+				return nil, err
+			}
+
+			if spill != nil {
+				delete(l.inline, spill)
+				continue
+			}
+
+			body = append(body, ops...)
+			break
+		}
+	}
+
+	i := 0
+	if len(body) != 0 {
+		if _, ok := body[0].(*BeginScope); ok {
+			i = 1
+		}
+	}
+	body = append(body[:i], append(l.decls, body[i:]...)...)
+	n := len(l.decls)
+	index := func(i int) int {
+		if i >= l.nvars {
+			return i - l.nvars
+		}
+
+		return i + n
+	}
+	for i, op := range body {
+		switch x := op.(type) {
+		case *Variable:
+			y := *x
+			y.Index = index(x.Index)
+			body[i] = &y
+		case *VariableDeclaration:
+			y := *x
+			y.Index = index(x.Index)
+			body[i] = &y
+		}
+	}
+	r := *f.Function
+	r.Body = body
+	r.Positions = nil
+	return &r, nil
+}
+
+type ssaLowerer struct {
+	*SSAFunc
+	decls  []Operation
+	inline map[*SSAValue]bool // Value is used directly from the evaluation stack.
+	nvars  int                // Number of variables of Function.
+	phiIn  map[*SSAValue]int  // Phi: variable of the incoming value.
+	tc     TypeCache
+	uses   map[*SSAValue]int
+	vars   map[*SSAValue]int // Value: variable.
+}
+
+// variable declares a new variable of type t and returns its index.
+func (l *ssaLowerer) variable(t TypeID) int {
+	n := l.nvars + len(l.decls)
+	l.decls = append(l.decls, &VariableDeclaration{Index: n, TypeID: t, Position: l.Function.Position})
+	return n
+}
+
+func (l *ssaLowerer) pointer(t TypeID) TypeID { return l.tc.MustType(t).Pointer().ID() }
+
+// varOf returns the variable keeping v.
+func (l *ssaLowerer) varOf(v *SSAValue) int {
+	n, ok := l.vars[v]
+	if !ok {
+		n = l.variable(v.TypeID)
+		l.vars[v] = n
+	}
+	return n
+}
+
+// incoming returns the variable keeping the incoming value of phi v.
+func (l *ssaLowerer) incoming(v *SSAValue) int {
+	n, ok := l.phiIn[v]
+	if !ok {
+		n = l.variable(v.TypeID)
+		l.phiIn[v] = n
+	}
+	return n
+}
+
+// load returns the operation pushing v kept in a variable.
+func (l *ssaLowerer) load(v *SSAValue, pos token.Position) Operation {
+	return &Variable{Index: l.varOf(v), TypeID: v.TypeID, Position: pos}
+}
+
+// store returns the operations storing a value of type t to variable n. The
+// first one must be executed before computing the value.
+func (l *ssaLowerer) store(n int, t TypeID, pos token.Position) (Operation, []Operation) {
+	return &Variable{Address: true, Index: n, TypeID: l.pointer(t), Position: pos},
+		[]Operation{&Store{TypeID: t, Position: pos}, &Drop{TypeID: t, Position: pos}}
+}
+
+// copies returns the operations passing values of b to the phis of its
+// successors.
+func (l *ssaLowerer) copies(b *SSABlock, pos token.Position) (r []Operation) {
+	for _, s := range b.Succs {
+		j := 0
+		for j < len(s.Preds) && s.Preds[j] != b {
+			j++
+		}
+		for _, v := range s.Phis {
+			p, st := l.store(l.incoming(v), v.TypeID, pos)
+			r = append(append(append(r, p), l.load(v.Args[j], pos)), st...)
+		}
+	}
+	return r
+}
+
+// block returns the operations of b or a value which cannot be used directly
+// from the evaluation stack.
+func (l *ssaLowerer) block(b *SSABlock) ([]Operation, *SSAValue, error) {
+	type item struct {
+		v     *SSAValue
+		start int // Index of the first operation computing v.
+	}
+
+	var out []Operation
+	values := b.Values
+	if b.Unreachable {
+		for _, v := range values {
+			out = append(out, v.Op)
+		}
+		return out, nil, nil
+	}
+
+	if len(values) != 0 {
+		if _, ok := values[0].Op.(*Label); ok {
+			out = append(out, values[0].Op)
+			values = values[1:]
+		}
+	}
+	for _, v := range b.Phis {
+		p, st := l.store(l.varOf(v), v.TypeID, token.Position{})
+		out = append(append(append(out, p), &Variable{Index: l.incoming(v), TypeID: v.TypeID}), st...)
+	}
+
+	ins := map[int][]Operation{}   // Index: operations to insert before it.
+	defined := map[*SSAValue]int{} // Value of b kept in a variable: index of the operation following the store.
+	var stack []item
+	terminated := false
+	for i := 0; i < len(values); i++ {
+		v := values[i]
+		if v.Op == nil {
+			return nil, nil, fmt.Errorf("%v: misplaced value v%v", v.Block.ID, v.ID)
+		}
+
+		pos := v.Op.Pos()
+		args := v.Args
+		var direct []*SSAValue // Operands used from the evaluation stack.
+		for _, a := range args {
+			if l.inline[a] {
+				direct = append(direct, a)
+			}
+		}
+		n := len(stack)
+		m := len(direct)
+		if m > n {
+			return nil, direct[m-1], nil
+		}
+
+		for j, a := range direct {
+			if stack[n-m+j].v != a {
+				for _, a := range direct {
+					if a == stack[n-1].v {
+						return nil, direct[m-1], nil
+					}
+				}
+
+				return nil, stack[n-1].v, nil
+			}
+		}
+
+		switch v.Op.(type) {
+		case *BeginScope, *EndScope, *JmpP, *Return, *SaveContext:
+			if n > m {
+				return nil, stack[n-m-1].v, nil
+			}
+		}
+
+		start := len(out)
+		if m != 0 {
+			start = stack[n-m].start
+		}
+		fixed := -1 // Number of result and function pointer operands of a call.
+		switch x := v.Op.(type) {
+		case *Call:
+			fixed = len(args) - x.Arguments
+		case *CallFP:
+			fixed = len(args) - x.Arguments
+		}
+		// Operands kept in variables are pushed before the subtree of the
+		// next operand used from the evaluation stack, if any.
+		at := len(out)
+		var tail []Operation
+		if fixed == len(args) {
+			tail = []Operation{&Arguments{Position: pos}}
+		}
+		for j := len(args) - 1; j >= 0; j-- {
+			var pre []Operation
+			if j == fixed {
+				pre = append(pre, &Arguments{Position: pos})
+			}
+			a := args[j]
+			switch {
+			case l.inline[a]:
+				m--
+				at = stack[n-len(direct)+m].start
+			default:
+				if d, ok := defined[a]; ok && d > at {
+					return nil, stack[n-len(direct)+m].v, nil
+				}
+
+				pre = append(pre, l.load(a, pos))
+			}
+			switch {
+			case at == len(out):
+				tail = append(pre, tail...)
+			default:
+				ins[at] = append(pre, ins[at]...)
+			}
+		}
+		stack = stack[:n-len(direct)]
+		out = append(out, tail...)
+		switch v.Op.(type) {
+		case *Jmp, *JmpP, *Jnz, *Jz, *Switch:
+			out = append(out, l.copies(b, pos)...)
+		}
+		if ssaTerminator(v.Op) {
+			terminated = true
+		}
+		out = append(out, v.Op)
+
+		results := []*SSAValue{v}
+		if v.TypeID == 0 {
+			results = nil
+			for i+1 < len(values) && values[i+1].Op == nil && !values[i+1].Phi && values[i+1].Args[0] == v {
+				results = append(results, values[i+1])
+				i++
+			}
+		}
+		for len(results) != 0 && l.uses[results[len(results)-1]] == 0 {
+			out = append(out, &Drop{TypeID: results[len(results)-1].TypeID, Position: pos})
+			results = results[:len(results)-1]
+		}
+		switch {
+		case len(results) == 0:
+			// nop
+		case len(results) == 1 && !l.inline[results[0]]:
+			p, st := l.store(l.varOf(results[0]), results[0].TypeID, pos)
+			ins[start] = append([]Operation{p}, ins[start]...)
+			out = append(out, st...)
+			defined[results[0]] = len(out)
+		default:
+			for j, r := range results {
+				if !l.inline[r] {
+					return nil, nil, fmt.Errorf("%v: cannot lower v%v, result #%v is not used directly from the evaluation stack", pos, v.ID, j)
+				}
+
+				stack = append(stack, item{r, start})
+			}
+		}
+	}
+	if n := len(stack); n != 0 {
+		return nil, stack[n-1].v, nil
+	}
+
+	if !terminated {
+		out = append(out, l.copies(b, token.Position{})...)
+	}
+	if len(ins) == 0 {
+		return out, nil, nil
+	}
+
+	r := make([]Operation, 0, len(out)+len(ins))
+	for i, op := range out {
+		r = append(append(r, ins[i]...), op)
+	}
+	return r, nil, nil
+}
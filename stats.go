@@ -0,0 +1,158 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cznic/internal/buffer"
+)
+
+// FunctionStatistics describes a function definition.
+type FunctionStatistics struct {
+	NameID     NameID
+	Operations int            // Length of the function body.
+	Counts     map[string]int // Operation name, like "Add": number of occurrences.
+}
+
+// Statistics describes a collection of objects. It is returned by Stats.
+type Statistics struct {
+	Aliases     int
+	Constants   int            // Distinct numeric constants of Const, Const32, Const64 and ConstC128 operations.
+	Counts      map[string]int // Operation name, like "Add": number of occurrences in all functions.
+	Data        int
+	Functions   []FunctionStatistics // In the order of the objects.
+	Operations  int                  // Total length of the function bodies.
+	StringBytes int                  // Total length of Strings.
+	Strings     int                  // Distinct string literals of StringConst operations and string values.
+	Types       int                  // Distinct types referenced, the population of a type cache used by a consumer of the objects.
+}
+
+// Stats returns statistics of objects, for example to track code size
+// regressions of a front end.
+func Stats(objects []Object) Statistics {
+	r := Statistics{Counts: map[string]int{}}
+	constants := map[interface{}]struct{}{}
+	strs := map[string]struct{}{}
+	types := map[TypeID]struct{}{}
+	str := func(s string) {
+		if _, ok := strs[s]; !ok {
+			strs[s] = struct{}{}
+			r.StringBytes += len(s)
+		}
+	}
+	typ := func(t TypeID) {
+		if t != 0 {
+			types[t] = struct{}{}
+		}
+	}
+	var value func(Value)
+	value = func(v Value) {
+		switch x := v.(type) {
+		case *CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *DesignatedValue:
+			value(x.Value)
+		case *StringValue:
+			str(string(dict.S(int(x.StringID))))
+		case *WideStringValue:
+			str(string(x.Value))
+		}
+	}
+	for _, v := range objects {
+		typ(v.Base().TypeID)
+		switch x := v.(type) {
+		case *AliasDefinition:
+			r.Aliases++
+		case *DataDefinition:
+			r.Data++
+			value(x.Value)
+		case *FunctionDefinition:
+			f := FunctionStatistics{NameID: x.NameID, Operations: len(x.Body), Counts: map[string]int{}}
+			r.Operations += len(x.Body)
+			for _, op := range x.Body {
+				rv := reflect.ValueOf(op).Elem()
+				nm := rv.Type().Name()
+				f.Counts[nm]++
+				r.Counts[nm]++
+				for i := 0; i < rv.NumField(); i++ {
+					if fv := rv.Field(i); fv.Type() == typeIDType {
+						typ(TypeID(fv.Int()))
+					}
+				}
+				switch y := op.(type) {
+				case *Const:
+					value(y.Value)
+				case *Const32:
+					constants[y.Value] = struct{}{}
+				case *Const64:
+					constants[y.Value] = struct{}{}
+				case *ConstC128:
+					constants[y.Value] = struct{}{}
+				case *StringConst:
+					str(string(dict.S(int(y.Value))))
+				case *Switch:
+					for _, v := range y.Values {
+						value(v)
+					}
+				case *VariableDeclaration:
+					value(y.Value)
+				}
+			}
+			r.Functions = append(r.Functions, f)
+		}
+	}
+	r.Constants = len(constants)
+	r.Strings = len(strs)
+	r.Types = len(types)
+	return r
+}
+
+// String returns s as a table, for example
+//
+//	objects    functions 1, data 1, aliases 0
+//	operations 13
+//	types      4
+//	constants  2
+//	strings    1 (3 bytes)
+//
+//	function operations
+//	main     13
+//
+//	operation   count
+//	Add         2
+//	BeginScope  1
+//	...
+func (s Statistics) String() string {
+	var buf buffer.Bytes
+	w := tabwriter.NewWriter(&buf, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "objects\tfunctions %v, data %v, aliases %v\n", len(s.Functions), s.Data, s.Aliases)
+	fmt.Fprintf(w, "operations\t%v\n", s.Operations)
+	fmt.Fprintf(w, "types\t%v\n", s.Types)
+	fmt.Fprintf(w, "constants\t%v\n", s.Constants)
+	fmt.Fprintf(w, "strings\t%v (%v bytes)\n", s.Strings, s.StringBytes)
+	fmt.Fprintf(w, "\nfunction\toperations\n")
+	for _, v := range s.Functions {
+		fmt.Fprintf(w, "%s\t%v\n", v.NameID, v.Operations)
+	}
+	fmt.Fprintf(w, "\noperation\tcount\n")
+	var a []string
+	for k := range s.Counts {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	for _, v := range a {
+		fmt.Fprintf(w, "%s\t%v\n", v, s.Counts[v])
+	}
+	w.Flush()
+	r := string(buf.Bytes())
+	buf.Close()
+	return r
+}
@@ -4,9 +4,9 @@ package ir
 
 import "fmt"
 
-const _tok_name = "tokI8tokI16tokI32tokI64tokU8tokU16tokU32tokU64tokF32tokF64tokF128tokC64tokC128tokC256tokEllipsistokFunctokNumbertokStructtokUniontokNametokEOFtokIllegal"
+const _tok_name = "tokI8tokI16tokI32tokI64tokI128tokU8tokU16tokU32tokU64tokU128tokF16tokF32tokF64tokF128tokC64tokC128tokC256tokEllipsistokFunctokNumbertokStructtokUniontokVoidtokNametokEOFtokIllegal"
 
-var _tok_index = [...]uint8{0, 5, 11, 17, 23, 28, 34, 40, 46, 52, 58, 65, 71, 78, 85, 96, 103, 112, 121, 129, 136, 142, 152}
+var _tok_index = [...]uint8{0, 5, 11, 17, 23, 30, 35, 41, 47, 53, 60, 66, 72, 78, 85, 91, 98, 105, 116, 123, 132, 141, 149, 156, 163, 169, 179}
 
 func (i tok) String() string {
 	i -= 256
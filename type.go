@@ -5,8 +5,14 @@
 package ir
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cznic/internal/buffer"
 )
@@ -17,34 +23,159 @@ var (
 	_ Type = (*PointerType)(nil)
 	_ Type = (*StructOrUnionType)(nil)
 	_ Type = (*TypeBase)(nil)
+	_ Type = (*VectorType)(nil)
+
+	_ Types = (*SyncTypeCache)(nil)
+	_ Types = TypeCache(nil)
+
+	_ io.ReaderFrom = TypeCache(nil)
+	_ io.WriterTo   = TypeCache(nil)
+
+	baseTypes   atomic.Value // TypeCache, never mutated once stored.
+	baseTypesMu sync.Mutex
+
+	// The types and specifiers of standardTypes indexed by TypeID, never
+	// mutated after package initialization.
+	smallTypes []Type
+	smallNames []string
+
+	standardTypes = []string{
+		"complex128",
+		"complex256",
+		"complex64",
+		"float128",
+		"float16",
+		"float32",
+		"float64",
+		"func()int32",
+		"int128",
+		"int16",
+		"int32",
+		"int64",
+		"int8",
+		"struct{}",
+		"uint128",
+		"uint16",
+		"uint32",
+		"uint64",
+		"uint8",
+
+		"*complex128",
+		"*complex256",
+		"*complex64",
+		"*float128",
+		"*float16",
+		"*float32",
+		"*float64",
+		"*func()int32",
+		"*int128",
+		"*int16",
+		"*int32",
+		"*int64",
+		"*int8",
+		"*struct{}",
+		"*uint128",
+		"*uint16",
+		"*uint32",
+		"*uint64",
+		"*uint8",
+
+		"**int8",
+		"**struct{}",
+	}
 )
 
+const maxSmallTypeID = 1 << 12 // TypeIDs of standardTypes above the limit are not in smallTypes.
+
+func init() {
+	if err := SeedTypes(standardTypes...); err != nil {
+		panic(fmt.Errorf("internal error: %v", err))
+	}
+
+	base := baseTypes.Load().(TypeCache)
+	for _, v := range standardTypes {
+		id := TypeID(dict.SID(v))
+		if id >= maxSmallTypeID {
+			continue
+		}
+
+		for int(id) >= len(smallTypes) {
+			smallTypes = append(smallTypes, nil)
+			smallNames = append(smallNames, "")
+		}
+		smallTypes[id] = base[id]
+		smallNames[id] = v
+	}
+}
+
+// SeedTypes parses type specifiers and adds the resulting types to the base
+// type cache shared by all TypeCaches. On a miss, TypeCache.Type consults the
+// base type cache before parsing the type specifier. The scalar types and
+// pointers to them are seeded on package initialization. SeedTypes is safe
+// for concurrent use by multiple goroutines.
+func SeedTypes(specifiers ...string) error {
+	baseTypesMu.Lock()
+
+	defer baseTypesMu.Unlock()
+
+	base, _ := baseTypes.Load().(TypeCache)
+	c := make(TypeCache, len(base)+len(specifiers))
+	for k, v := range base {
+		c[k] = v
+	}
+	for _, v := range specifiers {
+		if _, err := c.Type(TypeID(dict.SID(v))); err != nil {
+			return err
+		}
+	}
+	baseTypes.Store(c)
+	return nil
+}
+
 // Type represents an IR type.
 //
 // The type specifier syntax is defined using Extended Backus-Naur Form
 // (EBNF[0]):
 //
-//	Type		= ArrayType | FunctionType | PointerType | StructType | TypeName | UnionType .
-//	ArrayType	= "[" "0"..."9" { "0"..."9" } "]" Type .
+//	Type		= ArrayType | FunctionType | NamedType | PointerType | StructType | TypeName | UnionType | VectorType .
+//	ArrayType	= "[" ( "0"..."9" { "0"..."9" } | "?" ) "]" Type .
+//	BitWidth	= ":" "1"..."9" { "0"..."9" } .
 //	FunctionType	= "func" "(" [ TypeList ] [ "..." ] ")" [ Type | "(" TypeList ")" ] .
 //	PointerType	= "*" Type .
 //	StructType	= "struct" "{" [ FieldList ] "}" .
-//	Fieldist	= name " " Type { "," name " " Type } .
+//	Fieldist	= name " " Type [ BitWidth ] { "," name " " Type [ BitWidth ] } .
+//	NamedType	= name "=" Type .
 //	TypeList	= Type { "," Type } .
-//	TypeName	= "uint8" | "uint16" | "uint32" | "uint64"
-//			| "int8" | "int16" | "int32" | "int64"
-//			| "float32" | "float64" | "float128"
+//	TypeName	= "uint8" | "uint16" | "uint32" | "uint64" | "uint128"
+//			| "int8" | "int16" | "int32" | "int64" | "int128"
+//			| "float16" | "float32" | "float64" | "float128"
 //			| "complex64" | "complex128" | complex256
-//			| "uint0" | "uint8" | "uint16" | "uint32" | "uint64" .
+//			| "uint0" | "uint8" | "uint16" | "uint32" | "uint64"
+//			| "void" .
 //	UnionType	= "union" "{" [ FieldList ] "}" .
+//	VectorType	= "<" "1"..."9" { "0"..."9" } "x" Type ">" .
 //
 // No whitespace is allowed in type specifiers except as the name Type separator.
+// The type of a bit field must be an integer type other than int128 and
+// uint128.
+// The item type of a vector type must be an integer type, float32 or float64.
+//
+// Void and incomplete arrays, like "[?]int32", have no size. They can be used
+// as pointer elements and in declarations of external data, but not as the
+// type of a variable, of an initialized data definition or of a loaded value.
+// See IsComplete.
+//
+// A named type, like "tm=struct{tm_sec int32,tm_min int32}", has the same
+// representation as the type following the equal sign, but its TypeBase.Name
+// is set. The name is an identifier consisting of ASCII letters, digits and
+// underscores that does not start with a digit.
 //
 //  [0]: https://golang.org/ref/spec#Notation
 //
 // Type identity
 //
-// Two types are identical if their type specifiers are equivalent.
+// Two types are identical if their type specifiers are equivalent. Named types
+// are thus distinct from any other type, including their underlying types.
 type Type interface {
 	Equal(Type) bool
 	ID() TypeID
@@ -55,6 +186,7 @@ type Type interface {
 
 // TypeBase collects fields common to all types.
 type TypeBase struct {
+	Name NameID // Non zero for a named type.
 	TypeKind
 	TypeID
 }
@@ -96,6 +228,69 @@ func newPointerType(t Type) Type {
 	}
 }
 
+// IsComplete reports whether t has a known size. Void, incomplete arrays and
+// arrays, structs or unions containing them are not complete.
+func IsComplete(t Type) bool {
+	switch x := t.(type) {
+	case *ArrayType:
+		return x.Items >= 0 && IsComplete(x.Item)
+	case *StructOrUnionType:
+		for _, v := range x.Fields {
+			if !IsComplete(v) {
+				return false
+			}
+		}
+	default:
+		return t.Kind() != Void
+	}
+	return true
+}
+
+func typeBase(t Type) *TypeBase {
+	switch x := t.(type) {
+	case *ArrayType:
+		return &x.TypeBase
+	case *FunctionType:
+		return &x.TypeBase
+	case *PointerType:
+		return &x.TypeBase
+	case *StructOrUnionType:
+		return &x.TypeBase
+	case *TypeBase:
+		return x
+	case *VectorType:
+		return &x.TypeBase
+	}
+	return nil
+}
+
+func isSigned(k TypeKind) bool {
+	switch k {
+	case Int8, Int16, Int32, Int64, Int128:
+		return true
+	}
+
+	return false
+}
+
+func isFloating(k TypeKind) bool {
+	switch k {
+	case Float16, Float32, Float64, Float128:
+		return true
+	}
+
+	return false
+}
+
+func isIntegral(k TypeKind) bool {
+	switch k {
+	case Int8, Int16, Int32, Int64, Int128, Uint8, Uint16, Uint32, Uint64, Uint128:
+		return true
+	}
+
+	return false
+}
+
 // TypeID is a numeric identifier of a type specifier as registered in a global
 // dictionary[0].
 //
@@ -107,36 +302,74 @@ func (t TypeID) Equal(u Type) bool { return t == u.ID() }
 
 // Signed implements Type.
 func (t TypeID) Signed() bool {
-	switch t {
-	case idInt8, idInt16, idInt32, idInt64:
-		return true
-	}
-
-	return false
+	return t >= 0 && int(t) < len(smallTypes) && smallTypes[t] != nil && isSigned(smallTypes[t].Kind())
 }
 
 // ID implements Type.
 func (t TypeID) ID() TypeID { return t }
 
-// String implements fmt.Stringer.
-func (t TypeID) String() string { return string(dict.S(int(t))) }
+// String implements fmt.Stringer. The type specifiers of the scalar types and
+// pointers to them are precomputed.
+func (t TypeID) String() string {
+	if t >= 0 && int(t) < len(smallNames) && smallNames[t] != "" {
+		return smallNames[t]
+	}
+
+	return string(dict.S(int(t)))
+}
+
+// Abbrev returns the type specifier of t with the definitions of named types
+// replaced by their names, for example "*tm" instead of
+// "*tm=struct{tm_sec int32,tm_min int32}". The result is meant for humans, it
+// is not a valid type specifier if t contains named types.
+func (t TypeID) Abbrev() string {
+	s := dict.S(int(t))
+	if bytes.IndexByte(s, '=') < 0 {
+		return string(s)
+	}
+
+	var buf buffer.Bytes
+	c := TypeCache{}
+	for len(s) != 0 {
+		if nm := c.name(&s); nm != 0 {
+			buf.Write(dict.S(int(nm)))
+			if _, err := c.parse(&s, 0); err != nil {
+				buf.Close()
+				return t.String()
+			}
+
+			continue
+		}
+
+		for len(s) != 0 {
+			b := s[0]
+			buf.WriteByte(b)
+			s = s[1:]
+			if b == ' ' || b == ',' || b == '*' || b == '(' || b == ')' || b == '{' || b == ']' || b == '<' || b == 'x' {
+				break
+			}
+		}
+	}
+	r := string(buf.Bytes())
+	buf.Close()
+	return r
+}
 
 // GobDecode implements GobDecoder.
 func (t *TypeID) GobDecode(b []byte) error {
-	*t = TypeID(dict.ID(b))
-	return nil
+	id, err := gobDecodeID(b)
+	*t = TypeID(id)
+	return err
 }
 
 // GobEncode implements GobEncoder.
-func (t TypeID) GobEncode() ([]byte, error) {
-	return append([]byte(nil), dict.S(int(t))...), nil
-}
+func (t TypeID) GobEncode() ([]byte, error) { return gobEncodeID(int(t)), nil }
 
 // ArrayType represents a collection of items that can be selected by index.
 type ArrayType struct {
 	TypeBase
 	Item  Type
-	Items int64
+	Items int64 // Negative for an incomplete array, "[?]T".
 }
 
 // Pointer implements Type.
@@ -165,7 +398,12 @@ func (t *PointerType) Pointer() Type { return newPointerType(t) }
 
 // StructOrUnionType represents a collection of fields that can be selected by
 // name.
+//
+// A field declared as, for example, "a int32:3" is a bit field of width 3. Bits
+// is nil if there are no bit fields, otherwise Bits[i] is the width of the bit
+// field Fields[i] or zero if the field is not a bit field.
 type StructOrUnionType struct {
+	Bits   []int
 	Fields []Type
 	Names  []NameID
 	TypeBase
@@ -174,10 +412,41 @@ type StructOrUnionType struct {
 // Pointer implements Type.
 func (t *StructOrUnionType) Pointer() Type { return newPointerType(t) }
 
+// VectorType represents a fixed number of items of a scalar type operated on
+// as a whole, for example a GCC vector extension type or a SIMD register
+// type.
+type VectorType struct {
+	TypeBase
+	Item  Type
+	Items int64
+}
+
+// Pointer implements Type.
+func (t *VectorType) Pointer() Type { return newPointerType(t) }
+
 // TypeCache maps TypeIDs to  Types. Use TypeCache{} to create a ready to use
 // TypeCache value.
 type TypeCache map[TypeID]Type
 
+// Types resolves TypeIDs to Types. It is implemented by TypeCache and by
+// *SyncTypeCache, which can be shared by goroutines verifying, evaluating or
+// linking IR concurrently.
+type Types interface {
+	Compatible(a, b TypeID) bool
+	Composite(a, b TypeID) (TypeID, error)
+	MustType(id TypeID) Type
+	Type(id TypeID) (Type, error)
+}
+
+// newTypes returns tc or a new TypeCache if tc is nil.
+func newTypes(tc Types) Types {
+	if c, ok := tc.(TypeCache); tc == nil || ok && c == nil {
+		return TypeCache{}
+	}
+
+	return tc
+}
+
 func (c TypeCache) c(p *[]byte) tok {
 	s := *p
 	if len(s) == 0 {
@@ -205,7 +474,7 @@ func (c TypeCache) n(p *[]byte) tok {
 func (c TypeCache) lex2(p *[]byte) (tok, int64) {
 	t := c.c(p)
 	switch t {
-	case '*', '(', ')', '{', '}', ',', '[', ']':
+	case '*', '(', ')', '{', '}', ',', '[', ']', '<', '>':
 		c.n(p)
 		return t, 0
 	case '.':
@@ -252,9 +521,15 @@ func (c TypeCache) lex2(p *[]byte) (tok, int64) {
 			if c.n(p) == 'o' && c.n(p) == 'a' && c.n(p) == 't' {
 				switch c.n(p) {
 				case '1':
-					if c.n(p) == '2' && c.n(p) == '8' {
+					switch c.n(p) {
+					case '2':
+						if c.n(p) == '8' {
+							c.n(p)
+							return tokF128, 0
+						}
+					case '6':
 						c.n(p)
-						return tokF128, 0
+						return tokF16, 0
 					}
 				case '3':
 					if c.n(p) == '2' {
@@ -278,7 +553,13 @@ func (c TypeCache) lex2(p *[]byte) (tok, int64) {
 		if c.n(p) == 'n' && c.n(p) == 't' {
 			switch c.n(p) {
 			case '1':
-				if c.n(p) == '6' {
+				switch c.n(p) {
+				case '2':
+					if c.n(p) == '8' {
+						c.n(p)
+						return tokI128, 0
+					}
+				case '6':
 					c.n(p)
 					return tokI16, 0
 				}
@@ -308,7 +589,13 @@ func (c TypeCache) lex2(p *[]byte) (tok, int64) {
 			if c.n(p) == 'n' && c.n(p) == 't' {
 				switch c.n(p) {
 				case '1':
-					if c.n(p) == '6' {
+					switch c.n(p) {
+					case '2':
+						if c.n(p) == '8' {
+							c.n(p)
+							return tokU128, 0
+						}
+					case '6':
 						c.n(p)
 						return tokU16, 0
 					}
@@ -333,6 +620,11 @@ func (c TypeCache) lex2(p *[]byte) (tok, int64) {
 				return tokUnion, 0
 			}
 		}
+	case 'v':
+		if c.n(p) == 'o' && c.n(p) == 'i' && c.n(p) == 'd' {
+			c.n(p)
+			return tokVoid, 0
+		}
 	case tokEOF:
 		return t, 0
 	}
@@ -367,9 +659,10 @@ func (c TypeCache) parseTypeList(p *[]byte) ([]Type, error) {
 	}
 }
 
-func (c TypeCache) parseFieldList(p *[]byte) ([]NameID, []Type, error) {
+func (c TypeCache) parseFieldList(p *[]byte) ([]NameID, []Type, []int, error) {
 	var nl []NameID
 	var tl []Type
+	var bl []int
 	first := true
 	for {
 		p0 := *p
@@ -381,10 +674,10 @@ func (c TypeCache) parseFieldList(p *[]byte) ([]NameID, []Type, error) {
 				c.n(p)
 				break outer
 			case tokEOF:
-				return nil, nil, fmt.Errorf("expected ' '")
+				return nil, nil, nil, fmt.Errorf("expected ' '")
 			case '}':
 				if first {
-					return nl, tl, nil
+					return nl, tl, bl, nil
 				}
 			}
 			c.n(p)
@@ -394,15 +687,32 @@ func (c TypeCache) parseFieldList(p *[]byte) ([]NameID, []Type, error) {
 
 		t, err := c.parse(p, 0)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		tl = append(tl, t)
+		if c.c(p) == ':' {
+			c.n(p)
+			tk, n := c.lex2(p)
+			if k := t.Kind(); tk != tokNumber || n == 0 || n > 64 || !isIntegral(k) || k == Int128 || k == Uint128 {
+				return nil, nil, nil, fmt.Errorf("invalid bit field")
+			}
+
+			for len(bl) < len(tl)-1 {
+				bl = append(bl, 0)
+			}
+			bl = append(bl, int(n))
+		}
 		switch c.c(p) {
 		case ',':
 			c.n(p)
 		case '}':
-			return nl, tl, nil
+			if bl != nil {
+				for len(bl) < len(tl) {
+					bl = append(bl, 0)
+				}
+			}
+			return nl, tl, bl, nil
 		}
 	}
 }
@@ -476,8 +786,65 @@ more:
 	}
 }
 
+// name consumes the name of a named type, if any, including the equal sign.
+func (c TypeCache) name(p *[]byte) NameID {
+	s := *p
+	i := 0
+	for ; i < len(s); i++ {
+		switch b := s[i]; {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b == '_', i != 0 && b >= '0' && b <= '9':
+			continue
+		}
+		break
+	}
+	if i == 0 || i == len(s) || s[i] != '=' {
+		return 0
+	}
+
+	*p = s[i+1:]
+	return NameID(dict.ID(s[:i]))
+}
+
+func (c TypeCache) parseNamed(p *[]byte, id TypeID, p0 []byte, nm NameID) (Type, error) {
+	u, err := c.parse(p, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := u.(type) {
+	case *ArrayType:
+		t := *x
+		t.TypeBase = TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, &t), nil
+	case *FunctionType:
+		t := *x
+		t.TypeBase = TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, &t), nil
+	case *PointerType:
+		t := *x
+		t.TypeBase = TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, &t), nil
+	case *StructOrUnionType:
+		t := *x
+		t.TypeBase = TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, &t), nil
+	case *TypeBase:
+		t := &TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, t), nil
+	case *VectorType:
+		t := *x
+		t.TypeBase = TypeBase{Name: nm, TypeKind: x.TypeKind}
+		return t.setID(id, p0, p, c, &t), nil
+	}
+	panic("internal error")
+}
+
 func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 	p0 := *p
+	if nm := c.name(p); nm != 0 {
+		return c.parseNamed(p, id, p0, nm)
+	}
+
 	tk := c.lex(p)
 	k := Union
 	switch tk {
@@ -493,6 +860,9 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 	case tokI64:
 		t := &TypeBase{TypeKind: Int64}
 		return t.setID(id, p0, p, c, t), nil
+	case tokI128:
+		t := &TypeBase{TypeKind: Int128}
+		return t.setID(id, p0, p, c, t), nil
 	case tokU8:
 		t := &TypeBase{TypeKind: Uint8}
 		return t.setID(id, p0, p, c, t), nil
@@ -505,6 +875,12 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 	case tokU64:
 		t := &TypeBase{TypeKind: Uint64}
 		return t.setID(id, p0, p, c, t), nil
+	case tokU128:
+		t := &TypeBase{TypeKind: Uint128}
+		return t.setID(id, p0, p, c, t), nil
+	case tokF16:
+		t := &TypeBase{TypeKind: Float16}
+		return t.setID(id, p0, p, c, t), nil
 	case tokF32:
 		t := &TypeBase{TypeKind: Float32}
 		return t.setID(id, p0, p, c, t), nil
@@ -523,6 +899,9 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 	case tokC256:
 		t := &TypeBase{TypeKind: Complex256}
 		return t.setID(id, p0, p, c, t), nil
+	case tokVoid:
+		t := &TypeBase{TypeKind: Void}
+		return t.setID(id, p0, p, c, t), nil
 	case '*':
 		element, err := c.parse(p, 0)
 		if err != nil {
@@ -535,7 +914,13 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 		}
 		return t.setID(id, p0, p, c, t), nil
 	case '[':
-		if tk, n := c.lex2(p); tk == tokNumber && c.lex(p) == ']' {
+		tk, n := tokNumber, int64(-1)
+		if c.c(p) == '?' {
+			c.n(p)
+		} else {
+			tk, n = c.lex2(p)
+		}
+		if tk == tokNumber && c.lex(p) == ']' {
 			item, err := c.parse(p, 0)
 			if err != nil {
 				return nil, err
@@ -548,6 +933,29 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 			}
 			return t.setID(id, p0, p, c, t), nil
 		}
+	case '<':
+		if tk, n := c.lex2(p); tk == tokNumber && n != 0 && c.c(p) == 'x' {
+			c.n(p)
+			item, err := c.parse(p, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			if k := item.Kind(); !isIntegral(k) && k != Float32 && k != Float64 {
+				return nil, fmt.Errorf("invalid vector item type %s", item)
+			}
+
+			if c.lex(p) != '>' {
+				return nil, fmt.Errorf("expected '>'")
+			}
+
+			t := &VectorType{
+				Item:     item,
+				Items:    n,
+				TypeBase: TypeBase{TypeKind: Vector},
+			}
+			return t.setID(id, p0, p, c, t), nil
+		}
 	case tokFunc:
 		t, err := c.parseFunc(p)
 		if err != nil {
@@ -563,7 +971,7 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 			return nil, fmt.Errorf("expected '{'")
 		}
 
-		nl, tl, err := c.parseFieldList(p)
+		nl, tl, bl, err := c.parseFieldList(p)
 		if err != nil {
 			return nil, err
 		}
@@ -572,27 +980,37 @@ func (c TypeCache) parse(p *[]byte, id TypeID) (Type, error) {
 			return nil, fmt.Errorf("expected '}'")
 		}
 
-		t := &StructOrUnionType{TypeBase: TypeBase{TypeKind: k}, Fields: tl, Names: nl}
+		t := &StructOrUnionType{TypeBase: TypeBase{TypeKind: k}, Bits: bl, Fields: tl, Names: nl}
 		return t.setID(id, p0, p, c, t), nil
 	}
 	return nil, fmt.Errorf("unexpected %q (%q)", tk, p0)
 }
 
-// Type returns the type identified by id or an error, if any. If the cache has
-// already a value for id, it is returned.  Otherwise the type specifier
-// denoted by id is parsed.
+// Type returns the type identified by id or an error, if any. If the cache, or
+// the base type cache populated by SeedTypes, has already a value for id, it
+// is returned.  Otherwise the type specifier denoted by id is parsed.
 func (c TypeCache) Type(id TypeID) (Type, error) {
+	if id >= 0 && int(id) < len(smallTypes) && smallTypes[id] != nil {
+		return smallTypes[id], nil
+	}
+
 	if t := c[id]; t != nil {
 		return t, nil
 	}
 
+	if base, _ := baseTypes.Load().(TypeCache); base[id] != nil {
+		return base[id], nil
+	}
+
 	b := dict.S(int(id))
 	t, err := c.parse(&b, id)
 	if err != nil {
+		delete(c, id)
 		return nil, err
 	}
 
 	if tk := c.lex(&b); tk != tokEOF {
+		delete(c, id) // parse may have cached the prefix of b as id.
 		return nil, fmt.Errorf("unexpected token %q", tk)
 	}
 
@@ -600,6 +1018,32 @@ func (c TypeCache) Type(id TypeID) (Type, error) {
 	return t, nil
 }
 
+// Define returns the named type nm with underlying type t, parsing it if
+// necessary.
+func (c TypeCache) Define(nm NameID, t TypeID) (Type, error) {
+	var buf buffer.Bytes
+	buf.Write(dict.S(int(nm)))
+	buf.WriteByte('=')
+	buf.Write(dict.S(int(t)))
+	id := TypeID(dict.ID(buf.Bytes()))
+	buf.Close()
+	return c.Type(id)
+}
+
+// Named returns the types named nm in c or nil if there are none. Distinct
+// named types can share the same name, for example types of the same name
+// declared in different scopes.
+func (c TypeCache) Named(nm NameID) []Type {
+	var r []Type
+	for _, v := range c {
+		if x := typeBase(v); x != nil && x.Name == nm {
+			r = append(r, v)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ID().String() < r[j].ID().String() })
+	return r
+}
+
 // MustType is like Type but panics on error.
 func (c TypeCache) MustType(id TypeID) Type {
 	t, err := c.Type(id)
@@ -609,3 +1053,365 @@ func (c TypeCache) MustType(id TypeID) Type {
 
 	return t
 }
+
+// Compatible reports whether the types a and b are compatible in the sense of
+// C, for example whether two declarations of the same external name in
+// different translation units may refer to the same object. Types that
+// cannot be parsed are not compatible.
+//
+// Identical types are compatible. Otherwise named, struct, union and scalar
+// types are compatible only with themselves. Pointers are compatible if their
+// elements are. Arrays are compatible if their items are and if they have the
+// same number of items or at least one of them is incomplete. Vectors are
+// compatible if they have the same number of compatible items. Function types
+// are compatible if they have the same number of compatible results and
+// either one of them has no arguments, as a C function declared without a
+// prototype, or both have the same number of compatible arguments and are
+// both variadic or both not.
+func (c TypeCache) Compatible(a, b TypeID) bool { return typesCompatible(c, a, b) }
+
+// typesCompatible implements Types.Compatible using tc to parse a and b. The
+// parsed types are compared without using tc.
+func typesCompatible(tc Types, a, b TypeID) bool {
+	if a == b {
+		return true
+	}
+
+	t, err := tc.Type(a)
+	if err != nil {
+		return false
+	}
+
+	u, err := tc.Type(b)
+	if err != nil {
+		return false
+	}
+
+	return TypeCache(nil).compatible(t, u)
+}
+
+func (c TypeCache) compatible(t, u Type) bool {
+	if t.ID() == u.ID() {
+		return true
+	}
+
+	if t.Kind() != u.Kind() || typeBase(t).Name != 0 || typeBase(u).Name != 0 {
+		return false
+	}
+
+	switch x := t.(type) {
+	case *ArrayType:
+		y := u.(*ArrayType)
+		return (x.Items < 0 || y.Items < 0 || x.Items == y.Items) && c.compatible(x.Item, y.Item)
+	case *FunctionType:
+		y := u.(*FunctionType)
+		if !c.compatibleList(x.Results, y.Results) {
+			return false
+		}
+
+		if len(x.Arguments) == 0 || len(y.Arguments) == 0 {
+			return true
+		}
+
+		return x.Variadic == y.Variadic && c.compatibleList(x.Arguments, y.Arguments)
+	case *PointerType:
+		return c.compatible(x.Element, u.(*PointerType).Element)
+	case *VectorType:
+		y := u.(*VectorType)
+		return x.Items == y.Items && c.compatible(x.Item, y.Item)
+	}
+	return false
+}
+
+func (c TypeCache) compatibleList(t, u []Type) bool {
+	if len(t) != len(u) {
+		return false
+	}
+
+	for i, v := range t {
+		if !c.compatible(v, u[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Composite returns the composite type of the compatible types a and b in the
+// sense of C. It is the type having the number of items of the complete array
+// and the arguments of the function type with a prototype wherever a and b
+// differ, for example the composite type of "*[?]int32" and "*[10]int32" is
+// "*[10]int32".
+func (c TypeCache) Composite(a, b TypeID) (TypeID, error) { return typesComposite(c, a, b) }
+
+// typesComposite implements Types.Composite using tc to parse the types. The
+// specifier of the composite type is computed without using tc.
+func typesComposite(tc Types, a, b TypeID) (TypeID, error) {
+	if !typesCompatible(tc, a, b) {
+		return 0, fmt.Errorf("incompatible types %v and %v", a, b)
+	}
+
+	if a == b {
+		return a, nil
+	}
+
+	var buf buffer.Bytes
+
+	defer buf.Close()
+
+	TypeCache(nil).composite(&buf, tc.MustType(a), tc.MustType(b))
+	id := TypeID(dict.ID(buf.Bytes()))
+	if _, err := tc.Type(id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// composite writes the specifier of the composite type of the compatible
+// types t and u to buf.
+func (c TypeCache) composite(buf *buffer.Bytes, t, u Type) {
+	if t.ID() == u.ID() {
+		buf.Write(dict.S(int(t.ID())))
+		return
+	}
+
+	switch x := t.(type) {
+	case *ArrayType:
+		y := u.(*ArrayType)
+		switch n := x.Items; {
+		case n < 0 && y.Items < 0:
+			buf.WriteString("[?]")
+		case n < 0:
+			fmt.Fprintf(buf, "[%v]", y.Items)
+		default:
+			fmt.Fprintf(buf, "[%v]", n)
+		}
+		c.composite(buf, x.Item, y.Item)
+	case *FunctionType:
+		y := u.(*FunctionType)
+		args, other, variadic := x.Arguments, y.Arguments, x.Variadic
+		switch {
+		case len(x.Arguments) == 0:
+			args, other, variadic = y.Arguments, y.Arguments, y.Variadic
+		case len(y.Arguments) == 0:
+			other = x.Arguments
+		}
+		buf.WriteString("func(")
+		c.compositeList(buf, args, other)
+		if variadic {
+			if len(args) != 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString("...")
+		}
+		buf.WriteByte(')')
+		switch len(x.Results) {
+		case 0:
+			// nop
+		case 1:
+			c.composite(buf, x.Results[0], y.Results[0])
+		default:
+			buf.WriteByte('(')
+			c.compositeList(buf, x.Results, y.Results)
+			buf.WriteByte(')')
+		}
+	case *PointerType:
+		buf.WriteByte('*')
+		c.composite(buf, x.Element, u.(*PointerType).Element)
+	case *VectorType:
+		fmt.Fprintf(buf, "<%vx", x.Items)
+		c.composite(buf, x.Item, u.(*VectorType).Item)
+		buf.WriteByte('>')
+	default:
+		panic(fmt.Errorf("internal error: %T", x))
+	}
+}
+
+func (c TypeCache) compositeList(buf *buffer.Bytes, t, u []Type) {
+	for i, v := range t {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		c.composite(buf, v, u[i])
+	}
+}
+
+// typeRecord is the serialized form of a type. Types it refers to are
+// represented by indices of their preceding records.
+type typeRecord struct {
+	Bits     []int
+	Elements []int // Item, element, arguments or fields.
+	Items    int64
+	Kind     TypeKind
+	Name     NameID
+	Names    []NameID
+	Results  []int
+	TypeID   TypeID
+	Variadic bool
+}
+
+// WriteTo writes the types of c, and the types they refer to, to w so they
+// can be later added to a TypeCache using ReadFrom without parsing their type
+// specifiers, for example to share a warmed up cache between a compiler, a
+// linker and a back end.
+func (c TypeCache) WriteTo(w io.Writer) (n int64, err error) {
+	ids := make([]TypeID, 0, len(c))
+	for k := range c {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	var r []typeRecord
+	m := map[TypeID]int{}
+	var add func(Type) int
+	adds := func(a []Type) (r []int) {
+		for _, v := range a {
+			r = append(r, add(v))
+		}
+		return r
+	}
+	add = func(t Type) int {
+		if n, ok := m[t.ID()]; ok {
+			return n
+		}
+
+		rec := typeRecord{Kind: t.Kind(), Name: typeBase(t).Name, TypeID: t.ID()}
+		switch x := t.(type) {
+		case *ArrayType:
+			rec.Elements = []int{add(x.Item)}
+			rec.Items = x.Items
+		case *FunctionType:
+			rec.Elements = adds(x.Arguments)
+			rec.Results = adds(x.Results)
+			rec.Variadic = x.Variadic
+		case *PointerType:
+			rec.Elements = []int{add(x.Element)}
+		case *StructOrUnionType:
+			rec.Bits = x.Bits
+			rec.Elements = adds(x.Fields)
+			rec.Names = x.Names
+		case *VectorType:
+			rec.Elements = []int{add(x.Item)}
+			rec.Items = x.Items
+		}
+		m[t.ID()] = len(r)
+		r = append(r, rec)
+		return len(r) - 1
+	}
+	for _, v := range ids {
+		add(c[v])
+	}
+
+	return encode(w, &WriteOptions{ModTime: time.Now()}, "IR types", r)
+}
+
+// ReadFrom adds to c the types written by TypeCache.WriteTo to r. Types
+// already present in c are kept.
+func (c TypeCache) ReadFrom(r io.Reader) (n int64, err error) {
+	var a []typeRecord
+	n, err = decode(r, HostTarget(), &a)
+	if err != nil {
+		return n, err
+	}
+
+	base, _ := baseTypes.Load().(TypeCache)
+	types := make([]Type, len(a))
+	for i, v := range a {
+		if t := c[v.TypeID]; t != nil {
+			types[i] = t
+			continue
+		}
+
+		if t := base[v.TypeID]; t != nil {
+			types[i] = t
+			continue
+		}
+
+		var el, res []Type
+		for _, k := range v.Elements {
+			if k < 0 || k >= i {
+				return n, fmt.Errorf("corrupted type cache")
+			}
+
+			el = append(el, types[k])
+		}
+		for _, k := range v.Results {
+			if k < 0 || k >= i {
+				return n, fmt.Errorf("corrupted type cache")
+			}
+
+			res = append(res, types[k])
+		}
+		tb := TypeBase{Name: v.Name, TypeKind: v.Kind, TypeID: v.TypeID}
+		var t Type
+		switch v.Kind {
+		case Array, Pointer, Vector:
+			if len(el) != 1 {
+				return n, fmt.Errorf("corrupted type cache")
+			}
+
+			switch v.Kind {
+			case Array:
+				t = &ArrayType{TypeBase: tb, Item: el[0], Items: v.Items}
+			case Pointer:
+				t = &PointerType{TypeBase: tb, Element: el[0]}
+			default:
+				t = &VectorType{TypeBase: tb, Item: el[0], Items: v.Items}
+			}
+		case Function:
+			t = &FunctionType{TypeBase: tb, Arguments: el, Results: res, Variadic: v.Variadic}
+		case Struct, Union:
+			t = &StructOrUnionType{Bits: v.Bits, Fields: el, Names: v.Names, TypeBase: tb}
+		default:
+			t = &tb
+		}
+		types[i] = t
+		c[v.TypeID] = t
+	}
+	return n, nil
+}
+
+// SyncTypeCache is a TypeCache safe for concurrent use by multiple goroutines.
+// Pass it as the Types of VerifyValue, EvalConst, EmitData, FrameInfo,
+// Verifier, Linker or LinkLibOptions to share the parsed types between
+// parallel pipelines. The zero value is ready to use.
+type SyncTypeCache struct {
+	c  TypeCache
+	mu sync.RWMutex
+}
+
+// Type is like TypeCache.Type.
+func (c *SyncTypeCache) Type(id TypeID) (Type, error) {
+	c.mu.RLock()
+	t := c.c[id]
+	c.mu.RUnlock()
+	if t != nil {
+		return t, nil
+	}
+
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	if c.c == nil {
+		c.c = TypeCache{}
+	}
+	return c.c.Type(id)
+}
+
+// MustType is like Type but panics on error.
+func (c *SyncTypeCache) MustType(id TypeID) Type {
+	t, err := c.Type(id)
+	if err != nil {
+		panic(fmt.Errorf("%q: %v", id, err))
+	}
+
+	return t
+}
+
+// Compatible is like TypeCache.Compatible. Only resolving a and b uses the
+// lock of c, the types are compared without holding it.
+func (c *SyncTypeCache) Compatible(a, b TypeID) bool { return typesCompatible(c, a, b) }
+
+// Composite is like TypeCache.Composite. Only resolving the types uses the
+// lock of c, the composite type is computed without holding it.
+func (c *SyncTypeCache) Composite(a, b TypeID) (TypeID, error) { return typesComposite(c, a, b) }
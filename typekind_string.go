@@ -4,9 +4,9 @@ package ir
 
 import "fmt"
 
-const _TypeKind_name = "Int8Int16Int32Int64Uint8Uint16Uint32Uint64Float32Float64Float128Complex64Complex128Complex256ArrayUnionStructPointerFunction"
+const _TypeKind_name = "Int8Int16Int32Int64Int128Uint8Uint16Uint32Uint64Uint128Float16Float32Float64Float128Complex64Complex128Complex256ArrayUnionStructPointerFunctionVectorVoid"
 
-var _TypeKind_index = [...]uint8{0, 4, 9, 14, 19, 24, 30, 36, 42, 49, 56, 64, 73, 83, 93, 98, 103, 109, 116, 124}
+var _TypeKind_index = [...]uint8{0, 4, 9, 14, 19, 25, 30, 36, 42, 48, 55, 62, 69, 76, 84, 93, 103, 113, 118, 123, 129, 136, 144, 150, 154}
 
 func (i TypeKind) String() string {
 	i -= 1
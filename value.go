@@ -6,21 +6,34 @@ package ir
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/cznic/internal/buffer"
 )
 
+// Float128Prec is the precision, in bits, of the significand of the IEEE 754
+// binary128 format.
+const Float128Prec = 113
+
 var (
+	int128Min    = new(big.Int).Lsh(big.NewInt(-1), 127)
+	uint128Limit = new(big.Int).Lsh(big.NewInt(1), 128)
+
 	_ Value = (*AddressValue)(nil)
 	_ Value = (*Complex128Value)(nil)
 	_ Value = (*Complex64Value)(nil)
 	_ Value = (*CompositeValue)(nil)
 	_ Value = (*DesignatedValue)(nil)
+	_ Value = (*DifferenceValue)(nil)
+	_ Value = (*Float128Value)(nil)
 	_ Value = (*Float32Value)(nil)
 	_ Value = (*Float64Value)(nil)
+	_ Value = (*Int128Value)(nil)
 	_ Value = (*Int32Value)(nil)
 	_ Value = (*Int64Value)(nil)
 	_ Value = (*StringValue)(nil)
+	_ Value = (*Uint32Value)(nil)
+	_ Value = (*Uint64Value)(nil)
 	_ Value = (*WideStringValue)(nil)
 )
 
@@ -55,13 +68,15 @@ func (v *AddressValue) String() string {
 		default:
 			return fmt.Sprintf("(%v, %v+%v)", v.Index, v.NameID, v.Offset)
 		}
-	case ExternalLinkage:
+	case ExternalLinkage, OnceLinkage, WeakLinkage, CommonLinkage:
 		switch {
 		case v.Label != 0:
 			return fmt.Sprintf("(%v, %v, &&%v+%v)", v.Index, v.NameID, v.Label, v.Offset)
 		default:
 			return fmt.Sprintf("(extern %v, &%v+%v)", v.Index, v.NameID, v.Offset)
 		}
+	case WeakExternalLinkage:
+		return fmt.Sprintf("(weak %v, &%v+%v)", v.Index, v.NameID, v.Offset)
 	default:
 		switch {
 		case v.Label != 0:
@@ -116,6 +131,28 @@ type DesignatedValue struct {
 
 func (v *DesignatedValue) String() string { return fmt.Sprintf("%v: %v", v.Index, v.Value) }
 
+// DifferenceValue is a declaration initializer constant of an integer type,
+// the difference of the addresses A and B. Its final value is determined by
+// the linker/loader. Position independent data, like the jump tables of the
+// GCC computed goto extension consisting of &&label - &&base items, are
+// initialized using DifferenceValues of label addresses. Arithmetic on the
+// labels is expressed by the Offset fields of A and B.
+type DifferenceValue struct {
+	A, B *AddressValue
+	valuer
+}
+
+func (v *DifferenceValue) String() string { return fmt.Sprintf("(%v - %v)", v.A, v.B) }
+
+// Float128Value is a declaration initializer constant of type float128 or
+// long double. Value should have a precision of at least Float128Prec bits.
+type Float128Value struct {
+	valuer
+	Value *big.Float
+}
+
+func (v *Float128Value) String() string { return v.Value.Text('g', -1) }
+
 // Float32Value is a declaration initializer constant of type float32.
 type Float32Value struct {
 	valuer
@@ -132,6 +169,15 @@ type Float64Value struct {
 
 func (v *Float64Value) String() string { return fmt.Sprint(v.Value) }
 
+// Int128Value is a declaration initializer constant of type int128 or
+// uint128. Value must be in [-2^127, 2^128), it is stored modulo 2^128.
+type Int128Value struct {
+	valuer
+	Value *big.Int
+}
+
+func (v *Int128Value) String() string { return v.Value.String() }
+
 // Int32Value is a declaration initializer constant of type int32.
 type Int32Value struct {
 	valuer
@@ -157,6 +203,24 @@ type StringValue struct {
 
 func (v *StringValue) String() string { return fmt.Sprintf("%q+%v", v.StringID, v.Offset) }
 
+// Uint32Value is a declaration initializer constant of type uint32. Its String
+// form has a "u" suffix, for example "42u".
+type Uint32Value struct {
+	valuer
+	Value uint32
+}
+
+func (v *Uint32Value) String() string { return fmt.Sprintf("%vu", v.Value) }
+
+// Uint64Value is a declaration initializer constant of type uint64. Its String
+// form has a "u" suffix, for example "42u".
+type Uint64Value struct {
+	valuer
+	Value uint64
+}
+
+func (v *Uint64Value) String() string { return fmt.Sprintf("%vu", v.Value) }
+
 // WideStringValue is a declaration initializer constant of type wide string.
 type WideStringValue struct {
 	valuer
@@ -0,0 +1,158 @@
+// Copyright 2017 The IR Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ir
+
+// Walk calls fn for every operation of body in order. The operation returned
+// by fn replaces body[ip], returning nil deletes it. If fn returns false, Walk
+// stops and the remaining operations are kept unchanged. Walk returns the
+// resulting body, which may share the backing array of body.
+func Walk(body []Operation, fn func(ip int, op Operation) (Operation, bool)) []Operation {
+	w := 0
+	for ip, op := range body {
+		op, ok := fn(ip, op)
+		if op != nil {
+			body[w] = op
+			w++
+		}
+		if !ok {
+			w += copy(body[w:], body[ip+1:])
+			break
+		}
+	}
+	return body[:w]
+}
+
+type rewrite struct {
+	after    []Operation
+	before   []Operation
+	replace  []Operation
+	replaced bool
+}
+
+// Rewriter collects edits of a function body and applies them all at once.
+// Edits are addressed by the indices of the original body, so their order
+// does not matter.
+//
+// Numbered labels and branches to them in the inserted operations are
+// renumbered, separately for every edit, to not clash with labels of the
+// original body or of other edits. Branches to numbers not defined by the
+// inserted operations are left alone, they refer to the labels of the
+// original body.
+type Rewriter struct {
+	body  []Operation
+	edits map[int]*rewrite
+	label int // Next free label number.
+}
+
+// NewRewriter returns a newly created Rewriter of body.
+func NewRewriter(body []Operation) *Rewriter {
+	r := &Rewriter{body: body, edits: map[int]*rewrite{}}
+	for _, op := range body {
+		if x, ok := op.(*Label); ok && x.NameID == 0 && x.Number >= r.label {
+			r.label = x.Number + 1
+		}
+	}
+	return r
+}
+
+func (r *Rewriter) edit(ip int) *rewrite {
+	if ip < 0 || ip >= len(r.body) {
+		panic("ir.Rewriter: index out of range")
+	}
+
+	e := r.edits[ip]
+	if e == nil {
+		e = &rewrite{}
+		r.edits[ip] = e
+	}
+	return e
+}
+
+// Delete removes the operation at ip.
+func (r *Rewriter) Delete(ip int) { r.Replace(ip) }
+
+// InsertAfter inserts ops after the operation at ip.
+func (r *Rewriter) InsertAfter(ip int, ops ...Operation) {
+	e := r.edit(ip)
+	e.after = append(e.after, r.renumber(ops)...)
+}
+
+// InsertBefore inserts ops before the operation at ip.
+func (r *Rewriter) InsertBefore(ip int, ops ...Operation) {
+	e := r.edit(ip)
+	e.before = append(e.before, r.renumber(ops)...)
+}
+
+// Replace replaces the operation at ip by ops.
+func (r *Rewriter) Replace(ip int, ops ...Operation) {
+	e := r.edit(ip)
+	e.replace = r.renumber(ops)
+	e.replaced = true
+}
+
+// Body returns the body with all edits applied.
+func (r *Rewriter) Body() []Operation {
+	s := make([]Operation, 0, len(r.body))
+	for ip, op := range r.body {
+		e := r.edits[ip]
+		if e == nil {
+			s = append(s, op)
+			continue
+		}
+
+		s = append(s, e.before...)
+		switch {
+		case e.replaced:
+			s = append(s, e.replace...)
+		default:
+			s = append(s, op)
+		}
+		s = append(s, e.after...)
+	}
+	return s
+}
+
+func (r *Rewriter) renumber(ops []Operation) []Operation {
+	m := map[int]int{}
+	for _, op := range ops {
+		if x, ok := op.(*Label); ok && x.NameID == 0 && x.Number >= 0 {
+			m[x.Number] = r.label
+			r.label++
+		}
+	}
+	if len(m) == 0 {
+		return ops
+	}
+
+	fix := func(nm NameID, n *int) {
+		if nm != 0 {
+			return
+		}
+
+		if k, ok := m[*n]; ok {
+			*n = k
+		}
+	}
+	for _, op := range ops {
+		switch x := op.(type) {
+		case *Jmp:
+			fix(x.NameID, &x.Number)
+		case *Jnz:
+			fix(x.NameID, &x.Number)
+		case *Jz:
+			fix(x.NameID, &x.Number)
+		case *Label:
+			fix(x.NameID, &x.Number)
+		case *LabelAddr:
+			fix(x.NameID, &x.Number)
+		case *Switch:
+			fix(x.Default.NameID, &x.Default.Number)
+			for i := range x.Labels {
+				fix(x.Labels[i].NameID, &x.Labels[i].Number)
+			}
+		}
+	}
+	return ops
+}